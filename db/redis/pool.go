@@ -133,8 +133,8 @@ func (p *pool) push(resp *resp) error {
 	p.mu.Lock()
 	defer p.mu.Unlock()
 	delete(p.inUse, resp)
-	if !p.active || len(p.available) >= p.database.poolsize {
-		// Simply close it.
+	if !p.active || resp.broken || len(p.available) >= p.database.poolsize {
+		// Simply close it, broken ones are replaced lazily.
 		return resp.close()
 	}
 	// Return to availanle ones.
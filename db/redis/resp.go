@@ -79,6 +79,7 @@ type resp struct {
 	conn     net.Conn
 	reader   *bufio.Reader
 	cmd      string
+	broken   bool
 }
 
 // newResp establishes a connection to a Redis database
@@ -108,6 +109,7 @@ func (r *resp) sendCommand(cmd string, args ...interface{}) error {
 	packet := join(lengthPart, cmdPart, argsPart)
 	_, err := r.conn.Write(packet)
 	if err != nil {
+		r.broken = true
 		return failure.Annotate(err, "cannot send %s, connection is broken", r.cmd)
 	}
 	return nil
@@ -118,6 +120,7 @@ func (r *resp) receiveResponse() *response {
 	// Receive first line.
 	line, err := r.reader.ReadBytes('\n')
 	if err != nil {
+		r.broken = true
 		rerr := failure.Annotate(err, "cannot receive after %s, connection is broken", r.cmd)
 		return &response{receivingError, 0, nil, rerr}
 	}
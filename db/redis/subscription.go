@@ -95,11 +95,16 @@ func (sub *Subscription) Pop() (*PublishedValue, error) {
 	}
 	switch {
 	case strings.Contains(kind, "message"):
-		channel, err := result.StringAt(1)
+		// Pattern messages additionally contain the pattern.
+		offset := 1
+		if kind == "pmessage" {
+			offset = 2
+		}
+		channel, err := result.StringAt(offset)
 		if err != nil {
 			return nil, err
 		}
-		value, err := result.ValueAt(2)
+		value, err := result.ValueAt(offset + 1)
 		if err != nil {
 			return nil, err
 		}
@@ -135,11 +140,13 @@ func (sub *Subscription) Close() error {
 	}
 	err = sub.resp.sendCommand("punsubscribe")
 	if err != nil {
+		sub.Kill()
 		return err
 	}
 	for {
 		pv, err := sub.Pop()
 		if err != nil {
+			sub.Kill()
 			return err
 		}
 		if pv.Kind == "punsubscribe" {
@@ -150,6 +157,16 @@ func (sub *Subscription) Close() error {
 	return nil
 }
 
+// Kill closes the connection of the subscription without unsubscribing.
+// It can be called from another goroutine to interrupt a waiting Pop().
+// The subscription cannot be used anymore afterwards.
+func (sub *Subscription) Kill() error {
+	if sub.resp == nil {
+		return nil
+	}
+	return sub.database.pool.kill(sub.resp)
+}
+
 // ensureProtocol retrieves a protocol from the pool if needed.
 func (sub *Subscription) ensureProtocol() error {
 	if sub.resp == nil {
@@ -0,0 +1,291 @@
+// Tideland Go Library - DSA - Time Extensions
+//
+// Copyright (C) 2019 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license.
+
+package timex
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"sync"
+	"time"
+)
+
+//--------------------
+// CLOCK
+//--------------------
+
+// Timer is a stoppable one-shot timer of a clock.
+type Timer interface {
+	// Stop prevents the timer from firing. It returns false if
+	// the timer already fired or has been stopped.
+	Stop() bool
+}
+
+// Ticker delivers ticks of a clock in intervals.
+type Ticker interface {
+	// C returns the channel the ticks are delivered on.
+	C() <-chan time.Time
+
+	// Stop turns off the ticker.
+	Stop()
+}
+
+// Clock provides the current time, timers, and tickers. It allows
+// code depending on time to run on the real time as well as on a
+// manually controlled one, e.g. in tests.
+type Clock interface {
+	// Now returns the current time.
+	Now() time.Time
+
+	// Since returns the time elapsed since t.
+	Since(t time.Time) time.Duration
+
+	// After waits for the duration to elapse and then sends the
+	// current time on the returned channel.
+	After(d time.Duration) <-chan time.Time
+
+	// AfterFunc waits for the duration to elapse and then calls f.
+	AfterFunc(d time.Duration, f func()) Timer
+
+	// NewTicker returns a ticker sending the time on its channel
+	// after each tick.
+	NewTicker(d time.Duration) Ticker
+}
+
+//--------------------
+// REAL CLOCK
+//--------------------
+
+// realClock implements the Clock interface based on the
+// time package.
+type realClock struct{}
+
+// RealClock returns the clock working with the real time.
+func RealClock() Clock {
+	return realClock{}
+}
+
+// Now implements Clock.
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+// Since implements Clock.
+func (realClock) Since(t time.Time) time.Duration {
+	return time.Since(t)
+}
+
+// After implements Clock.
+func (realClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+// AfterFunc implements Clock.
+func (realClock) AfterFunc(d time.Duration, f func()) Timer {
+	return time.AfterFunc(d, f)
+}
+
+// NewTicker implements Clock.
+func (realClock) NewTicker(d time.Duration) Ticker {
+	return realTicker{time.NewTicker(d)}
+}
+
+// realTicker wraps a ticker of the time package.
+type realTicker struct {
+	ticker *time.Ticker
+}
+
+// C implements Ticker.
+func (t realTicker) C() <-chan time.Time {
+	return t.ticker.C
+}
+
+// Stop implements Ticker.
+func (t realTicker) Stop() {
+	t.ticker.Stop()
+}
+
+//--------------------
+// MANUAL CLOCK
+//--------------------
+
+// ManualClock is a clock only moving when it is advanced. Timers and
+// tickers fire during the advancing in the order of their times. So
+// functions passed to AfterFunc are called synchronously by Advance
+// and Set, and they see the time they are due as current time.
+type ManualClock struct {
+	mu     sync.Mutex
+	now    time.Time
+	timers []*manualTimer
+}
+
+// NewManualClock creates a manual clock starting at the given time.
+func NewManualClock(now time.Time) *ManualClock {
+	return &ManualClock{
+		now: now,
+	}
+}
+
+// Now implements Clock.
+func (c *ManualClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// Since implements Clock.
+func (c *ManualClock) Since(t time.Time) time.Duration {
+	return c.Now().Sub(t)
+}
+
+// After implements Clock.
+func (c *ManualClock) After(d time.Duration) <-chan time.Time {
+	return c.add(d, 0, nil).c
+}
+
+// AfterFunc implements Clock.
+func (c *ManualClock) AfterFunc(d time.Duration, f func()) Timer {
+	return c.add(d, 0, f)
+}
+
+// NewTicker implements Clock.
+func (c *ManualClock) NewTicker(d time.Duration) Ticker {
+	if d <= 0 {
+		panic("non-positive interval for NewTicker")
+	}
+	return manualTicker{c.add(d, d, nil)}
+}
+
+// Advance moves the clock forward by the duration and fires all
+// timers and tickers due until then.
+func (c *ManualClock) Advance(d time.Duration) {
+	c.Set(c.Now().Add(d))
+}
+
+// Set moves the clock to the given time and fires all timers and
+// tickers due until then. Earlier times than the current one are
+// ignored, but timers already due are fired.
+func (c *ManualClock) Set(t time.Time) {
+	for {
+		c.mu.Lock()
+		mt := c.next(t)
+		if mt == nil {
+			if t.After(c.now) {
+				c.now = t
+			}
+			c.mu.Unlock()
+			return
+		}
+		if mt.at.After(c.now) {
+			c.now = mt.at
+		}
+		if mt.period > 0 {
+			mt.at = mt.at.Add(mt.period)
+		} else {
+			c.remove(mt)
+		}
+		now := c.now
+		c.mu.Unlock()
+		// Fire outside the lock, so that the function
+		// is able to use the clock.
+		if mt.f != nil {
+			mt.f()
+			continue
+		}
+		select {
+		case mt.c <- now:
+		default:
+		}
+	}
+}
+
+// Timers returns the number of active timers and tickers.
+func (c *ManualClock) Timers() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.timers)
+}
+
+// add registers a new timer or ticker.
+func (c *ManualClock) add(d, period time.Duration, f func()) *manualTimer {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	mt := &manualTimer{
+		clock:  c,
+		at:     c.now.Add(d),
+		period: period,
+		f:      f,
+	}
+	if f == nil {
+		mt.c = make(chan time.Time, 1)
+	}
+	c.timers = append(c.timers, mt)
+	return mt
+}
+
+// next returns the earliest timer due until the given time. Timers
+// due at the same time are returned in the order of their creation.
+func (c *ManualClock) next(t time.Time) *manualTimer {
+	var next *manualTimer
+	for _, mt := range c.timers {
+		if mt.at.After(t) {
+			continue
+		}
+		if next == nil || mt.at.Before(next.at) {
+			next = mt
+		}
+	}
+	return next
+}
+
+// remove deregisters a timer. It returns false if the timer
+// isn't registered anymore.
+func (c *ManualClock) remove(mt *manualTimer) bool {
+	for i, cmt := range c.timers {
+		if cmt == mt {
+			c.timers = append(c.timers[:i], c.timers[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// manualTimer implements Timer for the manual clock and is the
+// base of its tickers.
+type manualTimer struct {
+	clock  *ManualClock
+	at     time.Time
+	period time.Duration
+	f      func()
+	c      chan time.Time
+}
+
+// Stop implements Timer.
+func (mt *manualTimer) Stop() bool {
+	mt.clock.mu.Lock()
+	defer mt.clock.mu.Unlock()
+	return mt.clock.remove(mt)
+}
+
+// manualTicker implements Ticker for the manual clock.
+type manualTicker struct {
+	timer *manualTimer
+}
+
+// C implements Ticker.
+func (t manualTicker) C() <-chan time.Time {
+	return t.timer.c
+}
+
+// Stop implements Ticker.
+func (t manualTicker) Stop() {
+	t.timer.Stop()
+}
+
+// EOF
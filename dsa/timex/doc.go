@@ -6,6 +6,11 @@
 // by the new BSD license.
 
 // Package timex adds some useful functions for the work with them time type.
+//
+// The Clock interface allows code depending on time to work with the
+// real time returned by RealClock() or with a ManualClock. The latter
+// only moves when it is advanced and fires its timers and tickers then,
+// so that time dependent code can be tested deterministically.
 package timex
 
 // EOF
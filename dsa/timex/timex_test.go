@@ -137,4 +137,75 @@ func TestRetryTooOften(t *testing.T) {
 	assert.ErrorMatch(err, ".* retried more than .* times")
 }
 
+// TestManualClock tests the manually advanced clock.
+func TestManualClock(t *testing.T) {
+	assert := asserts.NewTesting(t, asserts.FailStop)
+	start := time.Date(2019, time.January, 1, 12, 0, 0, 0, time.UTC)
+	clock := timex.NewManualClock(start)
+
+	var fired []time.Time
+	clock.AfterFunc(90*time.Second, func() {
+		fired = append(fired, clock.Now())
+	})
+	ticker := clock.NewTicker(time.Minute)
+	afterc := clock.After(30 * time.Second)
+	timer := clock.AfterFunc(time.Hour, func() {
+		assert.Fail("stopped timer fired")
+	})
+	assert.Equal(clock.Timers(), 4)
+	assert.True(timer.Stop())
+	assert.False(timer.Stop())
+
+	clock.Advance(59 * time.Second)
+	assert.Equal(clock.Now(), start.Add(59*time.Second))
+	assert.Equal(clock.Since(start), 59*time.Second)
+	assert.Equal(<-afterc, start.Add(30*time.Second))
+	assert.Length(fired, 0)
+
+	clock.Advance(time.Minute)
+	assert.Equal(fired, []time.Time{start.Add(90 * time.Second)})
+	assert.Equal(<-ticker.C(), start.Add(time.Minute))
+	assert.Equal(clock.Timers(), 1)
+
+	// Missed ticks are dropped like by real tickers.
+	clock.Set(start.Add(10 * time.Minute))
+	assert.Equal(<-ticker.C(), start.Add(2*time.Minute))
+	select {
+	case <-ticker.C():
+		assert.Fail("missed tick not dropped")
+	default:
+	}
+	ticker.Stop()
+	assert.Equal(clock.Timers(), 0)
+
+	// Setting earlier times is ignored.
+	clock.Set(start)
+	assert.Equal(clock.Now(), start.Add(10*time.Minute))
+
+	// Timers already due fire without moving the time back.
+	pastc := clock.After(-time.Minute)
+	clock.Advance(0)
+	assert.Equal(<-pastc, start.Add(10*time.Minute))
+	assert.Equal(clock.Now(), start.Add(10*time.Minute))
+}
+
+// TestRealClock tests the clock based on the real time.
+func TestRealClock(t *testing.T) {
+	assert := asserts.NewTesting(t, asserts.FailStop)
+	clock := timex.RealClock()
+
+	now := clock.Now()
+	<-clock.After(10 * time.Millisecond)
+	assert.True(clock.Since(now) >= 10*time.Millisecond)
+
+	donec := make(chan struct{})
+	clock.AfterFunc(10*time.Millisecond, func() { close(donec) })
+	<-donec
+
+	ticker := clock.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+	<-ticker.C()
+	<-ticker.C()
+}
+
 // EOF
@@ -18,12 +18,30 @@ import (
 	"sync"
 	"time"
 
+	"tideland.dev/go/dsa/timex"
 	"tideland.dev/go/net/jwt/token"
 	"tideland.dev/go/together/loop"
 	"tideland.dev/go/together/notifier"
 	"tideland.dev/go/trace/failure"
 )
 
+//--------------------
+// OPTIONS
+//--------------------
+
+// Option defines the signature of an option setting function.
+type Option func(c *Cache)
+
+// WithClock sets the clock used for the access times of the tokens
+// and the cleanup intervals. By default it is the real clock.
+func WithClock(clock timex.Clock) Option {
+	return func(c *Cache) {
+		if clock != nil {
+			c.clock = clock
+		}
+	}
+}
+
 //--------------------
 // CACHE
 //--------------------
@@ -43,6 +61,7 @@ type Cache struct {
 	leeway     time.Duration
 	interval   time.Duration
 	maxEntries int
+	clock      timex.Clock
 	cleanupc   chan time.Duration
 	loop       *loop.Loop
 }
@@ -53,21 +72,26 @@ type Cache struct {
 // The duration of the interval controls how often the background
 // cleanup is running. Final configuration parameter is the maximum
 // number of entries inside the cache. If these grow too fast the
-// ttl will be temporarily reduced for cleanup.
-func New(ctx context.Context, ttl, leeway, interval time.Duration, maxEntries int) *Cache {
+// ttl will be temporarily reduced for cleanup. Further options like
+// the clock are optional.
+func New(ctx context.Context, ttl, leeway, interval time.Duration, maxEntries int, options ...Option) *Cache {
 	c := &Cache{
 		entries:    map[string]*cacheEntry{},
 		ttl:        ttl,
 		leeway:     leeway,
 		interval:   interval,
 		maxEntries: maxEntries,
+		clock:      timex.RealClock(),
 		cleanupc:   make(chan time.Duration, 5),
 	}
-	options := []loop.Option{}
+	for _, option := range options {
+		option(c)
+	}
+	loopOptions := []loop.Option{}
 	if ctx != nil {
-		options = append(options, loop.WithContext(ctx))
+		loopOptions = append(loopOptions, loop.WithContext(ctx))
 	}
-	c.loop = loop.New(c.worker, options...).Go()
+	c.loop = loop.New(c.worker, loopOptions...).Go()
 	return c
 }
 
@@ -83,7 +107,7 @@ func (c *Cache) Get(token string) (*token.JWT, bool) {
 		return nil, false
 	}
 	if entry.jwt.IsValid(c.leeway) {
-		entry.accessed = time.Now()
+		entry.accessed = c.clock.Now()
 		return entry.jwt, true
 	}
 	// Remove invalid token.
@@ -139,7 +163,7 @@ func (c *Cache) Put(jwt *token.JWT) int {
 		return 0
 	}
 	if jwt.IsValid(c.leeway) {
-		c.entries[jwt.String()] = &cacheEntry{jwt, time.Now()}
+		c.entries[jwt.String()] = &cacheEntry{jwt, c.clock.Now()}
 		lenEntries := len(c.entries)
 		if lenEntries > c.maxEntries {
 			ttl := int64(c.ttl) / int64(lenEntries) * int64(c.maxEntries)
@@ -185,7 +209,7 @@ func (c *Cache) worker(nc *notifier.Closer) error {
 		defer c.mu.Unlock()
 		c.entries = nil
 	}()
-	ticker := time.NewTicker(c.interval)
+	ticker := c.clock.NewTicker(c.interval)
 	defer ticker.Stop()
 	for {
 		select {
@@ -193,7 +217,7 @@ func (c *Cache) worker(nc *notifier.Closer) error {
 			return nil
 		case ttl := <-c.cleanupc:
 			c.cleanup(ttl)
-		case <-ticker.C:
+		case <-ticker.C():
 			c.cleanup(c.ttl)
 		}
 	}
@@ -204,7 +228,7 @@ func (c *Cache) cleanup(ttl time.Duration) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 	valids := map[string]*cacheEntry{}
-	now := time.Now()
+	now := c.clock.Now()
 	for token, entry := range c.entries {
 		if entry.jwt.IsValid(c.leeway) {
 			if entry.accessed.Add(ttl).After(now) {
@@ -18,6 +18,7 @@ import (
 	"time"
 
 	"tideland.dev/go/audit/asserts"
+	"tideland.dev/go/dsa/timex"
 	"tideland.dev/go/net/jwt/cache"
 	"tideland.dev/go/net/jwt/token"
 )
@@ -153,6 +154,37 @@ func TestCacheContext(t *testing.T) {
 	assert.ErrorMatch(err, ".* loop not working")
 }
 
+// TestCacheClock tests the access based cleanup of the JWT
+// cache using a manual clock.
+func TestCacheClock(t *testing.T) {
+	assert := asserts.NewTesting(t, asserts.FailStop)
+	assert.Logf("testing cache cleanup with manual clock")
+	ctx := context.Background()
+	clock := timex.NewManualClock(time.Now())
+	cache := cache.New(ctx, time.Minute, time.Minute, 10*time.Second, 10, cache.WithClock(clock))
+	defer cache.Stop()
+	key := []byte("secret")
+	claims := initClaims()
+	jwtOld, err := token.Encode(claims, key, token.HS512)
+	assert.Nil(err)
+	claims.Set("name", "Jane Doe")
+	jwtNew, err := token.Encode(claims, key, token.HS512)
+	assert.Nil(err)
+	assert.Equal(cache.Put(jwtOld), 1)
+	// Wait for the cleanup ticker and let it tick.
+	assert.Retry(func() bool { return clock.Timers() == 1 }, 100, 10*time.Millisecond)
+	clock.Advance(30 * time.Second)
+	assert.Equal(cache.Put(jwtNew), 2)
+	clock.Advance(45 * time.Second)
+	assert.Retry(func() bool { return cache.Put(jwtNew) == 1 }, 100, 10*time.Millisecond)
+	jwtOut, ok := cache.Get(jwtOld.String())
+	assert.False(ok)
+	assert.Nil(jwtOut)
+	jwtOut, ok = cache.Get(jwtNew.String())
+	assert.True(ok)
+	assert.Equal(jwtOut, jwtNew)
+}
+
 //--------------------
 // HELPERS
 //--------------------
@@ -0,0 +1,225 @@
+// Tideland Go Library - Network - Web
+//
+// Copyright (C) 2019 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license.
+
+package web // import "tideland.dev/go/net/web"
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"time"
+
+	"tideland.dev/go/net/httpx"
+	"tideland.dev/go/together/cells/event"
+	"tideland.dev/go/together/cells/mesh"
+	"tideland.dev/go/trace/failure"
+)
+
+//--------------------
+// CONSTANTS
+//--------------------
+
+const (
+	// DefaultReplyTimeout is the time a mesh handler waits for
+	// the reply of a cell if no other timeout is configured.
+	DefaultReplyTimeout = 5 * time.Second
+
+	// maxEventBodySize limits the size of a posted event.
+	maxEventBodySize = 1024 * 1024
+)
+
+//--------------------
+// MESH HANDLER
+//--------------------
+
+// MeshHandlerConfig allows to control how the mesh handler works.
+// Only the cell ID is needed. By default the last part of the path
+// is the topic of the event, it is emitted without waiting for a
+// reply, and the access isn't checked.
+type MeshHandlerConfig struct {
+	CellID  string
+	Topic   func(r *http.Request) string
+	Reply   bool
+	Timeout time.Duration
+	JWT     *JWTHandlerConfig
+}
+
+// MeshHandler emits POSTed JSON objects as events to a cell of
+// a mesh. So external systems can send events via webhooks.
+type MeshHandler struct {
+	handler http.Handler
+	mesh    *mesh.Mesh
+	cellID  string
+	topic   func(r *http.Request) string
+	reply   bool
+	timeout time.Duration
+}
+
+// NewMeshHandler creates a handler emitting events to the configured
+// cell of the mesh. The fields of the JSON object in the request body
+// are the payload of the event. In case of a configured reply the
+// handler waits for the reply of the cell and returns its payload as
+// JSON object, otherwise it answers with status "202 Accepted". If the
+// JWT configuration is set the requests are checked by a JWTHandler.
+func NewMeshHandler(msh *mesh.Mesh, config *MeshHandlerConfig) *MeshHandler {
+	mh := &MeshHandler{
+		mesh:    msh,
+		topic:   lastPathPart,
+		timeout: DefaultReplyTimeout,
+	}
+	if config != nil {
+		mh.cellID = config.CellID
+		if config.Topic != nil {
+			mh.topic = config.Topic
+		}
+		mh.reply = config.Reply
+		if config.Timeout != 0 {
+			mh.timeout = config.Timeout
+		}
+	}
+	mh.handler = http.HandlerFunc(mh.serveEvent)
+	if config != nil && config.JWT != nil {
+		mh.handler = NewJWTHandler(mh.handler, config.JWT)
+	}
+	return mh
+}
+
+// ServeHTTP implements the http.Handler interface.
+func (mh *MeshHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	mh.handler.ServeHTTP(w, r)
+}
+
+// serveEvent creates the event out of the request and emits it.
+func (mh *MeshHandler) serveEvent(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		mh.feedback(w, "only POST allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	topic := mh.topic(r)
+	if topic == "" {
+		mh.feedback(w, "no event topic", http.StatusBadRequest)
+		return
+	}
+	pl, err := readPayload(w, r)
+	if err != nil {
+		mh.feedback(w, "invalid event payload: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !mh.reply {
+		// Request context ends with returning, so don't use it.
+		if err := mh.mesh.Emit(mh.cellID, event.New(topic, pl)); err != nil {
+			mh.feedback(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+	evt := event.WithContext(r.Context(), topic, pl)
+	rpl, err := mh.mesh.Request(mh.cellID, evt, mh.timeout)
+	switch {
+	case mesh.IsErrRequestTimeout(err):
+		mh.feedback(w, err.Error(), http.StatusGatewayTimeout)
+		return
+	case mesh.IsErrRequestFailed(err):
+		mh.feedback(w, err.Error(), http.StatusInternalServerError)
+		return
+	case err != nil:
+		mh.feedback(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	b, err := json.Marshal(rpl.Map())
+	if err != nil {
+		mh.feedback(w, "cannot marshal reply: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set(httpx.HeaderContentType, httpx.ContentTypeJSON)
+	w.WriteHeader(http.StatusOK)
+	w.Write(b)
+}
+
+// feedback sends a negative feedback to the caller.
+func (mh *MeshHandler) feedback(w http.ResponseWriter, msg string, statusCode int) {
+	b, _ := json.Marshal(map[string]interface{}{
+		"statusCode": statusCode,
+		"message":    msg,
+	})
+	w.Header().Set(httpx.HeaderContentType, httpx.ContentTypeJSON)
+	w.WriteHeader(statusCode)
+	w.Write(b)
+}
+
+//--------------------
+// HELPERS
+//--------------------
+
+// lastPathPart returns the last part of the request path.
+func lastPathPart(r *http.Request) string {
+	parts := httpx.PathParts(r)
+	if len(parts) == 0 {
+		return ""
+	}
+	return parts[len(parts)-1]
+}
+
+// readPayload reads the JSON object of the request body as
+// payload. An empty body leads to an empty payload.
+func readPayload(w http.ResponseWriter, r *http.Request) (*event.Payload, error) {
+	data, err := httpx.ReadBody(http.MaxBytesReader(w, r.Body, maxEventBodySize))
+	if err != nil {
+		return nil, err
+	}
+	if len(bytes.TrimSpace(data)) == 0 {
+		return event.NewPayload(), nil
+	}
+	var values map[string]interface{}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err := dec.Decode(&values); err != nil {
+		return nil, err
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return nil, failure.New("data after JSON object")
+	}
+	return event.NewPayload(jsonValues(values)), nil
+}
+
+// jsonValues converts the numbers of decoded JSON objects and
+// arrays into ints or float64s and drops null values.
+func jsonValues(v interface{}) interface{} {
+	switch tv := v.(type) {
+	case json.Number:
+		if i, err := tv.Int64(); err == nil {
+			return int(i)
+		}
+		f, _ := tv.Float64()
+		return f
+	case map[string]interface{}:
+		m := map[string]interface{}{}
+		for key, value := range tv {
+			if value != nil {
+				m[key] = jsonValues(value)
+			}
+		}
+		return m
+	case []interface{}:
+		s := []interface{}{}
+		for _, value := range tv {
+			if value != nil {
+				s = append(s, jsonValues(value))
+			}
+		}
+		return s
+	}
+	return v
+}
+
+// EOF
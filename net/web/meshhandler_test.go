@@ -0,0 +1,161 @@
+// Tideland Go Library - Network - Web - Unit Tests
+//
+// Copyright (C) 2019 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license.
+
+package web_test // import "tideland.dev/go/net/web_test"
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"tideland.dev/go/audit/asserts"
+	"tideland.dev/go/audit/environments"
+	"tideland.dev/go/net/jwt/token"
+	"tideland.dev/go/net/web"
+	"tideland.dev/go/together/cells/behaviors"
+	"tideland.dev/go/together/cells/event"
+	"tideland.dev/go/together/cells/mesh"
+)
+
+//--------------------
+// TESTS
+//--------------------
+
+// TestMeshHandlerEmit tests the emitting of posted events.
+func TestMeshHandlerEmit(t *testing.T) {
+	assert := asserts.NewTesting(t, asserts.FailStop)
+	wa := startWebAsserter(assert)
+	defer wa.Close()
+	evtc := make(chan *event.Event, 1)
+	msh := mesh.New()
+	defer msh.Stop()
+	err := msh.SpawnCells(behaviors.NewSimpleProcessorBehavior("collector", func(emitter mesh.Emitter, evt *event.Event) error {
+		evtc <- evt
+		return nil
+	}))
+	assert.NoError(err)
+
+	wa.Handle("/events/", web.NewMeshHandler(msh, &web.MeshHandlerConfig{
+		CellID: "collector",
+	}))
+
+	wreq := wa.CreateRequest(http.MethodPost, "/events/order-created")
+	wreq.SetContentType(environments.ContentTypeJSON)
+	wreq.AssertMarshalBody(map[string]interface{}{
+		"id":      1,
+		"price":   12.5,
+		"express": true,
+		"items":   []interface{}{"a", nil, "b"},
+		"address": map[string]interface{}{"street": "Main Street"},
+		"note":    nil,
+	})
+	wresp := wreq.Do()
+	wresp.AssertStatusCodeEquals(http.StatusAccepted)
+
+	select {
+	case evt := <-evtc:
+		assert.Equal(evt.Topic(), "order-created")
+		assert.Equal(evt.Payload().At("id").AsInt(0), 1)
+		assert.Equal(evt.Payload().At("price").AsFloat64(0.0), 12.5)
+		assert.True(evt.Payload().At("express").AsBool(false))
+		assert.Equal(evt.Payload().At("items", "1").AsString(""), "b")
+		assert.Equal(evt.Payload().At("address", "street").AsString(""), "Main Street")
+		assert.True(evt.Payload().At("note").IsUndefined())
+	case <-time.After(5 * time.Second):
+		assert.Fail("no event emitted")
+	}
+
+	// Invalid requests.
+	wreq = wa.CreateRequest(http.MethodGet, "/events/order-created")
+	wresp = wreq.Do()
+	wresp.AssertStatusCodeEquals(http.StatusMethodNotAllowed)
+
+	wreq = wa.CreateRequest(http.MethodPost, "/events/order-created")
+	wreq.SetContentType(environments.ContentTypeJSON)
+	wreq.AssertMarshalBody([]int{1, 2, 3})
+	wresp = wreq.Do()
+	wresp.AssertStatusCodeEquals(http.StatusBadRequest)
+	wresp.AssertBodyContains("invalid event payload")
+}
+
+// TestMeshHandlerReply tests the synchronous replies of cells
+// and the protection by JWT.
+func TestMeshHandlerReply(t *testing.T) {
+	assert := asserts.NewTesting(t, asserts.FailStop)
+	wa := startWebAsserter(assert)
+	defer wa.Close()
+	msh := mesh.New()
+	defer msh.Stop()
+	err := msh.SpawnCells(behaviors.NewSimpleProcessorBehavior("calculator", func(emitter mesh.Emitter, evt *event.Event) error {
+		a := evt.Payload().At("a").AsInt(0)
+		b := evt.Payload().At("b").AsInt(0)
+		switch evt.Topic() {
+		case "add":
+			return mesh.Reply(evt, "result", a+b, "operands", []int{a, b})
+		case "div":
+			if b == 0 {
+				return mesh.ReplyError(evt, errors.New("division by zero"))
+			}
+			return mesh.Reply(evt, "result", a/b)
+		}
+		return nil
+	}))
+	assert.NoError(err)
+
+	wa.Handle("/calculator/", web.NewMeshHandler(msh, &web.MeshHandlerConfig{
+		CellID:  "calculator",
+		Topic:   func(r *http.Request) string { return strings.TrimPrefix(r.URL.Path, "/calculator/") },
+		Reply:   true,
+		Timeout: 250 * time.Millisecond,
+		JWT: &web.JWTHandlerConfig{
+			Key: []byte("secret"),
+		},
+	}))
+	jwt, err := token.Encode(token.NewClaims(), []byte("secret"), token.HS512)
+	assert.NoError(err)
+
+	tests := []struct {
+		topic      string
+		auth       bool
+		statusCode int
+		body       string
+	}{
+		{"add", false, http.StatusUnauthorized, "request contains no authorization header"},
+		{"add", true, http.StatusOK, `{"operands":{"0":4,"1":2},"result":6}`},
+		{"div", true, http.StatusOK, `{"result":2}`},
+		{"unknown", true, http.StatusGatewayTimeout, "request timeout"},
+		{"", true, http.StatusBadRequest, "no event topic"},
+	}
+	for i, test := range tests {
+		assert.Logf("test case #%d: %s", i, test.topic)
+		wreq := wa.CreateRequest(http.MethodPost, "/calculator/"+test.topic)
+		if test.auth {
+			wreq.Header().Set("Authorization", "Bearer "+jwt.String())
+		}
+		wreq.SetContentType(environments.ContentTypeJSON)
+		wreq.AssertMarshalBody(map[string]int{"a": 4, "b": 2})
+		wresp := wreq.Do()
+		wresp.AssertStatusCodeEquals(test.statusCode)
+		wresp.AssertBodyContains(test.body)
+	}
+
+	wreq := wa.CreateRequest(http.MethodPost, "/calculator/div")
+	wreq.Header().Set("Authorization", "Bearer "+jwt.String())
+	wreq.SetContentType(environments.ContentTypeJSON)
+	wreq.AssertMarshalBody(map[string]int{"a": 4, "b": 0})
+	wresp := wreq.Do()
+	wresp.AssertStatusCodeEquals(http.StatusInternalServerError)
+	wresp.AssertBodyContains("division by zero")
+}
+
+// EOF
@@ -48,17 +48,23 @@ type Action func() error
 
 // Actor allows to simply use and control a goroutine.
 type Actor struct {
-	mu      sync.Mutex
-	actionC chan Action
-	options []loop.Option
-	loop    *loop.Loop
-	err     error
+	mu        sync.Mutex
+	actionC   chan Action
+	options   []loop.Option
+	finalizer loop.Finalizer
+	loop      *loop.Loop
+	err       error
+	fmu       sync.Mutex
+	futures   map[*Future]struct{}
+	ferr      error
 }
 
 // New creates an Actor with the passed options.
 func New(options ...Option) *Actor {
 	// Init with options.
-	act := &Actor{}
+	act := &Actor{
+		futures: make(map[*Future]struct{}),
+	}
 	for _, option := range options {
 		if err := option(act); err != nil {
 			act.err = err
@@ -70,6 +76,7 @@ func New(options ...Option) *Actor {
 		act.actionC = make(chan Action, 1)
 	}
 	// Create loop with its options.
+	act.options = append(act.options, loop.WithFinalizer(act.finalize))
 	act.loop = loop.New(act.worker, act.options...)
 	return act
 }
@@ -146,6 +153,16 @@ func (act *Actor) Err() error {
 	return act.loop.Err()
 }
 
+// finalize calls a configured finalizer and completes the pending
+// futures with the error of the Actor.
+func (act *Actor) finalize(err error) error {
+	if act.finalizer != nil {
+		err = act.finalizer(err)
+	}
+	act.terminateFutures(err)
+	return err
+}
+
 // worker is the Loop worker of the Actor.
 func (act *Actor) worker(c *notifier.Closer) error {
 	for {
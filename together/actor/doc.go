@@ -37,6 +37,20 @@
 //         return counter
 //     }
 //
+// Actions returning a value are executed with DoFuture(). It returns
+// immediately with a future providing the value and the error when the
+// action is done, or with the error of the Actor if it terminates
+// before. Multiple futures can be combined in a batch.
+//
+//     func (c *Counter) Get() *actor.Future {
+//         return c.act.DoFuture(func() (interface{}, error) {
+//             return c.counter, nil
+//         })
+//     }
+//
+//     b := actor.NewBatch(c1.Get(), c2.Get())
+//     values, err := b.Wait(ctx)
+//
 // Different options for the constructor allow to pass a context for stopping,
 // how many actions are queued, and how panics in actions shall be handled.
 package actor // import "tideland.dev/go/together/actor"
@@ -0,0 +1,223 @@
+// Tideland Go Library - Together - Actor
+//
+// Copyright (C) 2017-2019 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license.
+
+package actor // import "tideland.dev/go/together/actor"
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"context"
+	"sync"
+
+	"tideland.dev/go/trace/failure"
+)
+
+//--------------------
+// FUTURE
+//--------------------
+
+// ValueAction defines the signature of an actor action returning
+// a value.
+type ValueAction func() (interface{}, error)
+
+// Future provides the value and the error of a value action when
+// it is done.
+type Future struct {
+	once  sync.Once
+	doneC chan struct{}
+	value interface{}
+	err   error
+}
+
+// newFuture creates a future waiting for its result.
+func newFuture() *Future {
+	return &Future{
+		doneC: make(chan struct{}),
+	}
+}
+
+// Done returns a channel which is closed when the action is done.
+func (f *Future) Done() <-chan struct{} {
+	return f.doneC
+}
+
+// Wait waits until the action is done or the context is done. It
+// returns the value and the error of the action or the error of
+// the context.
+func (f *Future) Wait(ctx context.Context) (interface{}, error) {
+	if !f.wait(ctx) {
+		return nil, ctx.Err()
+	}
+	return f.value, f.err
+}
+
+// wait waits until the action or the context is done. It returns
+// false only if the action isn't done.
+func (f *Future) wait(ctx context.Context) bool {
+	select {
+	case <-f.doneC:
+		return true
+	case <-ctx.Done():
+		select {
+		case <-f.doneC:
+			return true
+		default:
+			return false
+		}
+	}
+}
+
+// Err returns the error of the action if it's done, otherwise nil.
+func (f *Future) Err() error {
+	select {
+	case <-f.doneC:
+		return f.err
+	default:
+		return nil
+	}
+}
+
+// complete sets the result of the future if it's not done yet.
+func (f *Future) complete(value interface{}, err error) {
+	f.once.Do(func() {
+		f.value = value
+		f.err = err
+		close(f.doneC)
+	})
+}
+
+// DoFuture executes the value action asynchronously and returns a
+// future for its result. Different to other actions errors don't
+// stop the Actor, they are returned by the future. If the Actor
+// terminates before the action is done the future returns the
+// error of the Actor.
+func (act *Actor) DoFuture(action ValueAction) *Future {
+	f := newFuture()
+	if !act.addFuture(f) {
+		return f
+	}
+	if err := act.DoAsync(func() error {
+		completed := false
+		defer func() {
+			if !completed {
+				// Let the recoverer handle the panic.
+				r := recover()
+				act.completeFuture(f, nil, failure.New("value action panicked: %v", r))
+				panic(r)
+			}
+		}()
+		value, err := action()
+		completed = true
+		act.completeFuture(f, value, err)
+		return nil
+	}); err != nil {
+		act.completeFuture(f, nil, err)
+	}
+	return f
+}
+
+// addFuture registers a pending future. If the Actor already
+// terminated the future is completed with its error and false
+// is returned.
+func (act *Actor) addFuture(f *Future) bool {
+	act.fmu.Lock()
+	defer act.fmu.Unlock()
+	if act.ferr != nil {
+		f.complete(nil, act.ferr)
+		return false
+	}
+	act.futures[f] = struct{}{}
+	return true
+}
+
+// completeFuture completes the future and removes it from the
+// pending ones.
+func (act *Actor) completeFuture(f *Future, value interface{}, err error) {
+	act.fmu.Lock()
+	defer act.fmu.Unlock()
+	delete(act.futures, f)
+	f.complete(value, err)
+}
+
+// terminateFutures completes the pending futures and those created
+// later with the error the Actor terminated with.
+func (act *Actor) terminateFutures(err error) {
+	act.fmu.Lock()
+	defer act.fmu.Unlock()
+	if err != nil {
+		act.ferr = failure.Annotate(err, "actor terminated")
+	} else {
+		act.ferr = failure.New("actor terminated")
+	}
+	for f := range act.futures {
+		f.complete(nil, act.ferr)
+	}
+	act.futures = make(map[*Future]struct{})
+}
+
+//--------------------
+// BATCH
+//--------------------
+
+// Batch combines multiple futures to wait for all of them.
+type Batch struct {
+	futures []*Future
+}
+
+// NewBatch creates a batch of the passed futures.
+func NewBatch(futures ...*Future) *Batch {
+	return &Batch{
+		futures: futures,
+	}
+}
+
+// Add adds futures to the batch.
+func (b *Batch) Add(futures ...*Future) {
+	b.futures = append(b.futures, futures...)
+}
+
+// Len returns the number of futures of the batch.
+func (b *Batch) Len() int {
+	return len(b.futures)
+}
+
+// Done returns a channel which is closed when all actions of
+// the batch are done. As futures are completed when their Actor
+// terminates this always happens.
+func (b *Batch) Done() <-chan struct{} {
+	doneC := make(chan struct{})
+	futures := append([]*Future{}, b.futures...)
+	go func() {
+		for _, f := range futures {
+			<-f.Done()
+		}
+		close(doneC)
+	}()
+	return doneC
+}
+
+// Wait waits until all actions of the batch are done or the context
+// is done. It returns the values in the order of the futures and the
+// first error of them or the error of the context.
+func (b *Batch) Wait(ctx context.Context) ([]interface{}, error) {
+	values := make([]interface{}, len(b.futures))
+	var first error
+	for i, f := range b.futures {
+		if !f.wait(ctx) {
+			return nil, ctx.Err()
+		}
+		if f.err != nil && first == nil {
+			first = f.err
+		}
+		values[i] = f.value
+	}
+	return values, first
+}
+
+// EOF
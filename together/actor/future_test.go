@@ -0,0 +1,201 @@
+// Tideland Go Library - Together - Actor - Unit Tests
+//
+// Copyright (C) 2017-2019 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license.
+
+package actor_test
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"tideland.dev/go/audit/asserts"
+	"tideland.dev/go/together/actor"
+)
+
+//--------------------
+// TESTS
+//--------------------
+
+// TestFuture tests retrieving values and errors of actions
+// with futures.
+func TestFuture(t *testing.T) {
+	assert := asserts.NewTesting(t, asserts.FailStop)
+	act := actor.New().Go()
+	defer act.Stop(nil)
+	ctx := context.Background()
+
+	counter := 0
+	incr := func() (interface{}, error) {
+		counter++
+		return counter, nil
+	}
+
+	f := act.DoFuture(incr)
+	value, err := f.Wait(ctx)
+	assert.NoError(err)
+	assert.Equal(value, 1)
+	<-f.Done()
+	assert.NoError(f.Err())
+
+	// Errors are returned by the future, the actor continues.
+	f = act.DoFuture(func() (interface{}, error) {
+		return nil, errors.New("ouch")
+	})
+	value, err = f.Wait(ctx)
+	assert.ErrorMatch(err, "ouch")
+	assert.Nil(value)
+	assert.ErrorMatch(f.Err(), "ouch")
+	assert.NoError(act.Err())
+
+	f = act.DoFuture(incr)
+	value, err = f.Wait(ctx)
+	assert.NoError(err)
+	assert.Equal(value, 2)
+}
+
+// TestFutureTimeout tests waiting for a future with a context
+// being done before.
+func TestFutureTimeout(t *testing.T) {
+	assert := asserts.NewTesting(t, asserts.FailStop)
+	act := actor.New().Go()
+	defer act.Stop(nil)
+	waitC := make(chan struct{})
+
+	f := act.DoFuture(func() (interface{}, error) {
+		<-waitC
+		return "done", nil
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	value, err := f.Wait(ctx)
+	assert.Equal(err, context.DeadlineExceeded)
+	assert.Nil(value)
+	assert.NoError(f.Err())
+
+	close(waitC)
+	value, err = f.Wait(context.Background())
+	assert.NoError(err)
+	assert.Equal(value, "done")
+}
+
+// TestFuturePanic tests a panicking value action.
+func TestFuturePanic(t *testing.T) {
+	assert := asserts.NewTesting(t, asserts.FailStop)
+	act := actor.New(actor.WithRecoverer(func(reason interface{}) error {
+		return nil
+	})).Go()
+	defer act.Stop(nil)
+
+	f := act.DoFuture(func() (interface{}, error) {
+		panic("ouch")
+	})
+	value, err := f.Wait(context.Background())
+	assert.ErrorMatch(err, ".*value action panicked: ouch.*")
+	assert.Nil(value)
+
+	f = act.DoFuture(func() (interface{}, error) {
+		return 42, nil
+	})
+	value, err = f.Wait(context.Background())
+	assert.NoError(err)
+	assert.Equal(value, 42)
+}
+
+// TestFutureTerminated tests futures of an Actor terminating before
+// their actions are done.
+func TestFutureTerminated(t *testing.T) {
+	assert := asserts.NewTesting(t, asserts.FailStop)
+	act := actor.New(actor.WithQueueLen(10)).Go()
+	defer act.Stop(nil)
+	waitC := make(chan struct{})
+
+	err := act.DoAsync(func() error {
+		<-waitC
+		return errors.New("ouch")
+	})
+	assert.NoError(err)
+	f := act.DoFuture(func() (interface{}, error) {
+		return 42, nil
+	})
+	b := actor.NewBatch(f)
+	close(waitC)
+
+	<-b.Done()
+	value, err := f.Wait(context.Background())
+	assert.ErrorMatch(err, ".*actor terminated: ouch.*")
+	assert.Nil(value)
+
+	// Futures of the terminated Actor are done immediately.
+	f = act.DoFuture(func() (interface{}, error) {
+		return 42, nil
+	})
+	value, err = f.Wait(context.Background())
+	assert.ErrorMatch(err, ".*actor terminated: ouch.*")
+	assert.Nil(value)
+}
+
+// TestBatch tests waiting for multiple futures.
+func TestBatch(t *testing.T) {
+	assert := asserts.NewTesting(t, asserts.FailStop)
+	act := actor.New().Go()
+	defer act.Stop(nil)
+	ctx := context.Background()
+
+	square := func(i int) actor.ValueAction {
+		return func() (interface{}, error) {
+			if i < 0 {
+				return nil, errors.New("negative")
+			}
+			return i * i, nil
+		}
+	}
+
+	b := actor.NewBatch()
+	for i := 1; i <= 5; i++ {
+		b.Add(act.DoFuture(square(i)))
+	}
+	assert.Equal(b.Len(), 5)
+	<-b.Done()
+	values, err := b.Wait(ctx)
+	assert.NoError(err)
+	assert.Equal(values, []interface{}{1, 4, 9, 16, 25})
+
+	// First error is returned, values of the others too.
+	b = actor.NewBatch(act.DoFuture(square(2)), act.DoFuture(square(-1)), act.DoFuture(square(3)))
+	values, err = b.Wait(ctx)
+	assert.ErrorMatch(err, "negative")
+	assert.Equal(values, []interface{}{4, nil, 9})
+
+	// Done futures are returned even if the context is done.
+	b = actor.NewBatch(act.DoFuture(square(2)), act.DoFuture(square(3)))
+	<-b.Done()
+	doneCtx, doneCancel := context.WithCancel(ctx)
+	doneCancel()
+	values, err = b.Wait(doneCtx)
+	assert.NoError(err)
+	assert.Equal(values, []interface{}{4, 9})
+
+	// Context is done before.
+	waitC := make(chan struct{})
+	defer close(waitC)
+	b = actor.NewBatch(act.DoFuture(func() (interface{}, error) {
+		<-waitC
+		return nil, nil
+	}))
+	ctx, cancel := context.WithCancel(ctx)
+	cancel()
+	values, err = b.Wait(ctx)
+	assert.Equal(err, context.Canceled)
+	assert.Nil(values)
+}
+
+// EOF
@@ -16,6 +16,7 @@ import (
 
 	"tideland.dev/go/together/loop"
 	"tideland.dev/go/together/notifier"
+	"tideland.dev/go/trace/failure"
 )
 
 //--------------------
@@ -66,7 +67,10 @@ func WithNotifier(notifier *notifier.Notifier) Option {
 // work of a Loop.
 func WithFinalizer(finalizer loop.Finalizer) Option {
 	return func(act *Actor) error {
-		act.options = append(act.options, loop.WithFinalizer(finalizer))
+		if finalizer == nil {
+			return failure.New("invalid actor option: finalizer is nil")
+		}
+		act.finalizer = finalizer
 		return nil
 	}
 }
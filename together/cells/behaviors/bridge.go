@@ -0,0 +1,445 @@
+// Tideland Go Library - Together - Cells - Behaviors
+//
+// Copyright (C) 2010-2019 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license.
+
+package behaviors // import "tideland.dev/go/together/cells/behaviors"
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"tideland.dev/go/together/cells/event"
+	"tideland.dev/go/together/cells/mesh"
+	"tideland.dev/go/together/loop"
+	"tideland.dev/go/together/notifier"
+	"tideland.dev/go/trace/failure"
+)
+
+//--------------------
+// CONSTANTS
+//--------------------
+
+const (
+	// bridgeReconnectDelay is the time the sender waits before
+	// it tries to connect the receiver again.
+	bridgeReconnectDelay = 100 * time.Millisecond
+
+	// bridgeDialTimeout limits the time for connecting the receiver.
+	bridgeDialTimeout = 5 * time.Second
+
+	// bridgeMaxFrameSize limits the size of one frame.
+	bridgeMaxFrameSize = 16 * 1024 * 1024
+
+	// DefaultBridgeCapacity is the default number of events a bridge
+	// sender keeps until they are acknowledged.
+	DefaultBridgeCapacity = 1024
+)
+
+//--------------------
+// FRAMES
+//--------------------
+
+// bridgeFrame is transported between sender and receiver. Frames
+// sent by the sender contain an event, frames sent back by the
+// receiver acknowledge all events up to the sequence number.
+type bridgeFrame struct {
+	Seq   uint64       `json:"seq"`
+	Event *event.Event `json:"event,omitempty"`
+}
+
+// encodeFrame encodes a frame as JSON prefixed by its length as
+// 32 bit big endian value.
+func encodeFrame(frame *bridgeFrame) ([]byte, error) {
+	data, err := json.Marshal(frame)
+	if err != nil {
+		return nil, failure.Annotate(err, "cannot marshal frame %d", frame.Seq)
+	}
+	if len(data) > bridgeMaxFrameSize {
+		return nil, failure.New("frame size %d exceeds maximum", len(data))
+	}
+	buf := make([]byte, 4+len(data))
+	binary.BigEndian.PutUint32(buf, uint32(len(data)))
+	copy(buf[4:], data)
+	return buf, nil
+}
+
+// writeFrame encodes and writes a frame.
+func writeFrame(w io.Writer, frame *bridgeFrame) error {
+	buf, err := encodeFrame(frame)
+	if err != nil {
+		return err
+	}
+	if _, err = w.Write(buf); err != nil {
+		return failure.Annotate(err, "cannot write frame %d", frame.Seq)
+	}
+	return nil
+}
+
+// readFrame reads one length prefixed frame.
+func readFrame(r io.Reader) (*bridgeFrame, error) {
+	var size [4]byte
+	if _, err := io.ReadFull(r, size[:]); err != nil {
+		return nil, err
+	}
+	l := binary.BigEndian.Uint32(size[:])
+	if l > bridgeMaxFrameSize {
+		return nil, failure.New("frame size %d exceeds maximum", l)
+	}
+	data := make([]byte, l)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, err
+	}
+	var frame bridgeFrame
+	if err := json.Unmarshal(data, &frame); err != nil {
+		return nil, failure.Annotate(err, "cannot unmarshal frame")
+	}
+	return &frame, nil
+}
+
+//--------------------
+// BRIDGE SENDER BEHAVIOR
+//--------------------
+
+// BridgeSenderConfig allows to control how the bridge sender keeps
+// the events until they are acknowledged by the receiver. All values
+// are optional.
+type BridgeSenderConfig struct {
+	// Capacity limits the number of kept events. By default it
+	// is DefaultBridgeCapacity.
+	Capacity int
+
+	// Overflow defines how new events are handled if the capacity
+	// is reached, e.g. while the receiver is not connected. Valid are
+	// mesh.OverflowDropNewest, mesh.OverflowDropOldest, and the default
+	// mesh.OverflowReject, which routes the events to the dead-letter
+	// cell.
+	Overflow mesh.OverflowPolicy
+}
+
+// bridgePending is an encoded frame waiting for its acknowledgement.
+type bridgePending struct {
+	seq  uint64
+	data []byte
+}
+
+// bridgeSenderBehavior forwards the received events to a bridge
+// receiver in another process.
+type bridgeSenderBehavior struct {
+	id       string
+	address  string
+	capacity int
+	overflow mesh.OverflowPolicy
+	mu       sync.Mutex
+	seq      uint64
+	pending  []*bridgePending
+	sendc    chan struct{}
+	loop     *loop.Loop
+}
+
+// NewBridgeSenderBehavior creates a behavior forwarding all received
+// events via TCP to the bridge receiver listening at the given address.
+// So cells of the local mesh can emit events to the cells subscribed
+// to the receiver in a remote mesh. If the connection breaks the
+// sender reconnects and sends all events not yet acknowledged again.
+// So the delivery is at-least-once, receivers may get duplicates.
+func NewBridgeSenderBehavior(id, address string) mesh.Behavior {
+	return NewConfiguredBridgeSenderBehavior(id, address, nil)
+}
+
+// NewConfiguredBridgeSenderBehavior creates a bridge sender like
+// NewBridgeSenderBehavior but controlled by the configuration. Events
+// which cannot be encoded are routed to the dead-letter cell.
+func NewConfiguredBridgeSenderBehavior(id, address string, config *BridgeSenderConfig) mesh.Behavior {
+	b := &bridgeSenderBehavior{
+		id:       id,
+		address:  address,
+		capacity: DefaultBridgeCapacity,
+		overflow: mesh.OverflowReject,
+		sendc:    make(chan struct{}, 1),
+	}
+	if config != nil {
+		if config.Capacity > 0 {
+			b.capacity = config.Capacity
+		}
+		if config.Overflow != 0 {
+			b.overflow = config.Overflow
+		}
+	}
+	return b
+}
+
+// ID returns the individual identifier of a behavior instance.
+func (b *bridgeSenderBehavior) ID() string {
+	return b.id
+}
+
+// Init the behavior.
+func (b *bridgeSenderBehavior) Init(emitter mesh.Emitter) error {
+	switch b.overflow {
+	case mesh.OverflowDropNewest, mesh.OverflowDropOldest, mesh.OverflowReject:
+	default:
+		return failure.New("invalid bridge overflow policy %d", b.overflow)
+	}
+	b.loop = loop.New(b.connectLoop).Go()
+	return nil
+}
+
+// Terminate the behavior.
+func (b *bridgeSenderBehavior) Terminate() error {
+	return b.loop.Stop(nil)
+}
+
+// Process encodes the event and queues it for sending. Errors
+// are routed to the dead-letter cell by the mesh.
+func (b *bridgeSenderBehavior) Process(evt *event.Event) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if len(b.pending) >= b.capacity {
+		switch b.overflow {
+		case mesh.OverflowDropNewest:
+			return nil
+		case mesh.OverflowDropOldest:
+			b.pending[0] = nil
+			b.pending = b.pending[1:]
+		default:
+			return failure.New("bridge %q has %d unacknowledged events", b.id, len(b.pending))
+		}
+	}
+	data, err := encodeFrame(&bridgeFrame{
+		Seq:   b.seq + 1,
+		Event: evt,
+	})
+	if err != nil {
+		return err
+	}
+	b.seq++
+	b.pending = append(b.pending, &bridgePending{
+		seq:  b.seq,
+		data: data,
+	})
+	b.signal()
+	return nil
+}
+
+// Recover from an error.
+func (b *bridgeSenderBehavior) Recover(err interface{}) error {
+	return nil
+}
+
+// signal notifies the connection about new events.
+func (b *bridgeSenderBehavior) signal() {
+	select {
+	case b.sendc <- struct{}{}:
+	default:
+	}
+}
+
+// unsent returns the pending frames after the given sequence number.
+func (b *bridgeSenderBehavior) unsent(sent uint64) []*bridgePending {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var frames []*bridgePending
+	for _, frame := range b.pending {
+		if frame.seq > sent {
+			frames = append(frames, frame)
+		}
+	}
+	return frames
+}
+
+// acknowledge removes all pending frames up to the given
+// sequence number.
+func (b *bridgeSenderBehavior) acknowledge(seq uint64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	idx := 0
+	for idx < len(b.pending) && b.pending[idx].seq <= seq {
+		b.pending[idx] = nil
+		idx++
+	}
+	b.pending = b.pending[idx:]
+}
+
+// connectLoop connects the receiver and reconnects it after
+// failures.
+func (b *bridgeSenderBehavior) connectLoop(c *notifier.Closer) error {
+	for {
+		conn, err := net.DialTimeout("tcp", b.address, bridgeDialTimeout)
+		if err == nil {
+			b.session(c, conn)
+		}
+		select {
+		case <-c.Done():
+			return nil
+		case <-time.After(bridgeReconnectDelay):
+		}
+	}
+}
+
+// session sends the pending events over one connection until it
+// fails or the behavior terminates.
+func (b *bridgeSenderBehavior) session(c *notifier.Closer, conn net.Conn) {
+	donec := make(chan struct{})
+	defer close(donec)
+	errc := make(chan error, 1)
+	go func() {
+		// Close a blocking connection when terminating.
+		select {
+		case <-c.Done():
+		case <-donec:
+		}
+		conn.Close()
+	}()
+	go func() {
+		r := bufio.NewReader(conn)
+		for {
+			frame, err := readFrame(r)
+			if err != nil {
+				errc <- err
+				return
+			}
+			b.acknowledge(frame.Seq)
+		}
+	}()
+	// All unacknowledged events are sent again after reconnecting.
+	var sent uint64
+	for {
+		for _, frame := range b.unsent(sent) {
+			if _, err := conn.Write(frame.data); err != nil {
+				return
+			}
+			sent = frame.seq
+		}
+		select {
+		case <-c.Done():
+			return
+		case <-errc:
+			return
+		case <-b.sendc:
+		}
+	}
+}
+
+//--------------------
+// BRIDGE RECEIVER BEHAVIOR
+//--------------------
+
+// bridgeReceiverBehavior receives events from bridge senders in
+// other processes and emits them to its subscribers.
+type bridgeReceiverBehavior struct {
+	id       string
+	emitter  mesh.Emitter
+	address  string
+	listener net.Listener
+	mu       sync.Mutex
+	conns    map[net.Conn]struct{}
+}
+
+// NewBridgeReceiverBehavior creates a behavior listening at the given
+// TCP address for bridge senders. All events it receives from them are
+// emitted to the subscribers. Each event is acknowledged to the sender
+// after it has been queued for the receiver. With a full mailbox the
+// receiver waits, if it rejects or spills the event the connection is
+// closed without acknowledgement so that the sender sends it again.
+// Dropping overflow policies lose events.
+func NewBridgeReceiverBehavior(id, address string) mesh.Behavior {
+	return &bridgeReceiverBehavior{
+		id:      id,
+		address: address,
+		conns:   map[net.Conn]struct{}{},
+	}
+}
+
+// ID returns the individual identifier of a behavior instance.
+func (b *bridgeReceiverBehavior) ID() string {
+	return b.id
+}
+
+// Init the behavior.
+func (b *bridgeReceiverBehavior) Init(emitter mesh.Emitter) error {
+	listener, err := net.Listen("tcp", b.address)
+	if err != nil {
+		return failure.Annotate(err, "cannot listen at %q", b.address)
+	}
+	b.emitter = emitter
+	b.listener = listener
+	go b.acceptLoop()
+	return nil
+}
+
+// Terminate the behavior.
+func (b *bridgeReceiverBehavior) Terminate() error {
+	err := b.listener.Close()
+	b.mu.Lock()
+	for conn := range b.conns {
+		conn.Close()
+	}
+	b.mu.Unlock()
+	return err
+}
+
+// Process emits the received events to all subscribers.
+func (b *bridgeReceiverBehavior) Process(evt *event.Event) error {
+	return b.emitter.Broadcast(evt)
+}
+
+// Recover from an error.
+func (b *bridgeReceiverBehavior) Recover(err interface{}) error {
+	return nil
+}
+
+// acceptLoop accepts the connections of the senders until the
+// listener is closed.
+func (b *bridgeReceiverBehavior) acceptLoop() {
+	for {
+		conn, err := b.listener.Accept()
+		if err != nil {
+			return
+		}
+		b.mu.Lock()
+		b.conns[conn] = struct{}{}
+		b.mu.Unlock()
+		go b.receive(conn)
+	}
+}
+
+// receive reads the frames of one sender. The events are emitted
+// to the receiver itself to avoid races when subscribers are updated.
+// Only events queued in its mailbox are acknowledged.
+func (b *bridgeReceiverBehavior) receive(conn net.Conn) {
+	defer func() {
+		b.mu.Lock()
+		delete(b.conns, conn)
+		b.mu.Unlock()
+		conn.Close()
+	}()
+	r := bufio.NewReader(conn)
+	for {
+		frame, err := readFrame(r)
+		if err != nil {
+			return
+		}
+		if frame.Event != nil {
+			if err := b.emitter.Self(frame.Event); err != nil {
+				return
+			}
+		}
+		if err := writeFrame(conn, &bridgeFrame{Seq: frame.Seq}); err != nil {
+			return
+		}
+	}
+}
+
+// EOF
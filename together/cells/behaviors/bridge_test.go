@@ -0,0 +1,270 @@
+// Tideland Go Library - Together - Cells - Behaviors - Unit Tests
+//
+// Copyright (C) 2010-2019 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license.
+
+package behaviors_test // import "tideland.dev/go/together/cells/behaviors"
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"tideland.dev/go/audit/asserts"
+	"tideland.dev/go/together/cells/behaviors"
+	"tideland.dev/go/together/cells/event"
+	"tideland.dev/go/together/cells/mesh"
+)
+
+//--------------------
+// TESTS
+//--------------------
+
+// TestBridgeBehaviors tests the bridging of two meshes.
+func TestBridgeBehaviors(t *testing.T) {
+	assert := asserts.NewTesting(t, asserts.FailStop)
+	address := freeAddress(assert)
+	evtc := make(chan *event.Event, 10)
+	mshA := mesh.New()
+	defer mshA.Stop()
+	mshB := mesh.New()
+	defer mshB.Stop()
+
+	err := mshB.SpawnCells(
+		behaviors.NewBridgeReceiverBehavior("in", address),
+		newBridgeCollector("collector", evtc),
+	)
+	assert.NoError(err)
+	err = mshB.Subscribe("in", "collector")
+	assert.NoError(err)
+
+	err = mshA.SpawnCells(
+		behaviors.NewBroadcasterBehavior("source"),
+		behaviors.NewBridgeSenderBehavior("out", address),
+	)
+	assert.NoError(err)
+	err = mshA.Subscribe("source", "out")
+	assert.NoError(err)
+
+	now := time.Now()
+	mshA.Emit("source", event.New("a", "value", 1))
+	mshA.Emit("source", event.New("b", "value", "two", "time", now))
+	mshA.Emit("source", event.New("c", "nested", event.NewPayload("value", 3.0)))
+
+	evt := waitBridged(assert, evtc)
+	assert.Equal(evt.Topic(), "a")
+	assert.Equal(evt.Payload().At("value").AsInt(0), 1)
+	evt = waitBridged(assert, evtc)
+	assert.Equal(evt.Topic(), "b")
+	assert.Equal(evt.Payload().At("value").AsString(""), "two")
+	assert.True(evt.Payload().At("time").AsTime(time.Time{}).Equal(now))
+	evt = waitBridged(assert, evtc)
+	assert.Equal(evt.Topic(), "c")
+	assert.Equal(evt.Payload().At("nested", "value").AsFloat64(0.0), 3.0)
+}
+
+// TestBridgeReconnect tests the reconnecting of a bridge sender
+// and the sending of unacknowledged events.
+func TestBridgeReconnect(t *testing.T) {
+	assert := asserts.NewTesting(t, asserts.FailStop)
+	address := freeAddress(assert)
+	evtc := make(chan *event.Event, 10)
+	mshA := mesh.New()
+	defer mshA.Stop()
+	mshB := mesh.New()
+	defer mshB.Stop()
+
+	// Sender starts without receiver.
+	err := mshA.SpawnCells(behaviors.NewBridgeSenderBehavior("out", address))
+	assert.NoError(err)
+	mshA.Emit("out", event.New("a"))
+	mshA.Emit("out", event.New("b"))
+
+	err = mshB.SpawnCells(
+		behaviors.NewBridgeReceiverBehavior("in", address),
+		newBridgeCollector("collector", evtc),
+	)
+	assert.NoError(err)
+	err = mshB.Subscribe("in", "collector")
+	assert.NoError(err)
+
+	assert.Equal(waitBridged(assert, evtc).Topic(), "a")
+	assert.Equal(waitBridged(assert, evtc).Topic(), "b")
+
+	// Restart the receiver.
+	err = mshB.StopCells("in")
+	assert.NoError(err)
+	mshA.Emit("out", event.New("c"))
+	err = mshB.SpawnCells(behaviors.NewBridgeReceiverBehavior("in", address))
+	assert.NoError(err)
+	err = mshB.Subscribe("in", "collector")
+	assert.NoError(err)
+	mshA.Emit("out", event.New("d"))
+
+	// At-least-once, so unacknowledged events may be received twice.
+	var topics []string
+	for len(topics) == 0 || topics[len(topics)-1] != "d" {
+		topic := waitBridged(assert, evtc).Topic()
+		switch topic {
+		case "a", "b":
+			assert.Empty(topics)
+		default:
+			topics = append(topics, topic)
+		}
+	}
+	assert.Contains(topics, "c")
+}
+
+// TestBridgeBackpressure tests that events are only acknowledged
+// when they are queued by the receiver.
+func TestBridgeBackpressure(t *testing.T) {
+	assert := asserts.NewTesting(t, asserts.FailStop)
+	address := freeAddress(assert)
+	evtc := make(chan *event.Event, 10)
+	mshA := mesh.New()
+	defer mshA.Stop()
+	mshB := mesh.New()
+	defer mshB.Stop()
+
+	err := mshB.SpawnCell(behaviors.NewBridgeReceiverBehavior("in", address), mesh.WithMailbox(2, mesh.OverflowBlock))
+	assert.NoError(err)
+	err = mshB.SpawnCell(newBridgeCollector("collector", evtc), mesh.WithMailbox(2, mesh.OverflowBlock))
+	assert.NoError(err)
+	err = mshB.Subscribe("in", "collector")
+	assert.NoError(err)
+	err = mshA.SpawnCells(behaviors.NewBridgeSenderBehavior("out", address))
+	assert.NoError(err)
+
+	const count = 200
+	for i := 0; i < count; i++ {
+		mshA.Emit("out", event.New("n", "n", i))
+	}
+	received := map[int]bool{}
+	for len(received) < count {
+		evt := waitBridged(assert, evtc)
+		received[evt.Payload().At("n").AsInt(-1)] = true
+	}
+	assert.Length(received, count)
+}
+
+// TestBridgeDeadLetters tests the routing of events which cannot be
+// encoded or exceed the capacity to the dead-letter cell.
+func TestBridgeDeadLetters(t *testing.T) {
+	assert := asserts.NewTesting(t, asserts.FailStop)
+	address := freeAddress(assert)
+	evtc := make(chan *event.Event, 10)
+	deadc := make(chan *event.Event, 10)
+	mshA := mesh.New(mesh.WithDeadLetterCell("dead"))
+	defer mshA.Stop()
+	mshB := mesh.New()
+	defer mshB.Stop()
+
+	// Sender starts without receiver.
+	err := mshA.SpawnCells(
+		newBridgeCollector("dead", deadc),
+		behaviors.NewConfiguredBridgeSenderBehavior("out", address, &behaviors.BridgeSenderConfig{
+			Capacity: 2,
+		}),
+	)
+	assert.NoError(err)
+	mshA.Emit("out", event.New("a"))
+	mshA.Emit("out", event.New("unencodable", "f", func() {}))
+	mshA.Emit("out", event.New("b"))
+	mshA.Emit("out", event.New("c"))
+
+	evt := waitBridged(assert, deadc)
+	assert.Equal(evt.Payload().At("topic").AsString(""), "unencodable")
+	assert.Match(evt.Payload().At("error").AsString(""), ".*cannot marshal frame.*")
+	evt = waitBridged(assert, deadc)
+	assert.Equal(evt.Payload().At("topic").AsString(""), "c")
+	assert.Match(evt.Payload().At("error").AsString(""), ".*has 2 unacknowledged events.*")
+
+	err = mshB.SpawnCells(
+		behaviors.NewBridgeReceiverBehavior("in", address),
+		newBridgeCollector("collector", evtc),
+	)
+	assert.NoError(err)
+	err = mshB.Subscribe("in", "collector")
+	assert.NoError(err)
+
+	assert.Equal(waitBridged(assert, evtc).Topic(), "a")
+	assert.Equal(waitBridged(assert, evtc).Topic(), "b")
+
+	err = mshA.SpawnCells(behaviors.NewConfiguredBridgeSenderBehavior("invalid", address, &behaviors.BridgeSenderConfig{
+		Overflow: mesh.OverflowBlock,
+	}))
+	assert.NotNil(err)
+}
+
+// TestBridgeDropOldest tests dropping the oldest events while
+// the receiver is not connected.
+func TestBridgeDropOldest(t *testing.T) {
+	assert := asserts.NewTesting(t, asserts.FailStop)
+	address := freeAddress(assert)
+	evtc := make(chan *event.Event, 10)
+	mshA := mesh.New()
+	defer mshA.Stop()
+	mshB := mesh.New()
+	defer mshB.Stop()
+
+	err := mshA.SpawnCells(behaviors.NewConfiguredBridgeSenderBehavior("out", address, &behaviors.BridgeSenderConfig{
+		Capacity: 2,
+		Overflow: mesh.OverflowDropOldest,
+	}))
+	assert.NoError(err)
+	mshA.Emit("out", event.New("a"))
+	mshA.Emit("out", event.New("b"))
+	mshA.Emit("out", event.New("c"))
+
+	err = mshB.SpawnCells(
+		behaviors.NewBridgeReceiverBehavior("in", address),
+		newBridgeCollector("collector", evtc),
+	)
+	assert.NoError(err)
+	err = mshB.Subscribe("in", "collector")
+	assert.NoError(err)
+
+	assert.Equal(waitBridged(assert, evtc).Topic(), "b")
+	assert.Equal(waitBridged(assert, evtc).Topic(), "c")
+}
+
+//--------------------
+// HELPERS
+//--------------------
+
+// freeAddress returns a currently free loopback address.
+func freeAddress(assert *asserts.Asserts) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(err)
+	address := l.Addr().String()
+	assert.NoError(l.Close())
+	return address
+}
+
+// waitBridged waits for the next bridged event.
+func waitBridged(assert *asserts.Asserts, evtc chan *event.Event) *event.Event {
+	select {
+	case evt := <-evtc:
+		return evt
+	case <-time.After(5 * time.Second):
+		assert.Fail("no bridged event received")
+	}
+	return nil
+}
+
+// newBridgeCollector creates a behavior passing the received
+// events to a channel.
+func newBridgeCollector(id string, evtc chan *event.Event) mesh.Behavior {
+	return behaviors.NewSimpleProcessorBehavior(id, func(emitter mesh.Emitter, evt *event.Event) error {
+		evtc <- evt
+		return nil
+	})
+}
+
+// EOF
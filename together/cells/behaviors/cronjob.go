@@ -16,8 +16,6 @@ import (
 
 	"tideland.dev/go/together/cells/event"
 	"tideland.dev/go/together/cells/mesh"
-	"tideland.dev/go/together/loop"
-	"tideland.dev/go/together/notifier"
 )
 
 //--------------------
@@ -33,7 +31,7 @@ type cronjobBehavior struct {
 	emitter  mesh.Emitter
 	duration time.Duration
 	cronjob  Cronjob
-	loop     *loop.Loop
+	ticker   *selfTicker
 }
 
 // NewCronjobBehavior creates a ticker behavior for the emitting of
@@ -54,13 +52,14 @@ func (b *cronjobBehavior) ID() string {
 // Init the behavior.
 func (b *cronjobBehavior) Init(emitter mesh.Emitter) error {
 	b.emitter = emitter
-	b.loop = loop.New(b.tickerLoop).Go()
+	b.ticker = startSelfTicker(emitter, b.duration)
 	return nil
 }
 
 // Terminate the behavior.
 func (b *cronjobBehavior) Terminate() error {
-	return b.loop.Stop(nil)
+	b.ticker.stop()
+	return nil
 }
 
 // Process emits a ticker event each time the defined duration elapsed.
@@ -76,19 +75,4 @@ func (b *cronjobBehavior) Recover(err interface{}) error {
 	return nil
 }
 
-// tickerLoop is the sending a tick event to itself. It acts there to
-// avoid races when subscribers are updated.
-func (b *cronjobBehavior) tickerLoop(c *notifier.Closer) error {
-	ticker := time.NewTicker(b.duration)
-	defer ticker.Stop()
-	for {
-		select {
-		case <-c.Done():
-			return nil
-		case <-ticker.C:
-			b.emitter.Self(event.New(TopicTick))
-		}
-	}
-}
-
 // EOF
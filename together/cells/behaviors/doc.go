@@ -14,6 +14,9 @@
 //
 // Aggregator aggregates events and emits each aggregated value.
 //
+// Bridge Sender and Bridge Receiver forward events via TCP between
+// meshes in different processes with at-least-once delivery.
+//
 // Broadcaster simply emits received events to all subscribers.
 //
 // Callback calls a number of passed functions for each received event.
@@ -35,10 +38,15 @@
 // Evaluator evaluates events based on a user-defined function which
 // returns a rating.
 //
-// Filter emits received events based on a user-defined filter.
+// Filter emits received events based on a user-defined filter. Filters,
+// condition testers, and routers can also be compiled out of expressions
+// like "topic == 'order' && order.amount > 100", e.g. from a configuration.
 //
 // Finite State Machine allows to build finite state machines for events.
 //
+// HTTP Client performs HTTP requests with all common methods, optionally
+// with timeouts, retries, and in parallel, and emits the responses.
+//
 // Logger logs received events with level INFO.
 //
 // Mapper maps received events based on a user-defined function to
@@ -52,12 +60,18 @@
 // Pair checks if the event stream contains two matching ones based on a
 // user-based criterion in a given timespan.
 //
+// Pattern checks the event stream per key for complex patterns like A
+// followed by B within a timespan and without C in between.
+//
 // Rate measures times between a number of criterion fitting events and
 // emits the result.
 //
 // Rate Window checks if a number of events in a given timespan matches
 // a given criterion.
 //
+// Redis Subscriber and Redis Publisher connect meshes to Redis
+// Pub/Sub channels.
+//
 // Round Robin distribtes the received events round robin to the subscribed
 // cells.
 //
@@ -72,6 +86,10 @@
 //
 // Ticker emits tick events in a defined interval.
 //
+// Tumbling, Sliding, and Session Window collect events by key in
+// windows based on their timestamps and process them when the
+// watermark passes the windows.
+//
 // Topic/Payloads collects the payloads of events by topics, processes
 // those, and emits the processed result.
 package behaviors // import "tideland.dev/go/together/cells/behaviors"
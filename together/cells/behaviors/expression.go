@@ -0,0 +1,635 @@
+// Tideland Go Library - Together - Cells - Behaviors
+//
+// Copyright (C) 2010-2019 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license.
+
+package behaviors // import "tideland.dev/go/together/cells/behaviors"
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"tideland.dev/go/together/cells/event"
+	"tideland.dev/go/trace/failure"
+)
+
+//--------------------
+// TOKENS
+//--------------------
+
+// exprTokenKind describes the kind of a token of an expression.
+type exprTokenKind int
+
+// List of token kinds.
+const (
+	tokenEOF exprTokenKind = iota
+	tokenIdent
+	tokenString
+	tokenNumber
+	tokenOperator
+)
+
+// exprToken is one token of an expression.
+type exprToken struct {
+	kind  exprTokenKind
+	text  string
+	value interface{}
+	pos   int
+}
+
+// exprOperators contains the operators, longer ones first.
+var exprOperators = []string{
+	"==", "!=", "<=", ">=", "=~", "!~", "&&", "||", "->",
+	"<", ">", "!", "(", ")", ",",
+}
+
+// tokenize splits the source of an expression into tokens.
+func tokenize(source string) ([]exprToken, error) {
+	var tokens []exprToken
+	rs := []rune(source)
+	pos := 0
+	for pos < len(rs) {
+		r := rs[pos]
+		switch {
+		case unicode.IsSpace(r):
+			pos++
+		case r == '"' || r == '\'':
+			end := pos + 1
+			for end < len(rs) && rs[end] != r {
+				if rs[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end >= len(rs) {
+				return nil, exprError(source, pos, "unterminated string")
+			}
+			raw := string(rs[pos+1 : end])
+			if r == '"' {
+				s, err := strconv.Unquote(`"` + raw + `"`)
+				if err != nil {
+					return nil, exprError(source, pos, "invalid string")
+				}
+				raw = s
+			} else {
+				raw = strings.Replace(raw, `\'`, `'`, -1)
+			}
+			tokens = append(tokens, exprToken{tokenString, string(rs[pos : end+1]), raw, pos})
+			pos = end + 1
+		case unicode.IsDigit(r) || (r == '-' && pos+1 < len(rs) && unicode.IsDigit(rs[pos+1])):
+			end := pos + 1
+			for end < len(rs) && (unicode.IsDigit(rs[end]) || rs[end] == '.') {
+				end++
+			}
+			text := string(rs[pos:end])
+			f, err := strconv.ParseFloat(text, 64)
+			if err != nil {
+				return nil, exprError(source, pos, "invalid number %q", text)
+			}
+			tokens = append(tokens, exprToken{tokenNumber, text, f, pos})
+			pos = end
+		case unicode.IsLetter(r) || r == '_':
+			end := pos + 1
+			for end < len(rs) && isIdentRune(rs[end]) {
+				end++
+			}
+			text := string(rs[pos:end])
+			tokens = append(tokens, exprToken{tokenIdent, text, text, pos})
+			pos = end
+		default:
+			found := false
+			for _, op := range exprOperators {
+				if strings.HasPrefix(string(rs[pos:]), op) {
+					tokens = append(tokens, exprToken{tokenOperator, op, op, pos})
+					pos += len([]rune(op))
+					found = true
+					break
+				}
+			}
+			if !found {
+				return nil, exprError(source, pos, "unexpected character %q", r)
+			}
+		}
+	}
+	tokens = append(tokens, exprToken{tokenEOF, "", nil, len(rs)})
+	return tokens, nil
+}
+
+// isIdentRune checks if the rune is allowed inside of identifiers
+// and paths like "order.items.0.status-code".
+func isIdentRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_' || r == '-' || r == '.'
+}
+
+// exprError creates an error for an invalid expression.
+func exprError(source string, pos int, msg string, args ...interface{}) error {
+	return failure.New("invalid expression %q at position %d: %s", source, pos, fmt.Sprintf(msg, args...))
+}
+
+//--------------------
+// NODES
+//--------------------
+
+// exprNode is one node of the expression tree.
+type exprNode interface {
+	eval(evt *event.Event) bool
+}
+
+// exprOperand returns a value out of the event or a literal.
+type exprOperand func(evt *event.Event) *event.Value
+
+// literalOperand returns a literal as value.
+func literalOperand(v interface{}) exprOperand {
+	value := event.NewPayload("literal", v).At("literal")
+	return func(evt *event.Event) *event.Value {
+		return value
+	}
+}
+
+// topicOperand returns the topic of the event as value.
+func topicOperand(evt *event.Event) *event.Value {
+	return event.NewPayload("topic", evt.Topic()).At("topic")
+}
+
+// pathOperand returns the payload value at the path.
+func pathOperand(path []string) exprOperand {
+	return func(evt *event.Event) *event.Value {
+		return evt.Payload().At(path...)
+	}
+}
+
+// orNode is true if one of its nodes is true.
+type orNode []exprNode
+
+func (n orNode) eval(evt *event.Event) bool {
+	for _, sub := range n {
+		if sub.eval(evt) {
+			return true
+		}
+	}
+	return false
+}
+
+// andNode is true if all of its nodes are true.
+type andNode []exprNode
+
+func (n andNode) eval(evt *event.Event) bool {
+	for _, sub := range n {
+		if !sub.eval(evt) {
+			return false
+		}
+	}
+	return true
+}
+
+// notNode negates its node.
+type notNode struct {
+	sub exprNode
+}
+
+func (n notNode) eval(evt *event.Event) bool {
+	return !n.sub.eval(evt)
+}
+
+// truthNode checks if an operand is defined and not false,
+// zero, or empty.
+type truthNode struct {
+	operand exprOperand
+}
+
+func (n truthNode) eval(evt *event.Event) bool {
+	v := n.operand(evt)
+	if v.IsUndefined() {
+		return false
+	}
+	switch v.AsString("") {
+	case "", "false", "0":
+		return false
+	}
+	return true
+}
+
+// compareNode compares two operands.
+type compareNode struct {
+	op    string
+	left  exprOperand
+	right exprOperand
+}
+
+func (n compareNode) eval(evt *event.Event) bool {
+	l := n.left(evt)
+	r := n.right(evt)
+	if l.IsUndefined() || r.IsUndefined() {
+		return n.op == "!="
+	}
+	var c int
+	lf := l.AsFloat64(math.NaN())
+	rf := r.AsFloat64(math.NaN())
+	if !math.IsNaN(lf) && !math.IsNaN(rf) {
+		switch {
+		case lf < rf:
+			c = -1
+		case lf > rf:
+			c = 1
+		}
+	} else {
+		c = strings.Compare(l.AsString(""), r.AsString(""))
+	}
+	switch n.op {
+	case "==":
+		return c == 0
+	case "!=":
+		return c != 0
+	case "<":
+		return c < 0
+	case "<=":
+		return c <= 0
+	case ">":
+		return c > 0
+	default:
+		return c >= 0
+	}
+}
+
+// matchNode matches an operand against a regular expression.
+type matchNode struct {
+	negate  bool
+	operand exprOperand
+	re      *regexp.Regexp
+}
+
+func (n matchNode) eval(evt *event.Event) bool {
+	v := n.operand(evt)
+	if v.IsUndefined() {
+		return n.negate
+	}
+	return n.re.MatchString(v.AsString("")) != n.negate
+}
+
+// inNode checks if an operand equals one of a list of operands.
+type inNode struct {
+	operand exprOperand
+	list    []exprOperand
+}
+
+func (n inNode) eval(evt *event.Event) bool {
+	for _, item := range n.list {
+		if (compareNode{"==", n.operand, item}).eval(evt) {
+			return true
+		}
+	}
+	return false
+}
+
+//--------------------
+// PARSER
+//--------------------
+
+// exprParser parses the tokens of an expression.
+type exprParser struct {
+	source string
+	tokens []exprToken
+	pos    int
+}
+
+// peek returns the current token.
+func (p *exprParser) peek() exprToken {
+	return p.tokens[p.pos]
+}
+
+// next returns the current token and moves forward.
+func (p *exprParser) next() exprToken {
+	t := p.tokens[p.pos]
+	if t.kind != tokenEOF {
+		p.pos++
+	}
+	return t
+}
+
+// is checks if the current token is one of the given operators
+// or keywords.
+func (p *exprParser) is(texts ...string) bool {
+	t := p.peek()
+	if t.kind != tokenOperator && t.kind != tokenIdent {
+		return false
+	}
+	for _, text := range texts {
+		if t.text == text {
+			return true
+		}
+	}
+	return false
+}
+
+// fail returns an error at the current token.
+func (p *exprParser) fail(msg string, args ...interface{}) error {
+	return exprError(p.source, p.peek().pos, msg, args...)
+}
+
+// parseOr parses alternatives combined with "||" or "or".
+func (p *exprParser) parseOr() (exprNode, error) {
+	node, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	nodes := orNode{node}
+	for p.is("||", "or") {
+		p.next()
+		node, err = p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, node)
+	}
+	if len(nodes) == 1 {
+		return nodes[0], nil
+	}
+	return nodes, nil
+}
+
+// parseAnd parses conditions combined with "&&" or "and".
+func (p *exprParser) parseAnd() (exprNode, error) {
+	node, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	nodes := andNode{node}
+	for p.is("&&", "and") {
+		p.next()
+		node, err = p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, node)
+	}
+	if len(nodes) == 1 {
+		return nodes[0], nil
+	}
+	return nodes, nil
+}
+
+// parseNot parses negations with "!" or "not".
+func (p *exprParser) parseNot() (exprNode, error) {
+	if p.is("!", "not") {
+		p.next()
+		node, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return notNode{node}, nil
+	}
+	return p.parseCondition()
+}
+
+// parseCondition parses parenthesized expressions, comparisons,
+// regular expression matches, lists, and single operands.
+func (p *exprParser) parseCondition() (exprNode, error) {
+	if p.is("(") {
+		p.next()
+		node, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if !p.is(")") {
+			return nil, p.fail("missing closing parenthesis")
+		}
+		p.next()
+		return node, nil
+	}
+	left, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case p.is("==", "!=", "<", "<=", ">", ">="):
+		op := p.next().text
+		right, err := p.parseOperand()
+		if err != nil {
+			return nil, err
+		}
+		return compareNode{op, left, right}, nil
+	case p.is("=~", "!~"):
+		op := p.next().text
+		t := p.next()
+		if t.kind != tokenString {
+			return nil, exprError(p.source, t.pos, "regular expression has to be a string")
+		}
+		re, err := regexp.Compile(t.value.(string))
+		if err != nil {
+			return nil, exprError(p.source, t.pos, "invalid regular expression: %v", err)
+		}
+		return matchNode{op == "!~", left, re}, nil
+	case p.is("in"):
+		p.next()
+		if !p.is("(") {
+			return nil, p.fail("missing list")
+		}
+		p.next()
+		var list []exprOperand
+		for {
+			item, err := p.parseOperand()
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, item)
+			if p.is(")") {
+				p.next()
+				return inNode{left, list}, nil
+			}
+			if !p.is(",") {
+				return nil, p.fail("missing comma or closing parenthesis")
+			}
+			p.next()
+		}
+	}
+	return truthNode{left}, nil
+}
+
+// parseOperand parses a literal, the topic, or a payload path.
+func (p *exprParser) parseOperand() (exprOperand, error) {
+	t := p.peek()
+	switch t.kind {
+	case tokenString, tokenNumber:
+		p.next()
+		return literalOperand(t.value), nil
+	case tokenIdent:
+		switch t.text {
+		case "and", "or", "not", "in":
+			return nil, p.fail("unexpected keyword %q", t.text)
+		case "true", "false":
+			p.next()
+			return literalOperand(t.text == "true"), nil
+		case "topic":
+			p.next()
+			return topicOperand, nil
+		}
+		p.next()
+		path := strings.Split(strings.TrimPrefix(t.text, "payload."), ".")
+		for _, key := range path {
+			if key == "" {
+				return nil, exprError(p.source, t.pos, "invalid path %q", t.text)
+			}
+		}
+		return pathOperand(path), nil
+	case tokenEOF:
+		return nil, p.fail("unexpected end")
+	}
+	return nil, p.fail("unexpected %q", t.text)
+}
+
+//--------------------
+// EXPRESSION
+//--------------------
+
+// Expression is a compiled condition for events. It allows to define
+// filters, conditions, and routes in configurations instead of code.
+type Expression struct {
+	source string
+	root   exprNode
+}
+
+// CompileExpression compiles the source of an expression. It supports
+//
+//   - the event topic as "topic",
+//   - payload values by paths like "order.items.0.id", the prefix
+//     "payload." is optional but needed for the key "topic",
+//   - string literals in single or double quotes, numbers, true, and
+//     false,
+//   - the comparisons ==, !=, <, <=, >, and >=, numerically if both
+//     values are numbers, otherwise as strings,
+//   - regular expression matches with =~ and !~,
+//   - lists like "topic in ('a', 'b')",
+//   - single operands being true if they are defined and not false,
+//     zero, or empty, and
+//   - ! or not, && or and, || or or, and parentheses.
+//
+// Undefined payload values are never equal to anything.
+func CompileExpression(source string) (*Expression, error) {
+	tokens, err := tokenize(source)
+	if err != nil {
+		return nil, err
+	}
+	p := &exprParser{
+		source: source,
+		tokens: tokens,
+	}
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.peek().kind != tokenEOF {
+		return nil, p.fail("unexpected %q", p.peek().text)
+	}
+	return &Expression{
+		source: source,
+		root:   root,
+	}, nil
+}
+
+// Matches evaluates the expression for the event.
+func (x *Expression) Matches(evt *event.Event) bool {
+	return x.root.eval(evt)
+}
+
+// Filter returns the expression as filter function.
+func (x *Expression) Filter() Filter {
+	return func(evt *event.Event) (bool, error) {
+		return x.Matches(evt), nil
+	}
+}
+
+// Tester returns the expression as condition tester.
+func (x *Expression) Tester() ConditionTester {
+	return x.Matches
+}
+
+// String implements fmt.Stringer.
+func (x *Expression) String() string {
+	return x.source
+}
+
+// CompileFilter compiles the expression source into a filter function.
+func CompileFilter(source string) (Filter, error) {
+	x, err := CompileExpression(source)
+	if err != nil {
+		return nil, err
+	}
+	return x.Filter(), nil
+}
+
+// CompileRouter compiles routing rules into a router function. Each rule
+// has the form "<expression> -> <cell ID>, <cell ID>, ...". Cell IDs
+// can be quoted. An event is routed to the cells of all matching rules.
+func CompileRouter(rules ...string) (Router, error) {
+	type route struct {
+		x   *Expression
+		ids []string
+	}
+	var routes []route
+	for _, rule := range rules {
+		tokens, err := tokenize(rule)
+		if err != nil {
+			return nil, err
+		}
+		p := &exprParser{
+			source: rule,
+			tokens: tokens,
+		}
+		root, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if !p.is("->") {
+			return nil, p.fail("missing arrow")
+		}
+		p.next()
+		var ids []string
+		for {
+			t := p.next()
+			switch t.kind {
+			case tokenIdent:
+				ids = append(ids, t.text)
+			case tokenString:
+				ids = append(ids, t.value.(string))
+			default:
+				return nil, exprError(rule, t.pos, "missing cell ID")
+			}
+			if p.peek().kind == tokenEOF {
+				break
+			}
+			if !p.is(",") {
+				return nil, p.fail("missing comma")
+			}
+			p.next()
+		}
+		routes = append(routes, route{&Expression{rule, root}, ids})
+	}
+	return func(evt *event.Event) []string {
+		var ids []string
+		seen := map[string]bool{}
+		for _, r := range routes {
+			if !r.x.Matches(evt) {
+				continue
+			}
+			for _, id := range r.ids {
+				if !seen[id] {
+					seen[id] = true
+					ids = append(ids, id)
+				}
+			}
+		}
+		return ids
+	}, nil
+}
+
+// EOF
@@ -0,0 +1,168 @@
+// Tideland Go Library - Together - Cells - Behaviors - Unit Tests
+//
+// Copyright (C) 2010-2019 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license.
+
+package behaviors_test // import "tideland.dev/go/together/cells/behaviors"
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"testing"
+	"time"
+
+	"tideland.dev/go/audit/asserts"
+	"tideland.dev/go/together/cells/behaviors"
+	"tideland.dev/go/together/cells/event"
+	"tideland.dev/go/together/cells/mesh"
+)
+
+//--------------------
+// TESTS
+//--------------------
+
+// TestExpressions tests the evaluation of compiled expressions.
+func TestExpressions(t *testing.T) {
+	assert := asserts.NewTesting(t, asserts.FailStop)
+	evt := event.New("order-created",
+		"user", "alice",
+		"amount", 250,
+		"express", true,
+		"note", "",
+		"topic", "payload-topic",
+		"order", event.NewPayload(
+			"id", "A-4711",
+			"items", []string{"book", "pen"},
+			"status-code", 201,
+		),
+	)
+	tests := []struct {
+		source  string
+		matches bool
+	}{
+		{`topic == "order-created"`, true},
+		{`topic == 'order-deleted'`, false},
+		{`topic =~ "^order-"`, true},
+		{`topic !~ "^order-"`, false},
+		{`topic in ("order-created", "order-deleted")`, true},
+		{`payload.topic == "payload-topic"`, true},
+		{`user == "alice"`, true},
+		{`user != "bob"`, true},
+		{`amount > 100`, true},
+		{`amount >= 250 && amount <= 250`, true},
+		{`amount < 100.5`, false},
+		{`amount == "250"`, true},
+		{`express`, true},
+		{`express == true`, true},
+		{`note`, false},
+		{`!note`, true},
+		{`missing`, false},
+		{`missing == ""`, false},
+		{`missing != ""`, true},
+		{`order.id =~ "^A-[0-9]+$"`, true},
+		{`order.items.1 == "pen"`, true},
+		{`order.status-code == 201`, true},
+		{`user == "bob" or amount > 200`, true},
+		{`user == "bob" || (amount > 200 && not express)`, false},
+		{`not (user == "bob" and express)`, true},
+		{`amount in (1, 2, 3)`, false},
+	}
+	for i, test := range tests {
+		assert.Logf("test case #%d: %s", i, test.source)
+		x, err := behaviors.CompileExpression(test.source)
+		assert.NoError(err)
+		assert.Equal(x.Matches(evt), test.matches)
+		assert.Equal(x.String(), test.source)
+	}
+}
+
+// TestExpressionErrors tests the compiling of invalid expressions.
+func TestExpressionErrors(t *testing.T) {
+	assert := asserts.NewTesting(t, asserts.FailStop)
+	tests := []struct {
+		source string
+		err    string
+	}{
+		{``, `.*position 0: unexpected end.*`},
+		{`user == "alice`, `.*position 8: unterminated string.*`},
+		{`user == `, `.*position 8: unexpected end.*`},
+		{`(user == "alice"`, `.*missing closing parenthesis.*`},
+		{`user =~ 42`, `.*regular expression has to be a string.*`},
+		{`user =~ "[a-"`, `.*invalid regular expression.*`},
+		{`user in "alice"`, `.*missing list.*`},
+		{`user in ("alice" "bob")`, `.*missing comma or closing parenthesis.*`},
+		{`user == "alice" amount`, `.*unexpected "amount".*`},
+		{`user == and`, `.*unexpected keyword "and".*`},
+		{`order..id`, `.*invalid path "order..id".*`},
+		{`user # 1`, `.*unexpected character '#'.*`},
+	}
+	for i, test := range tests {
+		assert.Logf("test case #%d: %s", i, test.source)
+		_, err := behaviors.CompileExpression(test.source)
+		assert.ErrorMatch(err, test.err)
+	}
+}
+
+// TestCompiledFilterAndRouter tests filter and router behaviors
+// using compiled expressions.
+func TestCompiledFilterAndRouter(t *testing.T) {
+	assert := asserts.NewTesting(t, asserts.FailStop)
+	evtc := make(chan *event.Event, 10)
+	msh := mesh.New()
+	defer msh.Stop()
+
+	filter, err := behaviors.CompileFilter(`amount >= 100`)
+	assert.NoError(err)
+	router, err := behaviors.CompileRouter(
+		`topic == "order" && amount > 1000 -> audit, 'billing'`,
+		`topic == "order" -> billing`,
+	)
+	assert.NoError(err)
+
+	err = msh.SpawnCells(
+		behaviors.NewSelectFilterBehavior("filter", filter),
+		behaviors.NewRouterBehavior("router", router),
+		behaviors.NewSimpleProcessorBehavior("audit", func(emitter mesh.Emitter, evt *event.Event) error {
+			evtc <- event.New("audit", evt.Payload())
+			return nil
+		}),
+		behaviors.NewSimpleProcessorBehavior("billing", func(emitter mesh.Emitter, evt *event.Event) error {
+			evtc <- event.New("billing", evt.Payload())
+			return nil
+		}),
+	)
+	assert.NoError(err)
+	err = msh.Subscribe("filter", "router")
+	assert.NoError(err)
+	err = msh.Subscribe("router", "audit", "billing")
+	assert.NoError(err)
+
+	msh.Emit("filter", event.New("order", "amount", 50))
+	msh.Emit("filter", event.New("order", "amount", 500))
+	msh.Emit("filter", event.New("order", "amount", 5000))
+
+	received := map[string][]int{}
+	for i := 0; i < 3; i++ {
+		select {
+		case evt := <-evtc:
+			received[evt.Topic()] = append(received[evt.Topic()], evt.Payload().At("amount").AsInt(0))
+		case <-time.After(time.Second):
+			assert.Fail("missing routed event")
+		}
+	}
+	assert.Equal(received["billing"], []int{500, 5000})
+	assert.Equal(received["audit"], []int{5000})
+
+	_, err = behaviors.CompileRouter(`topic == "order"`)
+	assert.ErrorMatch(err, `.*missing arrow.*`)
+	_, err = behaviors.CompileRouter(`topic == "order" -> a b`)
+	assert.ErrorMatch(err, `.*missing comma.*`)
+	_, err = behaviors.CompileRouter(`topic == "order" ->`)
+	assert.ErrorMatch(err, `.*missing cell ID.*`)
+}
+
+// EOF
@@ -12,29 +12,115 @@ package behaviors // import "tideland.dev/go/together/cells/behaviors"
 //--------------------
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
 	"net/http"
+	"time"
 
+	"tideland.dev/go/dsa/timex"
 	"tideland.dev/go/net/httpx"
 	"tideland.dev/go/together/cells/event"
 	"tideland.dev/go/together/cells/mesh"
+	"tideland.dev/go/together/limiter"
+	"tideland.dev/go/trace/failure"
+	"tideland.dev/go/trace/logger"
 )
 
+//--------------------
+// CONSTANTS
+//--------------------
+
+// httpMethods maps the request topics to the HTTP methods.
+var httpMethods = map[string]string{
+	TopicHTTPGet:    http.MethodGet,
+	TopicHTTPPost:   http.MethodPost,
+	TopicHTTPPut:    http.MethodPut,
+	TopicHTTPPatch:  http.MethodPatch,
+	TopicHTTPDelete: http.MethodDelete,
+}
+
+// httpReplyTopics maps the request topics to the reply topics.
+var httpReplyTopics = map[string]string{
+	TopicHTTPGet:    TopicHTTPGetReply,
+	TopicHTTPPost:   TopicHTTPPostReply,
+	TopicHTTPPut:    TopicHTTPPutReply,
+	TopicHTTPPatch:  TopicHTTPPatchReply,
+	TopicHTTPDelete: TopicHTTPDeleteReply,
+}
+
 //--------------------
 // HTTP CLIENT BEHAVIOR
 //--------------------
 
+// HTTPClientConfig allows to control how the HTTP client behavior
+// works. All values are optional. Without them requests have no
+// timeout, aren't retried, have no additional header, and are
+// performed one after another by the cell.
+type HTTPClientConfig struct {
+	// Timeout limits the time of one request. It can be overwritten
+	// per request by the payload key "timeout".
+	Timeout time.Duration
+
+	// Retry defines how requests are retried in case of errors,
+	// status 429, and status 5xx. Its count has to be at least 1.
+	// The breaks between the retries end with the context of the
+	// request event.
+	Retry *timex.RetryStrategy
+
+	// Concurrency is the number of requests performed in parallel
+	// in the background. So slow endpoints don't block the cell.
+	Concurrency int
+
+	// Header is added to all requests.
+	Header http.Header
+}
+
 // httpClientBehavior performs HTTP requests.
 type httpClientBehavior struct {
 	id      string
 	emitter mesh.Emitter
+	client  *http.Client
+	timeout time.Duration
+	retry   *timex.RetryStrategy
+	header  http.Header
+	limiter *limiter.Limiter
 }
 
 // NewHTTPClientBehavior performs HTTP request and transforms the
 // response into emitted payload, depending on the content-type.
 func NewHTTPClientBehavior(id string) mesh.Behavior {
-	return &httpClientBehavior{
-		id: id,
+	return NewConfiguredHTTPClientBehavior(id, nil)
+}
+
+// NewConfiguredHTTPClientBehavior performs HTTP requests like the one
+// created by NewHTTPClientBehavior but controlled by the configuration.
+// Requests are done for the topics "http-get", "http-post", "http-put",
+// "http-patch", and "http-delete". The payload contains the "url", an
+// optional "id" for the reply, a nested payload "header", a "body" as
+// string or nested payload sent as JSON, and a "timeout". The reply
+// topic is the request topic with the suffix "-reply". The payload
+// contains "id", "url", "method", "status-code", "header", and "data"
+// or "error". It is emitted to the subscribers and, if the request
+// event contains a reply channel, returned to the requester too.
+func NewConfiguredHTTPClientBehavior(id string, config *HTTPClientConfig) mesh.Behavior {
+	b := &httpClientBehavior{
+		id:     id,
+		client: &http.Client{},
+		header: http.Header{},
 	}
+	if config != nil {
+		b.timeout = config.Timeout
+		b.retry = config.Retry
+		if config.Concurrency > 0 {
+			b.limiter = limiter.New(config.Concurrency)
+		}
+		for key, values := range config.Header {
+			b.header[key] = append([]string{}, values...)
+		}
+	}
+	return b
 }
 
 // ID returns the individual identifier of a behavior instance.
@@ -44,6 +130,9 @@ func (b *httpClientBehavior) ID() string {
 
 // Init the behavior.
 func (b *httpClientBehavior) Init(emitter mesh.Emitter) error {
+	if b.retry != nil && b.retry.Count < 1 {
+		return failure.New("invalid retry count %d", b.retry.Count)
+	}
 	b.emitter = emitter
 	return nil
 }
@@ -56,8 +145,29 @@ func (b *httpClientBehavior) Terminate() error {
 // Process performs the HTTP request.
 func (b *httpClientBehavior) Process(evt *event.Event) error {
 	switch evt.Topic() {
-	case TopicHTTPGet:
-		return b.processGet(evt)
+	case TopicHTTPGet, TopicHTTPPost, TopicHTTPPut, TopicHTTPPatch, TopicHTTPDelete:
+		if b.limiter == nil {
+			return b.emitter.Broadcast(b.perform(evt))
+		}
+		go func() {
+			// Emit the reply to the behavior itself to avoid races
+			// when subscribers are updated. It waits for a full
+			// mailbox, other failures are logged.
+			performed := false
+			err := b.limiter.Do(evt.Context(), func() error {
+				performed = true
+				return b.emitter.Self(b.perform(evt))
+			})
+			if err != nil && !performed {
+				url := evt.Payload().At("url").AsString("")
+				err = b.emitter.Self(b.reply(evt, url, nil, err))
+			}
+			if err != nil {
+				logger.Errorf("http client %q cannot emit reply: %v", b.id, err)
+			}
+		}()
+	case TopicHTTPGetReply, TopicHTTPPostReply, TopicHTTPPutReply, TopicHTTPPatchReply, TopicHTTPDeleteReply:
+		return b.emitter.Broadcast(evt)
 	}
 	return nil
 }
@@ -67,46 +177,153 @@ func (b *httpClientBehavior) Recover(err interface{}) error {
 	return nil
 }
 
-// processGet handles the GET request.
-func (b *httpClientBehavior) processGet(evt *event.Event) error {
-	id := evt.Payload().At("id").AsString("<none>")
+// perform performs the request of the event and returns the
+// reply event.
+func (b *httpClientBehavior) perform(evt *event.Event) *event.Event {
 	url := evt.Payload().At("url").AsString("")
-	resp, err := http.Get(url)
-	b.broadcastReply(TopicHTTPGetReply, id, url, resp, err)
-	return nil
+	body, contentType, err := httpBody(evt.Payload())
+	if err != nil {
+		return b.reply(evt, url, nil, err)
+	}
+	timeout := evt.Payload().At("timeout").AsDuration(b.timeout)
+	do := func() (*http.Response, error) {
+		ctx := evt.Context()
+		if timeout > 0 {
+			var cancel func()
+			ctx, cancel = context.WithTimeout(ctx, timeout)
+			defer cancel()
+		}
+		req, err := http.NewRequest(httpMethods[evt.Topic()], url, bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		req = req.WithContext(ctx)
+		b.setHeader(req, evt.Payload(), contentType)
+		resp, err := b.client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		// Read the body before the context is canceled.
+		data, err := httpx.ReadBody(resp.Body)
+		if err != nil {
+			return nil, err
+		}
+		resp.Body = ioutil.NopCloser(bytes.NewReader(data))
+		return resp, nil
+	}
+	if b.retry == nil {
+		resp, err := do()
+		return b.reply(evt, url, resp, err)
+	}
+	resp, err := b.retryDo(evt.Context(), do)
+	if resp == nil {
+		return b.reply(evt, url, nil, err)
+	}
+	// Reply the last response even if it has a retry status.
+	return b.reply(evt, url, resp, nil)
 }
 
-// broadcastReply reads the reply and broadcasts it.
-func (b *httpClientBehavior) broadcastReply(topic, id, url string, resp *http.Response, err error) {
-	headerToPayload := func() *event.Payload {
-		var hplvs []interface{}
-		for key := range resp.Header {
-			hplvs = append(hplvs, key, resp.Header.Get(key))
+// retryDo performs the request until it succeeds or the retry
+// strategy or the context end it. It returns the last response if
+// there is one, otherwise the error.
+func (b *httpClientBehavior) retryDo(ctx context.Context, do func() (*http.Response, error)) (*http.Response, error) {
+	timeout := time.Now().Add(b.retry.Timeout)
+	pause := b.retry.Break
+	for i := 1; ; i++ {
+		resp, err := do()
+		if err == nil && resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode < 500 {
+			return resp, nil
+		}
+		var rerr error
+		switch {
+		case i >= b.retry.Count:
+			rerr = failure.New("retried more than %d times", b.retry.Count)
+		case time.Now().After(timeout):
+			rerr = failure.New("retried longer than %v", b.retry.Timeout)
 		}
-		return event.NewPayload(hplvs...)
+		if rerr == nil {
+			select {
+			case <-time.After(pause):
+				pause += b.retry.BreakIncrement
+				continue
+			case <-ctx.Done():
+				rerr = ctx.Err()
+			}
+		}
+		if err != nil {
+			return nil, failure.Annotate(err, "cannot perform request: %v", rerr)
+		}
+		return resp, nil
 	}
+}
 
-	var plvs []interface{}
-
-	plvs = append(plvs, "id", id)
-	plvs = append(plvs, "url", url)
+// setHeader sets the configured header, the content type, and the
+// header of the payload.
+func (b *httpClientBehavior) setHeader(req *http.Request, pl *event.Payload, contentType string) {
+	for key, values := range b.header {
+		for _, value := range values {
+			req.Header.Add(key, value)
+		}
+	}
+	if contentType != "" {
+		req.Header.Set(httpx.HeaderContentType, contentType)
+	}
+	hpl := pl.At("header")
+	if !hpl.IsPayload() {
+		return
+	}
+	hpl.AsPayload().Do(func(key string, value *event.Value) error {
+		req.Header.Set(key, value.AsString(""))
+		return nil
+	})
+}
 
+// reply creates the reply event with the response or the error and
+// replies it to a possible requester.
+func (b *httpClientBehavior) reply(evt *event.Event, url string, resp *http.Response, err error) *event.Event {
+	plvs := []interface{}{
+		"id", evt.Payload().At("id").AsString("<none>"),
+		"url", url,
+		"method", httpMethods[evt.Topic()],
+	}
 	if err != nil {
-		plvs = append(plvs, "error", err)
+		plvs = append(plvs, "error", err.Error())
 	} else {
+		var hplvs []interface{}
+		for key := range resp.Header {
+			hplvs = append(hplvs, key, resp.Header.Get(key))
+		}
+		plvs = append(plvs, "status-code", resp.StatusCode)
+		plvs = append(plvs, "header", event.NewPayload(hplvs...))
 		var data interface{}
-		err = httpx.UnmarshalBody(resp.Body, resp.Header, &data)
-		if err != nil {
-			plvs = append(plvs, "status-code", resp.StatusCode)
-			plvs = append(plvs, "header", headerToPayload())
-			plvs = append(plvs, "error", err)
+		if err = httpx.UnmarshalBody(resp.Body, resp.Header, &data); err != nil {
+			plvs = append(plvs, "error", err.Error())
 		} else {
-			plvs = append(plvs, "status-code", resp.StatusCode)
-			plvs = append(plvs, "header", headerToPayload())
 			plvs = append(plvs, "data", data)
 		}
 	}
-	b.emitter.Broadcast(event.New(topic, plvs...))
+	pl := event.NewPayload(plvs...)
+	// Ignore missing reply channel.
+	evt.Payload().Reply(pl)
+	return event.New(httpReplyTopics[evt.Topic()], pl)
+}
+
+// httpBody returns the body of the request and its content type.
+// Payloads are sent as JSON.
+func httpBody(pl *event.Payload) ([]byte, string, error) {
+	v := pl.At("body")
+	switch {
+	case v.IsUndefined():
+		return nil, "", nil
+	case v.IsPayload():
+		data, err := json.Marshal(v.AsPayload().Map())
+		if err != nil {
+			return nil, "", failure.Annotate(err, "cannot marshal body")
+		}
+		return data, httpx.ContentTypeJSON, nil
+	default:
+		return []byte(v.AsString("")), httpx.ContentTypePlain, nil
+	}
 }
 
 // EOF
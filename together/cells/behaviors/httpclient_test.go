@@ -12,13 +12,18 @@ package behaviors_test // import "tideland.dev/go/together/cells/behaviors"
 //--------------------
 
 import (
+	"context"
 	"encoding/json"
+	"fmt"
+	"io/ioutil"
 	"net/http"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"tideland.dev/go/audit/asserts"
 	"tideland.dev/go/audit/environments"
+	"tideland.dev/go/dsa/timex"
 	"tideland.dev/go/together/cells/behaviors"
 	"tideland.dev/go/together/cells/event"
 	"tideland.dev/go/together/cells/mesh"
@@ -86,6 +91,169 @@ func TestHTTPClientBehaviorGet(t *testing.T) {
 	assert.Wait(sigc, 2, time.Second)
 }
 
+// TestHTTPClientBehaviorVerbs tests the HTTP client behavior with
+// the different methods, bodies, and headers.
+func TestHTTPClientBehaviorVerbs(t *testing.T) {
+	assert := asserts.NewTesting(t, asserts.FailStop)
+	wa := initWebAsserter(assert)
+	msh := mesh.New()
+	defer msh.Stop()
+
+	err := msh.SpawnCells(behaviors.NewConfiguredHTTPClientBehavior("client", &behaviors.HTTPClientConfig{
+		Header: http.Header{"X-Client": []string{"cells"}},
+	}))
+	assert.NoError(err)
+
+	tests := []struct {
+		topic  string
+		method string
+		body   interface{}
+		data   string
+	}{
+		{behaviors.TopicHTTPGet, http.MethodGet, nil, ""},
+		{behaviors.TopicHTTPPost, http.MethodPost, "text", "text"},
+		{behaviors.TopicHTTPPut, http.MethodPut, event.NewPayload("a", 1), `{"a":1}`},
+		{behaviors.TopicHTTPPatch, http.MethodPatch, event.NewPayload("b", "two"), `{"b":"two"}`},
+		{behaviors.TopicHTTPDelete, http.MethodDelete, nil, ""},
+	}
+	for i, test := range tests {
+		assert.Logf("test case #%d: %s", i, test.method)
+		kvs := []interface{}{
+			"id", test.method,
+			"url", wa.URL() + "/echo",
+			"header", event.NewPayload("X-Request", "test"),
+		}
+		if test.body != nil {
+			kvs = append(kvs, "body", test.body)
+		}
+		pl, err := msh.Request("client", event.New(test.topic, kvs...), time.Second)
+		assert.NoError(err)
+		assert.Equal(pl.At("id").AsString(""), test.method)
+		assert.Equal(pl.At("method").AsString(""), test.method)
+		assert.Equal(pl.At("status-code").AsInt(0), http.StatusOK)
+		assert.Equal(pl.At("header", "X-Method").AsString(""), test.method)
+		assert.Equal(pl.At("header", "X-Client").AsString(""), "cells")
+		assert.Equal(pl.At("header", "X-Request").AsString(""), "test")
+		assert.Equal(pl.At("data").AsString(""), test.data)
+	}
+}
+
+// TestHTTPClientBehaviorRetry tests the retrying of failed requests
+// and the timeout.
+func TestHTTPClientBehaviorRetry(t *testing.T) {
+	assert := asserts.NewTesting(t, asserts.FailStop)
+	wa := initWebAsserter(assert)
+	msh := mesh.New()
+	defer msh.Stop()
+
+	err := msh.SpawnCells(behaviors.NewConfiguredHTTPClientBehavior("client", &behaviors.HTTPClientConfig{
+		Timeout: 100 * time.Millisecond,
+		Retry: &timex.RetryStrategy{
+			Count:   5,
+			Break:   10 * time.Millisecond,
+			Timeout: time.Second,
+		},
+	}))
+	assert.NoError(err)
+
+	pl, err := msh.Request("client", event.New(behaviors.TopicHTTPGet, "url", wa.URL()+"/flaky"), 5*time.Second)
+	assert.NoError(err)
+	assert.Equal(pl.At("status-code").AsInt(0), http.StatusOK)
+	assert.Equal(pl.At("data").AsString(""), "Done after 3 attempts!")
+
+	pl, err = msh.Request("client", event.New(behaviors.TopicHTTPGet, "url", wa.URL()+"/slow"), 5*time.Second)
+	assert.NoError(err)
+	assert.True(pl.At("status-code").IsUndefined())
+	assert.Contains("retried more than 5 times", pl.At("error").AsString(""))
+
+	// Breaks between retries end with the context of the event.
+	evtc := make(chan *event.Event, 1)
+	err = msh.SpawnCells(
+		behaviors.NewConfiguredHTTPClientBehavior("patient", &behaviors.HTTPClientConfig{
+			Timeout: 100 * time.Millisecond,
+			Retry: &timex.RetryStrategy{
+				Count:   100,
+				Break:   time.Minute,
+				Timeout: time.Hour,
+			},
+		}),
+		newBridgeCollector("collector", evtc),
+	)
+	assert.NoError(err)
+	err = msh.Subscribe("patient", "collector")
+	assert.NoError(err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 250*time.Millisecond)
+	defer cancel()
+	msh.Emit("patient", event.WithContext(ctx, behaviors.TopicHTTPGet, "url", wa.URL()+"/slow"))
+	evt := waitBridged(assert, evtc)
+	assert.Contains("context deadline exceeded", evt.Payload().At("error").AsString(""))
+
+	// Retry strategies need at least one attempt.
+	err = msh.SpawnCells(behaviors.NewConfiguredHTTPClientBehavior("never", &behaviors.HTTPClientConfig{
+		Retry: &timex.RetryStrategy{},
+	}))
+	assert.NotNil(err)
+}
+
+// TestHTTPClientBehaviorConcurrency tests that slow requests don't
+// block the cell.
+func TestHTTPClientBehaviorConcurrency(t *testing.T) {
+	assert := asserts.NewTesting(t, asserts.FailStop)
+	wa := initWebAsserter(assert)
+	evtc := make(chan *event.Event, 2)
+	msh := mesh.New()
+	defer msh.Stop()
+
+	err := msh.SpawnCells(
+		behaviors.NewConfiguredHTTPClientBehavior("client", &behaviors.HTTPClientConfig{
+			Concurrency: 2,
+		}),
+		newBridgeCollector("collector", evtc),
+	)
+	assert.NoError(err)
+	err = msh.Subscribe("client", "collector")
+	assert.NoError(err)
+
+	msh.Emit("client", event.New(behaviors.TopicHTTPGet, "id", "slow", "url", wa.URL()+"/slow"))
+	msh.Emit("client", event.New(behaviors.TopicHTTPPost, "id", "fast", "url", wa.URL()+"/echo"))
+
+	evt := waitBridged(assert, evtc)
+	assert.Equal(evt.Topic(), behaviors.TopicHTTPPostReply)
+	assert.Equal(evt.Payload().At("id").AsString(""), "fast")
+	evt = waitBridged(assert, evtc)
+	assert.Equal(evt.Topic(), behaviors.TopicHTTPGetReply)
+	assert.Equal(evt.Payload().At("id").AsString(""), "slow")
+}
+
+// TestHTTPClientBehaviorReplies tests that concurrent replies aren't
+// lost when they exceed the mailbox.
+func TestHTTPClientBehaviorReplies(t *testing.T) {
+	assert := asserts.NewTesting(t, asserts.FailStop)
+	wa := initWebAsserter(assert)
+	evtc := make(chan *event.Event, 10)
+	msh := mesh.New()
+	defer msh.Stop()
+
+	err := msh.SpawnCell(behaviors.NewConfiguredHTTPClientBehavior("client", &behaviors.HTTPClientConfig{
+		Concurrency: 10,
+	}), mesh.WithMailbox(2, mesh.OverflowBlock))
+	assert.NoError(err)
+	err = msh.SpawnCells(newBridgeCollector("collector", evtc))
+	assert.NoError(err)
+	err = msh.Subscribe("client", "collector")
+	assert.NoError(err)
+
+	const count = 50
+	for i := 0; i < count; i++ {
+		msh.Emit("client", event.New(behaviors.TopicHTTPPost, "url", wa.URL()+"/echo"))
+	}
+	for i := 0; i < count; i++ {
+		evt := waitBridged(assert, evtc)
+		assert.Equal(evt.Topic(), behaviors.TopicHTTPPostReply)
+	}
+}
+
 //--------------------
 // TESTS
 //--------------------
@@ -104,7 +272,7 @@ type outer struct {
 
 func initSimpleHandler() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Add(environments.HeaderContentType, environments.ContentTypeTextPlain)
+		w.Header().Add(environments.HeaderContentType, environments.ContentTypePlain)
 		w.WriteHeader(http.StatusOK)
 		w.Write([]byte("Done!"))
 	}
@@ -134,17 +302,58 @@ func initNestedHandler() http.HandlerFunc {
 	}
 	b, _ := json.Marshal(v)
 	return func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Add(environments.HeaderContentType, environments.ContentTypeApplicationJSON)
+		w.Header().Add(environments.HeaderContentType, environments.ContentTypeJSON)
 		w.WriteHeader(http.StatusOK)
 		w.Write(b)
 	}
 }
 
+func initEchoHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		data, _ := ioutil.ReadAll(r.Body)
+		w.Header().Add(environments.HeaderContentType, environments.ContentTypePlain)
+		w.Header().Add("X-Method", r.Method)
+		w.Header().Add("X-Client", r.Header.Get("X-Client"))
+		w.Header().Add("X-Request", r.Header.Get("X-Request"))
+		w.WriteHeader(http.StatusOK)
+		w.Write(data)
+	}
+}
+
+func initFlakyHandler() http.HandlerFunc {
+	var attempts int32
+	return func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&attempts, 1)
+		if n < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Add(environments.HeaderContentType, environments.ContentTypePlain)
+		w.WriteHeader(http.StatusOK)
+		fmt.Fprintf(w, "Done after %d attempts!", n)
+	}
+}
+
+func initSlowHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(500 * time.Millisecond):
+		}
+		w.Header().Add(environments.HeaderContentType, environments.ContentTypePlain)
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("Slow!"))
+	}
+}
+
 func initWebAsserter(assert *asserts.Asserts) *environments.WebAsserter {
 	wa := environments.NewWebAsserter(assert)
 
 	wa.Handle("/simple", initSimpleHandler())
 	wa.Handle("/nested", initNestedHandler())
+	wa.Handle("/echo", initEchoHandler())
+	wa.Handle("/flaky", initFlakyHandler())
+	wa.Handle("/slow", initSlowHandler())
 
 	return wa
 }
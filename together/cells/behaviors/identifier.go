@@ -13,18 +13,30 @@ package behaviors // import "tideland.dev/go/together/cells/behaviors"
 
 // Topics and payloads identifier of th
 const (
-	TopicAggregated    = "aggregated"
-	TopicComboComplete = "combo-complete"
-	TopicEvaluation    = "evaluation"
-	TopicFSMStatus     = "fsm-status"
-	TopicHTTPGet       = "http-get"
-	TopicHTTPGetReply  = "http-get-reply"
-	TopicPair          = "pair"
-	TopicPairTimeout   = "pair-timeout"
-	TopicRate          = "rate"
-	TopicRateWindow    = "rate-window"
-	TopicSequence      = "sequence"
-	TopicTick          = "tick"
+	TopicAggregated      = "aggregated"
+	TopicComboComplete   = "combo-complete"
+	TopicEvaluation      = "evaluation"
+	TopicFSMStatus       = "fsm-status"
+	TopicHTTPDelete      = "http-delete"
+	TopicHTTPDeleteReply = "http-delete-reply"
+	TopicHTTPGet         = "http-get"
+	TopicHTTPGetReply    = "http-get-reply"
+	TopicHTTPPatch       = "http-patch"
+	TopicHTTPPatchReply  = "http-patch-reply"
+	TopicHTTPPost        = "http-post"
+	TopicHTTPPostReply   = "http-post-reply"
+	TopicHTTPPut         = "http-put"
+	TopicHTTPPutReply    = "http-put-reply"
+	TopicPair            = "pair"
+	TopicPairTimeout     = "pair-timeout"
+	TopicPatternMatch    = "pattern-match"
+	TopicRate            = "rate"
+	TopicRateWindow      = "rate-window"
+	TopicRedisMessage    = "redis-message"
+	TopicSequence        = "sequence"
+	TopicTick            = "tick"
+	TopicWatermark       = "watermark"
+	TopicWindow          = "window"
 )
 
 // EOF
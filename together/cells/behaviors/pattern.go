@@ -0,0 +1,339 @@
+// Tideland Go Library - Together - Cells - Behaviors
+//
+// Copyright (C) 2010-2019 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license.
+
+package behaviors // import "tideland.dev/go/together/cells/behaviors"
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"time"
+
+	"tideland.dev/go/together/cells/event"
+	"tideland.dev/go/together/cells/mesh"
+	"tideland.dev/go/trace/failure"
+)
+
+//--------------------
+// CONSTANTS
+//--------------------
+
+// DefaultMaxPartialMatches is the number of partial matches per key
+// kept by the pattern behavior if no other number is given.
+const DefaultMaxPartialMatches = 100
+
+// MaxPartialMatchKeys is the number of keys the pattern behavior keeps
+// partial matches for. If a new key exceeds it the partial matches of
+// the longest not updated key are dropped.
+const MaxPartialMatchKeys = 1000
+
+//--------------------
+// PATTERN
+//--------------------
+
+// PatternCondition checks if an event fits into a step of a pattern.
+// The events matched by the steps before are passed in their order, so
+// conditions can correlate the event with them.
+type PatternCondition func(evt *event.Event, matched []*event.Event) bool
+
+// patternStep is one step of a pattern.
+type patternStep struct {
+	name   string
+	fits   PatternCondition
+	unless []PatternCondition
+}
+
+// Pattern describes a sequence of events like "A followed by B within
+// 5 minutes, not C in between". It is created with NewPattern and
+// extended with its methods.
+type Pattern struct {
+	steps  []*patternStep
+	within time.Duration
+}
+
+// NewPattern starts a pattern with its first step. The name is used
+// for the event of this step in the emitted match.
+func NewPattern(name string, fits PatternCondition) *Pattern {
+	return &Pattern{
+		steps: []*patternStep{{name: name, fits: fits}},
+	}
+}
+
+// FollowedBy adds a step to the pattern. Events between the steps
+// not fitting are ignored.
+func (p *Pattern) FollowedBy(name string, fits PatternCondition) *Pattern {
+	p.steps = append(p.steps, &patternStep{name: name, fits: fits})
+	return p
+}
+
+// NotFollowedBy defines events which must not occur between the last
+// added step and the next one. Such an event drops the partial match.
+// So it has to be followed by another step, otherwise the behavior
+// using the pattern fails to initialize.
+func (p *Pattern) NotFollowedBy(unless PatternCondition) *Pattern {
+	last := p.steps[len(p.steps)-1]
+	last.unless = append(last.unless, unless)
+	return p
+}
+
+// Within defines the maximum duration between the timestamps of the
+// first and the last event of a match. Zero means no limit.
+func (p *Pattern) Within(within time.Duration) *Pattern {
+	p.within = within
+	return p
+}
+
+// PatternTopic returns a condition checking the topic of an event.
+func PatternTopic(topic string) PatternCondition {
+	return func(evt *event.Event, matched []*event.Event) bool {
+		return evt.Topic() == topic
+	}
+}
+
+// PatternCondition returns the expression as condition for patterns.
+func (x *Expression) PatternCondition() PatternCondition {
+	return func(evt *event.Event, matched []*event.Event) bool {
+		return x.Matches(evt)
+	}
+}
+
+//--------------------
+// PATTERN BEHAVIOR
+//--------------------
+
+// partialMatch contains the events matching the first steps
+// of a pattern.
+type partialMatch struct {
+	events []*event.Event
+}
+
+// partialMatches contains the partial matches of one key and
+// when they have been updated.
+type partialMatches struct {
+	matches []*partialMatch
+	updated uint64
+}
+
+// patternBehavior implements the pattern behavior.
+type patternBehavior struct {
+	id          string
+	emitter     mesh.Emitter
+	key         string
+	pattern     *Pattern
+	maxPartials int
+	partials    map[string]*partialMatches
+	updates     uint64
+	swept       time.Time
+}
+
+// NewPatternBehavior creates a behavior for complex event processing. It
+// checks the received events for the pattern. The partial matches are
+// tracked per string value of the given payload key, so e.g. all steps
+// have to belong to the same user. An empty key tracks all events
+// together. The timestamps of the events are used for the time limit
+// of the pattern, expired partial matches of all keys are dropped
+// regularly. If more than maxPartials partial matches exist for a key
+// the oldest one is dropped, if more than MaxPartialMatchKeys keys
+// exist the longest not updated one.
+//
+// Each complete match is emitted with the topic "pattern-match". The
+// payload contains the "key" and per step name a nested payload with
+// the "topic", "timestamp", and "payload" of the matching event.
+func NewPatternBehavior(id, key string, pattern *Pattern, maxPartials int) mesh.Behavior {
+	if maxPartials < 1 {
+		maxPartials = DefaultMaxPartialMatches
+	}
+	return &patternBehavior{
+		id:          id,
+		key:         key,
+		pattern:     pattern,
+		maxPartials: maxPartials,
+		partials:    map[string]*partialMatches{},
+	}
+}
+
+// ID returns the individual identifier of a behavior instance.
+func (b *patternBehavior) ID() string {
+	return b.id
+}
+
+// Init the behavior.
+func (b *patternBehavior) Init(emitter mesh.Emitter) error {
+	steps := b.pattern.steps
+	if len(steps[len(steps)-1].unless) > 0 {
+		return failure.New("pattern ends with not followed by condition")
+	}
+	b.emitter = emitter
+	b.reset()
+	return nil
+}
+
+// Terminate the behavior.
+func (b *patternBehavior) Terminate() error {
+	b.reset()
+	return nil
+}
+
+// Process checks the event against the partial matches of its key
+// and possibly starts a new one.
+func (b *patternBehavior) Process(evt *event.Event) error {
+	if evt.Topic() == event.TopicReset {
+		b.reset()
+		return nil
+	}
+	b.sweep(evt)
+	var key string
+	if b.key != "" {
+		key = evt.Payload().At(b.key).AsString("")
+	}
+	steps := b.pattern.steps
+	var remaining []*partialMatch
+	var matches []*partialMatch
+	var current []*partialMatch
+	if pms, ok := b.partials[key]; ok {
+		current = pms.matches
+	}
+	for _, pm := range current {
+		if b.expired(pm, evt) {
+			continue
+		}
+		last := steps[len(pm.events)-1]
+		next := steps[len(pm.events)]
+		switch {
+		case next.fits(evt, pm.events):
+			pm.events = append(pm.events, evt)
+			if len(pm.events) == len(steps) {
+				matches = append(matches, pm)
+				continue
+			}
+		case b.unless(last, evt, pm.events):
+			continue
+		}
+		remaining = append(remaining, pm)
+	}
+	if steps[0].fits(evt, nil) {
+		pm := &partialMatch{
+			events: []*event.Event{evt},
+		}
+		if len(steps) == 1 {
+			matches = append(matches, pm)
+		} else {
+			remaining = append(remaining, pm)
+		}
+	}
+	if len(remaining) > b.maxPartials {
+		remaining = remaining[len(remaining)-b.maxPartials:]
+	}
+	b.update(key, remaining)
+	for _, pm := range matches {
+		if err := b.emitter.Broadcast(b.match(key, pm)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Recover from an error.
+func (b *patternBehavior) Recover(err interface{}) error {
+	b.reset()
+	return nil
+}
+
+// reset drops all partial matches.
+func (b *patternBehavior) reset() {
+	b.partials = map[string]*partialMatches{}
+	b.swept = time.Time{}
+}
+
+// update sets the remaining partial matches of the key. If the key
+// is new and the maximum number of keys is reached the longest not
+// updated key is dropped.
+func (b *patternBehavior) update(key string, remaining []*partialMatch) {
+	if len(remaining) == 0 {
+		delete(b.partials, key)
+		return
+	}
+	b.updates++
+	if pms, ok := b.partials[key]; ok {
+		pms.matches = remaining
+		pms.updated = b.updates
+		return
+	}
+	if len(b.partials) >= MaxPartialMatchKeys {
+		var oldestKey string
+		var oldest *partialMatches
+		for k, pms := range b.partials {
+			if oldest == nil || pms.updated < oldest.updated {
+				oldestKey = k
+				oldest = pms
+			}
+		}
+		delete(b.partials, oldestKey)
+	}
+	b.partials[key] = &partialMatches{
+		matches: remaining,
+		updated: b.updates,
+	}
+}
+
+// sweep drops the expired partial matches of all keys. It's done
+// once per time limit of the pattern.
+func (b *patternBehavior) sweep(evt *event.Event) {
+	if b.pattern.within <= 0 || evt.Timestamp().Sub(b.swept) <= b.pattern.within {
+		return
+	}
+	b.swept = evt.Timestamp()
+	for key, pms := range b.partials {
+		var remaining []*partialMatch
+		for _, pm := range pms.matches {
+			if !b.expired(pm, evt) {
+				remaining = append(remaining, pm)
+			}
+		}
+		if len(remaining) == 0 {
+			delete(b.partials, key)
+		} else {
+			pms.matches = remaining
+		}
+	}
+}
+
+// expired checks if the partial match cannot be completed in time
+// anymore.
+func (b *patternBehavior) expired(pm *partialMatch, evt *event.Event) bool {
+	if b.pattern.within <= 0 {
+		return false
+	}
+	return evt.Timestamp().Sub(pm.events[0].Timestamp()) > b.pattern.within
+}
+
+// unless checks if the event is not allowed after the step.
+func (b *patternBehavior) unless(step *patternStep, evt *event.Event, matched []*event.Event) bool {
+	for _, unless := range step.unless {
+		if unless(evt, matched) {
+			return true
+		}
+	}
+	return false
+}
+
+// match creates the event for a complete match.
+func (b *patternBehavior) match(key string, pm *partialMatch) *event.Event {
+	kvs := []interface{}{"key", key}
+	for i, step := range b.pattern.steps {
+		evt := pm.events[i]
+		kvs = append(kvs, step.name, event.NewPayload(
+			"topic", evt.Topic(),
+			"timestamp", evt.Timestamp(),
+			"payload", evt.Payload(),
+		))
+	}
+	return event.New(TopicPatternMatch, kvs...)
+}
+
+// EOF
@@ -0,0 +1,180 @@
+// Tideland Go Library - Together - Cells - Behaviors - Unit Tests
+//
+// Copyright (C) 2010-2019 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license.
+
+package behaviors_test // import "tideland.dev/go/together/cells/behaviors"
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"testing"
+	"time"
+
+	"tideland.dev/go/audit/asserts"
+	"tideland.dev/go/together/cells/behaviors"
+	"tideland.dev/go/together/cells/event"
+	"tideland.dev/go/together/cells/mesh"
+)
+
+//--------------------
+// TESTS
+//--------------------
+
+// TestPatternBehavior tests the pattern behavior with correlation,
+// time limit, and forbidden events.
+func TestPatternBehavior(t *testing.T) {
+	assert := asserts.NewTesting(t, asserts.FailStop)
+	evtc := make(chan *event.Event, 10)
+	msh := mesh.New()
+	defer msh.Stop()
+
+	sameDevice := func(evt *event.Event, matched []*event.Event) bool {
+		device := matched[0].Payload().At("device").AsString("")
+		return evt.Topic() == "purchase" && evt.Payload().At("device").AsString("-") == device
+	}
+	pattern := behaviors.NewPattern("login", behaviors.PatternTopic("login")).
+		NotFollowedBy(behaviors.PatternTopic("logout")).
+		FollowedBy("purchase", sameDevice).
+		Within(5 * time.Minute)
+
+	msh.SpawnCells(
+		behaviors.NewPatternBehavior("pattern", "user", pattern, 0),
+		newBridgeCollector("collector", evtc),
+	)
+	msh.Subscribe("pattern", "collector")
+
+	emitUserAt(msh, 0, "login", "alice", "x")
+	emitUserAt(msh, 1, "login", "bob", "y")
+	emitUserAt(msh, 2, "purchase", "alice", "x")
+	emitUserAt(msh, 3, "logout", "bob", "y")
+	emitUserAt(msh, 4, "purchase", "bob", "y")
+	emitUserAt(msh, 5, "login", "carol", "z")
+	emitUserAt(msh, 11, "purchase", "carol", "z")
+	emitUserAt(msh, 12, "login", "carol", "z")
+	emitUserAt(msh, 13, "purchase", "carol", "w")
+	emitUserAt(msh, 14, "purchase", "carol", "z")
+
+	evt := waitBridged(assert, evtc)
+	assert.Equal(evt.Topic(), behaviors.TopicPatternMatch)
+	assert.Equal(evt.Payload().At("key").AsString(""), "alice")
+	assert.Equal(evt.Payload().At("login", "topic").AsString(""), "login")
+	assert.Equal(evt.Payload().At("login", "timestamp").AsTime(time.Time{}), patternTime(0))
+	assert.Equal(evt.Payload().At("purchase", "timestamp").AsTime(time.Time{}), patternTime(2))
+	assert.Equal(evt.Payload().At("purchase", "payload", "device").AsString(""), "x")
+
+	evt = waitBridged(assert, evtc)
+	assert.Equal(evt.Payload().At("key").AsString(""), "carol")
+	assert.Equal(evt.Payload().At("login", "timestamp").AsTime(time.Time{}), patternTime(12))
+	assert.Equal(evt.Payload().At("purchase", "timestamp").AsTime(time.Time{}), patternTime(14))
+
+	select {
+	case evt := <-evtc:
+		assert.Fail("unexpected match: " + evt.Payload().At("key").AsString(""))
+	default:
+	}
+}
+
+// TestPatternBehaviorLimit tests the limit of partial matches.
+func TestPatternBehaviorLimit(t *testing.T) {
+	assert := asserts.NewTesting(t, asserts.FailStop)
+	evtc := make(chan *event.Event, 10)
+	msh := mesh.New()
+	defer msh.Stop()
+
+	x, err := behaviors.CompileExpression(`topic == "b" && ok`)
+	assert.NoError(err)
+	pattern := behaviors.NewPattern("a", behaviors.PatternTopic("a")).
+		FollowedBy("b", x.PatternCondition())
+
+	msh.SpawnCells(
+		behaviors.NewPatternBehavior("pattern", "", pattern, 2),
+		newBridgeCollector("collector", evtc),
+	)
+	msh.Subscribe("pattern", "collector")
+
+	msh.Emit("pattern", event.New("a", "n", 1))
+	msh.Emit("pattern", event.New("a", "n", 2))
+	msh.Emit("pattern", event.New("a", "n", 3))
+	msh.Emit("pattern", event.New("b", "ok", false))
+	msh.Emit("pattern", event.New("b", "ok", true))
+
+	evt := waitBridged(assert, evtc)
+	assert.Equal(evt.Payload().At("a", "payload", "n").AsInt(0), 2)
+	evt = waitBridged(assert, evtc)
+	assert.Equal(evt.Payload().At("a", "payload", "n").AsInt(0), 3)
+
+	// All partial matches are done.
+	msh.Emit("pattern", event.New("b", "ok", true))
+	msh.Emit("pattern", event.New("a", "n", 4))
+	msh.Emit("pattern", event.New(event.TopicReset))
+	msh.Emit("pattern", event.New("b", "ok", true))
+	msh.Emit("pattern", event.New("a", "n", 5))
+	msh.Emit("pattern", event.New("b", "ok", true))
+
+	evt = waitBridged(assert, evtc)
+	assert.Equal(evt.Payload().At("a", "payload", "n").AsInt(0), 5)
+}
+
+// TestPatternBehaviorKeys tests the limit of keys with partial matches.
+func TestPatternBehaviorKeys(t *testing.T) {
+	assert := asserts.NewTesting(t, asserts.FailStop)
+	evtc := make(chan *event.Event, 10)
+	msh := mesh.New()
+	defer msh.Stop()
+
+	pattern := behaviors.NewPattern("a", behaviors.PatternTopic("a")).
+		FollowedBy("b", behaviors.PatternTopic("b"))
+
+	msh.SpawnCells(
+		behaviors.NewPatternBehavior("pattern", "k", pattern, 0),
+		newBridgeCollector("collector", evtc),
+	)
+	msh.Subscribe("pattern", "collector")
+
+	for k := 0; k <= behaviors.MaxPartialMatchKeys; k++ {
+		msh.Emit("pattern", event.New("a", "k", k))
+	}
+	msh.Emit("pattern", event.New("b", "k", 0))
+	msh.Emit("pattern", event.New("b", "k", 1))
+
+	evt := waitBridged(assert, evtc)
+	assert.Equal(evt.Payload().At("key").AsString(""), "1")
+}
+
+// TestPatternBehaviorInvalid tests the rejection of patterns ending
+// with forbidden events.
+func TestPatternBehaviorInvalid(t *testing.T) {
+	assert := asserts.NewTesting(t, asserts.FailStop)
+	msh := mesh.New()
+	defer msh.Stop()
+
+	pattern := behaviors.NewPattern("a", behaviors.PatternTopic("a")).
+		FollowedBy("b", behaviors.PatternTopic("b")).
+		NotFollowedBy(behaviors.PatternTopic("c"))
+
+	err := msh.SpawnCells(behaviors.NewPatternBehavior("pattern", "", pattern, 0))
+	assert.NotNil(err)
+}
+
+//--------------------
+// HELPERS
+//--------------------
+
+// patternTime returns the base time of the window tests plus
+// the minutes.
+func patternTime(minutes int) time.Time {
+	return windowBase.Add(time.Duration(minutes) * time.Minute)
+}
+
+// emitUserAt emits a user event with the timestamp based on
+// the minutes.
+func emitUserAt(msh *mesh.Mesh, minutes int, topic, user, device string) {
+	msh.Emit("pattern", event.WithTimestamp(patternTime(minutes), topic, "user", user, "device", device))
+}
+
+// EOF
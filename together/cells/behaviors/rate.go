@@ -46,7 +46,6 @@ func NewRateBehavior(id string, matches RateCriterion, count int) mesh.Behavior
 		id:        id,
 		matches:   matches,
 		count:     count,
-		last:      time.Now(),
 		durations: []time.Duration{},
 	}
 }
@@ -59,6 +58,7 @@ func (b *rateBehavior) ID() string {
 // Init the behavior.
 func (b *rateBehavior) Init(emitter mesh.Emitter) error {
 	b.emitter = emitter
+	b.last = emitter.Mesh().Clock().Now()
 	return nil
 }
 
@@ -71,7 +71,7 @@ func (b *rateBehavior) Terminate() error {
 func (b *rateBehavior) Process(evt *event.Event) error {
 	switch evt.Topic() {
 	case event.TopicReset:
-		b.last = time.Now()
+		b.last = b.emitter.Mesh().Clock().Now()
 		b.durations = []time.Duration{}
 	default:
 		ok, err := b.matches(evt)
@@ -114,7 +114,7 @@ func (b *rateBehavior) Process(evt *event.Event) error {
 
 // Recover implements the cells.Behavior interface.
 func (b *rateBehavior) Recover(err interface{}) error {
-	b.last = time.Now()
+	b.last = b.emitter.Mesh().Clock().Now()
 	b.durations = []time.Duration{}
 	return nil
 }
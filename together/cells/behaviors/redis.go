@@ -0,0 +1,222 @@
+// Tideland Go Library - Together - Cells - Behaviors
+//
+// Copyright (C) 2010-2019 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license.
+
+package behaviors // import "tideland.dev/go/together/cells/behaviors"
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"encoding/json"
+	"time"
+
+	"tideland.dev/go/db/redis"
+	"tideland.dev/go/together/cells/event"
+	"tideland.dev/go/together/cells/mesh"
+	"tideland.dev/go/together/loop"
+	"tideland.dev/go/together/notifier"
+	"tideland.dev/go/trace/failure"
+)
+
+//--------------------
+// CONSTANTS
+//--------------------
+
+// redisReconnectDelay is the time the subscriber waits before
+// it subscribes again after a failure.
+const redisReconnectDelay = 100 * time.Millisecond
+
+//--------------------
+// REDIS SUBSCRIBER BEHAVIOR
+//--------------------
+
+// redisSubscriberBehavior emits the values published to Redis
+// channels as events.
+type redisSubscriberBehavior struct {
+	id       string
+	emitter  mesh.Emitter
+	database *redis.Database
+	channels []string
+	loop     *loop.Loop
+}
+
+// NewRedisSubscriberBehavior creates a behavior subscribing to the given
+// Redis channels or channel patterns. Values published as encoded event
+// by a Redis publisher behavior are emitted as the original event. All
+// other values are emitted as events with topic "redis-message" and the
+// payload keys "kind", "channel", and "value". With a full mailbox the
+// subscriber waits. If a value is rejected or spilled instead, or in
+// case of a failure, the subscription is renewed with a new connection
+// of the database pool.
+func NewRedisSubscriberBehavior(id string, db *redis.Database, channels ...string) mesh.Behavior {
+	return &redisSubscriberBehavior{
+		id:       id,
+		database: db,
+		channels: channels,
+	}
+}
+
+// ID returns the individual identifier of a behavior instance.
+func (b *redisSubscriberBehavior) ID() string {
+	return b.id
+}
+
+// Init the behavior.
+func (b *redisSubscriberBehavior) Init(emitter mesh.Emitter) error {
+	if len(b.channels) == 0 {
+		return failure.New("no channels to subscribe")
+	}
+	b.emitter = emitter
+	b.loop = loop.New(b.subscriptionLoop).Go()
+	return nil
+}
+
+// Terminate the behavior.
+func (b *redisSubscriberBehavior) Terminate() error {
+	return b.loop.Stop(nil)
+}
+
+// Process emits the received events to all subscribers.
+func (b *redisSubscriberBehavior) Process(evt *event.Event) error {
+	return b.emitter.Broadcast(evt)
+}
+
+// Recover from an error.
+func (b *redisSubscriberBehavior) Recover(err interface{}) error {
+	return nil
+}
+
+// subscriptionLoop subscribes to the channels and renews the
+// subscription after failures.
+func (b *redisSubscriberBehavior) subscriptionLoop(c *notifier.Closer) error {
+	for {
+		sub, err := b.database.Subscription()
+		if err == nil {
+			b.receive(c, sub)
+		}
+		select {
+		case <-c.Done():
+			return nil
+		case <-time.After(redisReconnectDelay):
+		}
+	}
+}
+
+// receive pops the published values of one subscription until
+// it fails or the behavior terminates. The events are emitted to
+// the behavior itself to avoid races when subscribers are updated.
+func (b *redisSubscriberBehavior) receive(c *notifier.Closer, sub *redis.Subscription) {
+	donec := make(chan struct{})
+	killedc := make(chan struct{})
+	defer func() {
+		close(donec)
+		<-killedc
+	}()
+	go func() {
+		// Only this goroutine kills the subscription, when receiving
+		// ends or earlier to interrupt waiting for values when
+		// terminating.
+		defer close(killedc)
+		select {
+		case <-c.Done():
+		case <-donec:
+		}
+		sub.Kill()
+	}()
+	if err := sub.Subscribe(b.channels...); err != nil {
+		return
+	}
+	for {
+		pv, err := sub.Pop()
+		if err != nil {
+			return
+		}
+		if pv.Kind != "message" && pv.Kind != "pmessage" {
+			continue
+		}
+		if err := b.emitter.Self(publishedEvent(pv)); err != nil {
+			return
+		}
+	}
+}
+
+// publishedEvent converts a published value into an event.
+func publishedEvent(pv *redis.PublishedValue) *event.Event {
+	var evt event.Event
+	if err := json.Unmarshal(pv.Value.Bytes(), &evt); err == nil && evt.Topic() != "" {
+		return &evt
+	}
+	return event.New(TopicRedisMessage,
+		"kind", pv.Kind,
+		"channel", pv.Channel,
+		"value", pv.Value.String(),
+	)
+}
+
+//--------------------
+// REDIS PUBLISHER BEHAVIOR
+//--------------------
+
+// redisPublisherBehavior publishes the received events to a
+// Redis channel.
+type redisPublisherBehavior struct {
+	id       string
+	database *redis.Database
+	channel  string
+}
+
+// NewRedisPublisherBehavior creates a behavior publishing all received
+// events JSON encoded to the given Redis channel. Each publishing uses
+// a connection of the database pool, so broken connections are
+// replaced automatically.
+func NewRedisPublisherBehavior(id string, db *redis.Database, channel string) mesh.Behavior {
+	return &redisPublisherBehavior{
+		id:       id,
+		database: db,
+		channel:  channel,
+	}
+}
+
+// ID returns the individual identifier of a behavior instance.
+func (b *redisPublisherBehavior) ID() string {
+	return b.id
+}
+
+// Init the behavior.
+func (b *redisPublisherBehavior) Init(emitter mesh.Emitter) error {
+	return nil
+}
+
+// Terminate the behavior.
+func (b *redisPublisherBehavior) Terminate() error {
+	return nil
+}
+
+// Process publishes the event.
+func (b *redisPublisherBehavior) Process(evt *event.Event) error {
+	data, err := json.Marshal(evt)
+	if err != nil {
+		return failure.Annotate(err, "cannot encode event for channel %q", b.channel)
+	}
+	conn, err := b.database.Connection()
+	if err != nil {
+		return failure.Annotate(err, "cannot publish to channel %q", b.channel)
+	}
+	defer conn.Return()
+	if _, err = conn.Do("publish", b.channel, data); err != nil {
+		return failure.Annotate(err, "cannot publish to channel %q", b.channel)
+	}
+	return nil
+}
+
+// Recover from an error.
+func (b *redisPublisherBehavior) Recover(err interface{}) error {
+	return nil
+}
+
+// EOF
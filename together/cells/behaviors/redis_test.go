@@ -0,0 +1,97 @@
+// Tideland Go Library - Together - Cells - Behaviors - Unit Tests
+//
+// Copyright (C) 2010-2019 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license.
+
+package behaviors_test // import "tideland.dev/go/together/cells/behaviors"
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"testing"
+	"time"
+
+	"tideland.dev/go/audit/asserts"
+	"tideland.dev/go/db/redis"
+	"tideland.dev/go/together/cells/behaviors"
+	"tideland.dev/go/together/cells/event"
+	"tideland.dev/go/together/cells/mesh"
+)
+
+//--------------------
+// TESTS
+//--------------------
+
+// TestRedisBehaviors tests the publishing and subscribing of
+// events via Redis.
+func TestRedisBehaviors(t *testing.T) {
+	assert := asserts.NewTesting(t, asserts.FailStop)
+	db := openRedis(t, assert)
+	defer db.Close()
+	evtc := make(chan *event.Event, 10)
+	msh := mesh.New()
+	defer msh.Stop()
+
+	err := msh.SpawnCells(
+		behaviors.NewRedisPublisherBehavior("publisher", db, "cells-test"),
+		behaviors.NewRedisSubscriberBehavior("subscriber", db, "cells-*"),
+		newBridgeCollector("collector", evtc),
+	)
+	assert.NoError(err)
+	err = msh.Subscribe("subscriber", "collector")
+	assert.NoError(err)
+
+	// Publish until the subscription is established.
+	var evt *event.Event
+	assert.Retry(func() bool {
+		msh.Emit("publisher", event.New("foo", "value", 42))
+		select {
+		case evt = <-evtc:
+			return true
+		case <-time.After(50 * time.Millisecond):
+			return false
+		}
+	}, 20, 10*time.Millisecond)
+	assert.Equal(evt.Topic(), "foo")
+	assert.Equal(evt.Payload().At("value").AsInt(0), 42)
+
+	// Values not published by the publisher.
+	conn, err := db.Connection()
+	assert.NoError(err)
+	_, err = conn.Do("publish", "cells-other", "bar")
+	assert.NoError(err)
+	assert.NoError(conn.Return())
+
+	for evt = range evtc {
+		if evt.Topic() == behaviors.TopicRedisMessage {
+			break
+		}
+	}
+	assert.Equal(evt.Payload().At("kind").AsString(""), "pmessage")
+	assert.Equal(evt.Payload().At("channel").AsString(""), "cells-other")
+	assert.Equal(evt.Payload().At("value").AsString(""), "bar")
+}
+
+//--------------------
+// HELPERS
+//--------------------
+
+// openRedis opens the local Redis database or skips the test
+// if it is not available.
+func openRedis(t *testing.T, assert *asserts.Asserts) *redis.Database {
+	db, err := redis.Open(redis.TCPConnection("", 100*time.Millisecond))
+	assert.NoError(err)
+	conn, err := db.Connection()
+	if err != nil {
+		db.Close()
+		t.Skipf("no Redis database available: %v", err)
+	}
+	assert.NoError(conn.Return())
+	return db
+}
+
+// EOF
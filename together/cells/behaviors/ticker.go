@@ -12,12 +12,12 @@ package behaviors // import "tideland.dev/go/together/cells/behaviors"
 //--------------------
 
 import (
+	"sync"
 	"time"
 
+	"tideland.dev/go/dsa/timex"
 	"tideland.dev/go/together/cells/event"
 	"tideland.dev/go/together/cells/mesh"
-	"tideland.dev/go/together/loop"
-	"tideland.dev/go/together/notifier"
 )
 
 //--------------------
@@ -29,7 +29,7 @@ type tickerBehavior struct {
 	id       string
 	emitter  mesh.Emitter
 	duration time.Duration
-	loop     *loop.Loop
+	ticker   *selfTicker
 }
 
 // NewTickerBehavior creates a ticker behavior for the emitting of
@@ -49,19 +49,20 @@ func (b *tickerBehavior) ID() string {
 // Init the behavior.
 func (b *tickerBehavior) Init(emitter mesh.Emitter) error {
 	b.emitter = emitter
-	b.loop = loop.New(b.tickerLoop).Go()
+	b.ticker = startSelfTicker(emitter, b.duration)
 	return nil
 }
 
 // Terminate the behavior.
 func (b *tickerBehavior) Terminate() error {
-	return b.loop.Stop(nil)
+	b.ticker.stop()
+	return nil
 }
 
 // Process emits a ticker event each time the defined duration elapsed.
 func (b *tickerBehavior) Process(evt *event.Event) error {
 	if evt.Topic() == TopicTick {
-		b.emitter.Broadcast(event.New(TopicTick, "id", b.id))
+		b.emitter.Broadcast(event.WithTimestamp(evt.Timestamp(), TopicTick, "id", b.id))
 	}
 	return nil
 }
@@ -71,18 +72,59 @@ func (b *tickerBehavior) Recover(err interface{}) error {
 	return nil
 }
 
-// tickerLoop is the sending a tick event to itself. It acts there to
-// avoid races when subscribers are updated.
-func (b *tickerBehavior) tickerLoop(c *notifier.Closer) error {
-	ticker := time.NewTicker(b.duration)
-	defer ticker.Stop()
-	for {
-		select {
-		case <-c.Done():
-			return nil
-		case <-ticker.C:
-			b.emitter.Self(event.New(TopicTick))
-		}
+//--------------------
+// SELF TICKER
+//--------------------
+
+// selfTicker sends tick events to a behavior in intervals based on
+// the clock of its mesh. It acts there to avoid races when subscribers
+// are updated.
+type selfTicker struct {
+	mu       sync.Mutex
+	emitter  mesh.Emitter
+	clock    timex.Clock
+	duration time.Duration
+	next     time.Time
+	timer    timex.Timer
+}
+
+// startSelfTicker starts sending tick events to the emitter.
+func startSelfTicker(emitter mesh.Emitter, duration time.Duration) *selfTicker {
+	t := &selfTicker{
+		emitter:  emitter,
+		clock:    emitter.Mesh().Clock(),
+		duration: duration,
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.next = t.clock.Now().Add(duration)
+	t.timer = t.clock.AfterFunc(duration, t.tick)
+	return t
+}
+
+// tick sends a tick event with the due time and schedules the
+// next one without drifting.
+func (t *selfTicker) tick() {
+	t.mu.Lock()
+	if t.timer == nil {
+		t.mu.Unlock()
+		return
+	}
+	due := t.next
+	t.next = due.Add(t.duration)
+	t.timer = t.clock.AfterFunc(t.next.Sub(t.clock.Now()), t.tick)
+	t.mu.Unlock()
+	// Emit outside the lock as a full mailbox may block.
+	t.emitter.Self(event.WithTimestamp(due, TopicTick))
+}
+
+// stop ends the sending of tick events.
+func (t *selfTicker) stop() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.timer != nil {
+		t.timer.Stop()
+		t.timer = nil
 	}
 }
 
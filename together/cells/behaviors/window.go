@@ -0,0 +1,311 @@
+// Tideland Go Library - Together - Cells - Behaviors
+//
+// Copyright (C) 2010-2019 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license.
+
+package behaviors // import "tideland.dev/go/together/cells/behaviors"
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"sort"
+	"time"
+
+	"tideland.dev/go/together/cells/event"
+	"tideland.dev/go/together/cells/mesh"
+	"tideland.dev/go/trace/failure"
+)
+
+//--------------------
+// WINDOW
+//--------------------
+
+// window collects the events of one key during a timespan.
+type window struct {
+	key    string
+	start  time.Time
+	end    time.Time
+	events []*event.Event
+}
+
+// add inserts the event ordered by its timestamp.
+func (w *window) add(evt *event.Event) {
+	idx := sort.Search(len(w.events), func(i int) bool {
+		return w.events[i].Timestamp().After(evt.Timestamp())
+	})
+	w.events = append(w.events, nil)
+	copy(w.events[idx+1:], w.events[idx:])
+	w.events[idx] = evt
+}
+
+// sink returns the events of the window as sink.
+func (w *window) sink() *event.Sink {
+	sink := event.NewSink(0)
+	for _, evt := range w.events {
+		sink.Push(evt)
+	}
+	return sink
+}
+
+// windowAssigner returns the start and end times of the windows
+// an event with the given timestamp belongs to.
+type windowAssigner func(ts time.Time) [][2]time.Time
+
+//--------------------
+// WINDOW BEHAVIOR
+//--------------------
+
+// windowBehavior implements the tumbling, sliding, and session
+// window behaviors.
+type windowBehavior struct {
+	id        string
+	emitter   mesh.Emitter
+	key       string
+	lateness  time.Duration
+	assign    windowAssigner
+	session   bool
+	process   event.SinkProcessor
+	windows   map[string][]*window
+	watermark time.Time
+	err       error
+}
+
+// NewTumblingWindowBehavior creates a behavior collecting the events
+// in fixed-size, non-overlapping windows based on their timestamps
+// and grouped by the string value of the given payload key. See
+// NewSessionWindowBehavior for the handling of watermarks and results.
+// The size has to be positive, otherwise the initialization fails.
+func NewTumblingWindowBehavior(
+	id, key string,
+	size, lateness time.Duration,
+	process event.SinkProcessor) mesh.Behavior {
+	b := newWindowBehavior(id, key, lateness, false, process, func(ts time.Time) [][2]time.Time {
+		start := ts.Truncate(size)
+		return [][2]time.Time{{start, start.Add(size)}}
+	})
+	if size <= 0 {
+		b.err = failure.New("invalid window size %v", size)
+	}
+	return b
+}
+
+// NewSlidingWindowBehavior creates a behavior collecting the events
+// in fixed-size windows starting every slide duration, so one event
+// may belong to multiple windows. See NewSessionWindowBehavior for the
+// handling of keys, watermarks, and results. The size has to be
+// positive and the slide between zero and the size, otherwise the
+// initialization fails.
+func NewSlidingWindowBehavior(
+	id, key string,
+	size, slide, lateness time.Duration,
+	process event.SinkProcessor) mesh.Behavior {
+	b := newWindowBehavior(id, key, lateness, false, process, func(ts time.Time) [][2]time.Time {
+		var spans [][2]time.Time
+		for start := ts.Truncate(slide); ts.Sub(start) < size; start = start.Add(-slide) {
+			spans = append(spans, [2]time.Time{start, start.Add(size)})
+		}
+		return spans
+	})
+	switch {
+	case size <= 0:
+		b.err = failure.New("invalid window size %v", size)
+	case slide <= 0 || slide > size:
+		b.err = failure.New("invalid window slide %v for size %v", slide, size)
+	}
+	return b
+}
+
+// NewSessionWindowBehavior creates a behavior collecting the events
+// grouped by the string value of the given payload key in sessions.
+// A session ends if no event of the key follows within the gap.
+//
+// Like the other window behaviors it uses the event timestamps. The
+// watermark follows the latest timestamp minus the allowed lateness.
+// Windows ending before the watermark are processed by the sink
+// processor, events for those are dropped. The returned payload is
+// emitted with the topic "window" and the additional keys "key",
+// "start", and "end". The watermark can also be set by an event with
+// the topic "watermark" and the payload key "time". The topic
+// "process" processes all open windows, "reset" drops them. The gap
+// has to be positive, otherwise the initialization fails.
+func NewSessionWindowBehavior(
+	id, key string,
+	gap, lateness time.Duration,
+	process event.SinkProcessor) mesh.Behavior {
+	b := newWindowBehavior(id, key, lateness, true, process, func(ts time.Time) [][2]time.Time {
+		return [][2]time.Time{{ts, ts.Add(gap)}}
+	})
+	if gap <= 0 {
+		b.err = failure.New("invalid session gap %v", gap)
+	}
+	return b
+}
+
+// newWindowBehavior creates a window behavior with the given
+// window assigner.
+func newWindowBehavior(
+	id, key string,
+	lateness time.Duration,
+	session bool,
+	process event.SinkProcessor,
+	assign windowAssigner) *windowBehavior {
+	return &windowBehavior{
+		id:       id,
+		key:      key,
+		lateness: lateness,
+		assign:   assign,
+		session:  session,
+		process:  process,
+	}
+}
+
+// ID returns the individual identifier of a behavior instance.
+func (b *windowBehavior) ID() string {
+	return b.id
+}
+
+// Init the behavior.
+func (b *windowBehavior) Init(emitter mesh.Emitter) error {
+	if b.err != nil {
+		return b.err
+	}
+	b.emitter = emitter
+	b.windows = map[string][]*window{}
+	b.watermark = time.Time{}
+	return nil
+}
+
+// Terminate the behavior.
+func (b *windowBehavior) Terminate() error {
+	b.windows = map[string][]*window{}
+	return nil
+}
+
+// Process collects the events in windows and processes those
+// passed by the watermark.
+func (b *windowBehavior) Process(evt *event.Event) error {
+	switch evt.Topic() {
+	case TopicWatermark:
+		return b.advance(evt.Payload().At("time").AsTime(time.Time{}))
+	case event.TopicProcess:
+		return b.fire(func(w *window) bool { return true })
+	case event.TopicReset:
+		b.windows = map[string][]*window{}
+		return nil
+	default:
+		b.collect(evt)
+		return b.advance(evt.Timestamp().Add(-b.lateness))
+	}
+}
+
+// Recover from an error.
+func (b *windowBehavior) Recover(err interface{}) error {
+	b.windows = map[string][]*window{}
+	return nil
+}
+
+// collect adds the event to its windows if those aren't
+// already passed by the watermark.
+func (b *windowBehavior) collect(evt *event.Event) {
+	key := evt.Payload().At(b.key).AsString("")
+	for _, span := range b.assign(evt.Timestamp()) {
+		if !span[1].After(b.watermark) {
+			// Too late.
+			continue
+		}
+		w := b.lookup(key, span[0], span[1])
+		w.add(evt)
+	}
+}
+
+// lookup returns the window of the key and timespan. It is created
+// if needed. Session windows are merged with overlapping ones.
+func (b *windowBehavior) lookup(key string, start, end time.Time) *window {
+	windows := b.windows[key]
+	if !b.session {
+		for _, w := range windows {
+			if w.start.Equal(start) {
+				return w
+			}
+		}
+		w := &window{key: key, start: start, end: end}
+		b.windows[key] = append(windows, w)
+		return w
+	}
+	merged := &window{key: key, start: start, end: end}
+	var remaining []*window
+	for _, w := range windows {
+		if w.start.After(merged.end) || merged.start.After(w.end) {
+			remaining = append(remaining, w)
+			continue
+		}
+		if w.start.Before(merged.start) {
+			merged.start = w.start
+		}
+		if w.end.After(merged.end) {
+			merged.end = w.end
+		}
+		for _, evt := range w.events {
+			merged.add(evt)
+		}
+	}
+	b.windows[key] = append(remaining, merged)
+	return merged
+}
+
+// advance moves the watermark forward and processes the windows
+// ending before it.
+func (b *windowBehavior) advance(watermark time.Time) error {
+	if !watermark.After(b.watermark) {
+		return nil
+	}
+	b.watermark = watermark
+	return b.fire(func(w *window) bool {
+		return !w.end.After(watermark)
+	})
+}
+
+// fire processes and removes the selected windows ordered by
+// their end and key.
+func (b *windowBehavior) fire(selected func(w *window) bool) error {
+	var fired []*window
+	for key, windows := range b.windows {
+		var remaining []*window
+		for _, w := range windows {
+			if selected(w) {
+				fired = append(fired, w)
+			} else {
+				remaining = append(remaining, w)
+			}
+		}
+		if len(remaining) == 0 {
+			delete(b.windows, key)
+		} else {
+			b.windows[key] = remaining
+		}
+	}
+	sort.Slice(fired, func(i, j int) bool {
+		if fired[i].end.Equal(fired[j].end) {
+			return fired[i].key < fired[j].key
+		}
+		return fired[i].end.Before(fired[j].end)
+	})
+	for _, w := range fired {
+		pl, err := b.process(w.sink())
+		if err != nil {
+			return err
+		}
+		kvs := []interface{}{"key", w.key, "start", w.start, "end", w.end}
+		if pl != nil {
+			kvs = append(kvs, pl)
+		}
+		b.emitter.Broadcast(event.New(TopicWindow, kvs...))
+	}
+	return nil
+}
+
+// EOF
@@ -0,0 +1,163 @@
+// Tideland Go Library - Together - Cells - Behaviors - Unit Tests
+//
+// Copyright (C) 2010-2019 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license.
+
+package behaviors_test // import "tideland.dev/go/together/cells/behaviors"
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"testing"
+	"time"
+
+	"tideland.dev/go/audit/asserts"
+	"tideland.dev/go/together/cells/behaviors"
+	"tideland.dev/go/together/cells/event"
+	"tideland.dev/go/together/cells/mesh"
+)
+
+//--------------------
+// TESTS
+//--------------------
+
+// TestTumblingWindowBehavior tests the tumbling window behavior.
+func TestTumblingWindowBehavior(t *testing.T) {
+	assert := asserts.NewTesting(t, asserts.FailStop)
+	evtc := make(chan *event.Event, 10)
+	msh := mesh.New()
+	defer msh.Stop()
+
+	msh.SpawnCells(
+		behaviors.NewTumblingWindowBehavior("windows", "sensor", 10*time.Second, 0, countWindow),
+		newBridgeCollector("collector", evtc),
+	)
+	msh.Subscribe("windows", "collector")
+
+	emitAt(msh, "windows", 1, "a")
+	emitAt(msh, "windows", 3, "b")
+	emitAt(msh, "windows", 5, "a")
+	emitAt(msh, "windows", 2, "a")
+	emitAt(msh, "windows", 12, "a")
+	// Late event.
+	emitAt(msh, "windows", 4, "a")
+
+	assertWindow(assert, evtc, "a", 0, 10, 3)
+	assertWindow(assert, evtc, "b", 0, 10, 1)
+
+	msh.Emit("windows", event.New(event.TopicProcess))
+	assertWindow(assert, evtc, "a", 10, 20, 1)
+}
+
+// TestSlidingWindowBehavior tests the sliding window behavior.
+func TestSlidingWindowBehavior(t *testing.T) {
+	assert := asserts.NewTesting(t, asserts.FailStop)
+	evtc := make(chan *event.Event, 10)
+	msh := mesh.New()
+	defer msh.Stop()
+
+	msh.SpawnCells(
+		behaviors.NewSlidingWindowBehavior("windows", "sensor", 10*time.Second, 5*time.Second, 0, countWindow),
+		newBridgeCollector("collector", evtc),
+	)
+	msh.Subscribe("windows", "collector")
+
+	emitAt(msh, "windows", 1, "a")
+	emitAt(msh, "windows", 7, "a")
+	emitAt(msh, "windows", 12, "a")
+
+	assertWindow(assert, evtc, "a", -5, 5, 1)
+	assertWindow(assert, evtc, "a", 0, 10, 2)
+
+	msh.Emit("windows", event.New(event.TopicProcess))
+	assertWindow(assert, evtc, "a", 5, 15, 2)
+	assertWindow(assert, evtc, "a", 10, 20, 1)
+}
+
+// TestWindowBehaviorInvalid tests the rejection of invalid window
+// durations.
+func TestWindowBehaviorInvalid(t *testing.T) {
+	assert := asserts.NewTesting(t, asserts.FailStop)
+
+	tests := []struct {
+		behavior mesh.Behavior
+		err      string
+	}{
+		{behaviors.NewTumblingWindowBehavior("w", "", 0, 0, countWindow), ".*invalid window size 0s.*"},
+		{behaviors.NewSlidingWindowBehavior("w", "", -time.Second, time.Second, 0, countWindow), ".*invalid window size -1s.*"},
+		{behaviors.NewSlidingWindowBehavior("w", "", 10*time.Second, 0, 0, countWindow), ".*invalid window slide 0s for size 10s.*"},
+		{behaviors.NewSlidingWindowBehavior("w", "", 10*time.Second, -time.Second, 0, countWindow), ".*invalid window slide -1s.*"},
+		{behaviors.NewSlidingWindowBehavior("w", "", 10*time.Second, 20*time.Second, 0, countWindow), ".*invalid window slide 20s.*"},
+		{behaviors.NewSessionWindowBehavior("w", "", 0, 0, countWindow), ".*invalid session gap 0s.*"},
+	}
+	for i, test := range tests {
+		assert.Logf("test case #%d: %s", i, test.err)
+		assert.ErrorMatch(test.behavior.Init(nil), test.err)
+	}
+}
+
+// TestSessionWindowBehavior tests the session window behavior.
+func TestSessionWindowBehavior(t *testing.T) {
+	assert := asserts.NewTesting(t, asserts.FailStop)
+	evtc := make(chan *event.Event, 10)
+	msh := mesh.New()
+	defer msh.Stop()
+
+	msh.SpawnCells(
+		behaviors.NewSessionWindowBehavior("windows", "sensor", 5*time.Second, 2*time.Second, countWindow),
+		newBridgeCollector("collector", evtc),
+	)
+	msh.Subscribe("windows", "collector")
+
+	emitAt(msh, "windows", 0, "a")
+	emitAt(msh, "windows", 3, "a")
+	emitAt(msh, "windows", 8, "a")
+	// Out of order but within lateness.
+	emitAt(msh, "windows", 6, "a")
+	emitAt(msh, "windows", 20, "a")
+	emitAt(msh, "windows", 21, "b")
+
+	assertWindow(assert, evtc, "a", 0, 13, 4)
+
+	msh.Emit("windows", event.New(behaviors.TopicWatermark, "time", windowTime(30)))
+	assertWindow(assert, evtc, "a", 20, 25, 1)
+	assertWindow(assert, evtc, "b", 21, 26, 1)
+}
+
+//--------------------
+// HELPERS
+//--------------------
+
+// windowBase is the base time of the window tests.
+var windowBase = time.Date(2019, time.January, 1, 12, 0, 0, 0, time.UTC)
+
+// windowTime returns the base time plus the seconds.
+func windowTime(seconds int) time.Time {
+	return windowBase.Add(time.Duration(seconds) * time.Second)
+}
+
+// emitAt emits an event with the timestamp based on the seconds.
+func emitAt(msh *mesh.Mesh, id string, seconds int, sensor string) {
+	msh.Emit(id, event.WithTimestamp(windowTime(seconds), "measure", "sensor", sensor))
+}
+
+// countWindow counts the events in a window.
+func countWindow(accessor event.SinkAccessor) (*event.Payload, error) {
+	return event.NewPayload("count", accessor.Len()), nil
+}
+
+// assertWindow checks the next window.
+func assertWindow(assert *asserts.Asserts, evtc chan *event.Event, key string, start, end, count int) {
+	evt := waitBridged(assert, evtc)
+	assert.Equal(evt.Topic(), behaviors.TopicWindow)
+	assert.Equal(evt.Payload().At("key").AsString(""), key)
+	assert.Equal(evt.Payload().At("start").AsTime(time.Time{}), windowTime(start))
+	assert.Equal(evt.Payload().At("end").AsTime(time.Time{}), windowTime(end))
+	assert.Equal(evt.Payload().At("count").AsInt(0), count)
+}
+
+// EOF
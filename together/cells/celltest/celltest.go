@@ -0,0 +1,330 @@
+// Tideland Go Library - Together - Cells - Cell Test
+//
+// Copyright (C) 2019 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license.
+
+package celltest // import "tideland.dev/go/together/cells/celltest"
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"sync"
+	"time"
+
+	"tideland.dev/go/audit/asserts"
+	"tideland.dev/go/dsa/timex"
+	"tideland.dev/go/together/cells/event"
+	"tideland.dev/go/together/cells/mesh"
+	"tideland.dev/go/trace/failure"
+)
+
+//--------------------
+// CONSTANTS
+//--------------------
+
+// StartTime is the time the clock of a test cell starts with.
+var StartTime = time.Date(2019, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+//--------------------
+// EMISSION
+//--------------------
+
+// Emission is an event emitted by the tested behavior.
+type Emission struct {
+	// To is the ID of the subscriber the event has been emitted
+	// to. It is empty for broadcasted events.
+	To string
+
+	// Event is the emitted event.
+	Event *event.Event
+}
+
+//--------------------
+// TEST CELL
+//--------------------
+
+// TestCell runs a behavior for tests.
+type TestCell struct {
+	mu            sync.Mutex
+	assert        *asserts.Asserts
+	behavior      mesh.Behavior
+	clock         *timex.ManualClock
+	msh           *mesh.Mesh
+	subscriberIDs []string
+	emissions     []Emission
+	queue         []*event.Event
+	processing    bool
+}
+
+// New creates a test cell and initializes the behavior. Failing
+// assertions are reported via the passed asserts.
+func New(assert *asserts.Asserts, behavior mesh.Behavior) *TestCell {
+	restore := assert.IncrCallstackOffset()
+	defer restore()
+	clock := timex.NewManualClock(StartTime)
+	tc := &TestCell{
+		assert:   assert,
+		behavior: behavior,
+		clock:    clock,
+		msh:      mesh.New(mesh.WithClock(clock)),
+	}
+	err := behavior.Init(&emitter{tc})
+	assert.NoError(err, "cannot init behavior")
+	return tc
+}
+
+// Clock returns the manual clock of the test cell.
+func (tc *TestCell) Clock() *timex.ManualClock {
+	return tc.clock
+}
+
+// Subscribe adds IDs to the subscribers the behavior can emit to.
+func (tc *TestCell) Subscribe(subscriberIDs ...string) {
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+	tc.subscriberIDs = append(tc.subscriberIDs, subscriberIDs...)
+}
+
+// Process lets the behavior process an event with the given topic
+// and payload. Its timestamp is the current time of the clock. The
+// errors of the processing are returned.
+func (tc *TestCell) Process(topic string, payloads ...interface{}) error {
+	return tc.ProcessEvent(event.WithTimestamp(tc.clock.Now(), topic, payloads...))
+}
+
+// ProcessEvent lets the behavior process the given event and all
+// events it emits to itself. Like in a mesh processing errors are
+// replied to a possible requester and the behavior has to recover.
+// The errors are returned.
+func (tc *TestCell) ProcessEvent(evt *event.Event) error {
+	tc.mu.Lock()
+	tc.queue = append(tc.queue, evt)
+	if tc.processing {
+		// Will be processed by the current processing.
+		tc.mu.Unlock()
+		return nil
+	}
+	tc.processing = true
+	var errs []error
+	for len(tc.queue) > 0 {
+		evt := tc.queue[0]
+		tc.queue = tc.queue[1:]
+		tc.mu.Unlock()
+		errs = append(errs, tc.process(evt))
+		tc.mu.Lock()
+	}
+	tc.processing = false
+	tc.mu.Unlock()
+	return failure.Collect(errs...)
+}
+
+// Advance moves the clock forward. Timers and tickers of the behavior
+// fire during this call and the events they emit to the behavior are
+// processed.
+func (tc *TestCell) Advance(d time.Duration) {
+	tc.clock.Advance(d)
+}
+
+// Emissions returns the events emitted by the behavior so far.
+func (tc *TestCell) Emissions() []Emission {
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+	return append([]Emission{}, tc.emissions...)
+}
+
+// Reset drops the recorded emissions.
+func (tc *TestCell) Reset() {
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+	tc.emissions = nil
+}
+
+// Stop terminates the behavior.
+func (tc *TestCell) Stop() {
+	restore := tc.assert.IncrCallstackOffset()
+	defer restore()
+	err := tc.behavior.Terminate()
+	tc.assert.NoError(err, "cannot terminate behavior")
+}
+
+// Event returns the event of the emission with the given index.
+func (tc *TestCell) Event(n int) *event.Event {
+	restore := tc.assert.IncrCallstackOffset()
+	defer restore()
+	emissions := tc.Emissions()
+	if !tc.assert.Range(n, 0, len(emissions)-1, "no emission with this index") {
+		return nil
+	}
+	return emissions[n].Event
+}
+
+// AssertTopics checks the topics of all emitted events.
+func (tc *TestCell) AssertTopics(topics ...string) {
+	restore := tc.assert.IncrCallstackOffset()
+	defer restore()
+	tc.assertTopics(emittedTopics(tc.Emissions(), false, ""), topics)
+}
+
+// AssertEmitted checks the topics of the events emitted to the
+// given subscriber. An empty ID checks the broadcasted events.
+func (tc *TestCell) AssertEmitted(to string, topics ...string) {
+	restore := tc.assert.IncrCallstackOffset()
+	defer restore()
+	tc.assertTopics(emittedTopics(tc.Emissions(), true, to), topics)
+}
+
+// AssertPayload checks values of the payload of the emission with
+// the given index. The payloads are pairs of keys and expected values.
+// The values are converted into the type of the expected ones, which
+// have to be strings, ints, float64s, bools, times, or durations.
+func (tc *TestCell) AssertPayload(n int, payloads ...interface{}) {
+	restore := tc.assert.IncrCallstackOffset()
+	defer restore()
+	emissions := tc.Emissions()
+	if !tc.assert.Range(n, 0, len(emissions)-1, "no emission with this index") {
+		return
+	}
+	pl := emissions[n].Event.Payload()
+	for i := 0; i < len(payloads); i += 2 {
+		key, ok := payloads[i].(string)
+		if !tc.assert.True(ok, "payload key is no string") {
+			return
+		}
+		if !tc.assert.True(i+1 < len(payloads), "payload key "+key+" has no value") {
+			return
+		}
+		value := pl.At(key)
+		if !tc.assert.False(value.IsUndefined(), "payload value "+key+" is undefined") {
+			return
+		}
+		tc.assert.Equal(valueAs(value, payloads[i+1]), payloads[i+1], "payload value "+key+" differs")
+	}
+}
+
+// assertTopics compares the emitted topics with the expected ones.
+func (tc *TestCell) assertTopics(emitted, expected []string) {
+	if len(expected) == 0 {
+		tc.assert.Empty(emitted, "emitted topics differ")
+		return
+	}
+	tc.assert.Equal(emitted, expected, "emitted topics differ")
+}
+
+// process lets the behavior process one event like a cell.
+func (tc *TestCell) process(evt *event.Event) error {
+	if evt.Done() {
+		return nil
+	}
+	perr := tc.behavior.Process(evt)
+	if perr == nil {
+		return nil
+	}
+	mesh.ReplyError(evt, perr)
+	return failure.Collect(perr, tc.behavior.Recover(perr))
+}
+
+// record adds an emission.
+func (tc *TestCell) record(to string, evt *event.Event) {
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+	tc.emissions = append(tc.emissions, Emission{
+		To:    to,
+		Event: evt,
+	})
+}
+
+// subscribed checks if the ID belongs to a subscriber.
+func (tc *TestCell) subscribed(id string) bool {
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+	for _, subscriberID := range tc.subscriberIDs {
+		if subscriberID == id {
+			return true
+		}
+	}
+	return false
+}
+
+//--------------------
+// EMITTER
+//--------------------
+
+// emitter implements mesh.Emitter for the test cell.
+type emitter struct {
+	tc *TestCell
+}
+
+// Mesh implements mesh.Emitter. It is empty but returns the
+// manual clock.
+func (e *emitter) Mesh() *mesh.Mesh {
+	return e.tc.msh
+}
+
+// Subscribers implements mesh.Emitter.
+func (e *emitter) Subscribers() []string {
+	e.tc.mu.Lock()
+	defer e.tc.mu.Unlock()
+	return append([]string{}, e.tc.subscriberIDs...)
+}
+
+// Emit implements mesh.Emitter.
+func (e *emitter) Emit(id string, evt *event.Event) error {
+	if !e.tc.subscribed(id) {
+		return failure.New("cell %q is no subscriber", id)
+	}
+	e.tc.record(id, evt)
+	return nil
+}
+
+// Broadcast implements mesh.Emitter.
+func (e *emitter) Broadcast(evt *event.Event) error {
+	e.tc.record("", evt)
+	return nil
+}
+
+// Self implements mesh.Emitter.
+func (e *emitter) Self(evt *event.Event) error {
+	return e.tc.ProcessEvent(evt)
+}
+
+//--------------------
+// HELPERS
+//--------------------
+
+// emittedTopics returns the topics of the emissions, possibly
+// filtered by the receiver.
+func emittedTopics(emissions []Emission, filter bool, to string) []string {
+	var topics []string
+	for _, emission := range emissions {
+		if filter && emission.To != to {
+			continue
+		}
+		topics = append(topics, emission.Event.Topic())
+	}
+	return topics
+}
+
+// valueAs returns the value in the type of the expected one.
+func valueAs(value *event.Value, expected interface{}) interface{} {
+	switch e := expected.(type) {
+	case string:
+		return value.AsString(e + "<invalid>")
+	case int:
+		return value.AsInt(e + 1)
+	case float64:
+		return value.AsFloat64(e + 1)
+	case bool:
+		return value.AsBool(!e)
+	case time.Time:
+		return value.AsTime(e.Add(1))
+	case time.Duration:
+		return value.AsDuration(e + 1)
+	}
+	return value
+}
+
+// EOF
@@ -0,0 +1,175 @@
+// Tideland Go Library - Together - Cells - Cell Test - Unit Tests
+//
+// Copyright (C) 2019 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license.
+
+package celltest_test // import "tideland.dev/go/together/cells/celltest"
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"tideland.dev/go/audit/asserts"
+	"tideland.dev/go/together/cells/behaviors"
+	"tideland.dev/go/together/cells/celltest"
+	"tideland.dev/go/together/cells/event"
+	"tideland.dev/go/together/cells/mesh"
+)
+
+//--------------------
+// TESTS
+//--------------------
+
+// TestTicker tests the controlled clock with the ticker behavior.
+func TestTicker(t *testing.T) {
+	assert := asserts.NewTesting(t, asserts.FailStop)
+	tc := celltest.New(assert, behaviors.NewTickerBehavior("ticker", time.Minute))
+
+	tc.Advance(59 * time.Second)
+	tc.AssertTopics()
+
+	tc.Advance(150 * time.Second)
+	tc.AssertTopics(behaviors.TopicTick, behaviors.TopicTick, behaviors.TopicTick)
+	tc.AssertEmitted("", behaviors.TopicTick, behaviors.TopicTick, behaviors.TopicTick)
+	tc.AssertPayload(0, "id", "ticker")
+	assert.Equal(tc.Event(2).Timestamp(), celltest.StartTime.Add(3*time.Minute))
+
+	tc.Stop()
+	tc.Reset()
+	tc.Advance(time.Hour)
+	tc.AssertTopics()
+	assert.Equal(tc.Clock().Timers(), 0)
+}
+
+// TestPair tests timestamps based on the controlled clock with
+// the pair behavior.
+func TestPair(t *testing.T) {
+	assert := asserts.NewTesting(t, asserts.FailStop)
+	matches := func(evt *event.Event, pl *event.Payload) (*event.Payload, bool) {
+		return pl, evt.Topic() == "login"
+	}
+	tc := celltest.New(assert, behaviors.NewPairBehavior("pair", matches, time.Minute))
+	defer tc.Stop()
+
+	assert.NoError(tc.Process("login"))
+	tc.Advance(30 * time.Second)
+	assert.NoError(tc.Process("logout"))
+	assert.NoError(tc.Process("login"))
+	tc.Advance(30 * time.Second)
+	assert.NoError(tc.Process("login"))
+	tc.Advance(90 * time.Second)
+	assert.NoError(tc.Process("login"))
+
+	tc.AssertTopics(behaviors.TopicPair, behaviors.TopicPairTimeout)
+	tc.AssertPayload(0,
+		"first", celltest.StartTime,
+		"second", celltest.StartTime.Add(30*time.Second),
+	)
+	tc.AssertPayload(1, "timeout", celltest.StartTime.Add(2*time.Minute))
+}
+
+// TestRate tests the durations of the rate behavior starting with
+// the time of the clock at initialization.
+func TestRate(t *testing.T) {
+	assert := asserts.NewTesting(t, asserts.FailStop)
+	matches := func(evt *event.Event) (bool, error) {
+		return evt.Topic() == "action", nil
+	}
+	tc := celltest.New(assert, behaviors.NewRateBehavior("rate", matches, 5))
+	defer tc.Stop()
+
+	tc.Advance(10 * time.Second)
+	assert.NoError(tc.Process("action"))
+	tc.Advance(30 * time.Second)
+	assert.NoError(tc.Process("action"))
+
+	tc.AssertTopics(behaviors.TopicRate, behaviors.TopicRate)
+	tc.AssertPayload(0, "duration", 10*time.Second)
+	tc.AssertPayload(1,
+		"duration", 30*time.Second,
+		"low", 10*time.Second,
+		"high", 30*time.Second,
+		"average", 20*time.Second,
+	)
+}
+
+// TestRateWindow tests the rate window behavior.
+func TestRateWindow(t *testing.T) {
+	assert := asserts.NewTesting(t, asserts.FailStop)
+	matches := func(evt *event.Event) (bool, error) {
+		return evt.Topic() == "error", nil
+	}
+	process := func(accessor event.SinkAccessor) (*event.Payload, error) {
+		first, _ := accessor.PeekFirst()
+		return event.NewPayload("first", first.Timestamp(), "len", accessor.Len()), nil
+	}
+	tc := celltest.New(assert, behaviors.NewRateWindowBehavior("rate", matches, 3, time.Minute, process))
+	defer tc.Stop()
+
+	for i := 0; i < 3; i++ {
+		assert.NoError(tc.Process("error"))
+		tc.Advance(40 * time.Second)
+	}
+	tc.AssertTopics()
+	for i := 0; i < 3; i++ {
+		assert.NoError(tc.Process("error"))
+		tc.Advance(20 * time.Second)
+	}
+	tc.AssertTopics(behaviors.TopicRateWindow, behaviors.TopicRateWindow)
+	tc.AssertPayload(0, "first", celltest.StartTime.Add(80*time.Second), "len", 3)
+	tc.AssertPayload(1, "first", celltest.StartTime.Add(120*time.Second))
+}
+
+// TestEmitter tests emitting to subscribers, to the behavior itself,
+// and the handling of errors.
+func TestEmitter(t *testing.T) {
+	assert := asserts.NewTesting(t, asserts.FailStop)
+	processor := func(emitter mesh.Emitter, evt *event.Event) error {
+		switch evt.Topic() {
+		case "forward":
+			to := evt.Payload().At("to").AsString("")
+			return emitter.Emit(to, event.New("forwarded"))
+		case "loop":
+			n := evt.Payload().At("n").AsInt(0)
+			if n > 0 {
+				emitter.Self(event.New("loop", "n", n-1))
+			}
+			return emitter.Broadcast(event.New("looped", "n", n))
+		case "fail":
+			return errors.New("failing on purpose")
+		}
+		return nil
+	}
+	tc := celltest.New(assert, behaviors.NewSimpleProcessorBehavior("simple", processor))
+	defer tc.Stop()
+	tc.Subscribe("foo", "bar")
+
+	assert.NoError(tc.Process("forward", "to", "foo"))
+	assert.NoError(tc.Process("forward", "to", "bar"))
+	err := tc.Process("forward", "to", "baz")
+	assert.ErrorMatch(err, `.*cell "baz" is no subscriber.*`)
+	tc.AssertEmitted("foo", "forwarded")
+	tc.AssertEmitted("bar", "forwarded")
+
+	tc.Reset()
+	assert.NoError(tc.Process("loop", "n", 2))
+	tc.AssertTopics("looped", "looped", "looped")
+	tc.AssertPayload(0, "n", 2)
+	tc.AssertPayload(2, "n", 0)
+
+	pl, plc := event.NewReplyPayload()
+	err = tc.ProcessEvent(event.New("fail", pl))
+	assert.ErrorMatch(err, ".*failing on purpose.*")
+	rpl, err := plc.Wait(time.Second)
+	assert.NoError(err)
+	assert.Equal(rpl.At("reply-error").AsString(""), "failing on purpose")
+}
+
+// EOF
@@ -0,0 +1,26 @@
+// Tideland Go Library - Together - Cells - Cell Test
+//
+// Copyright (C) 2019 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license.
+
+// Package celltest helps testing behaviors deterministically. A test
+// cell runs a behavior synchronously without a mesh and records the
+// events it emits. Time is controlled by a manual clock, which is also
+// returned as clock of the mesh to the behavior.
+//
+//     tc := celltest.New(assert, behaviors.NewTickerBehavior("ticker", time.Minute))
+//     defer tc.Stop()
+//
+//     tc.Advance(3 * time.Minute)
+//     tc.AssertTopics("tick", "tick", "tick")
+//
+// Events are processed with tc.Process("topic", "key", "value"). Their
+// timestamps are the current time of the clock, so behaviors working
+// with timestamps like the pair or the rate window behavior can be
+// tested without sleeps. Events the behavior emits to itself are
+// processed directly after the current one.
+package celltest // import "tideland.dev/go/together/cells/celltest"
+
+// EOF
@@ -0,0 +1,297 @@
+// Tideland Go Library - Together - Cells - Event
+//
+// Copyright (C) 2010-2019 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license
+
+package event // import "tideland.dev/go/together/cells/event"
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"math"
+	"sort"
+	"time"
+
+	"tideland.dev/go/trace/failure"
+)
+
+//--------------------
+// CONSTANTS
+//--------------------
+
+// binaryVersion is the first byte of binary encoded events.
+const binaryVersion byte = 1
+
+// Type tags of binary encoded payload values.
+const (
+	tagString byte = iota + 1
+	tagInt
+	tagFloat64
+	tagBool
+	tagTime
+	tagDuration
+	tagPayload
+)
+
+// binaryTags maps the type names of serialized values to the tags.
+var binaryTags = map[string]byte{
+	typeString:   tagString,
+	typeInt:      tagInt,
+	typeFloat64:  tagFloat64,
+	typeBool:     tagBool,
+	typeTime:     tagTime,
+	typeDuration: tagDuration,
+	typePayload:  tagPayload,
+}
+
+//--------------------
+// EVENT BINARY ENCODING
+//--------------------
+
+// MarshalBinary implements encoding.BinaryMarshaler. Like with JSON
+// the context of the event is not serialized. The encoding is more
+// compact than JSON and keeps the value types of the payload too.
+func (e *Event) MarshalBinary() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte(binaryVersion)
+	ts, err := e.timestamp.MarshalBinary()
+	if err != nil {
+		return nil, failure.Annotate(err, "cannot marshal event timestamp")
+	}
+	writeBytes(&buf, ts)
+	writeBytes(&buf, []byte(e.topic))
+	if err := writePayload(&buf, e.payload); err != nil {
+		return nil, failure.Annotate(err, "cannot marshal event payload")
+	}
+	return buf.Bytes(), nil
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler. The original
+// timestamp is restored, the context is a background one.
+func (e *Event) UnmarshalBinary(data []byte) error {
+	r := bytes.NewReader(data)
+	version, err := r.ReadByte()
+	if err != nil {
+		return failure.Annotate(err, "cannot unmarshal event")
+	}
+	if version != binaryVersion {
+		return failure.New("cannot unmarshal event: invalid version %d", version)
+	}
+	ts, err := readBytes(r)
+	if err != nil {
+		return failure.Annotate(err, "cannot unmarshal event timestamp")
+	}
+	var timestamp time.Time
+	if err := timestamp.UnmarshalBinary(ts); err != nil {
+		return failure.Annotate(err, "cannot unmarshal event timestamp")
+	}
+	topic, err := readBytes(r)
+	if err != nil {
+		return failure.Annotate(err, "cannot unmarshal event topic")
+	}
+	pl, err := readPayload(r)
+	if err != nil {
+		return failure.Annotate(err, "cannot unmarshal event payload")
+	}
+	if r.Len() > 0 {
+		return failure.New("cannot unmarshal event: %d bytes left", r.Len())
+	}
+	e.ctx = context.Background()
+	e.timestamp = timestamp
+	e.topic = string(topic)
+	e.payload = pl
+	return nil
+}
+
+//--------------------
+// PAYLOAD BINARY ENCODING
+//--------------------
+
+// MarshalBinary implements encoding.BinaryMarshaler. Only values of
+// the types readable by the accessors of Value can be marshalled, a
+// possible reply channel is not serialized.
+func (pl *Payload) MarshalBinary() ([]byte, error) {
+	var buf bytes.Buffer
+	if err := writePayload(&buf, pl); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler.
+func (pl *Payload) UnmarshalBinary(data []byte) error {
+	r := bytes.NewReader(data)
+	rpl, err := readPayload(r)
+	if err != nil {
+		return err
+	}
+	if r.Len() > 0 {
+		return failure.New("cannot unmarshal payload: %d bytes left", r.Len())
+	}
+	pl.values = rpl.values
+	return nil
+}
+
+// writePayload writes the number of values followed by the sorted
+// keys and their tagged values.
+func writePayload(buf *bytes.Buffer, pl *Payload) error {
+	keys := pl.Keys()
+	sort.Strings(keys)
+	writeUvarint(buf, uint64(len(keys)))
+	for _, key := range keys {
+		writeBytes(buf, []byte(key))
+		if err := writeValue(buf, pl.values[key]); err != nil {
+			return failure.Annotate(err, "cannot marshal payload value at key %q", key)
+		}
+	}
+	return nil
+}
+
+// writeValue writes the tag and the data of one raw value.
+func writeValue(buf *bytes.Buffer, raw interface{}) error {
+	typ, v, err := normalizeValue(raw)
+	if err != nil {
+		return err
+	}
+	buf.WriteByte(binaryTags[typ])
+	switch tv := v.(type) {
+	case string:
+		writeBytes(buf, []byte(tv))
+	case int:
+		writeVarint(buf, int64(tv))
+	case float64:
+		var b [8]byte
+		binary.BigEndian.PutUint64(b[:], math.Float64bits(tv))
+		buf.Write(b[:])
+	case bool:
+		if tv {
+			buf.WriteByte(1)
+		} else {
+			buf.WriteByte(0)
+		}
+	case time.Time:
+		data, err := tv.MarshalBinary()
+		if err != nil {
+			return err
+		}
+		writeBytes(buf, data)
+	case time.Duration:
+		writeVarint(buf, int64(tv))
+	case *Payload:
+		return writePayload(buf, tv)
+	}
+	return nil
+}
+
+// readPayload reads a payload written by writePayload.
+func readPayload(r *bytes.Reader) (*Payload, error) {
+	l, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, failure.Annotate(err, "cannot unmarshal payload")
+	}
+	if l > uint64(r.Len()) {
+		return nil, failure.New("cannot unmarshal payload: invalid length %d", l)
+	}
+	pl := NewPayload()
+	for i := uint64(0); i < l; i++ {
+		key, err := readBytes(r)
+		if err != nil {
+			return nil, failure.Annotate(err, "cannot unmarshal payload key")
+		}
+		raw, err := readValue(r)
+		if err != nil {
+			return nil, failure.Annotate(err, "cannot unmarshal payload value at key %q", key)
+		}
+		pl.values[string(key)] = raw
+	}
+	return pl, nil
+}
+
+// readValue reads one raw value written by writeValue.
+func readValue(r *bytes.Reader) (interface{}, error) {
+	tag, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	switch tag {
+	case tagString:
+		data, err := readBytes(r)
+		return string(data), err
+	case tagInt:
+		i, err := binary.ReadVarint(r)
+		return int(i), err
+	case tagFloat64:
+		var b [8]byte
+		if _, err := io.ReadFull(r, b[:]); err != nil {
+			return nil, err
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(b[:])), nil
+	case tagBool:
+		b, err := r.ReadByte()
+		return b == 1, err
+	case tagTime:
+		data, err := readBytes(r)
+		if err != nil {
+			return nil, err
+		}
+		var t time.Time
+		err = t.UnmarshalBinary(data)
+		return t, err
+	case tagDuration:
+		d, err := binary.ReadVarint(r)
+		return time.Duration(d), err
+	case tagPayload:
+		return readPayload(r)
+	}
+	return nil, failure.New("invalid value tag %d", tag)
+}
+
+//--------------------
+// BINARY HELPERS
+//--------------------
+
+// writeUvarint writes an unsigned variable length integer.
+func writeUvarint(buf *bytes.Buffer, x uint64) {
+	var b [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(b[:], x)
+	buf.Write(b[:n])
+}
+
+// writeVarint writes a signed variable length integer.
+func writeVarint(buf *bytes.Buffer, x int64) {
+	var b [binary.MaxVarintLen64]byte
+	n := binary.PutVarint(b[:], x)
+	buf.Write(b[:n])
+}
+
+// writeBytes writes a length prefixed byte slice.
+func writeBytes(buf *bytes.Buffer, data []byte) {
+	writeUvarint(buf, uint64(len(data)))
+	buf.Write(data)
+}
+
+// readBytes reads a length prefixed byte slice.
+func readBytes(r *bytes.Reader) ([]byte, error) {
+	l, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, err
+	}
+	if l > uint64(r.Len()) {
+		return nil, failure.New("invalid length %d", l)
+	}
+	data := make([]byte, l)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// EOF
@@ -0,0 +1,492 @@
+// Tideland Go Library - Together - Cells - Event
+//
+// Copyright (C) 2010-2019 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license
+
+package event // import "tideland.dev/go/together/cells/event"
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"tideland.dev/go/trace/failure"
+)
+
+//--------------------
+// CONSTANTS
+//--------------------
+
+// bindingTag is the struct tag controlling the binding of fields.
+const bindingTag = "payload"
+
+// Types handled special during binding.
+var (
+	timeType     = reflect.TypeOf(time.Time{})
+	durationType = reflect.TypeOf(time.Duration(0))
+	payloadType  = reflect.TypeOf(&Payload{})
+)
+
+//--------------------
+// BINDING FIELDS
+//--------------------
+
+// bindingField describes one bound field of a struct.
+type bindingField struct {
+	index     int
+	key       string
+	required  bool
+	omitempty bool
+}
+
+// bindingFields returns the bound fields of a struct type. The key
+// is the name of the tag "payload" or the field name. Options are
+// "required" and "omitempty", a tag "-" ignores the field.
+func bindingFields(t reflect.Type) []bindingField {
+	var fields []bindingField
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		if sf.PkgPath != "" {
+			// Unexported field.
+			continue
+		}
+		tag := sf.Tag.Get(bindingTag)
+		if tag == "-" {
+			continue
+		}
+		parts := strings.Split(tag, ",")
+		field := bindingField{
+			index: i,
+			key:   parts[0],
+		}
+		if field.key == "" {
+			field.key = sf.Name
+		}
+		for _, option := range parts[1:] {
+			switch option {
+			case "required":
+				field.required = true
+			case "omitempty":
+				field.omitempty = true
+			}
+		}
+		fields = append(fields, field)
+	}
+	return fields
+}
+
+// bindingPath returns the path of a key for error messages.
+func bindingPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+//--------------------
+// PAYLOAD TO STRUCT
+//--------------------
+
+// Bind sets the fields of the struct v points to with the values of
+// the payload. The keys are taken from the struct tag "payload", e.g.
+// `payload:"name,required"`, or the field names. Nested structs, slices,
+// arrays, and maps with string keys are bound to nested payloads, slices
+// and arrays by their index as key. The length of a slice is taken from
+// the highest index, so missing indexes like those of nil elements stay
+// zero. Trailing nil elements are lost. Additionally time.Time,
+// time.Duration, and *Payload fields are supported. In case of missing
+// required or invalid values the returned error collects one error per
+// field.
+func (pl *Payload) Bind(v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() || rv.Elem().Kind() != reflect.Struct {
+		return failure.New("cannot bind payload to %T, need pointer to struct", v)
+	}
+	return failure.Collect(bindStruct(pl, rv.Elem(), "")...)
+}
+
+// bindStruct sets the fields of a struct with the payload values.
+func bindStruct(pl *Payload, rv reflect.Value, path string) []error {
+	var errs []error
+	for _, field := range bindingFields(rv.Type()) {
+		fpath := bindingPath(path, field.key)
+		raw, ok := pl.values[field.key]
+		if !ok {
+			if field.required {
+				errs = append(errs, failure.New("missing payload value %q", fpath))
+			}
+			continue
+		}
+		errs = append(errs, bindValue(raw, rv.Field(field.index), fpath)...)
+	}
+	return errs
+}
+
+// bindValue sets one value of a struct, slice, or map.
+func bindValue(raw interface{}, rv reflect.Value, path string) []error {
+	invalid := func() []error {
+		return []error{failure.New("invalid payload value %q: cannot bind %T to %v", path, raw, rv.Type())}
+	}
+	switch rv.Type() {
+	case payloadType:
+		npl, ok := raw.(*Payload)
+		if !ok {
+			return invalid()
+		}
+		rv.Set(reflect.ValueOf(npl))
+		return nil
+	case timeType:
+		t, ok := bindTime(raw)
+		if !ok {
+			return invalid()
+		}
+		rv.Set(reflect.ValueOf(t))
+		return nil
+	case durationType:
+		d, ok := bindDuration(raw)
+		if !ok {
+			return invalid()
+		}
+		rv.Set(reflect.ValueOf(d))
+		return nil
+	}
+	switch rv.Kind() {
+	case reflect.String:
+		s, ok := bindString(raw)
+		if !ok {
+			return invalid()
+		}
+		rv.SetString(s)
+	case reflect.Bool:
+		b, ok := bindBool(raw)
+		if !ok {
+			return invalid()
+		}
+		rv.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i, ok := bindInt(raw)
+		if !ok || rv.OverflowInt(i) {
+			return invalid()
+		}
+		rv.SetInt(i)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		i, ok := bindInt(raw)
+		if !ok || i < 0 || rv.OverflowUint(uint64(i)) {
+			return invalid()
+		}
+		rv.SetUint(uint64(i))
+	case reflect.Float32, reflect.Float64:
+		f, ok := bindFloat(raw)
+		if !ok || rv.OverflowFloat(f) {
+			return invalid()
+		}
+		rv.SetFloat(f)
+	case reflect.Ptr:
+		pv := reflect.New(rv.Type().Elem())
+		errs := bindValue(raw, pv.Elem(), path)
+		if errs == nil {
+			rv.Set(pv)
+		}
+		return errs
+	case reflect.Struct:
+		npl, ok := raw.(*Payload)
+		if !ok {
+			return invalid()
+		}
+		return bindStruct(npl, rv, path)
+	case reflect.Slice:
+		npl, ok := raw.(*Payload)
+		if !ok {
+			return invalid()
+		}
+		l := 0
+		for key := range npl.values {
+			if i, err := strconv.Atoi(key); err == nil && i >= l {
+				l = i + 1
+			}
+		}
+		sv := reflect.MakeSlice(rv.Type(), l, l)
+		errs := bindIndexed(npl, sv, path)
+		rv.Set(sv)
+		return errs
+	case reflect.Array:
+		npl, ok := raw.(*Payload)
+		if !ok {
+			return invalid()
+		}
+		return bindIndexed(npl, rv, path)
+	case reflect.Map:
+		npl, ok := raw.(*Payload)
+		if !ok || rv.Type().Key().Kind() != reflect.String {
+			return invalid()
+		}
+		var errs []error
+		mv := reflect.MakeMap(rv.Type())
+		for key, nraw := range npl.values {
+			ev := reflect.New(rv.Type().Elem()).Elem()
+			kerrs := bindValue(nraw, ev, bindingPath(path, key))
+			if kerrs != nil {
+				errs = append(errs, kerrs...)
+				continue
+			}
+			mv.SetMapIndex(reflect.ValueOf(key).Convert(rv.Type().Key()), ev)
+		}
+		rv.Set(mv)
+		return errs
+	case reflect.Interface:
+		if !reflect.TypeOf(raw).AssignableTo(rv.Type()) {
+			return invalid()
+		}
+		rv.Set(reflect.ValueOf(raw))
+	default:
+		return invalid()
+	}
+	return nil
+}
+
+// bindIndexed sets the elements of a slice or an array with the
+// payload values at the according indexes.
+func bindIndexed(pl *Payload, rv reflect.Value, path string) []error {
+	var errs []error
+	for i := 0; i < rv.Len(); i++ {
+		key := strconv.Itoa(i)
+		raw, ok := pl.values[key]
+		if !ok {
+			continue
+		}
+		errs = append(errs, bindValue(raw, rv.Index(i), bindingPath(path, key))...)
+	}
+	return errs
+}
+
+// bindString converts strings, numbers, and bools into a string.
+func bindString(raw interface{}) (string, bool) {
+	switch tv := raw.(type) {
+	case string:
+		return tv, true
+	case int, float64, bool:
+		v := &Value{raw: tv}
+		return v.AsString(""), true
+	}
+	return "", false
+}
+
+// bindBool converts a raw value into a bool.
+func bindBool(raw interface{}) (bool, bool) {
+	switch tv := raw.(type) {
+	case bool:
+		return tv, true
+	case string:
+		b, err := strconv.ParseBool(tv)
+		return b, err == nil
+	}
+	return false, false
+}
+
+// bindInt converts a raw value into an int64. Floats are only
+// accepted without fraction.
+func bindInt(raw interface{}) (int64, bool) {
+	switch tv := raw.(type) {
+	case int:
+		return int64(tv), true
+	case int8:
+		return int64(tv), true
+	case int16:
+		return int64(tv), true
+	case int32:
+		return int64(tv), true
+	case int64:
+		return tv, true
+	case float64:
+		if tv != math.Trunc(tv) || tv < math.MinInt64 || tv >= math.MaxInt64 {
+			return 0, false
+		}
+		return int64(tv), true
+	case string:
+		i, err := strconv.ParseInt(tv, 10, 64)
+		return i, err == nil
+	}
+	return 0, false
+}
+
+// bindFloat converts a raw value into a float64.
+func bindFloat(raw interface{}) (float64, bool) {
+	switch tv := raw.(type) {
+	case float64:
+		return tv, true
+	case float32:
+		return float64(tv), true
+	case string:
+		f, err := strconv.ParseFloat(tv, 64)
+		return f, err == nil
+	}
+	if i, ok := bindInt(raw); ok {
+		return float64(i), true
+	}
+	return 0, false
+}
+
+// bindTime converts a raw value into a time. Strings have to
+// be in one of the formats parseable by Value.AsTime.
+func bindTime(raw interface{}) (time.Time, bool) {
+	switch tv := raw.(type) {
+	case time.Time:
+		return tv, true
+	case string:
+		for _, timeFormat := range timeFormats {
+			t, err := time.Parse(timeFormat, tv)
+			if err == nil {
+				return t, true
+			}
+		}
+	}
+	return time.Time{}, false
+}
+
+// bindDuration converts a raw value into a duration. Numbers
+// are interpreted as nanoseconds.
+func bindDuration(raw interface{}) (time.Duration, bool) {
+	switch tv := raw.(type) {
+	case time.Duration:
+		return tv, true
+	case string:
+		d, err := time.ParseDuration(tv)
+		return d, err == nil
+	}
+	if i, ok := bindInt(raw); ok {
+		return time.Duration(i), true
+	}
+	return 0, false
+}
+
+//--------------------
+// STRUCT TO PAYLOAD
+//--------------------
+
+// NewPayloadFrom creates a payload with the fields of the struct
+// or pointer to struct v. It uses the same keys and mapping of types
+// as Payload.Bind. Nil pointers as well as zero values of fields with
+// the option "omitempty" are not set. Fields of types which cannot be
+// mapped, like channels or functions, lead to an error.
+func NewPayloadFrom(v interface{}) (*Payload, error) {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() == reflect.Ptr && !rv.IsNil() {
+		rv = rv.Elem()
+	}
+	if rv.Kind() != reflect.Struct {
+		return nil, failure.New("cannot create payload from %T, need struct", v)
+	}
+	pl, errs := unbindStruct(rv, "")
+	if err := failure.Collect(errs...); err != nil {
+		return nil, err
+	}
+	return pl, nil
+}
+
+// unbindStruct creates a payload with the fields of a struct.
+func unbindStruct(rv reflect.Value, path string) (*Payload, []error) {
+	var errs []error
+	pl := NewPayload()
+	for _, field := range bindingFields(rv.Type()) {
+		fv := rv.Field(field.index)
+		if field.omitempty && isZero(fv) {
+			continue
+		}
+		raw, ferrs := unbindValue(fv, bindingPath(path, field.key))
+		if ferrs != nil {
+			errs = append(errs, ferrs...)
+			continue
+		}
+		if raw != nil {
+			pl.values[field.key] = raw
+		}
+	}
+	return pl, errs
+}
+
+// unbindValue converts a value into a raw payload value. Nil is
+// returned for nil pointers.
+func unbindValue(rv reflect.Value, path string) (interface{}, []error) {
+	switch rv.Type() {
+	case payloadType:
+		if rv.IsNil() {
+			return nil, nil
+		}
+		return rv.Interface(), nil
+	case timeType, durationType:
+		return rv.Interface(), nil
+	}
+	switch rv.Kind() {
+	case reflect.String:
+		return rv.String(), nil
+	case reflect.Bool:
+		return rv.Bool(), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return int(rv.Int()), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		u := rv.Uint()
+		if u > uint64(maxInt) {
+			return nil, []error{failure.New("invalid payload value %q: %d overflows int", path, u)}
+		}
+		return int(u), nil
+	case reflect.Float32, reflect.Float64:
+		return rv.Float(), nil
+	case reflect.Ptr, reflect.Interface:
+		if rv.IsNil() {
+			return nil, nil
+		}
+		return unbindValue(rv.Elem(), path)
+	case reflect.Struct:
+		return unbindStruct(rv, path)
+	case reflect.Slice, reflect.Array:
+		var errs []error
+		pl := NewPayload()
+		for i := 0; i < rv.Len(); i++ {
+			key := strconv.Itoa(i)
+			raw, ierrs := unbindValue(rv.Index(i), bindingPath(path, key))
+			if ierrs != nil {
+				errs = append(errs, ierrs...)
+				continue
+			}
+			if raw != nil {
+				pl.values[key] = raw
+			}
+		}
+		return pl, errs
+	case reflect.Map:
+		if rv.Type().Key().Kind() != reflect.String {
+			break
+		}
+		var errs []error
+		pl := NewPayload()
+		iter := rv.MapRange()
+		for iter.Next() {
+			key := iter.Key().String()
+			raw, kerrs := unbindValue(iter.Value(), bindingPath(path, key))
+			if kerrs != nil {
+				errs = append(errs, kerrs...)
+				continue
+			}
+			if raw != nil {
+				pl.values[key] = raw
+			}
+		}
+		return pl, errs
+	}
+	return nil, []error{failure.New("invalid payload value %q: cannot bind %v", path, rv.Type())}
+}
+
+// isZero checks if a value is the zero value of its type.
+func isZero(rv reflect.Value) bool {
+	return reflect.DeepEqual(rv.Interface(), reflect.Zero(rv.Type()).Interface())
+}
+
+// EOF
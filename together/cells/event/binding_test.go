@@ -0,0 +1,171 @@
+// Tideland Go Library - Together - Cells - Event - Unit Tests
+//
+// Copyright (C) 2010-2019 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license
+
+package event_test // import "tideland.dev/go/together/cells/event"
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"testing"
+	"time"
+
+	"tideland.dev/go/audit/asserts"
+	"tideland.dev/go/together/cells/event"
+	"tideland.dev/go/trace/failure"
+)
+
+//--------------------
+// TESTS
+//--------------------
+
+// TestBind verifies the binding of payloads to structs.
+func TestBind(t *testing.T) {
+	assert := asserts.NewTesting(t, asserts.FailStop)
+	now := time.Now()
+	pl := event.NewPayload(
+		"name", "order",
+		"count", 5,
+		"price", "12.5",
+		"express", true,
+		"ordered", now,
+		"timeout", "90s",
+		"address", event.NewPayload("street", "Main Street", "number", 5.0),
+		"items", []string{"a", "b", "c"},
+		"tags", map[string]int{"x": 1, "y": 2},
+		"extra", event.NewPayload("foo", "bar"),
+	)
+	var o order
+	err := pl.Bind(&o)
+	assert.NoError(err)
+	assert.Equal(o.Name, "order")
+	assert.Equal(o.Count, 5)
+	assert.Equal(o.Price, 12.5)
+	assert.True(o.Express)
+	assert.Equal(o.Ordered, now)
+	assert.Equal(o.Timeout, 90*time.Second)
+	assert.Equal(o.Address.Street, "Main Street")
+	assert.Equal(o.Address.Number, uint(5))
+	assert.Equal(o.Items, []string{"a", "b", "c"})
+	assert.Equal(o.Tags, map[string]int{"x": 1, "y": 2})
+	assert.Equal(o.Extra.At("foo").AsString(""), "bar")
+	assert.Nil(o.Note)
+	assert.Equal(o.ignored, "")
+}
+
+// TestBindErrors verifies the listing of missing and invalid values.
+func TestBindErrors(t *testing.T) {
+	assert := asserts.NewTesting(t, asserts.FailStop)
+	pl := event.NewPayload(
+		"count", "many",
+		"timeout", true,
+		"address", event.NewPayload("number", -1),
+		"items", []interface{}{"a", event.NewPayload()},
+	)
+	var o order
+	err := pl.Bind(&o)
+	assert.ErrorMatch(err, `(?s).*missing payload value "name".*`)
+	errs := failure.All(err)
+	assert.Length(errs, 6)
+	assert.ErrorMatch(errs[0], `.*missing payload value "name".*`)
+	assert.ErrorMatch(errs[1], `.*invalid payload value "count".*`)
+	assert.ErrorMatch(errs[2], `.*invalid payload value "timeout".*`)
+	assert.ErrorMatch(errs[3], `.*missing payload value "address.street".*`)
+	assert.ErrorMatch(errs[4], `.*invalid payload value "address.number".*`)
+	assert.ErrorMatch(errs[5], `.*invalid payload value "items.1".*`)
+
+	err = pl.Bind(o)
+	assert.ErrorMatch(err, `.*cannot bind payload to event_test.order.*`)
+}
+
+// TestNewPayloadFrom verifies the creation of payloads from structs.
+func TestNewPayloadFrom(t *testing.T) {
+	assert := asserts.NewTesting(t, asserts.FailStop)
+	now := time.Now()
+	note := "fragile"
+	o := order{
+		Name:    "order",
+		Count:   5,
+		Ordered: now,
+		Timeout: time.Minute,
+		Address: address{"Main Street", 5},
+		Items:   []string{"a", "b"},
+		Tags:    map[string]int{"x": 1},
+		Note:    &note,
+	}
+	pl, err := event.NewPayloadFrom(&o)
+	assert.NoError(err)
+	assert.Equal(pl.At("name").AsString(""), "order")
+	assert.Equal(pl.At("count").AsInt(0), 5)
+	assert.True(pl.At("price").IsUndefined())
+	assert.True(pl.At("express").IsDefined())
+	assert.Equal(pl.At("ordered").AsTime(time.Time{}), now)
+	assert.Equal(pl.At("timeout").AsDuration(0), time.Minute)
+	assert.Equal(pl.At("address", "street").AsString(""), "Main Street")
+	assert.Equal(pl.At("address", "number").AsInt(0), 5)
+	assert.Equal(pl.At("items", "1").AsString(""), "b")
+	assert.Equal(pl.At("tags", "x").AsInt(0), 1)
+	assert.True(pl.At("extra").IsUndefined())
+	assert.Equal(pl.At("Note").AsString(""), "fragile")
+	assert.True(pl.At("ignored").IsUndefined())
+
+	// Roundtrip.
+	var ro order
+	err = pl.Bind(&ro)
+	assert.NoError(err)
+	assert.Equal(ro.Name, o.Name)
+	assert.Equal(ro.Address, o.Address)
+	assert.Equal(ro.Items, o.Items)
+	assert.Equal(*ro.Note, note)
+
+	// Roundtrip of nil elements.
+	type addresses struct {
+		List []*address `payload:"list"`
+	}
+	as := addresses{[]*address{{"Main Street", 1}, nil, {"Side Street", 2}}}
+	pl, err = event.NewPayloadFrom(&as)
+	assert.NoError(err)
+	var ras addresses
+	err = pl.Bind(&ras)
+	assert.NoError(err)
+	assert.Equal(ras, as)
+
+	_, err = event.NewPayloadFrom(struct{ C chan int }{})
+	assert.ErrorMatch(err, `.*invalid payload value "C": cannot bind chan int.*`)
+	_, err = event.NewPayloadFrom(42)
+	assert.ErrorMatch(err, `.*cannot create payload from int.*`)
+}
+
+//--------------------
+// HELPERS
+//--------------------
+
+// address is a nested struct for binding tests.
+type address struct {
+	Street string `payload:"street,required"`
+	Number uint   `payload:"number"`
+}
+
+// order is a struct for binding tests.
+type order struct {
+	Name    string         `payload:"name,required"`
+	Count   int            `payload:"count"`
+	Price   float64        `payload:"price,omitempty"`
+	Express bool           `payload:"express"`
+	Ordered time.Time      `payload:"ordered"`
+	Timeout time.Duration  `payload:"timeout"`
+	Address address        `payload:"address"`
+	Items   []string       `payload:"items"`
+	Tags    map[string]int `payload:"tags"`
+	Extra   *event.Payload `payload:"extra"`
+	Note    *string
+	Skipped string `payload:"-"`
+	ignored string
+}
+
+// EOF
@@ -11,6 +11,20 @@
 // concurrently. Accessors and cloners make live for behavior
 // developers as well easier as the sink and its methods for
 // analyzing.
+//
+// Events and payloads can be marshalled to JSON and to a more compact
+// binary encoding. Both keep the types of the payload values. Values
+// which cannot be serialized, like payload channels, are rejected.
+//
+// Payloads can be bound to structs and created from them. The keys
+// are defined by struct tags like `payload:"name,required"`.
+//
+//     var order Order
+//     if err := evt.Payload().Bind(&order); err != nil {
+//         // Error lists all missing and invalid values.
+//         ...
+//     }
+//     pl, err := event.NewPayloadFrom(order)
 package event // import "tideland.dev/go/together/cells/event"
 
 // EOF
@@ -0,0 +1,216 @@
+// Tideland Go Library - Together - Cells - Event
+//
+// Copyright (C) 2010-2019 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license
+
+package event // import "tideland.dev/go/together/cells/event"
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"tideland.dev/go/trace/failure"
+)
+
+//--------------------
+// CONSTANTS
+//--------------------
+
+// Type names of serialized payload values.
+const (
+	typeString   = "string"
+	typeInt      = "int"
+	typeFloat64  = "float64"
+	typeBool     = "bool"
+	typeTime     = "time"
+	typeDuration = "duration"
+	typePayload  = "payload"
+)
+
+//--------------------
+// VALUE NORMALIZING
+//--------------------
+
+// normalizeValue returns the type name and the normalized form of a
+// raw payload value. Integers are normalized to int, floats to float64.
+// Payload channels and all types not readable by the accessors of
+// Value cannot be serialized.
+func normalizeValue(raw interface{}) (string, interface{}, error) {
+	switch tv := raw.(type) {
+	case string:
+		return typeString, tv, nil
+	case int:
+		return typeInt, tv, nil
+	case int8:
+		return typeInt, int(tv), nil
+	case int16:
+		return typeInt, int(tv), nil
+	case int32:
+		return typeInt, int(tv), nil
+	case int64:
+		return typeInt, int(tv), nil
+	case uint8:
+		return typeInt, int(tv), nil
+	case uint16:
+		return typeInt, int(tv), nil
+	case uint32:
+		return typeInt, int(tv), nil
+	case float32:
+		return typeFloat64, float64(tv), nil
+	case float64:
+		return typeFloat64, tv, nil
+	case bool:
+		return typeBool, tv, nil
+	case time.Time:
+		return typeTime, tv, nil
+	case time.Duration:
+		return typeDuration, tv, nil
+	case *Payload:
+		return typePayload, tv, nil
+	case PayloadChan:
+		return "", nil, failure.New("payload channel is not serializable")
+	}
+	return "", nil, failure.New("type %T is not serializable", raw)
+}
+
+//--------------------
+// EVENT ENCODING
+//--------------------
+
+// encodedEvent is the serializable form of an event.
+type encodedEvent struct {
+	Timestamp time.Time `json:"timestamp"`
+	Topic     string    `json:"topic"`
+	Payload   *Payload  `json:"payload"`
+}
+
+// MarshalJSON implements json.Marshaler. The context of the
+// event is not serialized.
+func (e *Event) MarshalJSON() ([]byte, error) {
+	return json.Marshal(encodedEvent{
+		Timestamp: e.timestamp,
+		Topic:     e.topic,
+		Payload:   e.payload,
+	})
+}
+
+// UnmarshalJSON implements json.Unmarshaler. The original
+// timestamp is restored, the context is a background one.
+func (e *Event) UnmarshalJSON(data []byte) error {
+	ee := encodedEvent{
+		Payload: NewPayload(),
+	}
+	if err := json.Unmarshal(data, &ee); err != nil {
+		return failure.Annotate(err, "cannot unmarshal event")
+	}
+	e.ctx = context.Background()
+	e.timestamp = ee.Timestamp
+	e.topic = ee.Topic
+	e.payload = ee.Payload
+	return nil
+}
+
+//--------------------
+// PAYLOAD ENCODING
+//--------------------
+
+// encodedValue is the serializable form of a payload value
+// keeping the information about its type.
+type encodedValue struct {
+	Type  string          `json:"type"`
+	Value json.RawMessage `json:"value"`
+}
+
+// MarshalJSON implements json.Marshaler. Only values of the types
+// readable by the accessors of Value can be marshalled, a possible
+// reply channel is not serialized.
+func (pl *Payload) MarshalJSON() ([]byte, error) {
+	evs := map[string]encodedValue{}
+	for key, raw := range pl.values {
+		ev, err := encodeValue(raw)
+		if err != nil {
+			return nil, failure.Annotate(err, "cannot marshal payload value at key %q", key)
+		}
+		evs[key] = ev
+	}
+	return json.Marshal(evs)
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (pl *Payload) UnmarshalJSON(data []byte) error {
+	evs := map[string]encodedValue{}
+	if err := json.Unmarshal(data, &evs); err != nil {
+		return failure.Annotate(err, "cannot unmarshal payload")
+	}
+	values := map[string]interface{}{}
+	for key, ev := range evs {
+		raw, err := decodeValue(ev)
+		if err != nil {
+			return failure.Annotate(err, "cannot unmarshal payload value at key %q", key)
+		}
+		values[key] = raw
+	}
+	pl.values = values
+	return nil
+}
+
+// encodeValue converts a raw payload value into its serializable form.
+func encodeValue(raw interface{}) (encodedValue, error) {
+	typ, v, err := normalizeValue(raw)
+	if err != nil {
+		return encodedValue{}, err
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return encodedValue{}, err
+	}
+	return encodedValue{
+		Type:  typ,
+		Value: data,
+	}, nil
+}
+
+// decodeValue converts a serialized payload value back into a raw one.
+func decodeValue(ev encodedValue) (interface{}, error) {
+	var err error
+	switch ev.Type {
+	case typeString:
+		var s string
+		err = json.Unmarshal(ev.Value, &s)
+		return s, err
+	case typeInt:
+		var i int
+		err = json.Unmarshal(ev.Value, &i)
+		return i, err
+	case typeFloat64:
+		var f float64
+		err = json.Unmarshal(ev.Value, &f)
+		return f, err
+	case typeBool:
+		var b bool
+		err = json.Unmarshal(ev.Value, &b)
+		return b, err
+	case typeTime:
+		var t time.Time
+		err = json.Unmarshal(ev.Value, &t)
+		return t, err
+	case typeDuration:
+		var d int64
+		err = json.Unmarshal(ev.Value, &d)
+		return time.Duration(d), err
+	case typePayload:
+		pl := NewPayload()
+		err = json.Unmarshal(ev.Value, pl)
+		return pl, err
+	}
+	return nil, failure.New("invalid value type %q", ev.Type)
+}
+
+// EOF
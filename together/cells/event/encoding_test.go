@@ -0,0 +1,168 @@
+// Tideland Go Library - Together - Cells - Event - Unit Tests
+//
+// Copyright (C) 2010-2019 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license
+
+package event_test // import "tideland.dev/go/together/cells/event"
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"tideland.dev/go/audit/asserts"
+	"tideland.dev/go/together/cells/event"
+)
+
+//--------------------
+// TESTS
+//--------------------
+
+// TestPayloadJSON verifies the marshalling and unmarshalling of
+// payloads keeping the value types.
+func TestPayloadJSON(t *testing.T) {
+	assert := asserts.NewTesting(t, asserts.FailStop)
+	now := time.Now()
+	pla := event.NewPayload(
+		"a", 1,
+		"b", "two",
+		"c", 3.5,
+		"d", true,
+		"e", now,
+		"f", 5*time.Second,
+		"g", event.NewPayload("ga", 10, "gb", []string{"x", "y"}),
+	)
+
+	data, err := json.Marshal(pla)
+	assert.NoError(err)
+
+	plb := event.NewPayload()
+	err = json.Unmarshal(data, plb)
+	assert.NoError(err)
+
+	assert.Length(plb.Keys(), 7)
+	assert.Equal(plb.At("a").AsInt(0), 1)
+	assert.Equal(plb.At("b").AsString(""), "two")
+	assert.Equal(plb.At("c").AsFloat64(0.0), 3.5)
+	assert.True(plb.At("d").AsBool(false))
+	assert.True(plb.At("e").AsTime(time.Time{}).Equal(now))
+	assert.Equal(plb.At("f").AsDuration(0), 5*time.Second)
+	assert.True(plb.At("g").IsPayload())
+	assert.Equal(plb.At("g", "ga").AsInt(0), 10)
+	assert.Equal(plb.At("g", "gb", "1").AsString(""), "y")
+}
+
+// TestPayloadJSONInvalid verifies that not serializable values
+// are rejected.
+func TestPayloadJSONInvalid(t *testing.T) {
+	assert := asserts.NewTesting(t, asserts.FailStop)
+	pl := event.NewPayload("a", struct{}{})
+
+	_, err := json.Marshal(pl)
+	assert.ErrorMatch(err, ".*cannot marshal payload value at key \"a\".*")
+
+	pl, _ = event.NewReplyPayload("a", make(event.PayloadChan))
+	_, err = json.Marshal(pl)
+	assert.ErrorMatch(err, ".*payload channel is not serializable.*")
+
+	err = json.Unmarshal([]byte(`{"a":{"type":"foo","value":1}}`), event.NewPayload())
+	assert.ErrorMatch(err, ".*invalid value type \"foo\".*")
+}
+
+// TestEventJSON verifies the marshalling and unmarshalling of events.
+func TestEventJSON(t *testing.T) {
+	assert := asserts.NewTesting(t, asserts.FailStop)
+	evta := event.New("test", "a", 1, "b", "two")
+
+	data, err := json.Marshal(evta)
+	assert.NoError(err)
+
+	var evtb event.Event
+	err = json.Unmarshal(data, &evtb)
+	assert.NoError(err)
+
+	assert.Equal(evtb.Topic(), "test")
+	assert.True(evtb.Timestamp().Equal(evta.Timestamp()))
+	assert.False(evtb.Done())
+	assert.Equal(evtb.Payload().At("a").AsInt(0), 1)
+	assert.Equal(evtb.Payload().At("b").AsString(""), "two")
+}
+
+// TestPayloadBinary verifies the binary marshalling and unmarshalling
+// of payloads keeping the value types.
+func TestPayloadBinary(t *testing.T) {
+	assert := asserts.NewTesting(t, asserts.FailStop)
+	now := time.Now()
+	pla := event.NewPayload(
+		"a", -1,
+		"b", "two",
+		"c", 3.5,
+		"d", true,
+		"e", now,
+		"f", 5*time.Second,
+		"g", event.NewPayload("ga", int64(10), "gb", []string{"x", ""}),
+	)
+
+	data, err := pla.MarshalBinary()
+	assert.NoError(err)
+	jsonData, err := json.Marshal(pla)
+	assert.NoError(err)
+	assert.True(len(data) < len(jsonData))
+
+	plb := event.NewPayload()
+	err = plb.UnmarshalBinary(data)
+	assert.NoError(err)
+
+	assert.Length(plb.Keys(), 7)
+	assert.Equal(plb.At("a").AsInt(0), -1)
+	assert.Equal(plb.At("b").AsString(""), "two")
+	assert.Equal(plb.At("c").AsFloat64(0.0), 3.5)
+	assert.True(plb.At("d").AsBool(false))
+	assert.True(plb.At("e").AsTime(time.Time{}).Equal(now))
+	assert.Equal(plb.At("f").AsDuration(0), 5*time.Second)
+	assert.Equal(plb.At("g", "ga").AsInt(0), 10)
+	assert.Equal(plb.At("g", "gb", "0").AsString(""), "x")
+	assert.True(plb.At("g", "gb", "1").IsDefined())
+
+	// Invalid data.
+	err = plb.UnmarshalBinary(data[:len(data)-1])
+	assert.ErrorMatch(err, ".*cannot unmarshal payload.*")
+	err = plb.UnmarshalBinary(append(data, 0))
+	assert.ErrorMatch(err, ".*1 bytes left.*")
+
+	// Payload channel.
+	pl, _ := event.NewReplyPayload("a", make(event.PayloadChan))
+	_, err = pl.MarshalBinary()
+	assert.ErrorMatch(err, ".*payload channel is not serializable.*")
+}
+
+// TestEventBinary verifies the binary marshalling and unmarshalling
+// of events.
+func TestEventBinary(t *testing.T) {
+	assert := asserts.NewTesting(t, asserts.FailStop)
+	evta := event.New("test", "a", 1, "b", event.NewPayload("c", "three"))
+
+	data, err := evta.MarshalBinary()
+	assert.NoError(err)
+
+	var evtb event.Event
+	err = evtb.UnmarshalBinary(data)
+	assert.NoError(err)
+
+	assert.Equal(evtb.Topic(), "test")
+	assert.True(evtb.Timestamp().Equal(evta.Timestamp()))
+	assert.False(evtb.Done())
+	assert.Equal(evtb.Payload().At("a").AsInt(0), 1)
+	assert.Equal(evtb.Payload().At("b", "c").AsString(""), "three")
+
+	err = evtb.UnmarshalBinary(append([]byte{99}, data[1:]...))
+	assert.ErrorMatch(err, ".*invalid version 99.*")
+}
+
+// EOF
@@ -63,6 +63,16 @@ func WithContext(ctx context.Context, topic string, kvs ...interface{}) *Event {
 	}
 }
 
+// WithTimestamp creates a new event with the given timestamp instead
+// of the current time, e.g. for events describing something happened
+// earlier. The arguments after the topic are taken to create a new
+// payload.
+func WithTimestamp(timestamp time.Time, topic string, kvs ...interface{}) *Event {
+	evt := New(topic, kvs...)
+	evt.timestamp = timestamp
+	return evt
+}
+
 // Context returns the event context.
 func (e *Event) Context() context.Context {
 	return e.ctx
@@ -90,4 +100,17 @@ func (e *Event) Payload() *Payload {
 	return e.payload
 }
 
+// WithReply returns a copy of the event with a reply channel in its
+// payload. The receiving behavior can answer with evt.Payload().Reply().
+func (e *Event) WithReply() (*Event, PayloadChan) {
+	pl := NewPayload(e.payload)
+	pl.replyc = make(PayloadChan, 1)
+	return &Event{
+		ctx:       e.ctx,
+		timestamp: e.timestamp,
+		topic:     e.topic,
+		payload:   pl,
+	}, pl.replyc
+}
+
 // EOF
@@ -86,6 +86,38 @@ func TestWithPayload(t *testing.T) {
 	assert.Equal(vb, "2")
 }
 
+// TestWithTimestamp verifies creation of an event with
+// a given timestamp.
+func TestWithTimestamp(t *testing.T) {
+	assert := asserts.NewTesting(t, asserts.FailStop)
+	ts := time.Date(2019, time.May, 1, 12, 0, 0, 0, time.UTC)
+	evt := event.WithTimestamp(ts, "topic", "a", 1)
+
+	assert.Equal(evt.Timestamp(), ts)
+	assert.Equal(evt.Topic(), "topic")
+	assert.Equal(evt.Payload().At("a").AsInt(0), 1)
+}
+
+// TestWithReply verifies the copying of an event with
+// a reply channel.
+func TestWithReply(t *testing.T) {
+	assert := asserts.NewTesting(t, asserts.FailStop)
+	evt := event.New("topic", "a", 1)
+	err := evt.Payload().Reply(event.NewPayload("b", 2))
+	assert.ErrorMatch(err, ".*payload contains no reply channel.*")
+
+	revt, plc := evt.WithReply()
+	assert.Equal(revt.Topic(), "topic")
+	assert.Equal(revt.Timestamp(), evt.Timestamp())
+	assert.Equal(revt.Payload().At("a").AsInt(0), 1)
+
+	err = revt.Payload().Reply(event.NewPayload("b", 2))
+	assert.NoError(err)
+	pl, err := plc.Wait(time.Second)
+	assert.NoError(err)
+	assert.Equal(pl.At("b").AsInt(0), 2)
+}
+
 // TestDefaultValue verifies creation of an event with a topic
 // and a valueless final key.
 func TestDefaultValue(t *testing.T) {
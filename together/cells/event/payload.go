@@ -85,6 +85,20 @@ func (pl *Payload) Keys() []string {
 	return keys
 }
 
+// Map returns the values of the payload as map. Nested payloads
+// are returned as nested maps.
+func (pl *Payload) Map() map[string]interface{} {
+	m := make(map[string]interface{}, len(pl.values))
+	for key, value := range pl.values {
+		if npl, ok := value.(*Payload); ok {
+			m[key] = npl.Map()
+			continue
+		}
+		m[key] = value
+	}
+	return m
+}
+
 // At returns the value at the given key. This value may
 // be empty.
 func (pl *Payload) At(keys ...string) *Value {
@@ -65,6 +65,18 @@ func TestNestedPayloads(t *testing.T) {
 	assert.Equal(pla.At("ab", "bb", "cb").AsInt(0), 200)
 }
 
+// TestPayloadMap verifies the returning of payloads as maps.
+func TestPayloadMap(t *testing.T) {
+	assert := asserts.NewTesting(t, asserts.FailStop)
+	pl := event.NewPayload("a", 1, "b", event.NewPayload("c", "d"), "e", []int{1, 2})
+
+	assert.Equal(pl.Map(), map[string]interface{}{
+		"a": 1,
+		"b": map[string]interface{}{"c": "d"},
+		"e": map[string]interface{}{"0": 1, "1": 2},
+	})
+}
+
 // TestPayloadValue verifies merging of payloads as values.
 func TestPayloadValue(t *testing.T) {
 	assert := asserts.NewTesting(t, asserts.FailStop)
@@ -12,8 +12,12 @@ package mesh // import "tideland.dev/go/together/cells/mesh"
 //--------------------
 
 import (
+	"sync/atomic"
+	"time"
+
 	"tideland.dev/go/together/actor"
 	"tideland.dev/go/together/cells/event"
+	"tideland.dev/go/together/loop"
 	"tideland.dev/go/trace/failure"
 )
 
@@ -24,23 +28,44 @@ import (
 // cell runs a behavior for the processing of events and emitting of
 // resulting events.
 type cell struct {
+	id              string
 	msh             *Mesh
 	behavior        Behavior
 	subscribedCells map[string]*cell
+	mailbox         *mailbox
+	scheduled       int32
+	busy            int32
+	goid            int64
+	spill           *cell
+	stats           *cellStats
+	strategy        SupervisionStrategy
+	restarts        int
+	period          time.Duration
+	factory         BehaviorFactory
+	reasons         loop.Reasons
 	act             *actor.Actor
 }
 
 // newCell creates a new cell running the given behavior in a goroutine.
-func newCell(msh *Mesh, behavior Behavior) (*cell, error) {
+func newCell(msh *Mesh, behavior Behavior, cfg *cellConfig, spill *cell) (*cell, error) {
 	c := &cell{
+		id:              behavior.ID(),
 		msh:             msh,
 		behavior:        behavior,
 		subscribedCells: map[string]*cell{},
-		act: actor.New(
-			actor.WithQueueLen(32),
-			actor.WithRecoverer(behavior.Recover),
-		).Go(),
+		mailbox:         newMailbox(cfg.capacity, cfg.policy),
+		spill:           spill,
+		stats:           newCellStats(),
+		strategy:        cfg.strategy,
+		restarts:        cfg.restarts,
+		period:          cfg.period,
+		factory:         cfg.factory,
+		reasons:         loop.MakeReasons(),
 	}
+	c.act = actor.New(
+		actor.WithQueueLen(32),
+		actor.WithRecoverer(c.supervise),
+	).Go()
 	err := c.behavior.Init(c)
 	if err != nil {
 		// Stop the actor with the annotated error.
@@ -69,9 +94,11 @@ func (c *cell) Subscribers() []string {
 func (c *cell) Emit(id string, evt *event.Event) error {
 	subscriber, ok := c.subscribedCells[id]
 	if !ok {
-		return failure.New("cell %q is no subscriber", id)
+		err := failure.New("cell %q is no subscriber", id)
+		c.msh.deadLetter.route(c.id, evt, err)
+		return err
 	}
-	return subscriber.process(evt)
+	return subscriber.process(evt, c)
 }
 
 // Broadcast is part of Emitter interface and emits the given
@@ -79,15 +106,19 @@ func (c *cell) Emit(id string, evt *event.Event) error {
 func (c *cell) Broadcast(evt *event.Event) error {
 	var serrs []error
 	for _, subscriber := range c.subscribedCells {
-		serrs = append(serrs, subscriber.process(evt))
+		serrs = append(serrs, subscriber.process(evt, c))
 	}
 	return failure.Collect(serrs...)
 }
 
 // Self is part of Emitter interface and emits the given event
-// back to the cell itself.
+// back to the cell itself. Like events from the outside it's
+// rejected while the mesh is draining.
 func (c *cell) Self(evt *event.Event) error {
-	return c.process(evt)
+	if err := c.msh.checkDraining(); err != nil {
+		return err
+	}
+	return c.process(evt, c)
 }
 
 // subscribers returns the subscriber IDs of the cell.
@@ -97,7 +128,7 @@ func (c *cell) subscribers() ([]string, error) {
 		subscriberIDs = c.Subscribers()
 		return nil
 	}); aerr != nil {
-		return nil, failure.Annotate(aerr, "backend failure of cell %q", c.behavior.ID())
+		return nil, failure.Annotate(aerr, "backend failure of cell %q", c.id)
 	}
 	return subscriberIDs, nil
 }
@@ -106,11 +137,11 @@ func (c *cell) subscribers() ([]string, error) {
 func (c *cell) subscribe(subscribers []*cell) error {
 	if aerr := c.act.DoAsync(func() error {
 		for _, subscriber := range subscribers {
-			c.subscribedCells[subscriber.behavior.ID()] = subscriber
+			c.subscribedCells[subscriber.id] = subscriber
 		}
 		return nil
 	}); aerr != nil {
-		return failure.Annotate(aerr, "backend failure of cell %q", c.behavior.ID())
+		return failure.Annotate(aerr, "backend failure of cell %q", c.id)
 	}
 	return nil
 }
@@ -119,43 +150,161 @@ func (c *cell) subscribe(subscribers []*cell) error {
 func (c *cell) unsubscribe(subscribers []*cell) error {
 	if aerr := c.act.DoAsync(func() error {
 		for _, subscriber := range subscribers {
-			delete(c.subscribedCells, subscriber.behavior.ID())
+			delete(c.subscribedCells, subscriber.id)
 		}
 		return nil
 	}); aerr != nil {
-		return failure.Annotate(aerr, "backend failure of cell %q", c.behavior.ID())
+		return failure.Annotate(aerr, "backend failure of cell %q", c.id)
 	}
 	return nil
 }
 
-// process lets the cell behavior process the event asynchronously.
-func (c *cell) process(evt *event.Event) error {
-	if aerr := c.act.DoAsync(func() error {
+// process queues the event emitted by the given cell, nil for emitters
+// outside the mesh, and lets the behavior process it asynchronously.
+// Emitters outside the mesh and goroutines of behaviors wait for a full
+// mailbox. The goroutine of the emitting cell only waits if this cell
+// doesn't wait for the emitter.
+func (c *cell) process(evt *event.Event, from *cell) error {
+	if from == nil || !from.onActor() {
+		return c.push(evt, true)
+	}
+	if !c.msh.waits.add(from, c) {
+		return c.push(evt, false)
+	}
+	defer c.msh.waits.remove(from, c)
+	return c.push(evt, true)
+}
+
+// push queues the event in the mailbox and schedules the processing.
+// Events which don't fit into the mailbox are emitted to the spill
+// cell or the dead-letter cell. If the mailbox should block but must
+// not an error is returned too.
+func (c *cell) push(evt *event.Event, block bool) error {
+	spilled, err := c.mailbox.push(evt, block)
+	if err != nil {
+		return failure.Annotate(err, "cannot queue event for cell %q", c.id)
+	}
+	if spilled == nil {
+		return c.schedule()
+	}
+	err = failure.New("mailbox of cell %q is full", c.id)
+	if c.spill == nil {
+		c.msh.deadLetter.route(c.id, spilled, err)
+		return err
+	}
+	if serr := c.spill.push(spilled, false); serr != nil {
+		return serr
+	}
+	if c.mailbox.policy == OverflowBlock {
+		return failure.Annotate(err, "event spilled to cell %q", c.spill.id)
+	}
+	return nil
+}
+
+// onActor checks if the caller runs on the goroutine of the cell.
+func (c *cell) onActor() bool {
+	goid := atomic.LoadInt64(&c.goid)
+	return goid != 0 && goid == goroutineID()
+}
+
+// schedule lets the actor drain the mailbox if this isn't
+// already scheduled.
+func (c *cell) schedule() error {
+	if !atomic.CompareAndSwapInt32(&c.scheduled, 0, 1) {
+		return nil
+	}
+	if aerr := c.act.DoAsync(c.drain); aerr != nil {
+		return failure.Annotate(aerr, "backend failure of cell %q", c.id)
+	}
+	return nil
+}
+
+// drain lets the behavior process all queued events.
+func (c *cell) drain() error {
+	// Mark as busy before unscheduling so that the cell
+	// never looks idle while events are queued.
+	atomic.StoreInt32(&c.busy, 1)
+	atomic.StoreInt32(&c.scheduled, 0)
+	if atomic.LoadInt64(&c.goid) == 0 {
+		atomic.StoreInt64(&c.goid, goroutineID())
+	}
+	defer atomic.StoreInt32(&c.busy, 0)
+	defer func() {
+		if r := recover(); r != nil {
+			// Continue with the remaining events after the
+			// actor recovered.
+			go c.schedule()
+			panic(r)
+		}
+	}()
+	for evt := c.mailbox.pop(); evt != nil; evt = c.mailbox.pop() {
 		if evt.Done() {
-			return nil
+			continue
 		}
-		perr := c.behavior.Process(evt)
+		perr := c.processBehavior(evt)
 		if perr != nil {
-			return c.behavior.Recover(perr)
+			// Let a possible requester know the error.
+			ReplyError(evt, perr)
+			c.msh.deadLetter.route(c.id, evt, perr)
+			if rerr := c.recover(perr); rerr != nil {
+				return rerr
+			}
 		}
-		return nil
-	}); aerr != nil {
-		return failure.Annotate(aerr, "backend failure of cell %q", c.behavior.ID())
 	}
 	return nil
 }
 
+// processBehavior lets the behavior process the event and
+// collects the statistics.
+func (c *cell) processBehavior(evt *event.Event) error {
+	if mon := c.msh.monitor; mon != nil {
+		mid := "cell:" + c.id
+		mon.StaySetIndicator().Increase(mid)
+		defer mon.StaySetIndicator().Decrease(mid)
+		defer mon.StopWatch().Begin(mid).End()
+	}
+	begin := time.Now()
+	err := c.behavior.Process(evt)
+	c.stats.process(time.Since(begin), err)
+	return err
+}
+
+// recover counts the recovering and lets the behavior recover.
+func (c *cell) recover(r interface{}) error {
+	c.stats.recover()
+	return c.behavior.Recover(r)
+}
+
+// idle checks if the cell has no queued events and processes none.
+// The order of the checks matters, events move from the mailbox to
+// the scheduling to the processing. A stopped cell is always idle.
+func (c *cell) idle() bool {
+	if c.act.Err() != nil {
+		return true
+	}
+	if c.mailbox.len() > 0 || atomic.LoadInt32(&c.scheduled) == 1 {
+		return false
+	}
+	return atomic.LoadInt32(&c.busy) == 0
+}
+
+// droppedEvents returns the number of events dropped by the mailbox.
+func (c *cell) droppedEvents() int {
+	return c.mailbox.droppedEvents()
+}
+
 // stop terminates the cell and stops the actor.
 func (c *cell) stop() error {
 	var cerr error
 	if aerr := c.act.DoSync(func() error {
 		cerr = c.behavior.Terminate()
-		c.behavior = &dummyBehavior{c.behavior.ID()}
+		c.behavior = &dummyBehavior{c.id}
 		c.subscribedCells = map[string]*cell{}
 		return nil
 	}); aerr != nil {
-		return failure.Annotate(aerr, "backend failure of cell %q", c.behavior.ID())
+		return failure.Annotate(aerr, "backend failure of cell %q", c.id)
 	}
+	c.mailbox.close()
 	// Stop actor with cell or given error.
 	return c.act.Stop(cerr)
 }
@@ -182,7 +331,7 @@ func (db *dummyBehavior) Terminate() error {
 }
 
 func (db *dummyBehavior) Process(evt *event.Event) error {
-	return nil
+	return failure.New("cell %q is halted", db.id)
 }
 
 func (db *dummyBehavior) Recover(r interface{}) error {
@@ -0,0 +1,81 @@
+// Tideland Go Library - Together - Cells - Mesh
+//
+// Copyright (C) 2010-2019 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license
+
+package mesh // import "tideland.dev/go/together/cells/mesh"
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"sync"
+
+	"tideland.dev/go/together/cells/event"
+)
+
+//--------------------
+// CONSTANTS
+//--------------------
+
+// TopicDeadLetter is the topic of the events routed to the
+// dead-letter cell of a mesh. Their payload contains the ID of
+// the failing cell at "cell", the error message at "error", and
+// the failed event with "topic", "timestamp", and "payload".
+const TopicDeadLetter = "dead-letter"
+
+//--------------------
+// DEAD LETTER
+//--------------------
+
+// deadLetter manages the dead-letter cell of a mesh. It is
+// independent of the mesh lock so that cells can route failed
+// events while the mesh is changed.
+type deadLetter struct {
+	mu   sync.RWMutex
+	id   string
+	cell *cell
+}
+
+// set stores the dead-letter cell if it has the configured ID.
+func (dl *deadLetter) set(c *cell) {
+	dl.mu.Lock()
+	defer dl.mu.Unlock()
+	if dl.id != "" && c.id == dl.id {
+		dl.cell = c
+	}
+}
+
+// unset removes the dead-letter cell if it has the given ID.
+func (dl *deadLetter) unset(id string) {
+	dl.mu.Lock()
+	defer dl.mu.Unlock()
+	if dl.cell != nil && dl.cell.id == id {
+		dl.cell = nil
+	}
+}
+
+// route emits the failed event of the given cell together with
+// the error to the dead-letter cell if it is spawned. Failures
+// of the dead-letter cell itself are not routed.
+func (dl *deadLetter) route(id string, evt *event.Event, err error) {
+	dl.mu.RLock()
+	c := dl.cell
+	dl.mu.RUnlock()
+	if c == nil || c.id == id {
+		return
+	}
+	c.push(event.New(
+		TopicDeadLetter,
+		"cell", id,
+		"error", err.Error(),
+		"topic", evt.Topic(),
+		"timestamp", evt.Timestamp(),
+		"payload", evt.Payload(),
+	), false)
+}
+
+// EOF
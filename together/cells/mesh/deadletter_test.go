@@ -0,0 +1,174 @@
+// Tideland Go Library - Together - Cells - Mesh - Unit Tests
+//
+// Copyright (C) 2010-2019 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license
+
+package mesh_test // import "tideland.dev/go/together/cells/mesh"
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"tideland.dev/go/audit/asserts"
+	"tideland.dev/go/together/cells/event"
+	"tideland.dev/go/together/cells/mesh"
+)
+
+//--------------------
+// TESTS
+//--------------------
+
+// TestDeadLetterProcessError verifies the routing of events failed
+// during processing to the dead-letter cell.
+func TestDeadLetterProcessError(t *testing.T) {
+	assert := asserts.NewTesting(t, asserts.FailStop)
+	evtc := make(chan *event.Event, 10)
+	msh := mesh.New(mesh.WithDeadLetterCell("dead"))
+	defer msh.Stop()
+
+	err := msh.SpawnCells(
+		NewTestBehavior("foo"),
+		NewDeadLetterBehavior("dead", evtc),
+	)
+	assert.NoError(err)
+
+	msh.Emit("foo", event.New("fail", "a", 1))
+
+	evt := waitDeadLetter(assert, evtc)
+	assert.Equal(evt.Topic(), mesh.TopicDeadLetter)
+	assert.Equal(evt.Payload().At("cell").AsString(""), "foo")
+	assert.Match(evt.Payload().At("error").AsString(""), ".*failing on purpose.*")
+	assert.Equal(evt.Payload().At("topic").AsString(""), "fail")
+	assert.Equal(evt.Payload().At("payload", "a").AsInt(0), 1)
+
+	// Failures of the dead-letter cell itself are dropped.
+	msh.Emit("dead", event.New("fail"))
+	select {
+	case <-evtc:
+		assert.Fail("failure of dead-letter cell has been routed")
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+// TestDeadLetterNoSubscriber verifies the routing of events emitted
+// to cells which are no subscribers to the dead-letter cell.
+func TestDeadLetterNoSubscriber(t *testing.T) {
+	assert := asserts.NewTesting(t, asserts.FailStop)
+	evtc := make(chan *event.Event, 10)
+	msh := mesh.New(mesh.WithDeadLetterCell("dead"))
+	defer msh.Stop()
+
+	err := msh.SpawnCells(
+		NewTestBehavior("foo"),
+		NewTestBehavior("bar"),
+		NewDeadLetterBehavior("dead", evtc),
+	)
+	assert.NoError(err)
+
+	msh.Emit("foo", event.New("emit", "to", "bar", "value", 1234))
+
+	evt := waitDeadLetter(assert, evtc)
+	assert.Equal(evt.Payload().At("cell").AsString(""), "foo")
+	assert.Match(evt.Payload().At("error").AsString(""), ".*cell \"bar\" is no subscriber.*")
+	assert.Equal(evt.Payload().At("topic").AsString(""), "set")
+	assert.Equal(evt.Payload().At("payload", "value").AsInt(0), 1234)
+}
+
+// TestDeadLetterSpill verifies the spilling of events to the
+// dead-letter cell when no spill cell is configured.
+func TestDeadLetterSpill(t *testing.T) {
+	assert := asserts.NewTesting(t, asserts.FailStop)
+	evtc := make(chan *event.Event, 10)
+	bb := NewBlockingBehavior("foo")
+
+	msh := mesh.New()
+	err := msh.SpawnCell(bb, mesh.WithMailbox(1, mesh.OverflowSpill))
+	assert.ErrorMatch(err, ".*neither spill nor dead-letter cell for cell \"foo\".*")
+	msh.Stop()
+
+	msh = mesh.New(mesh.WithDeadLetterCell("dead"))
+	defer msh.Stop()
+	err = msh.SpawnCell(bb, mesh.WithMailbox(1, mesh.OverflowSpill))
+	assert.NoError(err)
+	err = msh.SpawnCells(NewDeadLetterBehavior("dead", evtc))
+	assert.NoError(err)
+
+	bb.block(assert, msh)
+	msh.Emit("foo", event.New("value", "value", 1))
+	msh.Emit("foo", event.New("value", "value", 2))
+	bb.release(assert, 1)
+
+	evt := waitDeadLetter(assert, evtc)
+	assert.Equal(evt.Payload().At("cell").AsString(""), "foo")
+	assert.Match(evt.Payload().At("error").AsString(""), ".*mailbox of cell \"foo\" is full.*")
+	assert.Equal(evt.Payload().At("payload", "value").AsInt(0), 2)
+}
+
+// TestDeadLetterInvalid verifies the handling of an invalid
+// dead-letter option.
+func TestDeadLetterInvalid(t *testing.T) {
+	assert := asserts.NewTesting(t, asserts.FailStop)
+	msh := mesh.New(mesh.WithDeadLetterCell(""))
+
+	err := msh.SpawnCells(NewTestBehavior("foo"))
+	assert.ErrorMatch(err, ".*invalid mesh option: dead-letter cell ID is empty.*")
+}
+
+//--------------------
+// HELPERS
+//--------------------
+
+func waitDeadLetter(assert *asserts.Asserts, evtc chan *event.Event) *event.Event {
+	select {
+	case evt := <-evtc:
+		return evt
+	case <-time.After(waitTimeout):
+		assert.Fail("no dead-letter event received")
+	}
+	return nil
+}
+
+type DeadLetterBehavior struct {
+	id   string
+	evtc chan *event.Event
+}
+
+func NewDeadLetterBehavior(id string, evtc chan *event.Event) *DeadLetterBehavior {
+	return &DeadLetterBehavior{
+		id:   id,
+		evtc: evtc,
+	}
+}
+
+func (db *DeadLetterBehavior) ID() string {
+	return db.id
+}
+
+func (db *DeadLetterBehavior) Init(emitter mesh.Emitter) error {
+	return nil
+}
+
+func (db *DeadLetterBehavior) Terminate() error {
+	return nil
+}
+
+func (db *DeadLetterBehavior) Process(evt *event.Event) error {
+	if evt.Topic() == "fail" {
+		return errors.New("dead-letter failing")
+	}
+	db.evtc <- evt
+	return nil
+}
+
+func (db *DeadLetterBehavior) Recover(r interface{}) error {
+	return nil
+}
+
+// EOF
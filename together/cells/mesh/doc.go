@@ -25,6 +25,34 @@
 //        NewBazer("c"),
 //    )
 //
+// Each cell queues its events in a mailbox. By default it has a capacity
+// of 32 events and blocks the emitters when it is full. Only cells emitting
+// to themselves or to cells waiting for them don't wait, their events are
+// routed to the spill or dead-letter cell instead. Single cells can be
+// spawned with a different capacity and overflow policy, e.g.
+//
+//    msh.SpawnCell(NewLogger("log"), mesh.WithMailbox(1024, mesh.OverflowDropOldest))
+//
+// The number of dropped events is returned by msh.DroppedEvents("log").
+//
+// Panics of behaviors are handled based on the supervision strategy of
+// the cell. By default the behavior recovers itself, but it also can be
+// restarted, stopped, or the panic is escalated to a supervisor group.
+//
+//    msh.SpawnCell(NewParser("p"),
+//        mesh.WithSupervision(mesh.SuperviseEscalate),
+//        mesh.WithRestartIntensity(5, time.Minute),
+//    )
+//    msh.Supervise(mesh.OneForAll, 10, time.Hour, "p", "q")
+//
+// Restarted behaviors are initialized again. To start with a fresh state
+// the cell can get a factory creating a new behavior for each restart.
+//
+//    msh.SpawnCell(NewParser("p"),
+//        mesh.WithSupervision(mesh.SuperviseRestart),
+//        mesh.WithFactory(func(id string) mesh.Behavior { return NewParser(id) }),
+//    )
+//
 // These cells can subscribe each other with
 //
 //    msh.Subscribe("a", "b", "c")
@@ -34,10 +62,65 @@
 // to multiple other subscribers and even circular subscriptions are
 // no problem. But handle with care.
 //
+// A cell processes its events serially. If this becomes a bottleneck
+// a behavior can be spawned as partitioned cell. Here n cells run the
+// behaviors created by a factory, and the events are routed by a key
+// with consistent hashing. So the events with the same key are processed
+// in their order by the same partition.
+//
+//    msh.SpawnPartitionedCell("counter", 8, func(id string) mesh.Behavior {
+//        return NewCounter(id)
+//    }, mesh.PayloadKey("user"))
+//
+// The partitioned cell is addressed and subscribed by its ID like any
+// other cell. Its subscribers receive the events of all partitions.
+//
 // Events from the outside are emitted using
 //
 //     msh.Emit("foo", event.New("foo", "answer", 42))
 //
+// or, if a reply is needed, using
+//
+//     pl, err := msh.Request("foo", event.New("question"), time.Second)
+//
+// The behavior of the cell answers with mesh.Reply(evt, "answer", 42)
+// or mesh.ReplyError(evt, err) during processing.
+//
+// A journal records all events emitted or broadcasted into a mesh
+// and allows to replay them into a fresh one, e.g. after a restart.
+//
+//     j, err := mesh.OpenJournal("/var/lib/myapp/mesh.journal")
+//     msh := mesh.New(mesh.WithJournal(j))
+//     ...
+//     err = msh.Replay(j, mesh.FromTime(yesterday))
+//
+// Events which cannot be delivered or processed, e.g. because of
+// a processing error, a full mailbox without spill cell, or an emit
+// to a non-subscriber, can be routed to a dead-letter cell. It receives
+// them wrapped into events with the topic mesh.TopicDeadLetter.
+//
+//     msh := mesh.New(mesh.WithDeadLetterCell("dead-letters"))
+//
+// The statistics of all cells like the numbers of processed events,
+// errors, and recoverings as well as the mailbox depths and processing
+// latencies are returned by msh.Stats(). With the option mesh.WithMonitor()
+// the cells additionally feed a trace monitor. msh.WriteDOT() exports
+// the subscription graph for debugging with Graphviz.
+//
+// Time dependent behaviors like the ticker use the clock of the mesh
+// returned by msh.Clock(). By default it's the real clock, the option
+// mesh.WithClock() allows to set another one like a timex.ManualClock.
+// The package celltest uses it to test behaviors deterministically.
+//
+// msh.Stop() terminates the cells immediately and drops their queued
+// events. msh.Drain(ctx) instead rejects new events from the outside,
+// waits until the cells processed their queued ones, and terminates
+// them from upstream to downstream.
+//
+//     ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+//     defer cancel()
+//     err := msh.Drain(ctx)
+//
 package mesh // import "tideland.dev/go/together/cells/mesh"
 
 // EOF
@@ -0,0 +1,180 @@
+// Tideland Go Library - Together - Cells - Mesh
+//
+// Copyright (C) 2010-2019 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license
+
+package mesh // import "tideland.dev/go/together/cells/mesh"
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"context"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"tideland.dev/go/trace/failure"
+)
+
+//--------------------
+// CONSTANTS
+//--------------------
+
+// drainInterval is the interval for checking if a cell is idle.
+const drainInterval = 5 * time.Millisecond
+
+//--------------------
+// ERROR HELPERS
+//--------------------
+
+// IsErrDraining checks if an event has been rejected because
+// the mesh is draining.
+func IsErrDraining(err error) bool {
+	return failure.Contains(err, "mesh is draining")
+}
+
+//--------------------
+// DRAIN
+//--------------------
+
+// Drain stops the mesh gracefully. First it rejects all events from
+// the outside and those cells emit to themselves, e.g. by tickers or
+// receivers in the background. Only emitting to subscribers continues.
+// Then it waits until the cells processed their queued
+// events, following the subscription graph from upstream to downstream.
+// Finally the cells are terminated in the same order. If the context
+// is done before all cells are idle the remaining events are dropped,
+// the cells are terminated anyway, and an error is returned. A behavior
+// still processing an event is waited for.
+func (m *Mesh) Drain(ctx context.Context) error {
+	// Reject new events before waiting for the lock, because
+	// emitters may hold it.
+	atomic.StoreInt32(&m.draining, 1)
+	defer atomic.StoreInt32(&m.draining, 0)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	cells := m.cells.ordered(m.deadLetter.id)
+	derr := waitIdle(ctx, cells)
+	if derr != nil {
+		// Drop the remaining events.
+		for _, c := range cells {
+			c.mailbox.close()
+		}
+	}
+	return failure.Collect(derr, m.stopCells(cells))
+}
+
+// checkDraining returns an error if the mesh is draining.
+func (m *Mesh) checkDraining() error {
+	if atomic.LoadInt32(&m.draining) == 1 {
+		return failure.New("mesh is draining")
+	}
+	return nil
+}
+
+// stopCells terminates the given cells in their order and
+// cleans up.
+func (m *Mesh) stopCells(cells []*cell) error {
+	cerrs := make([]error, len(cells))
+	for i, c := range cells {
+		m.deadLetter.unset(c.id)
+		cerrs[i] = c.stop()
+	}
+	m.cells = cellRegistry{}
+	return failure.Collect(cerrs...)
+}
+
+// waitIdle waits until all cells are idle. As cells may emit
+// events to upstream cells in case of circular subscriptions,
+// the ordered waiting is repeated until no cell is busy anymore.
+func waitIdle(ctx context.Context, cells []*cell) error {
+	ticker := time.NewTicker(drainInterval)
+	defer ticker.Stop()
+	for {
+		busy := false
+		for _, c := range cells {
+			for !c.idle() {
+				busy = true
+				select {
+				case <-ctx.Done():
+					return failure.Annotate(ctx.Err(), "cannot drain cell %q", c.id)
+				case <-ticker.C:
+				}
+			}
+		}
+		if !busy {
+			return nil
+		}
+	}
+}
+
+//--------------------
+// TOPOLOGY
+//--------------------
+
+// ordered returns the cells sorted from upstream to downstream. Spill
+// and dead-letter cells are treated as subscribers of the cells routing
+// events to them, partitions as subscribers of their partitioned cell.
+// Independent cells are ordered by their IDs, cycles are broken at the
+// cell with the lowest ID.
+func (cr cellRegistry) ordered(deadLetterID string) []*cell {
+	// Collect edges and numbers of incoming ones.
+	downstreams := map[string][]string{}
+	incoming := map[string]int{}
+	connect := func(from, to string) {
+		downstreams[from] = append(downstreams[from], to)
+		incoming[to]++
+	}
+	for id, entry := range cr {
+		for upstreamID := range entry.subscribedTo {
+			if upstream, ok := cr[upstreamID]; ok {
+				connect(upstreamID, id)
+				for _, partitionID := range upstream.partitions {
+					connect(partitionID, id)
+				}
+			}
+		}
+		if entry.partitionOf != "" && cr.contains(entry.partitionOf) {
+			connect(entry.partitionOf, id)
+		}
+		if spill := entry.cell.spill; spill != nil && cr.contains(spill.id) {
+			connect(id, spill.id)
+		}
+		if deadLetterID != "" && deadLetterID != id && cr.contains(deadLetterID) {
+			connect(id, deadLetterID)
+		}
+	}
+	// Sort topologically.
+	var remaining []string
+	for id := range cr {
+		remaining = append(remaining, id)
+	}
+	sort.Strings(remaining)
+	var cells []*cell
+	for len(remaining) > 0 {
+		next := remaining[0]
+		for _, id := range remaining {
+			if incoming[id] == 0 {
+				next = id
+				break
+			}
+		}
+		cells = append(cells, cr[next].cell)
+		for _, downstreamID := range downstreams[next] {
+			incoming[downstreamID]--
+		}
+		for i, id := range remaining {
+			if id == next {
+				remaining = append(remaining[:i], remaining[i+1:]...)
+				break
+			}
+		}
+	}
+	return cells
+}
+
+// EOF
@@ -0,0 +1,283 @@
+// Tideland Go Library - Together - Cells - Mesh - Unit Tests
+//
+// Copyright (C) 2010-2019 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license
+
+package mesh_test // import "tideland.dev/go/together/cells/mesh"
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"tideland.dev/go/audit/asserts"
+	"tideland.dev/go/together/cells/event"
+	"tideland.dev/go/together/cells/mesh"
+)
+
+//--------------------
+// TESTS
+//--------------------
+
+// TestDrain verifies that draining processes all queued events
+// and terminates the cells from upstream to downstream.
+func TestDrain(t *testing.T) {
+	assert := asserts.NewTesting(t, asserts.FailStop)
+	rec := &drainRecorder{}
+	msh := mesh.New(mesh.WithDeadLetterCell("dead-letters"))
+
+	err := msh.SpawnCells(
+		NewDrainBehavior("dead-letters", rec, 0, nil),
+		NewDrainBehavior("sink", rec, 0, nil),
+		NewDrainBehavior("slow", rec, 5*time.Millisecond, nil),
+		NewDrainBehavior("fast", rec, 0, nil),
+		NewDrainBehavior("source", rec, 0, nil),
+	)
+	assert.NoError(err)
+	assert.NoError(msh.Subscribe("source", "slow", "fast"))
+	assert.NoError(msh.Subscribe("slow", "sink"))
+	assert.NoError(msh.Subscribe("fast", "sink"))
+
+	for i := 0; i < 20; i++ {
+		assert.NoError(msh.Emit("source", event.New("count")))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	err = msh.Drain(ctx)
+	assert.NoError(err)
+
+	assert.Equal(rec.processed("source"), 20)
+	assert.Equal(rec.processed("slow"), 20)
+	assert.Equal(rec.processed("fast"), 20)
+	assert.Equal(rec.processed("sink"), 40)
+	assert.Equal(rec.terminated(), []string{"source", "fast", "slow", "sink", "dead-letters"})
+	assert.Length(msh.Cells(), 0)
+
+	err = msh.Emit("source", event.New("count"))
+	assert.ErrorMatch(err, `.*cannot find cell "source".*`)
+}
+
+// TestDrainRejectAndTimeout verifies that events from the outside
+// are rejected while draining and that draining ends with the
+// context.
+func TestDrainRejectAndTimeout(t *testing.T) {
+	assert := asserts.NewTesting(t, asserts.FailStop)
+	rec := &drainRecorder{}
+	msh := mesh.New()
+	block := make(chan struct{})
+
+	err := msh.SpawnCells(
+		NewDrainBehavior("blocker", rec, 0, block),
+		NewDrainBehavior("sink", rec, 0, nil),
+	)
+	assert.NoError(err)
+	assert.NoError(msh.Subscribe("blocker", "sink"))
+	msh.Emit("blocker", event.New("block"))
+	msh.Emit("blocker", event.New("count"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 250*time.Millisecond)
+	defer cancel()
+	errc := make(chan error, 1)
+	go func() {
+		errc <- msh.Drain(ctx)
+	}()
+	go func() {
+		// Release the blocker after the remaining events are dropped.
+		<-ctx.Done()
+		time.Sleep(100 * time.Millisecond)
+		close(block)
+	}()
+
+	// Wait for rejected events.
+	assert.Retry(func() bool {
+		return mesh.IsErrDraining(msh.Emit("blocker", event.New("count")))
+	}, 100, 10*time.Millisecond)
+	err = msh.Broadcast(event.New("count"))
+	assert.True(mesh.IsErrDraining(err))
+	_, err = msh.Request("blocker", event.New("count"), time.Second)
+	assert.True(mesh.IsErrDraining(err))
+
+	// Draining times out, the cells are stopped anyway.
+	select {
+	case err = <-errc:
+		assert.ErrorMatch(err, `.*cannot drain cell "blocker".*deadline exceeded.*`)
+	case <-time.After(5 * time.Second):
+		assert.Fail("draining did not end")
+	}
+	assert.Equal(rec.processed("sink"), 0)
+	assert.Length(msh.Cells(), 0)
+}
+
+// TestDrainTicker verifies that draining ends while cells emit
+// events to themselves.
+func TestDrainTicker(t *testing.T) {
+	assert := asserts.NewTesting(t, asserts.FailStop)
+	rec := &drainRecorder{}
+	msh := mesh.New()
+
+	err := msh.SpawnCells(
+		NewDrainTickerBehavior("ticker", rec),
+		NewDrainBehavior("sink", rec, 0, nil),
+	)
+	assert.NoError(err)
+	assert.NoError(msh.Subscribe("ticker", "sink"))
+	assert.Retry(func() bool {
+		return rec.processed("sink") > 10
+	}, 100, 10*time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	start := time.Now()
+	err = msh.Drain(ctx)
+	assert.NoError(err)
+	assert.True(time.Since(start) < time.Second)
+	assert.Equal(rec.processed("sink"), rec.processed("ticker"))
+	assert.Length(msh.Cells(), 0)
+}
+
+//--------------------
+// HELPERS
+//--------------------
+
+// drainRecorder records the processing and terminating of cells.
+type drainRecorder struct {
+	mu     sync.Mutex
+	counts map[string]int
+	ids    []string
+}
+
+func (dr *drainRecorder) process(id string) {
+	dr.mu.Lock()
+	defer dr.mu.Unlock()
+	if dr.counts == nil {
+		dr.counts = map[string]int{}
+	}
+	dr.counts[id]++
+}
+
+func (dr *drainRecorder) terminate(id string) {
+	dr.mu.Lock()
+	defer dr.mu.Unlock()
+	dr.ids = append(dr.ids, id)
+}
+
+func (dr *drainRecorder) processed(id string) int {
+	dr.mu.Lock()
+	defer dr.mu.Unlock()
+	return dr.counts[id]
+}
+
+func (dr *drainRecorder) terminated() []string {
+	dr.mu.Lock()
+	defer dr.mu.Unlock()
+	return append([]string{}, dr.ids...)
+}
+
+type DrainBehavior struct {
+	id      string
+	emitter mesh.Emitter
+	rec     *drainRecorder
+	delay   time.Duration
+	block   chan struct{}
+}
+
+func NewDrainBehavior(id string, rec *drainRecorder, delay time.Duration, block chan struct{}) *DrainBehavior {
+	return &DrainBehavior{
+		id:    id,
+		rec:   rec,
+		delay: delay,
+		block: block,
+	}
+}
+
+func (db *DrainBehavior) ID() string {
+	return db.id
+}
+
+func (db *DrainBehavior) Init(emitter mesh.Emitter) error {
+	db.emitter = emitter
+	return nil
+}
+
+func (db *DrainBehavior) Terminate() error {
+	db.rec.terminate(db.id)
+	return nil
+}
+
+func (db *DrainBehavior) Process(evt *event.Event) error {
+	switch evt.Topic() {
+	case "block":
+		<-db.block
+	case "count":
+		time.Sleep(db.delay)
+		db.rec.process(db.id)
+		return db.emitter.Broadcast(evt)
+	}
+	return nil
+}
+
+func (db *DrainBehavior) Recover(r interface{}) error {
+	return nil
+}
+
+type DrainTickerBehavior struct {
+	id      string
+	emitter mesh.Emitter
+	rec     *drainRecorder
+	stopc   chan struct{}
+}
+
+func NewDrainTickerBehavior(id string, rec *drainRecorder) *DrainTickerBehavior {
+	return &DrainTickerBehavior{
+		id:  id,
+		rec: rec,
+	}
+}
+
+func (dtb *DrainTickerBehavior) ID() string {
+	return dtb.id
+}
+
+func (dtb *DrainTickerBehavior) Init(emitter mesh.Emitter) error {
+	dtb.emitter = emitter
+	dtb.stopc = make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(time.Millisecond)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-dtb.stopc:
+				return
+			case <-ticker.C:
+				dtb.emitter.Self(event.New("tick"))
+			}
+		}
+	}()
+	return nil
+}
+
+func (dtb *DrainTickerBehavior) Terminate() error {
+	close(dtb.stopc)
+	dtb.rec.terminate(dtb.id)
+	return nil
+}
+
+func (dtb *DrainTickerBehavior) Process(evt *event.Event) error {
+	time.Sleep(2 * time.Millisecond)
+	dtb.rec.process(dtb.id)
+	return dtb.emitter.Broadcast(event.New("count"))
+}
+
+func (dtb *DrainTickerBehavior) Recover(r interface{}) error {
+	return nil
+}
+
+// EOF
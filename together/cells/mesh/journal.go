@@ -0,0 +1,187 @@
+// Tideland Go Library - Together - Cells - Mesh
+//
+// Copyright (C) 2010-2019 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license
+
+package mesh // import "tideland.dev/go/together/cells/mesh"
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"tideland.dev/go/together/cells/event"
+	"tideland.dev/go/trace/failure"
+)
+
+//--------------------
+// JOURNAL BOUNDS
+//--------------------
+
+// JournalBound restricts the journal entries taken for iterations
+// and replays.
+type JournalBound func(entry *JournalEntry) bool
+
+// FromPosition limits the entries to those starting at the given
+// position.
+func FromPosition(pos int) JournalBound {
+	return func(entry *JournalEntry) bool {
+		return entry.Position >= pos
+	}
+}
+
+// ToPosition limits the entries to those up to and including the
+// given position.
+func ToPosition(pos int) JournalBound {
+	return func(entry *JournalEntry) bool {
+		return entry.Position <= pos
+	}
+}
+
+// FromTime limits the entries to those with an event timestamp
+// not before the given time.
+func FromTime(t time.Time) JournalBound {
+	return func(entry *JournalEntry) bool {
+		return !entry.Event.Timestamp().Before(t)
+	}
+}
+
+// ToTime limits the entries to those with an event timestamp
+// not after the given time.
+func ToTime(t time.Time) JournalBound {
+	return func(entry *JournalEntry) bool {
+		return !entry.Event.Timestamp().After(t)
+	}
+}
+
+//--------------------
+// JOURNAL
+//--------------------
+
+// JournalEntry contains one recorded event. An empty cell ID
+// marks a broadcasted event.
+type JournalEntry struct {
+	Position int          `json:"position"`
+	CellID   string       `json:"cell-id,omitempty"`
+	Event    *event.Event `json:"event"`
+}
+
+// JournalDoer performs an operation on a journal entry.
+type JournalDoer func(entry *JournalEntry) error
+
+// Journal is a file based append log of the events emitted or
+// broadcasted into a mesh. Each entry gets a position, the
+// recorded events can be replayed into a mesh.
+type Journal struct {
+	mu       sync.Mutex
+	filename string
+	file     *os.File
+	position int
+}
+
+// OpenJournal opens or creates the journal in the given file. New
+// entries are appended to existing ones.
+func OpenJournal(filename string) (*Journal, error) {
+	j := &Journal{
+		filename: filename,
+	}
+	// Find last position of an existing journal.
+	if err := j.Do(func(entry *JournalEntry) error {
+		j.position = entry.Position
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	file, err := os.OpenFile(filename, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, failure.Annotate(err, "cannot open journal %q", filename)
+	}
+	j.file = file
+	return j, nil
+}
+
+// Position returns the position of the last recorded entry.
+func (j *Journal) Position() int {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.position
+}
+
+// Do iterates over all journal entries matching the passed bounds.
+func (j *Journal) Do(doer JournalDoer, bounds ...JournalBound) error {
+	file, err := os.Open(j.filename)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return failure.Annotate(err, "cannot open journal %q", j.filename)
+	}
+	defer file.Close()
+	decoder := json.NewDecoder(file)
+	for {
+		var entry JournalEntry
+		err := decoder.Decode(&entry)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return failure.Annotate(err, "cannot read journal %q", j.filename)
+		}
+		if !entry.matches(bounds) {
+			continue
+		}
+		if err := doer(&entry); err != nil {
+			return err
+		}
+	}
+}
+
+// Close closes the journal file.
+func (j *Journal) Close() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if err := j.file.Close(); err != nil {
+		return failure.Annotate(err, "cannot close journal %q", j.filename)
+	}
+	return nil
+}
+
+// append records an event for the given cell ID.
+func (j *Journal) append(id string, evt *event.Event) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	entry := &JournalEntry{
+		Position: j.position + 1,
+		CellID:   id,
+		Event:    evt,
+	}
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return failure.Annotate(err, "cannot record event for journal")
+	}
+	if _, err := j.file.Write(append(data, '\n')); err != nil {
+		return failure.Annotate(err, "cannot write journal %q", j.filename)
+	}
+	j.position = entry.Position
+	return nil
+}
+
+// matches checks if the entry matches all bounds.
+func (entry *JournalEntry) matches(bounds []JournalBound) bool {
+	for _, bound := range bounds {
+		if !bound(entry) {
+			return false
+		}
+	}
+	return true
+}
+
+// EOF
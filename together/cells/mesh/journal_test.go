@@ -0,0 +1,197 @@
+// Tideland Go Library - Together - Cells - Mesh - Unit Tests
+//
+// Copyright (C) 2010-2019 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license
+
+package mesh_test // import "tideland.dev/go/together/cells/mesh"
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"tideland.dev/go/audit/asserts"
+	"tideland.dev/go/audit/environments"
+	"tideland.dev/go/together/cells/event"
+	"tideland.dev/go/together/cells/mesh"
+)
+
+//--------------------
+// TESTS
+//--------------------
+
+// TestJournalReplay verifies the recording of events and their
+// replaying into a fresh mesh.
+func TestJournalReplay(t *testing.T) {
+	assert := asserts.NewTesting(t, asserts.FailStop)
+	td := environments.NewTempDir(assert)
+	defer td.Restore()
+	filename := filepath.Join(td.String(), "mesh.journal")
+
+	j, err := mesh.OpenJournal(filename)
+	assert.NoError(err)
+	msh := mesh.New(mesh.WithJournal(j))
+
+	err = msh.SpawnCells(
+		NewTestBehavior("foo"),
+		NewTestBehavior("bar"),
+	)
+	assert.NoError(err)
+
+	msh.Emit("foo", event.New("set", "a", 1))
+	msh.Emit("foo", event.New("set", "b", 2))
+	msh.Broadcast(event.New("set", "c", 3))
+	assert.Equal(j.Position(), 3)
+
+	err = msh.Stop()
+	assert.NoError(err)
+	err = j.Close()
+	assert.NoError(err)
+
+	// Reopen journal and replay into a new mesh.
+	j, err = mesh.OpenJournal(filename)
+	assert.NoError(err)
+	defer j.Close()
+	assert.Equal(j.Position(), 3)
+	msh = mesh.New(mesh.WithJournal(j))
+
+	err = msh.SpawnCells(
+		NewTestBehavior("foo"),
+		NewTestBehavior("bar"),
+	)
+	assert.NoError(err)
+
+	err = msh.Replay(j)
+	assert.NoError(err)
+	assert.Equal(j.Position(), 3)
+
+	plr := sendData(assert, msh, "foo")
+	assert.Equal(plr.At("a").AsInt(0), 1)
+	assert.Equal(plr.At("b").AsInt(0), 2)
+	assert.Equal(plr.At("c").AsInt(0), 3)
+	plr = sendData(assert, msh, "bar")
+	assert.Length(plr.Keys(), 1)
+	assert.Equal(plr.At("c").AsInt(0), 3)
+
+	err = msh.Stop()
+	assert.NoError(err)
+}
+
+// TestJournalBounds verifies the replaying of events limited
+// by positions and timestamps.
+func TestJournalBounds(t *testing.T) {
+	assert := asserts.NewTesting(t, asserts.FailStop)
+	td := environments.NewTempDir(assert)
+	defer td.Restore()
+
+	j, err := mesh.OpenJournal(filepath.Join(td.String(), "mesh.journal"))
+	assert.NoError(err)
+	defer j.Close()
+	msh := mesh.New(mesh.WithJournal(j))
+	err = msh.SpawnCells(NewTestBehavior("foo"))
+	assert.NoError(err)
+
+	msh.Emit("foo", event.New("set", "a", 1))
+	msh.Emit("foo", event.New("set", "b", 2))
+	time.Sleep(10 * time.Millisecond)
+	split := time.Now()
+	msh.Emit("foo", event.New("set", "c", 3))
+	msh.Emit("foo", event.New("set", "d", 4))
+
+	err = msh.Stop()
+	assert.NoError(err)
+
+	// Replay by positions.
+	msh = mesh.New()
+	err = msh.SpawnCells(NewTestBehavior("foo"))
+	assert.NoError(err)
+
+	err = msh.Replay(j, mesh.FromPosition(2), mesh.ToPosition(3))
+	assert.NoError(err)
+
+	plr := sendData(assert, msh, "foo")
+	assert.Length(plr.Keys(), 2)
+	assert.Equal(plr.At("b").AsInt(0), 2)
+	assert.Equal(plr.At("c").AsInt(0), 3)
+
+	// Replay by time.
+	msh.Emit("foo", event.New("clear"))
+	err = msh.Replay(j, mesh.FromTime(split))
+	assert.NoError(err)
+
+	plr = sendData(assert, msh, "foo")
+	assert.Length(plr.Keys(), 2)
+	assert.Equal(plr.At("c").AsInt(0), 3)
+	assert.Equal(plr.At("d").AsInt(0), 4)
+
+	msh.Emit("foo", event.New("clear"))
+	err = msh.Replay(j, mesh.ToTime(split))
+	assert.NoError(err)
+
+	plr = sendData(assert, msh, "foo")
+	assert.Length(plr.Keys(), 2)
+	assert.Equal(plr.At("a").AsInt(0), 1)
+	assert.Equal(plr.At("b").AsInt(0), 2)
+
+	// Replay into missing cell.
+	err = msh.StopCells("foo")
+	assert.NoError(err)
+	err = msh.Replay(j)
+	assert.ErrorMatch(err, ".*cannot replay journal entry 1.*cannot find cell \"foo\".*")
+
+	err = msh.Stop()
+	assert.NoError(err)
+}
+
+// TestJournalUnrecordable verifies the delivery of events which
+// cannot be recorded.
+func TestJournalUnrecordable(t *testing.T) {
+	assert := asserts.NewTesting(t, asserts.FailStop)
+	td := environments.NewTempDir(assert)
+	defer td.Restore()
+	evtc := make(chan *event.Event, 10)
+
+	j, err := mesh.OpenJournal(filepath.Join(td.String(), "mesh.journal"))
+	assert.NoError(err)
+	defer j.Close()
+	msh := mesh.New(mesh.WithJournal(j), mesh.WithDeadLetterCell("dead"))
+	defer msh.Stop()
+	err = msh.SpawnCells(
+		NewTestBehavior("foo"),
+		NewDeadLetterBehavior("dead", evtc),
+	)
+	assert.NoError(err)
+
+	err = msh.Emit("foo", event.New("set", "a", 1, "f", func() {}))
+	assert.NoError(err)
+	assert.Equal(j.Position(), 0)
+
+	evt := waitDeadLetter(assert, evtc)
+	assert.Equal(evt.Payload().At("cell").AsString(""), "foo")
+	assert.Match(evt.Payload().At("error").AsString(""), ".*cannot record event.*")
+	assert.Equal(evt.Payload().At("topic").AsString(""), "set")
+
+	plr := sendData(assert, msh, "foo")
+	assert.Equal(plr.At("a").AsInt(0), 1)
+}
+
+//--------------------
+// HELPERS
+//--------------------
+
+func sendData(assert *asserts.Asserts, msh *mesh.Mesh, id string) *event.Payload {
+	pl, plc := event.NewReplyPayload()
+	err := msh.Emit(id, event.New("send", pl))
+	assert.NoError(err)
+	plr, err := plc.Wait(waitTimeout)
+	assert.NoError(err)
+	return plr
+}
+
+// EOF
@@ -0,0 +1,243 @@
+// Tideland Go Library - Together - Cells - Mesh
+//
+// Copyright (C) 2010-2019 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license
+
+package mesh // import "tideland.dev/go/together/cells/mesh"
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"bytes"
+	"runtime"
+	"strconv"
+	"sync"
+
+	"tideland.dev/go/together/cells/event"
+	"tideland.dev/go/trace/failure"
+)
+
+//--------------------
+// CONSTANTS
+//--------------------
+
+// OverflowPolicy defines how a cell handles new events when its
+// mailbox is full.
+type OverflowPolicy int
+
+// List of overflow policies.
+const (
+	// OverflowBlock lets the emitter wait until the cell processed
+	// an event. A behavior emitting while processing to its own cell
+	// or to a cell waiting for it, directly or via other cells, would
+	// wait forever. Here the event is spilled instead and an error is
+	// returned. Goroutines started by behaviors always wait.
+	OverflowBlock OverflowPolicy = iota + 1
+
+	// OverflowDropNewest drops the new event.
+	OverflowDropNewest
+
+	// OverflowDropOldest drops the oldest queued event to make
+	// space for the new one.
+	OverflowDropOldest
+
+	// OverflowReject drops the new event and returns an error
+	// to the emitter.
+	OverflowReject
+
+	// OverflowSpill emits the new event to a spill cell instead.
+	OverflowSpill
+)
+
+// Defaults of the mailbox.
+const (
+	DefaultMailboxCapacity = 32
+	DefaultOverflowPolicy  = OverflowBlock
+)
+
+//--------------------
+// MAILBOX
+//--------------------
+
+// mailbox queues the events of a cell until they are processed.
+type mailbox struct {
+	mu       sync.Mutex
+	cond     *sync.Cond
+	capacity int
+	policy   OverflowPolicy
+	events   []*event.Event
+	dropped  int
+	closed   bool
+}
+
+// newMailbox creates a mailbox with the given capacity and
+// overflow policy.
+func newMailbox(capacity int, policy OverflowPolicy) *mailbox {
+	mb := &mailbox{
+		capacity: capacity,
+		policy:   policy,
+	}
+	mb.cond = sync.NewCond(&mb.mu)
+	return mb
+}
+
+// push adds an event to the mailbox. In case of a full mailbox the
+// overflow policy is applied. If an event has been dropped for
+// spilling it is returned. Blocking is only allowed if wanted,
+// otherwise the event is spilled.
+func (mb *mailbox) push(evt *event.Event, block bool) (*event.Event, error) {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+	if mb.closed {
+		return nil, failure.New("mailbox is closed")
+	}
+	if len(mb.events) < mb.capacity {
+		mb.events = append(mb.events, evt)
+		return nil, nil
+	}
+	switch mb.policy {
+	case OverflowDropNewest:
+		mb.dropped++
+		return nil, nil
+	case OverflowDropOldest:
+		mb.dropped++
+		mb.events = append(mb.events[1:], evt)
+		return nil, nil
+	case OverflowReject:
+		mb.dropped++
+		return nil, failure.New("mailbox is full")
+	case OverflowSpill:
+		mb.dropped++
+		return evt, nil
+	}
+	if !block {
+		mb.dropped++
+		return evt, nil
+	}
+	// Block until space or closing.
+	for len(mb.events) >= mb.capacity && !mb.closed {
+		mb.cond.Wait()
+	}
+	if mb.closed {
+		return nil, failure.New("mailbox is closed")
+	}
+	mb.events = append(mb.events, evt)
+	return nil, nil
+}
+
+// pop retrieves the oldest event of the mailbox. It returns nil
+// if the mailbox is empty.
+func (mb *mailbox) pop() *event.Event {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+	if len(mb.events) == 0 {
+		return nil
+	}
+	evt := mb.events[0]
+	mb.events[0] = nil
+	mb.events = mb.events[1:]
+	mb.cond.Signal()
+	return evt
+}
+
+// len returns the number of queued events.
+func (mb *mailbox) len() int {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+	return len(mb.events)
+}
+
+// droppedEvents returns the number of dropped events.
+func (mb *mailbox) droppedEvents() int {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+	return mb.dropped
+}
+
+//--------------------
+// WAIT GRAPH
+//--------------------
+
+// waitGraph tracks which cells may wait for the mailboxes of other
+// cells. It is used to detect emits which would let a cell wait for
+// itself.
+type waitGraph struct {
+	mu    sync.Mutex
+	edges map[*cell]map[*cell]int
+}
+
+// add registers that the emitting cell may wait for the receiving
+// one. It returns false if the receiving cell is the emitting one or
+// already waits for it.
+func (wg *waitGraph) add(from, to *cell) bool {
+	wg.mu.Lock()
+	defer wg.mu.Unlock()
+	if wg.reaches(to, from, map[*cell]bool{}) {
+		return false
+	}
+	if wg.edges == nil {
+		wg.edges = map[*cell]map[*cell]int{}
+	}
+	if wg.edges[from] == nil {
+		wg.edges[from] = map[*cell]int{}
+	}
+	wg.edges[from][to]++
+	return true
+}
+
+// remove deregisters a wait added before.
+func (wg *waitGraph) remove(from, to *cell) {
+	wg.mu.Lock()
+	defer wg.mu.Unlock()
+	wg.edges[from][to]--
+	if wg.edges[from][to] == 0 {
+		delete(wg.edges[from], to)
+	}
+	if len(wg.edges[from]) == 0 {
+		delete(wg.edges, from)
+	}
+}
+
+// reaches checks if the first cell is the second one or waits
+// for it, directly or via other cells.
+func (wg *waitGraph) reaches(from, to *cell, visited map[*cell]bool) bool {
+	if from == to {
+		return true
+	}
+	visited[from] = true
+	for next := range wg.edges[from] {
+		if !visited[next] && wg.reaches(next, to, visited) {
+			return true
+		}
+	}
+	return false
+}
+
+// goroutineID returns the ID of the calling goroutine. It is used to
+// find out if a cell emits while processing an event.
+func goroutineID() int64 {
+	buf := make([]byte, 64)
+	buf = buf[:runtime.Stack(buf, false)]
+	// Stack starts with "goroutine <id> [".
+	buf = bytes.TrimPrefix(buf, []byte("goroutine "))
+	if i := bytes.IndexByte(buf, ' '); i > 0 {
+		buf = buf[:i]
+	}
+	id, _ := strconv.ParseInt(string(buf), 10, 64)
+	return id
+}
+
+// close drops all queued events and releases waiting emitters.
+func (mb *mailbox) close() {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+	mb.closed = true
+	mb.events = nil
+	mb.cond.Broadcast()
+}
+
+// EOF
@@ -0,0 +1,365 @@
+// Tideland Go Library - Together - Cells - Mesh - Unit Tests
+//
+// Copyright (C) 2010-2019 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license
+
+package mesh_test // import "tideland.dev/go/together/cells/mesh"
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"tideland.dev/go/audit/asserts"
+	"tideland.dev/go/together/cells/event"
+	"tideland.dev/go/together/cells/mesh"
+)
+
+//--------------------
+// TESTS
+//--------------------
+
+// TestMailboxDropNewest verifies the dropping of new events
+// when the mailbox is full.
+func TestMailboxDropNewest(t *testing.T) {
+	assert := asserts.NewTesting(t, asserts.FailStop)
+	msh := mesh.New()
+	defer msh.Stop()
+	bb := NewBlockingBehavior("foo")
+
+	err := msh.SpawnCell(bb, mesh.WithMailbox(2, mesh.OverflowDropNewest))
+	assert.NoError(err)
+
+	bb.block(assert, msh)
+	for i := 1; i <= 4; i++ {
+		err = msh.Emit("foo", event.New("value", "value", i))
+		assert.NoError(err)
+	}
+	bb.release(assert, 2)
+
+	assert.Equal(bb.values(assert, msh), []int{1, 2})
+	dropped, err := msh.DroppedEvents("foo")
+	assert.NoError(err)
+	assert.Equal(dropped, 2)
+}
+
+// TestMailboxDropOldest verifies the dropping of old events
+// when the mailbox is full.
+func TestMailboxDropOldest(t *testing.T) {
+	assert := asserts.NewTesting(t, asserts.FailStop)
+	msh := mesh.New()
+	defer msh.Stop()
+	bb := NewBlockingBehavior("foo")
+
+	err := msh.SpawnCell(bb, mesh.WithMailbox(2, mesh.OverflowDropOldest))
+	assert.NoError(err)
+
+	bb.block(assert, msh)
+	for i := 1; i <= 4; i++ {
+		err = msh.Emit("foo", event.New("value", "value", i))
+		assert.NoError(err)
+	}
+	bb.release(assert, 2)
+
+	assert.Equal(bb.values(assert, msh), []int{3, 4})
+	dropped, err := msh.DroppedEvents("foo")
+	assert.NoError(err)
+	assert.Equal(dropped, 2)
+}
+
+// TestMailboxReject verifies the rejection of new events
+// when the mailbox is full.
+func TestMailboxReject(t *testing.T) {
+	assert := asserts.NewTesting(t, asserts.FailStop)
+	msh := mesh.New()
+	defer msh.Stop()
+	bb := NewBlockingBehavior("foo")
+
+	err := msh.SpawnCell(bb, mesh.WithMailbox(2, mesh.OverflowReject))
+	assert.NoError(err)
+
+	bb.block(assert, msh)
+	err = msh.Emit("foo", event.New("value", "value", 1))
+	assert.NoError(err)
+	err = msh.Emit("foo", event.New("value", "value", 2))
+	assert.NoError(err)
+	err = msh.Emit("foo", event.New("value", "value", 3))
+	assert.ErrorMatch(err, ".*cannot queue event for cell \"foo\".*mailbox is full.*")
+	bb.release(assert, 2)
+
+	assert.Equal(bb.values(assert, msh), []int{1, 2})
+	dropped, err := msh.DroppedEvents("foo")
+	assert.NoError(err)
+	assert.Equal(dropped, 1)
+}
+
+// TestMailboxSpill verifies the spilling of new events to
+// another cell when the mailbox is full.
+func TestMailboxSpill(t *testing.T) {
+	assert := asserts.NewTesting(t, asserts.FailStop)
+	msh := mesh.New()
+	defer msh.Stop()
+	bb := NewBlockingBehavior("foo")
+
+	err := msh.SpawnCell(bb, mesh.WithMailbox(2, mesh.OverflowSpill), mesh.WithSpillCell("spill"))
+	assert.ErrorMatch(err, ".*cannot find spill cell \"spill\" for cell \"foo\".*")
+
+	err = msh.SpawnCells(NewTestBehavior("spill"))
+	assert.NoError(err)
+	err = msh.SpawnCell(bb, mesh.WithMailbox(2, mesh.OverflowSpill), mesh.WithSpillCell("spill"))
+	assert.NoError(err)
+
+	bb.block(assert, msh)
+	msh.Emit("foo", event.New("value", "value", 1))
+	msh.Emit("foo", event.New("value", "value", 2))
+	msh.Emit("foo", event.New("set", "c", 3))
+	msh.Emit("foo", event.New("set", "d", 4))
+	bb.release(assert, 2)
+
+	assert.Equal(bb.values(assert, msh), []int{1, 2})
+	plr := sendData(assert, msh, "spill")
+	assert.Equal(plr.At("c").AsInt(0), 3)
+	assert.Equal(plr.At("d").AsInt(0), 4)
+	dropped, err := msh.DroppedEvents("foo")
+	assert.NoError(err)
+	assert.Equal(dropped, 2)
+}
+
+// TestMailboxBlock verifies the blocking of emitters when the
+// mailbox is full.
+func TestMailboxBlock(t *testing.T) {
+	assert := asserts.NewTesting(t, asserts.FailStop)
+	sigc := asserts.MakeWaitChan()
+	msh := mesh.New()
+	defer msh.Stop()
+	bb := NewBlockingBehavior("foo")
+
+	err := msh.SpawnCell(bb, mesh.WithMailbox(2, mesh.OverflowBlock))
+	assert.NoError(err)
+
+	bb.block(assert, msh)
+	msh.Emit("foo", event.New("value", "value", 1))
+	msh.Emit("foo", event.New("value", "value", 2))
+	go func() {
+		msh.Emit("foo", event.New("value", "value", 3))
+		sigc <- true
+	}()
+	select {
+	case <-sigc:
+		assert.Fail("emitter has not been blocked")
+	case <-time.After(50 * time.Millisecond):
+	}
+	bb.release(assert, 3)
+	assert.Wait(sigc, true, time.Second)
+
+	assert.Equal(bb.values(assert, msh), []int{1, 2, 3})
+	dropped, err := msh.DroppedEvents("foo")
+	assert.NoError(err)
+	assert.Equal(dropped, 0)
+}
+
+// TestMailboxBlockSelf verifies that cells emitting to their own
+// full mailbox while processing don't block but spill the events to
+// the dead-letter cell and get an error. Goroutines of the behavior
+// block instead.
+func TestMailboxBlockSelf(t *testing.T) {
+	assert := asserts.NewTesting(t, asserts.FailStop)
+	evtc := make(chan *event.Event, 10)
+	msh := mesh.New(mesh.WithDeadLetterCell("dead"))
+	defer msh.Stop()
+
+	err := msh.SpawnCells(NewDeadLetterBehavior("dead", evtc))
+	assert.NoError(err)
+	err = msh.SpawnCell(NewSelfFloodBehavior("foo"), mesh.WithMailbox(2, mesh.OverflowBlock))
+	assert.NoError(err)
+
+	err = msh.Emit("foo", event.New("flood", "count", 5))
+	assert.NoError(err)
+	for i := 0; i < 3; i++ {
+		evt := waitDeadLetter(assert, evtc)
+		assert.Equal(evt.Payload().At("cell").AsString(""), "foo")
+		assert.Match(evt.Payload().At("error").AsString(""), `.*mailbox of cell "foo" is full.*`)
+	}
+
+	plr := sendData(assert, msh, "foo")
+	assert.Equal(plr.At("count").AsInt(0), 2)
+	assert.Equal(plr.At("errors").AsInt(0), 3)
+	dropped, err := msh.DroppedEvents("foo")
+	assert.NoError(err)
+	assert.Equal(dropped, 3)
+
+	err = msh.Emit("foo", event.New("flood-async", "count", 50))
+	assert.NoError(err)
+	assert.Retry(func() bool {
+		plr := sendData(assert, msh, "foo")
+		return plr.At("count").AsInt(0) == 52
+	}, 100, 10*time.Millisecond)
+	plr = sendData(assert, msh, "foo")
+	assert.Equal(plr.At("errors").AsInt(0), 3)
+	assert.Length(evtc, 0)
+}
+
+// TestMailboxInvalid verifies the handling of invalid mailbox options.
+func TestMailboxInvalid(t *testing.T) {
+	assert := asserts.NewTesting(t, asserts.FailStop)
+	msh := mesh.New()
+	defer msh.Stop()
+
+	err := msh.SpawnCell(NewTestBehavior("foo"), mesh.WithMailbox(2, mesh.OverflowPolicy(0)))
+	assert.ErrorMatch(err, ".*invalid cell option: overflow policy 0.*")
+
+	_, err = msh.DroppedEvents("foo")
+	assert.ErrorMatch(err, ".*cannot find cell \"foo\".*")
+}
+
+//--------------------
+// HELPERS
+//--------------------
+
+type BlockingBehavior struct {
+	id       string
+	startc   chan struct{}
+	releasec chan struct{}
+	valuec   chan int
+	data     []int
+}
+
+func NewBlockingBehavior(id string) *BlockingBehavior {
+	return &BlockingBehavior{
+		id:       id,
+		startc:   make(chan struct{}),
+		releasec: make(chan struct{}),
+		valuec:   make(chan int, 10),
+	}
+}
+
+func (bb *BlockingBehavior) block(assert *asserts.Asserts, msh *mesh.Mesh) {
+	err := msh.Emit(bb.id, event.New("block"))
+	assert.NoError(err)
+	select {
+	case <-bb.startc:
+	case <-time.After(waitTimeout):
+		assert.Fail("behavior has not been blocked")
+	}
+}
+
+func (bb *BlockingBehavior) release(assert *asserts.Asserts, processed int) {
+	close(bb.releasec)
+	for {
+		select {
+		case l := <-bb.valuec:
+			if l == processed {
+				return
+			}
+		case <-time.After(waitTimeout):
+			assert.Fail("behavior has not processed the values")
+		}
+	}
+}
+
+func (bb *BlockingBehavior) values(assert *asserts.Asserts, msh *mesh.Mesh) []int {
+	plr := sendData(assert, msh, bb.id)
+	var values []int
+	for i := 0; ; i++ {
+		v := plr.At("values", strconv.Itoa(i))
+		if v.IsUndefined() {
+			return values
+		}
+		values = append(values, v.AsInt(0))
+	}
+}
+
+func (bb *BlockingBehavior) ID() string {
+	return bb.id
+}
+
+func (bb *BlockingBehavior) Init(emitter mesh.Emitter) error {
+	return nil
+}
+
+func (bb *BlockingBehavior) Terminate() error {
+	return nil
+}
+
+func (bb *BlockingBehavior) Process(evt *event.Event) error {
+	switch evt.Topic() {
+	case "block":
+		bb.startc <- struct{}{}
+		<-bb.releasec
+	case "value":
+		bb.data = append(bb.data, evt.Payload().At("value").AsInt(0))
+		bb.valuec <- len(bb.data)
+	case "send":
+		return evt.Payload().Reply(event.NewPayload("values", bb.data))
+	}
+	return nil
+}
+
+func (bb *BlockingBehavior) Recover(r interface{}) error {
+	return nil
+}
+
+type SelfFloodBehavior struct {
+	id      string
+	emitter mesh.Emitter
+	count   int
+	errors  int
+}
+
+func NewSelfFloodBehavior(id string) *SelfFloodBehavior {
+	return &SelfFloodBehavior{
+		id: id,
+	}
+}
+
+func (sb *SelfFloodBehavior) ID() string {
+	return sb.id
+}
+
+func (sb *SelfFloodBehavior) Init(emitter mesh.Emitter) error {
+	sb.emitter = emitter
+	return nil
+}
+
+func (sb *SelfFloodBehavior) Terminate() error {
+	return nil
+}
+
+func (sb *SelfFloodBehavior) Process(evt *event.Event) error {
+	switch evt.Topic() {
+	case "flood":
+		for i := 0; i < evt.Payload().At("count").AsInt(0); i++ {
+			if err := sb.emitter.Self(event.New("value")); err != nil {
+				sb.errors++
+			}
+		}
+	case "flood-async":
+		count := evt.Payload().At("count").AsInt(0)
+		go func() {
+			for i := 0; i < count; i++ {
+				if err := sb.emitter.Self(event.New("value")); err != nil {
+					sb.emitter.Self(event.New("error"))
+				}
+			}
+		}()
+	case "value":
+		sb.count++
+	case "error":
+		sb.errors++
+	case "send":
+		return evt.Payload().Reply(event.NewPayload("count", sb.count, "errors", sb.errors))
+	}
+	return nil
+}
+
+func (sb *SelfFloodBehavior) Recover(r interface{}) error {
+	return nil
+}
+
+// EOF
@@ -12,10 +12,15 @@ package mesh // import "tideland.dev/go/together/cells/mesh"
 //--------------------
 
 import (
+	"fmt"
+	"io"
+	"sort"
 	"sync"
 
+	"tideland.dev/go/dsa/timex"
 	"tideland.dev/go/together/cells/event"
 	"tideland.dev/go/trace/failure"
+	"tideland.dev/go/trace/monitor"
 )
 
 //--------------------
@@ -24,14 +29,30 @@ import (
 
 // Mesh operates a set of interacting cells.
 type Mesh struct {
-	mu    sync.RWMutex
-	cells cellRegistry
+	mu         sync.RWMutex
+	cells      cellRegistry
+	journal    *Journal
+	deadLetter deadLetter
+	monitor    *monitor.Monitor
+	supervisor supervisor
+	waits      waitGraph
+	clock      timex.Clock
+	draining   int32
+	err        error
 }
 
-// New creates a new event processing mesh.
-func New() *Mesh {
+// New creates a new event processing mesh. An error of the
+// options will be returned when spawning cells.
+func New(options ...Option) *Mesh {
 	m := &Mesh{
 		cells: cellRegistry{},
+		clock: timex.RealClock(),
+	}
+	for _, option := range options {
+		if err := option(m); err != nil {
+			m.err = err
+			break
+		}
 	}
 	return m
 }
@@ -41,40 +62,66 @@ func New() *Mesh {
 func (m *Mesh) SpawnCells(behaviors ...Behavior) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
+	if m.err != nil {
+		return m.err
+	}
 	for _, behavior := range behaviors {
-		id := behavior.ID()
-		if m.cells.contains(id) {
-			// No double deployment.
-			continue
-		}
-		cell, err := newCell(m, behavior)
-		if err != nil {
+		if err := m.spawnCell(behavior); err != nil {
 			return err
 		}
-		m.cells.add(id, cell)
 	}
 	return nil
 }
 
-// StopCells terminates the given cells.
+// SpawnCell starts one cell running the passed behavior configured by
+// the passed options.
+func (m *Mesh) SpawnCell(behavior Behavior, options ...CellOption) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.err != nil {
+		return m.err
+	}
+	return m.spawnCell(behavior, options...)
+}
+
+// StopCells terminates the given cells. Partitioned cells are
+// terminated together with their partitions.
 func (m *Mesh) StopCells(ids ...string) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 	for _, id := range ids {
-		if err := m.cells.unsubscribeFromAll(id); err != nil {
-			return err
+		entry, ok := m.cells[id]
+		if !ok {
+			continue
+		}
+		if entry.partitionOf != "" {
+			return failure.New("cannot stop partition %q of cell %q", id, entry.partitionOf)
+		}
+		for _, cid := range append([]string{id}, entry.partitions...) {
+			if err := m.stopCell(cid); err != nil {
+				return err
+			}
 		}
-		return m.cells.remove(id)
 	}
 	return nil
 }
 
-// Cells returns the identifiers of the spawned cells.
+// Clock returns the clock of the mesh. Time dependent behaviors
+// should use it instead of the time package.
+func (m *Mesh) Clock() timex.Clock {
+	return m.clock
+}
+
+// Cells returns the identifiers of the spawned cells. Partitions are
+// represented by their partitioned cell.
 func (m *Mesh) Cells() []string {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
 	var ids []string
-	for id := range m.cells {
+	for id, entry := range m.cells {
+		if entry.partitionOf != "" {
+			continue
+		}
 		ids = append(ids, id)
 	}
 	return ids
@@ -92,6 +139,72 @@ func (m *Mesh) Subscribers(id string) ([]string, error) {
 	return entry.cell.subscribers()
 }
 
+// DroppedEvents returns the number of events a cell dropped due to
+// a full mailbox.
+func (m *Mesh) DroppedEvents(id string) (int, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	entry, ok := m.cells[id]
+	if !ok {
+		return 0, failure.New("cannot find cell %q", id)
+	}
+	return entry.cell.droppedEvents(), nil
+}
+
+// Stats returns the statistics of all cells sorted by their IDs.
+func (m *Mesh) Stats() CellStatsList {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var csl CellStatsList
+	for _, entry := range m.cells {
+		csl = append(csl, entry.cell.stats.read(entry.cell))
+	}
+	sort.Sort(csl)
+	return csl
+}
+
+// WriteDOT writes the subscription graph of the mesh in the DOT
+// language of Graphviz to the passed writer. A dead-letter cell
+// is drawn dashed, partitions are represented by their partitioned
+// cell.
+func (m *Mesh) WriteDOT(w io.Writer) error {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var ids []string
+	for id, entry := range m.cells {
+		if entry.partitionOf != "" {
+			continue
+		}
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	lines := []string{"digraph mesh {"}
+	for _, id := range ids {
+		if id == m.deadLetter.id {
+			lines = append(lines, fmt.Sprintf("\t%q [style=dashed];", id))
+			continue
+		}
+		lines = append(lines, fmt.Sprintf("\t%q;", id))
+	}
+	for _, id := range ids {
+		subscriberIDs, err := m.cells[id].cell.subscribers()
+		if err != nil {
+			return err
+		}
+		sort.Strings(subscriberIDs)
+		for _, subscriberID := range subscriberIDs {
+			lines = append(lines, fmt.Sprintf("\t%q -> %q;", id, subscriberID))
+		}
+	}
+	lines = append(lines, "}")
+	for _, line := range lines {
+		if _, err := fmt.Fprintln(w, line); err != nil {
+			return failure.Annotate(err, "cannot write DOT")
+		}
+	}
+	return nil
+}
+
 // Subscribe connects cells to the given cell.
 func (m *Mesh) Subscribe(id string, subscriberIDs ...string) error {
 	m.mu.Lock()
@@ -108,45 +221,151 @@ func (m *Mesh) Unsubscribe(id string, unsubscriberIDs ...string) error {
 
 // Emit sends an event to the given cell.
 func (m *Mesh) Emit(id string, evt *event.Event) error {
-	m.mu.RLock()
-	defer m.mu.RUnlock()
-	// Retrieve the needed cell.
-	entry, ok := m.cells[id]
-	if !ok {
-		return failure.New("cannot find cell %q", id)
+	if err := m.checkDraining(); err != nil {
+		return err
 	}
-	return entry.cell.process(evt)
+	return m.emit(id, evt, true)
 }
 
 // Broadcast sends an event to all cells.
 func (m *Mesh) Broadcast(evt *event.Event) error {
-	m.mu.RLock()
-	defer m.mu.RUnlock()
-	cerrs := make([]error, len(m.cells))
-	idx := 0
-	// Broadcast.
-	for _, entry := range m.cells {
-		cerrs[idx] = entry.cell.process(evt)
-		idx++
+	if err := m.checkDraining(); err != nil {
+		return err
 	}
-	// Return collected errors.
-	return failure.Collect(cerrs...)
+	return m.broadcast(evt, true)
 }
 
-// Stop terminates the cells and cleans up.
+// Replay emits or broadcasts the events recorded in the journal
+// matching the passed bounds again. They are not recorded a
+// second time.
+func (m *Mesh) Replay(j *Journal, bounds ...JournalBound) error {
+	if err := m.checkDraining(); err != nil {
+		return err
+	}
+	return j.Do(func(entry *JournalEntry) error {
+		var err error
+		if entry.CellID == "" {
+			err = m.broadcast(entry.Event, false)
+		} else {
+			err = m.emit(entry.CellID, entry.Event, false)
+		}
+		if err != nil {
+			return failure.Annotate(err, "cannot replay journal entry %d", entry.Position)
+		}
+		return nil
+	}, bounds...)
+}
+
+// Stop terminates the cells from upstream to downstream and cleans
+// up. Queued events are dropped, see Drain for a graceful stop.
 func (m *Mesh) Stop() error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
-	cerrs := make([]error, len(m.cells))
-	idx := 0
-	// Terminate.
+	return m.stopCells(m.cells.ordered(m.deadLetter.id))
+}
+
+// spawnCell starts a cell with the given options if it
+// doesn't exist already.
+func (m *Mesh) spawnCell(behavior Behavior, options ...CellOption) error {
+	id := behavior.ID()
+	if m.cells.contains(id) {
+		// No double deployment.
+		return nil
+	}
+	cfg := &cellConfig{
+		capacity: DefaultMailboxCapacity,
+		policy:   DefaultOverflowPolicy,
+		strategy: DefaultSupervisionStrategy,
+	}
+	for _, option := range options {
+		if err := option(cfg); err != nil {
+			return failure.Annotate(err, "cannot spawn cell %q", id)
+		}
+	}
+	var spill *cell
+	if cfg.policy == OverflowSpill {
+		switch {
+		case cfg.spillID != "":
+			entry, ok := m.cells[cfg.spillID]
+			if !ok {
+				return failure.New("cannot find spill cell %q for cell %q", cfg.spillID, id)
+			}
+			spill = entry.cell
+		case m.deadLetter.id == "":
+			return failure.New("neither spill nor dead-letter cell for cell %q", id)
+		}
+	}
+	cell, err := newCell(m, behavior, cfg, spill)
+	if err != nil {
+		return err
+	}
+	m.cells.add(id, cell)
+	m.deadLetter.set(cell)
+	return nil
+}
+
+// stopCell terminates a single cell and removes it from
+// the mesh.
+func (m *Mesh) stopCell(id string) error {
+	if err := m.cells.unsubscribeFromAll(id); err != nil {
+		return err
+	}
+	m.deadLetter.unset(id)
+	m.supervisor.remove(id)
+	if err := m.cells.remove(id); err != nil {
+		return err
+	}
+	m.cells.forget(id)
+	return nil
+}
+
+// emit sends an event to the given cell and records it if wanted.
+// The lock is not held while the emitter waits for a full mailbox.
+func (m *Mesh) emit(id string, evt *event.Event, record bool) error {
+	// Retrieve the needed cell.
+	m.mu.RLock()
+	entry, ok := m.cells[id]
+	m.mu.RUnlock()
+	if !ok {
+		return failure.New("cannot find cell %q", id)
+	}
+	m.record(id, evt, record)
+	return entry.cell.process(evt, nil)
+}
+
+// broadcast sends an event to all cells and records it if wanted.
+// Partitions receive it via their partitioned cell. Like in emit
+// the lock is not held while waiting for full mailboxes.
+func (m *Mesh) broadcast(evt *event.Event, record bool) error {
+	m.mu.RLock()
+	var cells []*cell
 	for _, entry := range m.cells {
-		cerrs[idx] = entry.cell.stop()
-		idx++
+		if entry.partitionOf != "" {
+			continue
+		}
+		cells = append(cells, entry.cell)
+	}
+	m.mu.RUnlock()
+	m.record("", evt, record)
+	var cerrs []error
+	// Broadcast.
+	for _, c := range cells {
+		cerrs = append(cerrs, c.process(evt, nil))
 	}
-	m.cells = cellRegistry{}
 	// Return collected errors.
 	return failure.Collect(cerrs...)
 }
 
+// record appends the event to a configured journal. Failures don't
+// stop the delivery of the event, they are routed to the dead-letter
+// cell instead.
+func (m *Mesh) record(id string, evt *event.Event, record bool) {
+	if !record || m.journal == nil || evt.Done() {
+		return
+	}
+	if err := m.journal.append(id, evt); err != nil {
+		m.deadLetter.route(id, evt, err)
+	}
+}
+
 // EOF
@@ -13,6 +13,7 @@ package mesh_test // import "tideland.dev/go/together/cells/mesh"
 
 import (
 	"context"
+	"errors"
 	"testing"
 	"time"
 
@@ -424,6 +425,8 @@ func (tb *TestBehavior) Process(evt *event.Event) error {
 		evt.Payload().Reply(event.NewPayload(tb.datas))
 	case "clear":
 		tb.datas = make(map[string]int)
+	case "fail":
+		return errors.New("failing on purpose")
 	}
 	return nil
 }
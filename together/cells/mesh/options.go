@@ -0,0 +1,165 @@
+// Tideland Go Library - Together - Cells - Mesh
+//
+// Copyright (C) 2010-2019 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license
+
+package mesh // import "tideland.dev/go/together/cells/mesh"
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"time"
+
+	"tideland.dev/go/dsa/timex"
+	"tideland.dev/go/trace/failure"
+	"tideland.dev/go/trace/monitor"
+)
+
+//--------------------
+// OPTIONS
+//--------------------
+
+// Option defines the signature of an option setting function.
+type Option func(m *Mesh) error
+
+// WithJournal sets a journal recording all events emitted or
+// broadcasted into the mesh. The journal is not closed when the
+// mesh stops. Events which cannot be recorded, e.g. because their
+// payload contains channels, are delivered anyway. The failure is
+// routed to the dead-letter cell.
+func WithJournal(j *Journal) Option {
+	return func(m *Mesh) error {
+		if j == nil {
+			return failure.New("invalid mesh option: journal is nil")
+		}
+		m.journal = j
+		return nil
+	}
+}
+
+// WithDeadLetterCell sets the ID of the cell receiving the events
+// failed to process or to emit by other cells. It can be spawned
+// like any other cell.
+func WithDeadLetterCell(id string) Option {
+	return func(m *Mesh) error {
+		if id == "" {
+			return failure.New("invalid mesh option: dead-letter cell ID is empty")
+		}
+		m.deadLetter.id = id
+		return nil
+	}
+}
+
+// WithMonitor sets a monitor the cells feed with their processing
+// durations and numbers of currently processed events. Both use
+// the IDs "cell:<cell ID>".
+func WithMonitor(mon *monitor.Monitor) Option {
+	return func(m *Mesh) error {
+		if mon == nil {
+			return failure.New("invalid mesh option: monitor is nil")
+		}
+		m.monitor = mon
+		return nil
+	}
+}
+
+// WithClock sets the clock used by time dependent behaviors. By
+// default it is the real clock.
+func WithClock(clock timex.Clock) Option {
+	return func(m *Mesh) error {
+		if clock == nil {
+			return failure.New("invalid mesh option: clock is nil")
+		}
+		m.clock = clock
+		return nil
+	}
+}
+
+//--------------------
+// CELL OPTIONS
+//--------------------
+
+// cellConfig contains the settings of a cell.
+type cellConfig struct {
+	capacity int
+	policy   OverflowPolicy
+	spillID  string
+	strategy SupervisionStrategy
+	restarts int
+	period   time.Duration
+	factory  BehaviorFactory
+}
+
+// CellOption defines the signature of a cell option setting function.
+type CellOption func(cfg *cellConfig) error
+
+// WithMailbox defines the capacity of the mailbox of a cell and the
+// policy used when it is full.
+func WithMailbox(capacity int, policy OverflowPolicy) CellOption {
+	return func(cfg *cellConfig) error {
+		if policy < OverflowBlock || policy > OverflowSpill {
+			return failure.New("invalid cell option: overflow policy %d", policy)
+		}
+		if capacity < 1 {
+			capacity = 1
+		}
+		cfg.capacity = capacity
+		cfg.policy = policy
+		return nil
+	}
+}
+
+// WithSpillCell defines the cell receiving the events which don't fit
+// into the mailbox when using the spill overflow policy. It has to be
+// spawned before. Without a spill cell those events are routed to the
+// dead-letter cell of the mesh.
+func WithSpillCell(id string) CellOption {
+	return func(cfg *cellConfig) error {
+		cfg.spillID = id
+		return nil
+	}
+}
+
+// WithSupervision defines how the cell reacts on panics of its
+// behavior.
+func WithSupervision(strategy SupervisionStrategy) CellOption {
+	return func(cfg *cellConfig) error {
+		if strategy < SuperviseRecover || strategy > SuperviseEscalate {
+			return failure.New("invalid cell option: supervision strategy %d", strategy)
+		}
+		cfg.strategy = strategy
+		return nil
+	}
+}
+
+// WithFactory defines the factory creating a fresh behavior when
+// the cell is restarted. Otherwise the existing behavior is terminated
+// and initialized again, keeping the state it doesn't reset in Init().
+func WithFactory(factory BehaviorFactory) CellOption {
+	return func(cfg *cellConfig) error {
+		if factory == nil {
+			return failure.New("invalid cell option: factory is nil")
+		}
+		cfg.factory = factory
+		return nil
+	}
+}
+
+// WithRestartIntensity limits the number of panics of the behavior
+// during the given period. If it is exceeded the cell is stopped.
+func WithRestartIntensity(restarts int, period time.Duration) CellOption {
+	return func(cfg *cellConfig) error {
+		if restarts < 1 || period <= 0 {
+			return failure.New("invalid cell option: restart intensity %d in %v", restarts, period)
+		}
+		cfg.restarts = restarts
+		cfg.period = period
+		return nil
+	}
+}
+
+// EOF
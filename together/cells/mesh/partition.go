@@ -0,0 +1,217 @@
+// Tideland Go Library - Together - Cells - Mesh
+//
+// Copyright (C) 2010-2019 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license
+
+package mesh // import "tideland.dev/go/together/cells/mesh"
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"fmt"
+	"hash/fnv"
+	"sort"
+
+	"tideland.dev/go/together/cells/event"
+	"tideland.dev/go/trace/failure"
+)
+
+//--------------------
+// CONSTANTS
+//--------------------
+
+// partitionReplicas is the number of points per partition on the
+// hash ring.
+const partitionReplicas = 64
+
+//--------------------
+// PARTITIONED CELLS
+//--------------------
+
+// BehaviorFactory creates the behavior for the partition with the
+// given ID. The behavior has to return this ID.
+type BehaviorFactory func(id string) Behavior
+
+// PartitionKey returns the key of an event used for the selection
+// of the partition processing it.
+type PartitionKey func(evt *event.Event) string
+
+// PayloadKey returns a partition key using the string value at the
+// given key of the event payload.
+func PayloadKey(key string) PartitionKey {
+	return func(evt *event.Event) string {
+		return evt.Payload().At(key).AsString("")
+	}
+}
+
+// PartitionID returns the ID of the nth partition of a partitioned cell.
+func PartitionID(id string, n int) string {
+	return fmt.Sprintf("%s#%d", id, n)
+}
+
+// SpawnPartitionedCell starts n cells running behaviors created by the
+// factory. They are addressed together by the given ID. Events emitted
+// to it are routed by the key of the event with consistent hashing, so
+// that all events with the same key are processed by the same partition
+// in their order. Subscribers of the partitioned cell receive the events
+// emitted by all partitions. The options are used for all cells. The
+// partitions are restarted with fresh behaviors of the factory.
+func (m *Mesh) SpawnPartitionedCell(
+	id string,
+	n int,
+	factory BehaviorFactory,
+	key PartitionKey,
+	options ...CellOption,
+) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.err != nil {
+		return m.err
+	}
+	if m.cells.contains(id) {
+		// No double deployment.
+		return nil
+	}
+	if n < 1 || factory == nil || key == nil {
+		return failure.New("cannot spawn partitioned cell %q: invalid arguments", id)
+	}
+	var partitionIDs []string
+	var partitions []*cell
+	partitionOptions := append(options[:len(options):len(options)], WithFactory(factory))
+	for i := 0; i < n; i++ {
+		pid := PartitionID(id, i)
+		behavior := factory(pid)
+		if behavior == nil || behavior.ID() != pid {
+			m.removePartitions(partitionIDs)
+			return failure.New("cannot spawn partitioned cell %q: invalid behavior for partition %q", id, pid)
+		}
+		if err := m.spawnCell(behavior, partitionOptions...); err != nil {
+			m.removePartitions(partitionIDs)
+			return err
+		}
+		partitionIDs = append(partitionIDs, pid)
+		partitions = append(partitions, m.cells[pid].cell)
+	}
+	router := &partitionRouter{
+		id:         id,
+		key:        key,
+		ring:       newHashRing(partitionIDs),
+		partitions: partitions,
+	}
+	if err := m.spawnCell(router, options...); err != nil {
+		m.removePartitions(partitionIDs)
+		return err
+	}
+	m.cells[id].partitions = partitionIDs
+	for _, pid := range partitionIDs {
+		m.cells[pid].partitionOf = id
+	}
+	return nil
+}
+
+// removePartitions stops and deregisters already spawned partitions
+// after a failure.
+func (m *Mesh) removePartitions(ids []string) {
+	for _, id := range ids {
+		m.deadLetter.unset(id)
+		m.cells.remove(id)
+	}
+}
+
+//--------------------
+// PARTITION ROUTER
+//--------------------
+
+// partitionRouter is the behavior of a partitioned cell routing the
+// events to the partitions.
+type partitionRouter struct {
+	id         string
+	key        PartitionKey
+	cell       *cell
+	ring       *hashRing
+	partitions []*cell
+}
+
+// ID implements Behavior.
+func (r *partitionRouter) ID() string {
+	return r.id
+}
+
+// Init implements Behavior.
+func (r *partitionRouter) Init(emitter Emitter) error {
+	r.cell = emitter.(*cell)
+	return nil
+}
+
+// Terminate implements Behavior.
+func (r *partitionRouter) Terminate() error {
+	return nil
+}
+
+// Process implements Behavior and routes the event.
+func (r *partitionRouter) Process(evt *event.Event) error {
+	return r.partitions[r.ring.lookup(r.key(evt))].process(evt, r.cell)
+}
+
+// Recover implements Behavior.
+func (r *partitionRouter) Recover(err interface{}) error {
+	return nil
+}
+
+//--------------------
+// HASH RING
+//--------------------
+
+// hashRing distributes keys consistently over a number of partitions.
+type hashRing struct {
+	points []uint32
+	owners map[uint32]int
+}
+
+// newHashRing creates a ring with virtual points for the given
+// partition IDs.
+func newHashRing(ids []string) *hashRing {
+	hr := &hashRing{
+		owners: map[uint32]int{},
+	}
+	for i, id := range ids {
+		for r := 0; r < partitionReplicas; r++ {
+			point := hash(fmt.Sprintf("%s/%d", id, r))
+			if _, ok := hr.owners[point]; ok {
+				// Keep the first owner of a collision.
+				continue
+			}
+			hr.owners[point] = i
+			hr.points = append(hr.points, point)
+		}
+	}
+	sort.Slice(hr.points, func(i, j int) bool {
+		return hr.points[i] < hr.points[j]
+	})
+	return hr
+}
+
+// lookup returns the index of the partition owning the key.
+func (hr *hashRing) lookup(key string) int {
+	h := hash(key)
+	i := sort.Search(len(hr.points), func(i int) bool {
+		return hr.points[i] >= h
+	})
+	if i == len(hr.points) {
+		i = 0
+	}
+	return hr.owners[hr.points[i]]
+}
+
+// hash returns the FNV-1a hash of the string.
+func hash(s string) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte(s))
+	return h.Sum32()
+}
+
+// EOF
@@ -0,0 +1,176 @@
+// Tideland Go Library - Together - Cells - Mesh - Unit Tests
+//
+// Copyright (C) 2010-2019 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license
+
+package mesh_test // import "tideland.dev/go/together/cells/mesh"
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"tideland.dev/go/audit/asserts"
+	"tideland.dev/go/together/cells/event"
+	"tideland.dev/go/together/cells/mesh"
+)
+
+//--------------------
+// TESTS
+//--------------------
+
+// TestPartitionedCell verifies the routing of events by their keys
+// to the partitions and the emitting to the subscribers.
+func TestPartitionedCell(t *testing.T) {
+	assert := asserts.NewTesting(t, asserts.FailStop)
+	rec := &partitionRecorder{}
+	drec := &drainRecorder{}
+	msh := mesh.New()
+	factory := func(id string) mesh.Behavior {
+		return NewPartitionBehavior(id, rec)
+	}
+
+	err := msh.SpawnPartitionedCell("counter", 4, factory, mesh.PayloadKey("key"))
+	assert.NoError(err)
+	assert.NoError(msh.SpawnCells(NewDrainBehavior("sink", drec, 0, nil)))
+	assert.NoError(msh.Subscribe("counter", "sink"))
+
+	ids := msh.Cells()
+	sort.Strings(ids)
+	assert.Equal(ids, []string{"counter", "sink"})
+	subscriberIDs, err := msh.Subscribers("counter")
+	assert.NoError(err)
+	assert.Equal(subscriberIDs, []string{"sink"})
+
+	keys := []string{"a", "b", "c", "d", "e", "f", "g", "h", "i", "j"}
+	for seq := 0; seq < 20; seq++ {
+		for _, key := range keys {
+			assert.NoError(msh.Emit("counter", event.New("count", "key", key, "seq", seq)))
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	assert.NoError(msh.Drain(ctx))
+
+	assert.Equal(drec.processed("sink"), 200)
+	partitions := map[string]bool{}
+	for _, key := range keys {
+		pids, seqs := rec.processed(key)
+		assert.Length(pids, 1, "key "+key+" processed by multiple partitions")
+		assert.Length(seqs, 20)
+		assert.True(sort.IntsAreSorted(seqs), "key "+key+" processed out of order")
+		for pid := range pids {
+			partitions[pid] = true
+		}
+	}
+	assert.True(len(partitions) > 1)
+}
+
+// TestPartitionedCellStop verifies the stopping of partitioned cells
+// and the handling of invalid arguments.
+func TestPartitionedCellStop(t *testing.T) {
+	assert := asserts.NewTesting(t, asserts.FailStop)
+	rec := &partitionRecorder{}
+	msh := mesh.New()
+	factory := func(id string) mesh.Behavior {
+		return NewPartitionBehavior(id, rec)
+	}
+
+	err := msh.SpawnPartitionedCell("counter", 0, factory, mesh.PayloadKey("key"))
+	assert.ErrorMatch(err, `.*cannot spawn partitioned cell "counter".*`)
+	err = msh.SpawnPartitionedCell("counter", 2, func(id string) mesh.Behavior {
+		return NewPartitionBehavior("other", rec)
+	}, mesh.PayloadKey("key"))
+	assert.ErrorMatch(err, `.*invalid behavior for partition "counter#0".*`)
+	assert.Length(msh.Stats(), 0)
+
+	err = msh.SpawnPartitionedCell("counter", 3, factory, mesh.PayloadKey("key"))
+	assert.NoError(err)
+	assert.Length(msh.Stats(), 4)
+
+	err = msh.StopCells(mesh.PartitionID("counter", 1))
+	assert.ErrorMatch(err, `.*cannot stop partition "counter#1" of cell "counter".*`)
+	assert.NoError(msh.StopCells("counter"))
+	assert.Length(msh.Stats(), 0)
+	assert.NoError(msh.Stop())
+}
+
+//--------------------
+// HELPERS
+//--------------------
+
+// partitionRecorder records the partitions and sequence numbers
+// of the processed keys.
+type partitionRecorder struct {
+	mu   sync.Mutex
+	pids map[string]map[string]bool
+	seqs map[string][]int
+}
+
+func (pr *partitionRecorder) process(pid, key string, seq int) {
+	pr.mu.Lock()
+	defer pr.mu.Unlock()
+	if pr.pids == nil {
+		pr.pids = map[string]map[string]bool{}
+		pr.seqs = map[string][]int{}
+	}
+	if pr.pids[key] == nil {
+		pr.pids[key] = map[string]bool{}
+	}
+	pr.pids[key][pid] = true
+	pr.seqs[key] = append(pr.seqs[key], seq)
+}
+
+func (pr *partitionRecorder) processed(key string) (map[string]bool, []int) {
+	pr.mu.Lock()
+	defer pr.mu.Unlock()
+	return pr.pids[key], append([]int{}, pr.seqs[key]...)
+}
+
+type PartitionBehavior struct {
+	id      string
+	emitter mesh.Emitter
+	rec     *partitionRecorder
+}
+
+func NewPartitionBehavior(id string, rec *partitionRecorder) *PartitionBehavior {
+	return &PartitionBehavior{
+		id:  id,
+		rec: rec,
+	}
+}
+
+func (pb *PartitionBehavior) ID() string {
+	return pb.id
+}
+
+func (pb *PartitionBehavior) Init(emitter mesh.Emitter) error {
+	pb.emitter = emitter
+	return nil
+}
+
+func (pb *PartitionBehavior) Terminate() error {
+	return nil
+}
+
+func (pb *PartitionBehavior) Process(evt *event.Event) error {
+	key := evt.Payload().At("key").AsString("")
+	seq := evt.Payload().At("seq").AsInt(-1)
+	pb.rec.process(pb.id, key, seq)
+	return pb.emitter.Broadcast(event.New("count", "key", key))
+}
+
+func (pb *PartitionBehavior) Recover(r interface{}) error {
+	return nil
+}
+
+// EOF
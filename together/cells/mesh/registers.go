@@ -43,10 +43,14 @@ func (sr subscribedRegistry) remove(id string) {
 }
 
 // cellEntry containes cells and the IDs of the cells it subscribed to.
+// Entries of partitioned cells know the IDs of their partitions, those
+// of partitions the ID of their partitioned cell.
 type cellEntry struct {
 	id           string
 	cell         *cell
 	subscribedTo subscribedRegistry
+	partitions   []string
+	partitionOf  string
 }
 
 // cellRegistry manages a number of cells and provides some convenience.
@@ -108,6 +112,11 @@ func (cr cellRegistry) subscribe(id string, subscriberIDs []string) error {
 	if err := entry.cell.subscribe(subscribers); err != nil {
 		return err
 	}
+	for _, partitionID := range entry.partitions {
+		if err := cr[partitionID].cell.subscribe(subscribers); err != nil {
+			return err
+		}
+	}
 	// Tell subscribers where they subscribed to.
 	for _, subscriberID := range subscriberIDs {
 		cr[subscriberID].subscribedTo.add(id)
@@ -135,6 +144,11 @@ func (cr cellRegistry) unsubscribe(id string, unsubscriberIDs []string) error {
 	if err := entry.cell.unsubscribe(unsubscribers); err != nil {
 		return err
 	}
+	for _, partitionID := range entry.partitions {
+		if err := cr[partitionID].cell.unsubscribe(unsubscribers); err != nil {
+			return err
+		}
+	}
 	// Tell unsubscribers that they aren't subscribed anymore.
 	for _, unsubscriberID := range unsubscriberIDs {
 		cr[unsubscriberID].subscribedTo.remove(id)
@@ -159,4 +173,12 @@ func (cr cellRegistry) unsubscribeFromAll(id string) error {
 	return nil
 }
 
+// forget removes the given cell from the cells it is subscribed to
+// after it has been stopped.
+func (cr cellRegistry) forget(id string) {
+	for _, entry := range cr {
+		entry.subscribedTo.remove(id)
+	}
+}
+
 // EOF
@@ -0,0 +1,93 @@
+// Tideland Go Library - Together - Cells - Mesh
+//
+// Copyright (C) 2010-2019 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license
+
+package mesh // import "tideland.dev/go/together/cells/mesh"
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"time"
+
+	"tideland.dev/go/together/cells/event"
+	"tideland.dev/go/trace/failure"
+)
+
+//--------------------
+// CONSTANTS
+//--------------------
+
+// replyErrorKey is the payload key of a reply containing
+// the error of the requested behavior.
+const replyErrorKey = "reply-error"
+
+//--------------------
+// ERROR HELPERS
+//--------------------
+
+// IsErrRequestTimeout checks if a request received no reply
+// until its timeout.
+func IsErrRequestTimeout(err error) bool {
+	return failure.Contains(err, "request timeout")
+}
+
+// IsErrRequestCanceled checks if the context of a request's
+// event has been canceled before receiving a reply.
+func IsErrRequestCanceled(err error) bool {
+	return failure.Contains(err, "request canceled")
+}
+
+// IsErrRequestFailed checks if the requested behavior replied
+// with an error or failed processing the request.
+func IsErrRequestFailed(err error) bool {
+	return failure.Contains(err, "request failed")
+}
+
+//--------------------
+// REQUEST / REPLY
+//--------------------
+
+// Request emits the event with a reply channel to the given cell
+// and waits for the reply. It ends with an error if the timeout
+// is reached, the event context is canceled, or the behavior
+// replied an error respectively returned an error processing
+// the event.
+func (m *Mesh) Request(id string, evt *event.Event, timeout time.Duration) (*event.Payload, error) {
+	revt, plc := evt.WithReply()
+	if err := m.Emit(id, revt); err != nil {
+		return nil, err
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case pl := <-plc:
+		if v := pl.At(replyErrorKey); !v.IsUndefined() {
+			return nil, failure.New("request failed at cell %q: %s", id, v.AsString("unknown error"))
+		}
+		return pl, nil
+	case <-evt.Context().Done():
+		return nil, failure.Annotate(evt.Context().Err(), "request canceled for cell %q", id)
+	case <-timer.C:
+		return nil, failure.New("request timeout for cell %q after %v", id, timeout)
+	}
+}
+
+// Reply answers a request with a payload created of the passed
+// keys and values. It's intended to be used by behaviors while
+// processing the event.
+func Reply(evt *event.Event, kvs ...interface{}) error {
+	return evt.Payload().Reply(event.NewPayload(kvs...))
+}
+
+// ReplyError answers a request with an error. The requester
+// receives it as failed request.
+func ReplyError(evt *event.Event, err error) error {
+	return evt.Payload().Reply(event.NewPayload(replyErrorKey, err.Error()))
+}
+
+// EOF
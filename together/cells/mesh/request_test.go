@@ -0,0 +1,137 @@
+// Tideland Go Library - Together - Cells - Mesh - Unit Tests
+//
+// Copyright (C) 2010-2019 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license
+
+package mesh_test // import "tideland.dev/go/together/cells/mesh"
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"tideland.dev/go/audit/asserts"
+	"tideland.dev/go/together/cells/event"
+	"tideland.dev/go/together/cells/mesh"
+)
+
+//--------------------
+// TESTS
+//--------------------
+
+// TestRequest verifies the request/reply of events.
+func TestRequest(t *testing.T) {
+	assert := asserts.NewTesting(t, asserts.FailStop)
+	msh := mesh.New()
+	defer msh.Stop()
+
+	err := msh.SpawnCells(
+		NewReplyBehavior("foo"),
+		NewTestBehavior("bar"),
+	)
+	assert.NoError(err)
+
+	pl, err := msh.Request("foo", event.New("double", "value", 21), waitTimeout)
+	assert.NoError(err)
+	assert.Equal(pl.At("value").AsInt(0), 42)
+
+	// Replies of behaviors not using the helper.
+	msh.Emit("bar", event.New("set", "a", 1))
+	pl, err = msh.Request("bar", event.New("send"), waitTimeout)
+	assert.NoError(err)
+	assert.Equal(pl.At("a").AsInt(0), 1)
+
+	// Unknown cell.
+	_, err = msh.Request("baz", event.New("double"), waitTimeout)
+	assert.ErrorMatch(err, ".*cannot find cell \"baz\".*")
+}
+
+// TestRequestErrors verifies the different errors of requests.
+func TestRequestErrors(t *testing.T) {
+	assert := asserts.NewTesting(t, asserts.FailStop)
+	msh := mesh.New()
+	defer msh.Stop()
+
+	err := msh.SpawnCells(
+		NewReplyBehavior("foo"),
+		NewTestBehavior("bar"),
+	)
+	assert.NoError(err)
+
+	// Replied error.
+	_, err = msh.Request("foo", event.New("invalid"), waitTimeout)
+	assert.ErrorMatch(err, ".*request failed at cell \"foo\": invalid value.*")
+	assert.True(mesh.IsErrRequestFailed(err))
+	assert.False(mesh.IsErrRequestTimeout(err))
+
+	// Returned error.
+	_, err = msh.Request("bar", event.New("fail"), waitTimeout)
+	assert.ErrorMatch(err, ".*request failed at cell \"bar\": .*failing on purpose.*")
+	assert.True(mesh.IsErrRequestFailed(err))
+
+	// No reply.
+	_, err = msh.Request("foo", event.New("ignore"), 50*time.Millisecond)
+	assert.ErrorMatch(err, ".*request timeout for cell \"foo\".*")
+	assert.True(mesh.IsErrRequestTimeout(err))
+	assert.False(mesh.IsErrRequestCanceled(err))
+
+	// Canceled context.
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		cancel()
+	}()
+	_, err = msh.Request("foo", event.WithContext(ctx, "ignore"), waitTimeout)
+	assert.ErrorMatch(err, ".*request canceled for cell \"foo\".*")
+	assert.True(mesh.IsErrRequestCanceled(err))
+	assert.False(mesh.IsErrRequestFailed(err))
+}
+
+//--------------------
+// HELPERS
+//--------------------
+
+type ReplyBehavior struct {
+	id string
+}
+
+func NewReplyBehavior(id string) *ReplyBehavior {
+	return &ReplyBehavior{
+		id: id,
+	}
+}
+
+func (rb *ReplyBehavior) ID() string {
+	return rb.id
+}
+
+func (rb *ReplyBehavior) Init(emitter mesh.Emitter) error {
+	return nil
+}
+
+func (rb *ReplyBehavior) Terminate() error {
+	return nil
+}
+
+func (rb *ReplyBehavior) Process(evt *event.Event) error {
+	switch evt.Topic() {
+	case "double":
+		return mesh.Reply(evt, "value", 2*evt.Payload().At("value").AsInt(0))
+	case "invalid":
+		return mesh.ReplyError(evt, errors.New("invalid value"))
+	}
+	return nil
+}
+
+func (rb *ReplyBehavior) Recover(r interface{}) error {
+	return nil
+}
+
+// EOF
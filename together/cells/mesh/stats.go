@@ -0,0 +1,168 @@
+// Tideland Go Library - Together - Cells - Mesh
+//
+// Copyright (C) 2010-2019 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license
+
+package mesh // import "tideland.dev/go/together/cells/mesh"
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"sync"
+	"time"
+)
+
+//--------------------
+// LATENCY HISTOGRAM
+//--------------------
+
+// LatencyBounds are the upper bounds of the buckets of the latency
+// histograms. Latencies above the last bound are counted in an
+// additional bucket.
+var LatencyBounds = []time.Duration{
+	100 * time.Microsecond,
+	time.Millisecond,
+	10 * time.Millisecond,
+	100 * time.Millisecond,
+	time.Second,
+}
+
+// LatencyHistogram contains the distribution of the processing
+// latencies of a cell.
+type LatencyHistogram struct {
+	Bounds []time.Duration
+	Counts []int
+	Total  time.Duration
+	Min    time.Duration
+	Max    time.Duration
+}
+
+// newLatencyHistogram creates an empty histogram based on
+// the latency bounds.
+func newLatencyHistogram() LatencyHistogram {
+	return LatencyHistogram{
+		Bounds: LatencyBounds,
+		Counts: make([]int, len(LatencyBounds)+1),
+	}
+}
+
+// Count returns the number of measured latencies.
+func (lh LatencyHistogram) Count() int {
+	count := 0
+	for _, c := range lh.Counts {
+		count += c
+	}
+	return count
+}
+
+// Mean returns the average latency.
+func (lh LatencyHistogram) Mean() time.Duration {
+	count := lh.Count()
+	if count == 0 {
+		return 0
+	}
+	return lh.Total / time.Duration(count)
+}
+
+// add sorts a latency into its bucket.
+func (lh *LatencyHistogram) add(latency time.Duration) {
+	idx := len(lh.Bounds)
+	for i, bound := range lh.Bounds {
+		if latency <= bound {
+			idx = i
+			break
+		}
+	}
+	if lh.Count() == 0 || latency < lh.Min {
+		lh.Min = latency
+	}
+	if latency > lh.Max {
+		lh.Max = latency
+	}
+	lh.Counts[idx]++
+	lh.Total += latency
+}
+
+// copy returns an independent copy of the histogram.
+func (lh LatencyHistogram) copy() LatencyHistogram {
+	clh := lh
+	clh.Counts = append([]int{}, lh.Counts...)
+	return clh
+}
+
+//--------------------
+// CELL STATS
+//--------------------
+
+// CellStats contains the statistics of one cell.
+type CellStats struct {
+	ID           string
+	Processed    int
+	Errors       int
+	Recovered    int
+	MailboxDepth int
+	Dropped      int
+	Latency      LatencyHistogram
+}
+
+// CellStatsList is a list of cell statistics sortable by ID.
+type CellStatsList []CellStats
+
+func (csl CellStatsList) Len() int           { return len(csl) }
+func (csl CellStatsList) Swap(i, j int)      { csl[i], csl[j] = csl[j], csl[i] }
+func (csl CellStatsList) Less(i, j int) bool { return csl[i].ID < csl[j].ID }
+
+// cellStats collects the statistics of a cell while it is running.
+type cellStats struct {
+	mu        sync.Mutex
+	processed int
+	errors    int
+	recovered int
+	latency   LatencyHistogram
+}
+
+// newCellStats creates empty statistics.
+func newCellStats() *cellStats {
+	return &cellStats{
+		latency: newLatencyHistogram(),
+	}
+}
+
+// process counts a processed event and its latency.
+func (cs *cellStats) process(latency time.Duration, err error) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	cs.processed++
+	if err != nil {
+		cs.errors++
+	}
+	cs.latency.add(latency)
+}
+
+// recover counts a recovering of the behavior.
+func (cs *cellStats) recover() {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	cs.recovered++
+}
+
+// read returns the current statistics of the cell.
+func (cs *cellStats) read(c *cell) CellStats {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	return CellStats{
+		ID:           c.id,
+		Processed:    cs.processed,
+		Errors:       cs.errors,
+		Recovered:    cs.recovered,
+		MailboxDepth: c.mailbox.len(),
+		Dropped:      c.mailbox.droppedEvents(),
+		Latency:      cs.latency.copy(),
+	}
+}
+
+// EOF
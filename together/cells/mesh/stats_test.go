@@ -0,0 +1,117 @@
+// Tideland Go Library - Together - Cells - Mesh - Unit Tests
+//
+// Copyright (C) 2010-2019 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license
+
+package mesh_test // import "tideland.dev/go/together/cells/mesh"
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"tideland.dev/go/audit/asserts"
+	"tideland.dev/go/together/cells/event"
+	"tideland.dev/go/together/cells/mesh"
+	"tideland.dev/go/trace/monitor"
+)
+
+//--------------------
+// TESTS
+//--------------------
+
+// TestStats verifies the collecting of cell statistics.
+func TestStats(t *testing.T) {
+	assert := asserts.NewTesting(t, asserts.FailStop)
+	mon := monitor.New()
+	defer mon.Stop()
+	msh := mesh.New(mesh.WithMonitor(mon))
+	defer msh.Stop()
+
+	err := msh.SpawnCells(
+		NewTestBehavior("foo"),
+		NewTestBehavior("bar"),
+	)
+	assert.NoError(err)
+
+	msh.Emit("foo", event.New("set", "a", 1))
+	msh.Emit("foo", event.New("fail"))
+	msh.Emit("foo", event.New("set", "b", 2))
+	waitEvents(assert, msh, "foo")
+
+	// Reply is sent before statistics are updated.
+	var csl mesh.CellStatsList
+	assert.Retry(func() bool {
+		csl = msh.Stats()
+		return csl[1].Processed == 4
+	}, 10, 10*time.Millisecond)
+	assert.Length(csl, 2)
+	assert.Equal(csl[0].ID, "bar")
+	assert.Equal(csl[0].Processed, 0)
+	assert.Equal(csl[0].Latency.Count(), 0)
+	assert.Equal(csl[1].ID, "foo")
+	assert.Equal(csl[1].Processed, 4)
+	assert.Equal(csl[1].Errors, 1)
+	assert.Equal(csl[1].Recovered, 1)
+	assert.Equal(csl[1].MailboxDepth, 0)
+	assert.Equal(csl[1].Dropped, 0)
+	assert.Equal(csl[1].Latency.Count(), 4)
+	assert.Length(csl[1].Latency.Counts, len(mesh.LatencyBounds)+1)
+	assert.True(csl[1].Latency.Min <= csl[1].Latency.Mean())
+	assert.True(csl[1].Latency.Mean() <= csl[1].Latency.Max)
+
+	// Monitor has been fed too.
+	wv, err := mon.StopWatch().Read("cell:foo")
+	assert.NoError(err)
+	assert.Equal(wv.Count, 4)
+	_, err = mon.StopWatch().Read("cell:bar")
+	assert.ErrorMatch(err, ".*does not exist.*")
+	_, err = mon.StaySetIndicator().Read("cell:foo")
+	assert.NoError(err)
+
+	// Invalid monitor.
+	msh = mesh.New(mesh.WithMonitor(nil))
+	err = msh.SpawnCells(NewTestBehavior("foo"))
+	assert.ErrorMatch(err, ".*invalid mesh option: monitor is nil.*")
+}
+
+// TestWriteDOT verifies the export of the subscription graph.
+func TestWriteDOT(t *testing.T) {
+	assert := asserts.NewTesting(t, asserts.FailStop)
+	msh := mesh.New(mesh.WithDeadLetterCell("dead"))
+	defer msh.Stop()
+
+	err := msh.SpawnCells(
+		NewTestBehavior("foo"),
+		NewTestBehavior("bar"),
+		NewTestBehavior("baz"),
+		NewTestBehavior("dead"),
+	)
+	assert.NoError(err)
+	err = msh.Subscribe("foo", "baz", "bar")
+	assert.NoError(err)
+	err = msh.Subscribe("bar", "baz")
+	assert.NoError(err)
+
+	var sb strings.Builder
+	err = msh.WriteDOT(&sb)
+	assert.NoError(err)
+	assert.Equal(sb.String(), `digraph mesh {
+	"bar";
+	"baz";
+	"dead" [style=dashed];
+	"foo";
+	"bar" -> "baz";
+	"foo" -> "bar";
+	"foo" -> "baz";
+}
+`)
+}
+
+// EOF
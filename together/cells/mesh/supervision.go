@@ -0,0 +1,296 @@
+// Tideland Go Library - Together - Cells - Mesh
+//
+// Copyright (C) 2010-2019 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license
+
+package mesh // import "tideland.dev/go/together/cells/mesh"
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"sync"
+	"time"
+
+	"tideland.dev/go/together/loop"
+	"tideland.dev/go/trace/failure"
+	"tideland.dev/go/trace/logger"
+)
+
+//--------------------
+// CONSTANTS
+//--------------------
+
+// SupervisionStrategy defines how a cell reacts on a panic of
+// its behavior.
+type SupervisionStrategy int
+
+// List of supervision strategies.
+const (
+	// SuperviseRecover lets the behavior recover itself and
+	// continues processing.
+	SuperviseRecover SupervisionStrategy = iota + 1
+
+	// SuperviseRestart terminates the behavior and initializes
+	// it again, or a fresh one if the cell has a factory.
+	SuperviseRestart
+
+	// SuperviseStop terminates the behavior and stops the cell.
+	SuperviseStop
+
+	// SuperviseEscalate lets the supervisor group of the cell
+	// decide. Cells without group are stopped.
+	SuperviseEscalate
+)
+
+// DefaultSupervisionStrategy is used by cells without explicit
+// supervision strategy.
+const DefaultSupervisionStrategy = SuperviseRecover
+
+// GroupStrategy defines how a supervisor group reacts on an
+// escalated panic of one of its cells.
+type GroupStrategy int
+
+// List of group strategies.
+const (
+	// OneForOne restarts only the panicking cell.
+	OneForOne GroupStrategy = iota + 1
+
+	// OneForAll restarts all cells of the group.
+	OneForAll
+)
+
+//--------------------
+// SUPERVISOR GROUP
+//--------------------
+
+// supervisorGroup contains cells restarted together.
+type supervisorGroup struct {
+	mu       sync.Mutex
+	strategy GroupStrategy
+	ids      []string
+	restarts int
+	period   time.Duration
+	reasons  loop.Reasons
+}
+
+// exceeded appends the reason and checks if the restart intensity
+// of the group is exceeded.
+func (g *supervisorGroup) exceeded(r interface{}) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.reasons = g.reasons.Append(r).Trim(g.restarts + 1)
+	return g.restarts > 0 && g.reasons.Frequency(g.restarts+1, g.period)
+}
+
+// supervisor manages the supervisor groups of a mesh.
+type supervisor struct {
+	mu     sync.RWMutex
+	groups map[string]*supervisorGroup
+}
+
+// add registers a new group for the given cells.
+func (s *supervisor) add(g *supervisorGroup) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.groups == nil {
+		s.groups = map[string]*supervisorGroup{}
+	}
+	for _, id := range g.ids {
+		if _, ok := s.groups[id]; ok {
+			return failure.New("cell %q is already supervised", id)
+		}
+	}
+	for _, id := range g.ids {
+		s.groups[id] = g
+	}
+	return nil
+}
+
+// group returns the group of the cell, or nil.
+func (s *supervisor) group(id string) *supervisorGroup {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.groups[id]
+}
+
+// remove removes a stopped cell from its group.
+func (s *supervisor) remove(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	g, ok := s.groups[id]
+	if !ok {
+		return
+	}
+	delete(s.groups, id)
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for i, gid := range g.ids {
+		if gid == id {
+			g.ids = append(g.ids[:i:i], g.ids[i+1:]...)
+			break
+		}
+	}
+}
+
+// cellIDs returns the IDs of the cells of the group.
+func (g *supervisorGroup) cellIDs() []string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return append([]string{}, g.ids...)
+}
+
+//--------------------
+// MESH SUPERVISION
+//--------------------
+
+// Supervise combines the given cells to a supervisor group. It handles
+// panics of cells with the supervision strategy SuperviseEscalate. In
+// case of OneForOne only the panicking cell is restarted, in case of
+// OneForAll all cells of the group. If more than the given number of
+// restarts happen during the period all cells of the group are stopped.
+// A number of zero restarts means no limit.
+func (m *Mesh) Supervise(strategy GroupStrategy, restarts int, period time.Duration, ids ...string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if strategy < OneForOne || strategy > OneForAll {
+		return failure.New("invalid group strategy %d", strategy)
+	}
+	for _, id := range ids {
+		if !m.cells.contains(id) {
+			return failure.New("cannot find cell %q", id)
+		}
+	}
+	return m.supervisor.add(&supervisorGroup{
+		strategy: strategy,
+		ids:      append([]string{}, ids...),
+		restarts: restarts,
+		period:   period,
+		reasons:  loop.MakeReasons(),
+	})
+}
+
+// escalate lets the group of the cell handle its panic. It is called
+// inside the backend of the cell, so the other cells are handled
+// asynchronously.
+func (m *Mesh) escalate(c *cell, r interface{}) {
+	g := m.supervisor.group(c.id)
+	if g == nil {
+		c.halt()
+		m.stopHalted(c.id)
+		return
+	}
+	if g.exceeded(r) {
+		c.halt()
+		m.stopHalted(g.cellIDs()...)
+		return
+	}
+	c.restart()
+	if g.strategy == OneForOne {
+		return
+	}
+	go func() {
+		m.mu.RLock()
+		defer m.mu.RUnlock()
+		for _, id := range g.cellIDs() {
+			entry, ok := m.cells[id]
+			if !ok || id == c.id {
+				continue
+			}
+			other := entry.cell
+			other.act.DoAsync(func() error {
+				other.restart()
+				return nil
+			})
+		}
+	}()
+}
+
+// stopHalted asynchronously stops the cells halted by the supervision.
+// A halted partition stops its whole partitioned cell, as its keys
+// cannot be routed to other partitions. Errors are logged.
+func (m *Mesh) stopHalted(ids ...string) {
+	go func() {
+		m.mu.RLock()
+		var sids []string
+		for _, id := range ids {
+			if entry, ok := m.cells[id]; ok && entry.partitionOf != "" {
+				id = entry.partitionOf
+			}
+			sids = append(sids, id)
+		}
+		m.mu.RUnlock()
+		if err := m.StopCells(sids...); err != nil {
+			logger.Errorf("cannot stop halted cells %v: %v", sids, err)
+		}
+	}()
+}
+
+//--------------------
+// CELL SUPERVISION
+//--------------------
+
+// supervise reacts on a panic of the behavior based on the
+// supervision strategy of the cell. It is called inside the
+// backend of the cell.
+func (c *cell) supervise(r interface{}) error {
+	c.stats.recover()
+	if c.restarts > 0 {
+		c.reasons = c.reasons.Append(r).Trim(c.restarts + 1)
+		if c.reasons.Frequency(c.restarts+1, c.period) {
+			// Too many panics, give up.
+			c.halt()
+			c.msh.stopHalted(c.id)
+			return nil
+		}
+	}
+	switch c.strategy {
+	case SuperviseRestart:
+		c.restart()
+	case SuperviseStop:
+		c.halt()
+		c.msh.stopHalted(c.id)
+	case SuperviseEscalate:
+		c.msh.escalate(c, r)
+	default:
+		return c.behavior.Recover(r)
+	}
+	return nil
+}
+
+// restart terminates the behavior and initializes it again. With a
+// factory a fresh behavior is created instead, as for partitions. If
+// this fails the cell is stopped, a partition together with its
+// partitioned cell.
+func (c *cell) restart() {
+	if _, ok := c.behavior.(*dummyBehavior); ok {
+		// Already halted.
+		return
+	}
+	c.behavior.Terminate()
+	behavior := c.behavior
+	if c.factory != nil {
+		behavior = c.factory(c.id)
+	}
+	if behavior == nil || behavior.ID() != c.id || behavior.Init(c) != nil {
+		c.behavior = &dummyBehavior{c.id}
+		c.msh.stopHalted(c.id)
+		return
+	}
+	c.behavior = behavior
+}
+
+// halt terminates the behavior before the cell is stopped. Until
+// then all events are routed to the dead-letter cell.
+func (c *cell) halt() {
+	if _, ok := c.behavior.(*dummyBehavior); ok {
+		return
+	}
+	c.behavior.Terminate()
+	c.behavior = &dummyBehavior{c.id}
+}
+
+// EOF
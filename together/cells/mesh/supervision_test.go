@@ -0,0 +1,304 @@
+// Tideland Go Library - Together - Cells - Mesh - Unit Tests
+//
+// Copyright (C) 2010-2019 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license
+
+package mesh_test // import "tideland.dev/go/together/cells/mesh"
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"testing"
+	"time"
+
+	"tideland.dev/go/audit/asserts"
+	"tideland.dev/go/together/cells/event"
+	"tideland.dev/go/together/cells/mesh"
+)
+
+//--------------------
+// TESTS
+//--------------------
+
+// TestSuperviseRecover verifies the default recovering of
+// panicking behaviors.
+func TestSuperviseRecover(t *testing.T) {
+	assert := asserts.NewTesting(t, asserts.FailStop)
+	msh := mesh.New()
+	defer msh.Stop()
+
+	err := msh.SpawnCells(NewPanicBehavior("foo"))
+	assert.NoError(err)
+
+	msh.Emit("foo", event.New("add", "value", 5))
+	msh.Emit("foo", event.New("panic"))
+	msh.Emit("foo", event.New("add", "value", 5))
+
+	inits, sum, recovers := panicState(assert, msh, "foo")
+	assert.Equal(inits, 1)
+	assert.Equal(sum, 10)
+	assert.Equal(recovers, 1)
+}
+
+// TestSuperviseRestart verifies the restarting of panicking behaviors.
+func TestSuperviseRestart(t *testing.T) {
+	assert := asserts.NewTesting(t, asserts.FailStop)
+	msh := mesh.New()
+	defer msh.Stop()
+
+	err := msh.SpawnCell(NewPanicBehavior("foo"), mesh.WithSupervision(mesh.SuperviseRestart))
+	assert.NoError(err)
+
+	msh.Emit("foo", event.New("add", "value", 5))
+	msh.Emit("foo", event.New("panic"))
+	msh.Emit("foo", event.New("add", "value", 3))
+
+	inits, sum, recovers := panicState(assert, msh, "foo")
+	assert.Equal(inits, 2)
+	assert.Equal(sum, 3)
+	assert.Equal(recovers, 0)
+}
+
+// TestSuperviseRestartFactory verifies the restarting of panicking
+// behaviors with fresh ones created by a factory.
+func TestSuperviseRestartFactory(t *testing.T) {
+	assert := asserts.NewTesting(t, asserts.FailStop)
+	msh := mesh.New()
+	defer msh.Stop()
+	factory := func(id string) mesh.Behavior {
+		return NewPanicBehavior(id)
+	}
+
+	err := msh.SpawnCell(NewPanicBehavior("foo"),
+		mesh.WithSupervision(mesh.SuperviseRestart),
+		mesh.WithFactory(factory),
+	)
+	assert.NoError(err)
+
+	msh.Emit("foo", event.New("add", "value", 5))
+	msh.Emit("foo", event.New("panic"))
+	msh.Emit("foo", event.New("add", "value", 3))
+
+	// State of the first instance has been dropped.
+	inits, sum, recovers := panicState(assert, msh, "foo")
+	assert.Equal(inits, 1)
+	assert.Equal(sum, 3)
+	assert.Equal(recovers, 0)
+
+	// Invalid behaviors of the factory stop the cell.
+	err = msh.SpawnCell(NewPanicBehavior("bar"),
+		mesh.WithSupervision(mesh.SuperviseRestart),
+		mesh.WithFactory(func(id string) mesh.Behavior { return NewPanicBehavior("baz") }),
+	)
+	assert.NoError(err)
+	msh.Emit("bar", event.New("panic"))
+	waitStopped(assert, msh, "bar")
+
+	err = msh.SpawnCell(NewPanicBehavior("bar"), mesh.WithFactory(nil))
+	assert.ErrorMatch(err, ".*invalid cell option: factory is nil.*")
+}
+
+// TestSuperviseStop verifies the stopping of panicking behaviors.
+func TestSuperviseStop(t *testing.T) {
+	assert := asserts.NewTesting(t, asserts.FailStop)
+	msh := mesh.New()
+	defer msh.Stop()
+
+	err := msh.SpawnCells(NewPanicBehavior("bar"))
+	assert.NoError(err)
+	err = msh.SpawnCell(NewPanicBehavior("foo"), mesh.WithSupervision(mesh.SuperviseStop))
+	assert.NoError(err)
+
+	msh.Emit("foo", event.New("panic"))
+	waitStopped(assert, msh, "foo")
+
+	// Escalation without group.
+	err = msh.SpawnCell(NewPanicBehavior("foo"), mesh.WithSupervision(mesh.SuperviseEscalate))
+	assert.NoError(err)
+	msh.Emit("foo", event.New("panic"))
+	waitStopped(assert, msh, "foo")
+
+	assert.Equal(msh.Cells(), []string{"bar"})
+}
+
+// TestSuperviseIntensity verifies the stopping of cells restarted
+// too often.
+func TestSuperviseIntensity(t *testing.T) {
+	assert := asserts.NewTesting(t, asserts.FailStop)
+	msh := mesh.New()
+	defer msh.Stop()
+
+	err := msh.SpawnCell(NewPanicBehavior("foo"),
+		mesh.WithSupervision(mesh.SuperviseRestart),
+		mesh.WithRestartIntensity(2, time.Minute),
+	)
+	assert.NoError(err)
+
+	msh.Emit("foo", event.New("panic"))
+	msh.Emit("foo", event.New("panic"))
+	inits, _, _ := panicState(assert, msh, "foo")
+	assert.Equal(inits, 3)
+
+	msh.Emit("foo", event.New("panic"))
+	waitStopped(assert, msh, "foo")
+
+	// Invalid options.
+	err = msh.SpawnCell(NewPanicBehavior("foo"), mesh.WithSupervision(mesh.SupervisionStrategy(0)))
+	assert.ErrorMatch(err, ".*invalid cell option: supervision strategy 0.*")
+	err = msh.SpawnCell(NewPanicBehavior("foo"), mesh.WithRestartIntensity(0, time.Minute))
+	assert.ErrorMatch(err, ".*invalid cell option: restart intensity 0 in 1m0s.*")
+}
+
+// TestSuperviseGroups verifies the supervisor groups.
+func TestSuperviseGroups(t *testing.T) {
+	assert := asserts.NewTesting(t, asserts.FailStop)
+	msh := mesh.New()
+	defer msh.Stop()
+
+	for _, id := range []string{"a", "b", "c", "d"} {
+		err := msh.SpawnCell(NewPanicBehavior(id), mesh.WithSupervision(mesh.SuperviseEscalate))
+		assert.NoError(err)
+	}
+	err := msh.Supervise(mesh.OneForOne, 0, 0, "a", "b")
+	assert.NoError(err)
+	err = msh.Supervise(mesh.OneForAll, 2, time.Minute, "c", "d")
+	assert.NoError(err)
+
+	// One for one.
+	msh.Emit("a", event.New("panic"))
+	inits, _, _ := panicState(assert, msh, "a")
+	assert.Equal(inits, 2)
+	inits, _, _ = panicState(assert, msh, "b")
+	assert.Equal(inits, 1)
+
+	// One for all.
+	msh.Emit("c", event.New("panic"))
+	inits, _, _ = panicState(assert, msh, "c")
+	assert.Equal(inits, 2)
+	assert.Retry(func() bool {
+		inits, _, _ = panicState(assert, msh, "d")
+		return inits == 2
+	}, 10, 10*time.Millisecond)
+
+	// Group intensity exceeded.
+	msh.Emit("d", event.New("panic"))
+	msh.Emit("c", event.New("panic"))
+	waitStopped(assert, msh, "c")
+	waitStopped(assert, msh, "d")
+
+	// Invalid groups.
+	err = msh.Supervise(mesh.OneForAll, 0, 0, "a", "x")
+	assert.ErrorMatch(err, ".*cannot find cell \"x\".*")
+	err = msh.Supervise(mesh.OneForAll, 0, 0, "a")
+	assert.ErrorMatch(err, ".*cell \"a\" is already supervised.*")
+	err = msh.Supervise(mesh.GroupStrategy(0), 0, 0, "a")
+	assert.ErrorMatch(err, ".*invalid group strategy 0.*")
+}
+
+// TestSupervisePartitions verifies the restarting of partitions with
+// fresh behaviors and the stopping of their partitioned cell.
+func TestSupervisePartitions(t *testing.T) {
+	assert := asserts.NewTesting(t, asserts.FailStop)
+	msh := mesh.New()
+	defer msh.Stop()
+	factory := func(id string) mesh.Behavior {
+		return NewPanicBehavior(id)
+	}
+
+	err := msh.SpawnPartitionedCell("restarter", 2, factory, mesh.PayloadKey("key"),
+		mesh.WithSupervision(mesh.SuperviseRestart),
+	)
+	assert.NoError(err)
+	msh.Emit("restarter", event.New("add", "value", 5))
+	msh.Emit("restarter", event.New("panic"))
+	msh.Emit("restarter", event.New("add", "value", 3))
+
+	inits, sum, recovers := panicState(assert, msh, "restarter")
+	assert.Equal(inits, 1)
+	assert.Equal(sum, 3)
+	assert.Equal(recovers, 0)
+
+	for _, strategy := range []mesh.SupervisionStrategy{mesh.SuperviseStop, mesh.SuperviseEscalate} {
+		err = msh.SpawnPartitionedCell("stopper", 2, factory, mesh.PayloadKey("key"),
+			mesh.WithSupervision(strategy),
+		)
+		assert.NoError(err)
+		msh.Emit("stopper", event.New("panic"))
+		waitStopped(assert, msh, "stopper")
+
+		err = msh.Emit("stopper", event.New("add", "value", 1))
+		assert.ErrorMatch(err, ".*cannot find cell \"stopper\".*")
+	}
+}
+
+//--------------------
+// HELPERS
+//--------------------
+
+func panicState(assert *asserts.Asserts, msh *mesh.Mesh, id string) (int, int, int) {
+	pl, err := msh.Request(id, event.New("state"), waitTimeout)
+	assert.NoError(err)
+	return pl.At("inits").AsInt(0), pl.At("sum").AsInt(0), pl.At("recovers").AsInt(0)
+}
+
+func waitStopped(assert *asserts.Asserts, msh *mesh.Mesh, id string) {
+	assert.Retry(func() bool {
+		for _, cid := range msh.Cells() {
+			if cid == id {
+				return false
+			}
+		}
+		return true
+	}, 10, 10*time.Millisecond)
+}
+
+type PanicBehavior struct {
+	id       string
+	inits    int
+	sum      int
+	recovers int
+}
+
+func NewPanicBehavior(id string) *PanicBehavior {
+	return &PanicBehavior{
+		id: id,
+	}
+}
+
+func (pb *PanicBehavior) ID() string {
+	return pb.id
+}
+
+func (pb *PanicBehavior) Init(emitter mesh.Emitter) error {
+	pb.inits++
+	pb.sum = 0
+	pb.recovers = 0
+	return nil
+}
+
+func (pb *PanicBehavior) Terminate() error {
+	return nil
+}
+
+func (pb *PanicBehavior) Process(evt *event.Event) error {
+	switch evt.Topic() {
+	case "add":
+		pb.sum += evt.Payload().At("value").AsInt(0)
+	case "panic":
+		panic("panic on purpose")
+	case "state":
+		return mesh.Reply(evt, "inits", pb.inits, "sum", pb.sum, "recovers", pb.recovers)
+	}
+	return nil
+}
+
+func (pb *PanicBehavior) Recover(r interface{}) error {
+	pb.recovers++
+	return nil
+}
+
+// EOF
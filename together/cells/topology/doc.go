@@ -0,0 +1,52 @@
+// Tideland Go Library - Together - Cells - Topology
+//
+// Copyright (C) 2010-2019 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license.
+
+// Package topology allows to declare the cells of a mesh, their
+// behaviors, and their subscriptions in an etc configuration instead
+// of wiring them by code. A topology looks like
+//
+//     {etc
+//         {mesh
+//             {cells
+//                 {counter
+//                     {behavior counter}
+//                     {subscribers collector logger}
+//                 }
+//                 {collector
+//                     {behavior collector}
+//                     {params {max 100}}
+//                 }
+//                 {logger
+//                     {behavior logger}
+//                 }
+//             }
+//         }
+//     }
+//
+// The behaviors are created by factories registered for their kind.
+// Each factory gets the cell ID and the optional parameters as own
+// configuration.
+//
+//     reg := topology.NewRegistry()
+//     reg.Register("collector", func(id string, params *etc.Etc) (mesh.Behavior, error) {
+//         max := params.ValueAsInt("max", 10)
+//         return behaviors.NewCollectorBehavior(id, max, process), nil
+//     })
+//     ...
+//     tpl, err := topology.Read(cfg, "mesh")
+//     err = tpl.Build(msh, reg)
+//
+// When the configuration changes the differences between the old and the
+// new topology can be applied to the running mesh. New cells are spawned,
+// removed cells are stopped, cells with changed behaviors or parameters
+// are replaced, and the subscriptions are rewired.
+//
+//     changes := topology.Diff(tpl, newTpl)
+//     err = changes.Apply(msh, reg)
+package topology // import "tideland.dev/go/together/cells/topology"
+
+// EOF
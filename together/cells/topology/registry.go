@@ -0,0 +1,77 @@
+// Tideland Go Library - Together - Cells - Topology
+//
+// Copyright (C) 2010-2019 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license.
+
+package topology // import "tideland.dev/go/together/cells/topology"
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"sync"
+
+	"tideland.dev/go/text/etc"
+	"tideland.dev/go/together/cells/mesh"
+	"tideland.dev/go/trace/failure"
+)
+
+//--------------------
+// REGISTRY
+//--------------------
+
+// BehaviorFactory creates a behavior with the given ID. The passed
+// parameters are the configuration below the params node of the cell,
+// it is empty if the cell has none.
+type BehaviorFactory func(id string, params *etc.Etc) (mesh.Behavior, error)
+
+// Registry manages the behavior factories by their kind.
+type Registry struct {
+	mu        sync.RWMutex
+	factories map[string]BehaviorFactory
+}
+
+// NewRegistry creates an empty registry for behavior factories.
+func NewRegistry() *Registry {
+	return &Registry{
+		factories: map[string]BehaviorFactory{},
+	}
+}
+
+// Register adds a behavior factory for the given kind.
+func (r *Registry) Register(kind string, factory BehaviorFactory) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if factory == nil {
+		return failure.New("factory for behavior kind %q is nil", kind)
+	}
+	if _, ok := r.factories[kind]; ok {
+		return failure.New("behavior kind %q already registered", kind)
+	}
+	r.factories[kind] = factory
+	return nil
+}
+
+// create lets the factory of the cell definitions behavior kind
+// create the behavior.
+func (r *Registry) create(cd *CellDefinition) (mesh.Behavior, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	factory, ok := r.factories[cd.Behavior]
+	if !ok {
+		return nil, failure.New("behavior kind %q of cell %q not registered", cd.Behavior, cd.ID)
+	}
+	behavior, err := factory(cd.ID, cd.params)
+	if err != nil {
+		return nil, failure.Annotate(err, "cannot create behavior of cell %q", cd.ID)
+	}
+	if behavior.ID() != cd.ID {
+		return nil, failure.New("behavior of cell %q has different ID %q", cd.ID, behavior.ID())
+	}
+	return behavior, nil
+}
+
+// EOF
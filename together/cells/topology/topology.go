@@ -0,0 +1,262 @@
+// Tideland Go Library - Together - Cells - Topology
+//
+// Copyright (C) 2010-2019 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license.
+
+package topology // import "tideland.dev/go/together/cells/topology"
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"path"
+	"reflect"
+	"sort"
+	"strings"
+
+	"tideland.dev/go/text/etc"
+	"tideland.dev/go/together/cells/mesh"
+	"tideland.dev/go/trace/failure"
+)
+
+//--------------------
+// CELL DEFINITION
+//--------------------
+
+// CellDefinition describes one cell of the topology.
+type CellDefinition struct {
+	ID          string
+	Behavior    string
+	Subscribers []string
+	params      *etc.Etc
+	dump        etc.Application
+}
+
+// Params returns the configured parameters of the cell.
+func (cd *CellDefinition) Params() *etc.Etc {
+	return cd.params
+}
+
+// sameBehavior checks if both definitions describe the same
+// behavior with the same parameters.
+func (cd *CellDefinition) sameBehavior(ocd *CellDefinition) bool {
+	return cd.Behavior == ocd.Behavior && reflect.DeepEqual(cd.dump, ocd.dump)
+}
+
+// subscribes checks if the cell has the given subscriber.
+func (cd *CellDefinition) subscribes(id string) bool {
+	for _, subscriberID := range cd.Subscribers {
+		if subscriberID == id {
+			return true
+		}
+	}
+	return false
+}
+
+//--------------------
+// TOPOLOGY
+//--------------------
+
+// Topology describes the cells of a mesh and their subscriptions.
+type Topology struct {
+	cells map[string]*CellDefinition
+}
+
+// Read reads the topology below the given path of the configuration.
+// All nodes below "<path>/cells" are cell definitions with the cell
+// ID as name, the behavior kind, and optionally the space separated
+// subscriber IDs and the parameters of the behavior.
+func Read(cfg *etc.Etc, p string) (*Topology, error) {
+	t := &Topology{
+		cells: map[string]*CellDefinition{},
+	}
+	cellsPath := path.Join(p, "cells")
+	if !cfg.HasPath(cellsPath) {
+		return t, nil
+	}
+	if err := cfg.Do(cellsPath, func(cellPath string) error {
+		cd, err := readCellDefinition(cfg, cellPath)
+		if err != nil {
+			return err
+		}
+		t.cells[cd.ID] = cd
+		return nil
+	}); err != nil {
+		return nil, failure.Annotate(err, "cannot read topology at %q", p)
+	}
+	// Validate subscribers.
+	for _, cd := range t.cells {
+		for _, subscriberID := range cd.Subscribers {
+			if _, ok := t.cells[subscriberID]; !ok {
+				return nil, failure.New("cell %q has unknown subscriber %q", cd.ID, subscriberID)
+			}
+		}
+	}
+	return t, nil
+}
+
+// CellIDs returns the sorted IDs of the defined cells.
+func (t *Topology) CellIDs() []string {
+	var ids []string
+	for id := range t.cells {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// Cell returns the definition of the given cell.
+func (t *Topology) Cell(id string) (*CellDefinition, bool) {
+	cd, ok := t.cells[id]
+	return cd, ok
+}
+
+// Build spawns the cells of the topology in the mesh and subscribes
+// them. The behaviors are created by the factories of the registry.
+func (t *Topology) Build(msh *mesh.Mesh, reg *Registry) error {
+	return Diff(nil, t).Apply(msh, reg)
+}
+
+// readCellDefinition reads the definition of one cell.
+func readCellDefinition(cfg *etc.Etc, cellPath string) (*CellDefinition, error) {
+	cd := &CellDefinition{
+		ID:       path.Base(cellPath),
+		Behavior: cfg.ValueAsString(path.Join(cellPath, "behavior"), ""),
+	}
+	if cd.Behavior == "" {
+		return nil, failure.New("cell %q has no behavior", cd.ID)
+	}
+	subscriberIDs := strings.Fields(cfg.ValueAsString(path.Join(cellPath, "subscribers"), ""))
+	for _, subscriberID := range subscriberIDs {
+		if !cd.subscribes(subscriberID) {
+			cd.Subscribers = append(cd.Subscribers, subscriberID)
+		}
+	}
+	params, err := cfg.Split(path.Join(cellPath, "params"))
+	if err != nil {
+		return nil, failure.Annotate(err, "cannot read parameters of cell %q", cd.ID)
+	}
+	dump, err := params.Dump()
+	if err != nil {
+		return nil, failure.Annotate(err, "cannot read parameters of cell %q", cd.ID)
+	}
+	cd.params = params
+	cd.dump = dump
+	return cd, nil
+}
+
+//--------------------
+// CHANGES
+//--------------------
+
+// Changes contains the differences between two topologies. Cells
+// with changed behaviors or parameters are stopped and spawned again.
+type Changes struct {
+	Stop        []string
+	Spawn       []string
+	Unsubscribe map[string][]string
+	Subscribe   map[string][]string
+	to          *Topology
+}
+
+// Diff compares two topologies and returns the changes needed to
+// get from the first to the second one. A nil topology is handled
+// like an empty one.
+func Diff(from, to *Topology) *Changes {
+	if from == nil {
+		from = &Topology{}
+	}
+	if to == nil {
+		to = &Topology{}
+	}
+	c := &Changes{
+		Unsubscribe: map[string][]string{},
+		Subscribe:   map[string][]string{},
+		to:          to,
+	}
+	stopped := map[string]bool{}
+	spawned := map[string]bool{}
+	// Cells to stop and to spawn.
+	for _, id := range from.CellIDs() {
+		tcd, ok := to.cells[id]
+		if !ok || !from.cells[id].sameBehavior(tcd) {
+			c.Stop = append(c.Stop, id)
+			stopped[id] = true
+		}
+	}
+	for _, id := range to.CellIDs() {
+		fcd, ok := from.cells[id]
+		if !ok || !fcd.sameBehavior(to.cells[id]) {
+			c.Spawn = append(c.Spawn, id)
+			spawned[id] = true
+		}
+	}
+	// Subscriptions to remove between remaining cells.
+	for _, id := range from.CellIDs() {
+		if stopped[id] {
+			continue
+		}
+		for _, subscriberID := range from.cells[id].Subscribers {
+			if stopped[subscriberID] || to.cells[id].subscribes(subscriberID) {
+				continue
+			}
+			c.Unsubscribe[id] = append(c.Unsubscribe[id], subscriberID)
+		}
+	}
+	// Subscriptions to add, all for spawned cells.
+	for _, id := range to.CellIDs() {
+		for _, subscriberID := range to.cells[id].Subscribers {
+			if !spawned[id] && !spawned[subscriberID] && from.cells[id].subscribes(subscriberID) {
+				continue
+			}
+			c.Subscribe[id] = append(c.Subscribe[id], subscriberID)
+		}
+	}
+	for _, ids := range c.Unsubscribe {
+		sort.Strings(ids)
+	}
+	for _, ids := range c.Subscribe {
+		sort.Strings(ids)
+	}
+	return c
+}
+
+// IsEmpty returns true if there are no changes.
+func (c *Changes) IsEmpty() bool {
+	return len(c.Stop) == 0 && len(c.Spawn) == 0 && len(c.Unsubscribe) == 0 && len(c.Subscribe) == 0
+}
+
+// Apply performs the changes on the mesh. All behaviors are created
+// before the mesh is touched, so failing factories leave it unchanged.
+func (c *Changes) Apply(msh *mesh.Mesh, reg *Registry) error {
+	var behaviors []mesh.Behavior
+	for _, id := range c.Spawn {
+		behavior, err := reg.create(c.to.cells[id])
+		if err != nil {
+			return err
+		}
+		behaviors = append(behaviors, behavior)
+	}
+	for id, subscriberIDs := range c.Unsubscribe {
+		if err := msh.Unsubscribe(id, subscriberIDs...); err != nil {
+			return failure.Annotate(err, "cannot apply topology changes")
+		}
+	}
+	if err := msh.StopCells(c.Stop...); err != nil {
+		return failure.Annotate(err, "cannot apply topology changes")
+	}
+	if err := msh.SpawnCells(behaviors...); err != nil {
+		return failure.Annotate(err, "cannot apply topology changes")
+	}
+	for id, subscriberIDs := range c.Subscribe {
+		if err := msh.Subscribe(id, subscriberIDs...); err != nil {
+			return failure.Annotate(err, "cannot apply topology changes")
+		}
+	}
+	return nil
+}
+
+// EOF
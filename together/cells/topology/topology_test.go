@@ -0,0 +1,254 @@
+// Tideland Go Library - Together - Cells - Topology - Unit Tests
+//
+// Copyright (C) 2010-2019 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license.
+
+package topology_test // import "tideland.dev/go/together/cells/topology"
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"testing"
+	"time"
+
+	"tideland.dev/go/audit/asserts"
+	"tideland.dev/go/text/etc"
+	"tideland.dev/go/together/cells/behaviors"
+	"tideland.dev/go/together/cells/event"
+	"tideland.dev/go/together/cells/mesh"
+	"tideland.dev/go/together/cells/topology"
+)
+
+//--------------------
+// CONSTANTS
+//--------------------
+
+const (
+	sourceA = `{etc {mesh {cells
+		{counter {behavior counter}{subscribers collector logger}}
+		{collector {behavior collector}{params {max 10}}}
+		{logger {behavior broadcaster}}
+	}}}`
+	sourceB = `{etc {mesh {cells
+		{counter {behavior counter}{subscribers collector ticker}}
+		{collector {behavior collector}{params {max 20}}}
+		{ticker {behavior ticker}{params {interval 1h}}}
+	}}}`
+)
+
+//--------------------
+// TESTS
+//--------------------
+
+// TestRead verifies the reading of topologies.
+func TestRead(t *testing.T) {
+	assert := asserts.NewTesting(t, asserts.FailStop)
+
+	tpl := readTopology(assert, sourceA)
+	assert.Equal(tpl.CellIDs(), []string{"collector", "counter", "logger"})
+
+	cd, ok := tpl.Cell("counter")
+	assert.True(ok)
+	assert.Equal(cd.Behavior, "counter")
+	assert.Equal(cd.Subscribers, []string{"collector", "logger"})
+
+	cd, ok = tpl.Cell("collector")
+	assert.True(ok)
+	assert.Equal(cd.Params().ValueAsInt("max", 0), 10)
+
+	_, ok = tpl.Cell("ticker")
+	assert.False(ok)
+
+	// Empty and invalid topologies.
+	tpl = readTopology(assert, `{etc {mesh}}`)
+	assert.Empty(tpl.CellIDs())
+
+	cfg, err := etc.ReadString(`{etc {mesh {cells {foo {subscribers bar}}}}}`)
+	assert.NoError(err)
+	_, err = topology.Read(cfg, "mesh")
+	assert.ErrorMatch(err, `.*cell "foo" has no behavior.*`)
+
+	cfg, err = etc.ReadString(`{etc {mesh {cells {foo {behavior foo}{subscribers bar}}}}}`)
+	assert.NoError(err)
+	_, err = topology.Read(cfg, "mesh")
+	assert.ErrorMatch(err, `.*cell "foo" has unknown subscriber "bar".*`)
+}
+
+// TestBuild verifies the building of a mesh based on a topology.
+func TestBuild(t *testing.T) {
+	assert := asserts.NewTesting(t, asserts.FailStop)
+	sigc := asserts.MakeWaitChan()
+	reg := newRegistry(assert, sigc)
+	msh := mesh.New()
+	defer msh.Stop()
+
+	tpl := readTopology(assert, sourceA)
+	err := tpl.Build(msh, reg)
+	assert.NoError(err)
+
+	assert.Length(msh.Cells(), 3)
+	subscriberIDs, err := msh.Subscribers("counter")
+	assert.NoError(err)
+	assert.Length(subscriberIDs, 2)
+	assert.Contains(subscriberIDs, "collector")
+	assert.Contains(subscriberIDs, "logger")
+
+	msh.Emit("counter", event.New("count"))
+	waitCollected(assert, msh, sigc, 1)
+
+	// Unknown behavior kind.
+	cfg, err := etc.ReadString(`{etc {mesh {cells {foo {behavior foo}}}}}`)
+	assert.NoError(err)
+	tpl, err = topology.Read(cfg, "mesh")
+	assert.NoError(err)
+	err = tpl.Build(msh, reg)
+	assert.ErrorMatch(err, `.*behavior kind "foo" of cell "foo" not registered.*`)
+}
+
+// TestDiffApply verifies the computing of changes between two
+// topologies and applying them to a running mesh.
+func TestDiffApply(t *testing.T) {
+	assert := asserts.NewTesting(t, asserts.FailStop)
+	sigc := asserts.MakeWaitChan()
+	reg := newRegistry(assert, sigc)
+	msh := mesh.New()
+	defer msh.Stop()
+
+	tplA := readTopology(assert, sourceA)
+	tplB := readTopology(assert, sourceB)
+
+	changes := topology.Diff(tplA, tplA)
+	assert.True(changes.IsEmpty())
+
+	changes = topology.Diff(tplA, tplB)
+	assert.False(changes.IsEmpty())
+	assert.Equal(changes.Stop, []string{"collector", "logger"})
+	assert.Equal(changes.Spawn, []string{"collector", "ticker"})
+	assert.Empty(changes.Unsubscribe)
+	assert.Equal(changes.Subscribe, map[string][]string{
+		"counter": {"collector", "ticker"},
+	})
+
+	err := tplA.Build(msh, reg)
+	assert.NoError(err)
+	err = changes.Apply(msh, reg)
+	assert.NoError(err)
+
+	ids := msh.Cells()
+	assert.Length(ids, 3)
+	assert.Contains(ids, "counter")
+	assert.Contains(ids, "collector")
+	assert.Contains(ids, "ticker")
+	subscriberIDs, err := msh.Subscribers("counter")
+	assert.NoError(err)
+	assert.Length(subscriberIDs, 2)
+	assert.Contains(subscriberIDs, "collector")
+	assert.Contains(subscriberIDs, "ticker")
+
+	msh.Emit("counter", event.New("count"))
+	waitCollected(assert, msh, sigc, 1)
+
+	// Only drop a subscription.
+	tplC := readTopology(assert, `{etc {mesh {cells
+		{counter {behavior counter}{subscribers collector}}
+		{collector {behavior collector}{params {max 20}}}
+		{ticker {behavior ticker}{params {interval 1h}}}
+	}}}`)
+	changes = topology.Diff(tplB, tplC)
+	assert.Empty(changes.Stop)
+	assert.Empty(changes.Spawn)
+	assert.Equal(changes.Unsubscribe, map[string][]string{
+		"counter": {"ticker"},
+	})
+	assert.Empty(changes.Subscribe)
+
+	err = changes.Apply(msh, reg)
+	assert.NoError(err)
+	subscriberIDs, err = msh.Subscribers("counter")
+	assert.NoError(err)
+	assert.Equal(subscriberIDs, []string{"collector"})
+}
+
+// TestDiffApplyRemove verifies the removal of connected cells where
+// the publisher is stopped before its subscriber.
+func TestDiffApplyRemove(t *testing.T) {
+	assert := asserts.NewTesting(t, asserts.FailStop)
+	sigc := asserts.MakeWaitChan()
+	reg := newRegistry(assert, sigc)
+	msh := mesh.New()
+	defer msh.Stop()
+
+	tplA := readTopology(assert, `{etc {mesh {cells
+		{a {behavior counter}{subscribers b}}
+		{b {behavior collector}{params {max 10}}}
+		{logger {behavior broadcaster}}
+	}}}`)
+	tplB := readTopology(assert, `{etc {mesh {cells
+		{logger {behavior broadcaster}}
+	}}}`)
+	err := tplA.Build(msh, reg)
+	assert.NoError(err)
+
+	changes := topology.Diff(tplA, tplB)
+	assert.Equal(changes.Stop, []string{"a", "b"})
+	err = changes.Apply(msh, reg)
+	assert.NoError(err)
+	assert.Equal(msh.Cells(), []string{"logger"})
+}
+
+//--------------------
+// HELPERS
+//--------------------
+
+func readTopology(assert *asserts.Asserts, source string) *topology.Topology {
+	cfg, err := etc.ReadString(source)
+	assert.NoError(err)
+	tpl, err := topology.Read(cfg, "mesh")
+	assert.NoError(err)
+	return tpl
+}
+
+func waitCollected(assert *asserts.Asserts, msh *mesh.Mesh, sigc chan interface{}, l int) {
+	assert.Retry(func() bool {
+		msh.Emit("collector", event.New(event.TopicProcess))
+		return <-sigc == l
+	}, 10, 10*time.Millisecond)
+}
+
+func newRegistry(assert *asserts.Asserts, sigc chan interface{}) *topology.Registry {
+	reg := topology.NewRegistry()
+	err := reg.Register("counter", func(id string, params *etc.Etc) (mesh.Behavior, error) {
+		return behaviors.NewCounterBehavior(id, func(evt *event.Event) []string {
+			return []string{evt.Topic()}
+		}), nil
+	})
+	assert.NoError(err)
+	err = reg.Register("collector", func(id string, params *etc.Etc) (mesh.Behavior, error) {
+		max := params.ValueAsInt("max", 5)
+		return behaviors.NewCollectorBehavior(id, max, func(accessor event.SinkAccessor) (*event.Payload, error) {
+			sigc <- accessor.Len()
+			return nil, nil
+		}), nil
+	})
+	assert.NoError(err)
+	err = reg.Register("broadcaster", func(id string, params *etc.Etc) (mesh.Behavior, error) {
+		return behaviors.NewBroadcasterBehavior(id), nil
+	})
+	assert.NoError(err)
+	err = reg.Register("ticker", func(id string, params *etc.Etc) (mesh.Behavior, error) {
+		interval := params.ValueAsDuration("interval", time.Minute)
+		return behaviors.NewTickerBehavior(id, interval), nil
+	})
+	assert.NoError(err)
+	err = reg.Register("ticker", func(id string, params *etc.Etc) (mesh.Behavior, error) {
+		return nil, nil
+	})
+	assert.ErrorMatch(err, `.*behavior kind "ticker" already registered.*`)
+	return reg
+}
+
+// EOF
@@ -0,0 +1,140 @@
+// Tideland Go Library - Together - CronTab
+//
+// Copyright (C) 2017-2019 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license.
+
+package crontab // import "tideland.dev/go/together/crontab"
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"tideland.dev/go/db/couchdb"
+)
+
+//--------------------
+// CONSTANTS
+//--------------------
+
+// couchdbPrefix is the prefix of the document IDs of job records.
+const couchdbPrefix = "crontab-job-"
+
+//--------------------
+// COUCHDB STORE
+//--------------------
+
+// couchdbJobDocument is a job record stored in CouchDB.
+type couchdbJobDocument struct {
+	DocumentID       string `json:"_id"`
+	DocumentRevision string `json:"_rev,omitempty"`
+
+	JobRecord
+}
+
+// couchdbStore keeps the job records as documents in CouchDB.
+type couchdbStore struct {
+	db *couchdb.Database
+}
+
+// NewCouchDBStore returns a store keeping the job records as
+// documents in the CouchDB database. Their IDs are prefixed
+// with "crontab-job-".
+func NewCouchDBStore(db *couchdb.Database) Store {
+	return &couchdbStore{
+		db: db,
+	}
+}
+
+// Load implements Store.
+func (cs *couchdbStore) Load() ([]JobRecord, error) {
+	ids, err := cs.db.AllDocumentIDs()
+	if err != nil {
+		return nil, fmt.Errorf("cannot read job store: %v", err)
+	}
+	var records []JobRecord
+	for _, id := range ids {
+		if !strings.HasPrefix(id, couchdbPrefix) {
+			continue
+		}
+		doc, err := cs.read(id)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, doc.JobRecord)
+	}
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].ID < records[j].ID
+	})
+	return records, nil
+}
+
+// Save implements Store.
+func (cs *couchdbStore) Save(record JobRecord) error {
+	id := couchdbPrefix + record.ID
+	doc := &couchdbJobDocument{
+		DocumentID: id,
+		JobRecord:  record,
+	}
+	ok, err := cs.db.HasDocument(id)
+	if err != nil {
+		return fmt.Errorf("cannot read job record '%s': %v", record.ID, err)
+	}
+	var rs *couchdb.ResultSet
+	if ok {
+		stored, err := cs.read(id)
+		if err != nil {
+			return err
+		}
+		doc.DocumentRevision = stored.DocumentRevision
+		rs = cs.db.UpdateDocument(doc)
+	} else {
+		rs = cs.db.CreateDocument(doc)
+	}
+	if !rs.IsOK() {
+		return fmt.Errorf("cannot write job record '%s': %v", record.ID, rs.Error())
+	}
+	return nil
+}
+
+// Delete implements Store.
+func (cs *couchdbStore) Delete(id string) error {
+	docID := couchdbPrefix + id
+	ok, err := cs.db.HasDocument(docID)
+	if err != nil {
+		return fmt.Errorf("cannot read job record '%s': %v", id, err)
+	}
+	if !ok {
+		return nil
+	}
+	doc, err := cs.read(docID)
+	if err != nil {
+		return err
+	}
+	rs := cs.db.DeleteDocumentByID(docID, doc.DocumentRevision)
+	if !rs.IsOK() {
+		return fmt.Errorf("cannot delete job record '%s': %v", id, rs.Error())
+	}
+	return nil
+}
+
+// read reads the document with the given ID.
+func (cs *couchdbStore) read(id string) (*couchdbJobDocument, error) {
+	rs := cs.db.ReadDocument(id)
+	if !rs.IsOK() {
+		return nil, fmt.Errorf("cannot read job document '%s': %v", id, rs.Error())
+	}
+	doc := &couchdbJobDocument{}
+	if err := rs.Document(doc); err != nil {
+		return nil, fmt.Errorf("cannot read job document '%s': %v", id, err)
+	}
+	return doc, nil
+}
+
+// EOF
@@ -0,0 +1,274 @@
+// Tideland Go Library - Together - CronTab
+//
+// Copyright (C) 2017-2019 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license.
+
+package crontab // import "tideland.dev/go/together/crontab"
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"tideland.dev/go/dsa/timex"
+)
+
+//--------------------
+// CONSTANTS
+//--------------------
+
+// cronSearchYears limits the search for the next run of a
+// schedule.
+const cronSearchYears = 5
+
+// cronMacros contains the supported shortcuts for expressions.
+var cronMacros = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// monthNames and weekdayNames can be used instead of numbers.
+var (
+	monthNames = map[string]int{
+		"JAN": 1, "FEB": 2, "MAR": 3, "APR": 4, "MAY": 5, "JUN": 6,
+		"JUL": 7, "AUG": 8, "SEP": 9, "OCT": 10, "NOV": 11, "DEC": 12,
+	}
+	weekdayNames = map[string]int{
+		"SUN": 0, "MON": 1, "TUE": 2, "WED": 3, "THU": 4, "FRI": 5, "SAT": 6,
+	}
+)
+
+//--------------------
+// SCHEDULE
+//--------------------
+
+// schedule contains the parsed fields of a cron expression.
+type schedule struct {
+	expr       string
+	location   *time.Location
+	seconds    []int
+	minutes    []int
+	hours      []int
+	days       []int
+	months     []time.Month
+	weekdays   []time.Weekday
+	anyDay     bool
+	anyWeekday bool
+}
+
+// parseSchedule parses a cron expression with 5 fields (minute, hour,
+// day of month, month, day of week) or 6 fields with a leading second.
+// The expression may start with "TZ=<location>" or "CRON_TZ=<location>",
+// otherwise the passed location is used.
+func parseSchedule(expr string, loc *time.Location) (*schedule, error) {
+	fields := strings.Fields(expr)
+	if len(fields) > 0 && (strings.HasPrefix(fields[0], "TZ=") || strings.HasPrefix(fields[0], "CRON_TZ=")) {
+		name := fields[0][strings.Index(fields[0], "=")+1:]
+		l, err := time.LoadLocation(name)
+		if err != nil {
+			return nil, fmt.Errorf("invalid cron expression '%s': %v", expr, err)
+		}
+		loc = l
+		fields = fields[1:]
+	}
+	if len(fields) == 1 {
+		macro, ok := cronMacros[fields[0]]
+		if !ok {
+			return nil, fmt.Errorf("invalid cron expression '%s': unknown macro", expr)
+		}
+		fields = strings.Fields(macro)
+	}
+	switch len(fields) {
+	case 5:
+		fields = append([]string{"0"}, fields...)
+	case 6:
+	default:
+		return nil, fmt.Errorf("invalid cron expression '%s': need 5 or 6 fields", expr)
+	}
+	s := &schedule{
+		expr:       strings.Join(fields, " "),
+		location:   loc,
+		anyDay:     isWildcard(fields[3]),
+		anyWeekday: isWildcard(fields[5]),
+	}
+	var err error
+	if s.seconds, err = parseField(fields[0], 0, 59, nil); err != nil {
+		return nil, fmt.Errorf("invalid cron expression '%s': seconds: %v", expr, err)
+	}
+	if s.minutes, err = parseField(fields[1], 0, 59, nil); err != nil {
+		return nil, fmt.Errorf("invalid cron expression '%s': minutes: %v", expr, err)
+	}
+	if s.hours, err = parseField(fields[2], 0, 23, nil); err != nil {
+		return nil, fmt.Errorf("invalid cron expression '%s': hours: %v", expr, err)
+	}
+	if s.days, err = parseField(fields[3], 1, 31, nil); err != nil {
+		return nil, fmt.Errorf("invalid cron expression '%s': days of month: %v", expr, err)
+	}
+	months, err := parseField(fields[4], 1, 12, monthNames)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cron expression '%s': months: %v", expr, err)
+	}
+	for _, month := range months {
+		s.months = append(s.months, time.Month(month))
+	}
+	// Sunday may be 0 or 7.
+	weekdays, err := parseField(fields[5], 0, 7, weekdayNames)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cron expression '%s': days of week: %v", expr, err)
+	}
+	for _, weekday := range weekdays {
+		s.weekdays = append(s.weekdays, time.Weekday(weekday%7))
+	}
+	return s, nil
+}
+
+// next returns the first time matching the schedule after the given
+// one. Runs falling into the gap of a daylight saving time change are
+// skipped, runs in a repeated hour happen only once. The zero time is
+// returned if there's no match during the next years.
+func (s *schedule) next(after time.Time) time.Time {
+	after = after.In(s.location)
+	t := after.Truncate(time.Second).Add(time.Second)
+	limit := t.AddDate(cronSearchYears, 0, 0)
+	for t.Before(limit) {
+		y, m, d := t.Date()
+		h := t.Hour()
+		switch {
+		case !timex.MonthInList(t, s.months):
+			t = time.Date(y, m+1, 1, 0, 0, 0, 0, s.location)
+		case !s.dayMatches(t):
+			t = time.Date(y, m, d+1, 0, 0, 0, 0, s.location)
+		case !timex.HourInList(t, s.hours):
+			nt := time.Date(y, m, d, h+1, 0, 0, 0, s.location)
+			if !nt.After(t) {
+				// Normalized back by a daylight saving
+				// time change.
+				nt = t.Truncate(time.Hour).Add(time.Hour)
+			}
+			t = nt
+		case !timex.MinuteInList(t, s.minutes):
+			t = t.Truncate(time.Minute).Add(time.Minute)
+		case !timex.SecondInList(t, s.seconds):
+			t = t.Add(time.Second)
+		case !wallClock(t).After(wallClock(after)):
+			// Repeated wall clock time after a daylight
+			// saving time change.
+			t = t.Add(time.Second)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// dayMatches checks the day of month and the day of week. Like in
+// standard cron a day matches either of both if both are restricted.
+func (s *schedule) dayMatches(t time.Time) bool {
+	dayOK := timex.DayInList(t, s.days)
+	weekdayOK := timex.WeekdayInList(t, s.weekdays)
+	switch {
+	case s.anyDay && s.anyWeekday:
+		return true
+	case s.anyDay:
+		return weekdayOK
+	case s.anyWeekday:
+		return dayOK
+	default:
+		return dayOK || weekdayOK
+	}
+}
+
+//--------------------
+// HELPERS
+//--------------------
+
+// parseField parses one field of a cron expression containing a
+// comma separated list of values, ranges, and steps.
+func parseField(field string, min, max int, names map[string]int) ([]int, error) {
+	set := make([]bool, max+1)
+	for _, part := range strings.Split(field, ",") {
+		rng, step := part, 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			var err error
+			rng = part[:i]
+			step, err = strconv.Atoi(part[i+1:])
+			if err != nil || step < 1 {
+				return nil, fmt.Errorf("invalid step in '%s'", part)
+			}
+		}
+		var first, last int
+		switch {
+		case isWildcard(rng):
+			first, last = min, max
+		case strings.Contains(rng, "-"):
+			bounds := strings.SplitN(rng, "-", 2)
+			var err error
+			if first, err = parseValue(bounds[0], names); err != nil {
+				return nil, err
+			}
+			if last, err = parseValue(bounds[1], names); err != nil {
+				return nil, err
+			}
+		default:
+			var err error
+			if first, err = parseValue(rng, names); err != nil {
+				return nil, err
+			}
+			last = first
+			if step > 1 {
+				// A start value with a step runs until the end.
+				last = max
+			}
+		}
+		if first < min || last > max || first > last {
+			return nil, fmt.Errorf("invalid range in '%s'", part)
+		}
+		for v := first; v <= last; v += step {
+			set[v] = true
+		}
+	}
+	var values []int
+	for v, ok := range set {
+		if ok {
+			values = append(values, v)
+		}
+	}
+	return values, nil
+}
+
+// parseValue parses a number or a name of a field.
+func parseValue(s string, names map[string]int) (int, error) {
+	if v, ok := names[strings.ToUpper(s)]; ok {
+		return v, nil
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid value '%s'", s)
+	}
+	return v, nil
+}
+
+// isWildcard checks if the field matches every value.
+func isWildcard(field string) bool {
+	return field == "*" || field == "?"
+}
+
+// wallClock returns the time shown by a clock in the location of
+// the time, independent of daylight saving time.
+func wallClock(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), 0, time.UTC)
+}
+
+// EOF
@@ -12,13 +12,14 @@ package crontab // import "tideland.dev/go/together/crontab"
 //--------------------
 
 import (
+	"context"
 	"fmt"
 	"sort"
 	"sync"
 	"time"
 
+	"tideland.dev/go/dsa/timex"
 	"tideland.dev/go/together/actor"
-	"tideland.dev/go/together/loop"
 	"tideland.dev/go/together/notifier"
 )
 
@@ -28,149 +29,118 @@ import (
 
 var (
 	mu sync.Mutex
-	ct *crontab
+	ct *Crontab
 )
 
-//--------------------
-// CRONTAB
-//--------------------
-
-// crontab implements the tanle for all cronjobs.
-type crontab struct {
-	actor *actor.Actor
-	jobs  map[string]*cronjob
-}
-
-// goCrontab instantiates and starts the crontab if it is not already running.
-func goGrontab() {
+// global returns the crontab used by the package functions. It is
+// started if it is not already running.
+func global() *Crontab {
 	mu.Lock()
 	defer mu.Unlock()
-	if ct != nil {
-		return
-	}
-	ct = &crontab{
-		actor: actor.New().Go(),
-		jobs:  make(map[string]*cronjob),
+	if ct == nil {
+		ct, _ = New(context.Background())
 	}
+	return ct
 }
 
 //--------------------
-// CRONJOB
+// OPTIONS
 //--------------------
 
-// cronjob is responsible to run one job.
-type cronjob struct {
-	id       string
-	start    *time.Time
-	interval *time.Duration
-	job      func() error
-	loop     *loop.Loop
-	notifier *notifier.Notifier
-	rs       loop.Reasons
-}
-
-// newCronjob creates a new cronjob and starts its goroutine.
-func newCronjob(id string, s *time.Time, i *time.Duration, j func() error) *cronjob {
-	cj := &cronjob{
-		id:       id,
-		start:    s,
-		interval: i,
-		job:      j,
-		notifier: notifier.New(),
-		rs:       loop.MakeReasons(),
-	}
-	cj.loop = loop.New(cj.worker,
-		loop.WithRecoverer(cj.recoverer),
-		loop.WithNotifier(cj.notifier)).Go()
-	<-cj.notifier.Working()
-	return cj
-}
+// Option defines the signature of an option setting function.
+type Option func(ct *Crontab) error
 
-// stop ends the cronjob goroutine.
-func (cj *cronjob) stop() error {
-	err := cj.loop.Stop(nil)
-	if loop.IsErrLoopNotWorking(err) {
+// WithClock sets the clock used by the crontab to wait for the
+// next runs of the jobs. By default it is the real clock.
+func WithClock(clock timex.Clock) Option {
+	return func(ct *Crontab) error {
+		if clock == nil {
+			return fmt.Errorf("invalid crontab option: clock is nil")
+		}
+		ct.clock = clock
 		return nil
 	}
-	return err
 }
 
-// status returns the status of the cronjob.
-func (cj *cronjob) status() notifier.Status {
-	return cj.loop.Status()
-}
-
-// worker runs the cronjob.
-func (cj *cronjob) worker(c *notifier.Closer) error {
-	// Init.
-	var interval time.Duration
-	if cj.start != nil {
-		interval = time.Until(*cj.start)
-	} else {
-		interval = *cj.interval
-	}
-	// Loop.
-	for {
-		select {
-		case <-c.Done():
-			return nil
-		case <-time.After(interval):
-			if err := cj.job(); err != nil {
-				return err
-			}
-			if cj.interval == nil {
-				// Only once.
-				return nil
-			}
-			// In intervals.
-			interval = *cj.interval
+// WithStore sets the store for persistent jobs. When the crontab is
+// created the stored jobs are restored and bound to the functions
+// of the registry with the stored names.
+func WithStore(store Store, registry Registry) Option {
+	return func(ct *Crontab) error {
+		if store == nil {
+			return fmt.Errorf("invalid crontab option: store is nil")
 		}
+		ct.store = store
+		ct.registry = registry
+		return nil
 	}
 }
 
-// recoverer allows the cronjob to survive panics.
-func (cj *cronjob) recoverer(reason interface{}) error {
-	cj.rs = cj.rs.Append(reason)
-	if cj.rs.Frequency(5, 10*time.Millisecond) {
-		return fmt.Errorf("too high error frequency: %v", cj.rs)
-	}
-	if cj.rs.Len() >= 10 {
-		return fmt.Errorf("too many errors: %v", cj.rs)
+// WithLocker sets the locker coordinating the runs of the jobs with
+// other crontabs, e.g. in the replicas of a service. A due run of a
+// job is only executed by the crontab acquiring its lock. The locker
+// is used by the jobs submitted or restored afterwards.
+func WithLocker(locker Locker) Option {
+	return func(ct *Crontab) error {
+		if locker == nil {
+			return fmt.Errorf("invalid crontab option: locker is nil")
+		}
+		ct.locker = locker
+		return nil
 	}
-	return nil
 }
 
 //--------------------
-// API
+// CRONTAB
 //--------------------
 
-// SubmitAt adds a function running only once at a given time.
-func SubmitAt(id string, at time.Time, j func() error) error {
-	goGrontab()
-	var err error
-	if actErr := ct.actor.DoSync(func() error {
-		if ct.jobs[id] != nil {
-			err = fmt.Errorf("job ID '%s' already exists", id)
-			return nil
+// Crontab implements the table for all cronjobs.
+type Crontab struct {
+	ctx      context.Context
+	actor    *actor.Actor
+	clock    timex.Clock
+	store    Store
+	registry Registry
+	locker   Locker
+	jobs     map[string]*cronjob
+}
+
+// New creates and starts a crontab. It runs until the context is
+// done or it is stopped.
+func New(ctx context.Context, options ...Option) (*Crontab, error) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	ct := &Crontab{
+		ctx:   ctx,
+		clock: timex.RealClock(),
+		jobs:  make(map[string]*cronjob),
+	}
+	for _, option := range options {
+		if err := option(ct); err != nil {
+			return nil, err
 		}
-		ct.jobs[id] = newCronjob(id, &at, nil, j)
-		return nil
-	}); actErr != nil {
-		return actErr
 	}
-	return err
+	if err := ct.restore(); err != nil {
+		for _, job := range ct.jobs {
+			job.stop()
+		}
+		return nil, err
+	}
+	ct.actor = actor.New(actor.WithContext(ctx)).Go()
+	return ct, nil
 }
 
-// SubmitEvery adds a function running every interval.
-func SubmitEvery(id string, every time.Duration, j func() error) error {
-	goGrontab()
+// Configure applies the options to the crontab. They are valid for
+// jobs submitted afterwards.
+func (ct *Crontab) Configure(options ...Option) error {
 	var err error
 	if actErr := ct.actor.DoSync(func() error {
-		if ct.jobs[id] != nil {
-			err = fmt.Errorf("job ID '%s' already exists", id)
-			return nil
+		for _, option := range options {
+			if err = option(ct); err != nil {
+				return nil
+			}
 		}
-		ct.jobs[id] = newCronjob(id, nil, &every, j)
 		return nil
 	}); actErr != nil {
 		return actErr
@@ -178,33 +148,48 @@ func SubmitEvery(id string, every time.Duration, j func() error) error {
 	return err
 }
 
+// SubmitAt adds a function running only once at a given time.
+func (ct *Crontab) SubmitAt(id string, at time.Time, j func() error, options ...JobOption) error {
+	return ct.submit(id, &at, nil, "", j, options)
+}
+
+// SubmitEvery adds a function running every interval.
+func (ct *Crontab) SubmitEvery(id string, every time.Duration, j func() error, options ...JobOption) error {
+	return ct.submit(id, nil, &every, "", j, options)
+}
+
 // SubmitAtEvery adds a function running every interval starting at a given time.
-func SubmitAtEvery(id string, at time.Time, every time.Duration, j func() error) error {
-	goGrontab()
-	var err error
+func (ct *Crontab) SubmitAtEvery(id string, at time.Time, every time.Duration, j func() error, options ...JobOption) error {
+	return ct.submit(id, &at, &every, "", j, options)
+}
+
+// SubmitAfterEvery adds a function running every interval after a given pause.
+func (ct *Crontab) SubmitAfterEvery(id string, pause, every time.Duration, j func() error, options ...JobOption) error {
+	var now time.Time
 	if actErr := ct.actor.DoSync(func() error {
-		if ct.jobs[id] != nil {
-			err = fmt.Errorf("job ID '%s' already exists", id)
-			return nil
-		}
-		ct.jobs[id] = newCronjob(id, &at, &every, j)
+		now = ct.clock.Now()
 		return nil
 	}); actErr != nil {
 		return actErr
 	}
-	return err
+	return ct.SubmitAtEvery(id, now.Add(pause), every, j, options...)
 }
 
-// SubmitAfterEvery adds a function running every interval after a given pause.
-func SubmitAfterEvery(id string, pause, every time.Duration, j func() error) error {
-	return SubmitAtEvery(id, time.Now().Add(pause), every, j)
+// SubmitCron adds a function running at the times matching the cron
+// expression. It has 5 fields for minute, hour, day of month, month,
+// and day of week, or 6 fields with a leading second. Each field
+// contains a "*", values, ranges, steps, or lists of them like
+// "*/5 9-17 * * MON-FRI". Months and days of week can be given by
+// their names, Sunday is 0 or 7. Macros like "@hourly" or "@daily"
+// are supported too. The times are interpreted in the location of
+// the clock unless the expression starts with "TZ=<location>".
+func (ct *Crontab) SubmitCron(id, expr string, j func() error, options ...JobOption) error {
+	return ct.submit(id, nil, nil, expr, j, options)
 }
 
 // List returns all currently submitted IDs.
-func List() ([]string, error) {
-	goGrontab()
+func (ct *Crontab) List() ([]string, error) {
 	var ids []string
-	var err error
 	if actErr := ct.actor.DoSync(func() error {
 		for id := range ct.jobs {
 			ids = append(ids, id)
@@ -214,38 +199,244 @@ func List() ([]string, error) {
 		return ids, actErr
 	}
 	sort.Strings(ids)
-	return ids, err
+	return ids, nil
 }
 
 // Status returns the status of a cronjob.
-func Status(id string) (notifier.Status, error) {
-	goGrontab()
+func (ct *Crontab) Status(id string) (notifier.Status, error) {
 	var status notifier.Status
+	err := ct.do(id, func(job *cronjob) error {
+		status = job.status()
+		return nil
+	})
+	if err != nil {
+		return notifier.Unknown, err
+	}
+	return status, nil
+}
+
+// Next returns the time of the next run of a cronjob.
+func (ct *Crontab) Next(id string) (time.Time, error) {
+	var next time.Time
+	err := ct.do(id, func(job *cronjob) error {
+		next = job.nextRun()
+		if next.IsZero() || job.status() != notifier.Working {
+			return fmt.Errorf("job ID '%s' has no next run", id)
+		}
+		return nil
+	})
+	if err != nil {
+		return time.Time{}, err
+	}
+	return next, nil
+}
+
+// History returns the last finished runs of a cronjob, the oldest
+// one first. Their number is limited by the option WithHistory().
+func (ct *Crontab) History(id string) ([]Run, error) {
+	var runs []Run
+	err := ct.do(id, func(job *cronjob) error {
+		runs = job.runs()
+		return nil
+	})
+	return runs, err
+}
+
+// Revoke stops a cronjob and removes it from the table. Persistent
+// jobs are deleted from the store first. If this fails the job stays
+// in the table and continues to run.
+func (ct *Crontab) Revoke(id string) error {
+	return ct.do(id, func(job *cronjob) error {
+		if job.store != nil {
+			if err := job.store.Delete(id); err != nil {
+				return err
+			}
+		}
+		delete(ct.jobs, id)
+		return job.stop()
+	})
+}
+
+// Stop terminates all cronjobs and the crontab.
+func (ct *Crontab) Stop() error {
+	var errs []error
+	if actErr := ct.actor.DoSync(func() error {
+		for id, job := range ct.jobs {
+			delete(ct.jobs, id)
+			if err := job.stop(); err != nil {
+				errs = append(errs, err)
+			}
+		}
+		return nil
+	}); actErr != nil {
+		return actErr
+	}
+	if err := ct.actor.Stop(nil); err != nil {
+		return err
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("stopping jobs: %v", errs)
+	}
+	return nil
+}
+
+// submit adds a new cronjob.
+func (ct *Crontab) submit(
+	id string,
+	at *time.Time,
+	every *time.Duration,
+	expr string,
+	j func() error,
+	options []JobOption,
+) error {
 	var err error
 	if actErr := ct.actor.DoSync(func() error {
-		if job, ok := ct.jobs[id]; ok {
-			status = job.status()
+		if ct.jobs[id] != nil {
+			err = fmt.Errorf("job ID '%s' already exists", id)
 			return nil
 		}
-		err = fmt.Errorf("job ID '%s' does not exist", id)
+		var cfg *jobConfig
+		if cfg, err = newJobConfig(options); err != nil {
+			return nil
+		}
+		var sched *schedule
+		if expr != "" {
+			if sched, err = parseSchedule(expr, ct.clock.Now().Location()); err != nil {
+				return nil
+			}
+			if sched.next(ct.clock.Now()).IsZero() {
+				err = fmt.Errorf("invalid cron expression '%s': never matches", expr)
+				return nil
+			}
+		}
+		if j != nil && cfg.job != nil {
+			err = fmt.Errorf("job ID '%s' has a function and a context job", id)
+			return nil
+		}
+		cj := plainJob(j)
+		if cfg.job != nil {
+			cj = cfg.job
+		}
+		job := newCronjob(id, ct.clock, cfg, at, every, sched, cj).lockWith(ct.locker)
+		if cfg.name != "" {
+			if err = ct.persist(job); err != nil {
+				return nil
+			}
+		}
+		ct.jobs[id] = job.goWork(ct.ctx)
 		return nil
 	}); actErr != nil {
-		return notifier.Unknown, actErr
+		return actErr
 	}
-	return status, err
+	return err
 }
 
-// Revoke stops a cronjob and removes it from the table.
-func Revoke(id string) error {
-	goGrontab()
+// persist stores a new persistent job. Its function is taken from
+// the registry if none is passed.
+func (ct *Crontab) persist(job *cronjob) error {
+	if ct.store == nil {
+		return fmt.Errorf("job ID '%s' cannot be persisted without store", job.id)
+	}
+	j, ok := ct.registry[job.cfg.name]
+	if !ok {
+		return fmt.Errorf("job function '%s' is not registered", job.cfg.name)
+	}
+	if job.job == nil {
+		job.job = plainJob(j)
+	}
+	record := &JobRecord{
+		ID:         job.id,
+		Name:       job.cfg.name,
+		Overlap:    job.cfg.overlap,
+		CatchUp:    job.cfg.catchUp,
+		HistoryLen: job.cfg.historyLen,
+	}
+	if job.start != nil {
+		record.At = *job.start
+	}
+	if job.interval != nil {
+		record.Every = *job.interval
+	}
+	if job.schedule != nil {
+		record.Cron = job.schedule.expr
+		record.Location = job.schedule.location.String()
+	}
+	if err := ct.store.Save(*record); err != nil {
+		return err
+	}
+	job.persistIn(ct.store, record)
+	return nil
+}
+
+// restore restarts the jobs of the store. Jobs continue after their
+// last run, missed runs are handled by the catch-up policy of the job.
+// Jobs running only once are deleted if they already ran.
+func (ct *Crontab) restore() error {
+	if ct.store == nil {
+		return nil
+	}
+	records, err := ct.store.Load()
+	if err != nil {
+		return err
+	}
+	for _, record := range records {
+		record := record
+		j, ok := ct.registry[record.Name]
+		if !ok {
+			return fmt.Errorf("job ID '%s' needs unregistered function '%s'", record.ID, record.Name)
+		}
+		cfg := &jobConfig{
+			overlap:    record.Overlap,
+			catchUp:    record.CatchUp,
+			historyLen: record.HistoryLen,
+			name:       record.Name,
+		}
+		var every *time.Duration
+		if record.Every > 0 {
+			every = &record.Every
+		}
+		var sched *schedule
+		if record.Cron != "" {
+			loc, err := time.LoadLocation(record.Location)
+			if err != nil {
+				return fmt.Errorf("job ID '%s' has invalid location: %v", record.ID, err)
+			}
+			if sched, err = parseSchedule(record.Cron, loc); err != nil {
+				return err
+			}
+		}
+		var at *time.Time
+		switch {
+		case record.LastRun.IsZero():
+			if !record.At.IsZero() {
+				at = &record.At
+			}
+		case every == nil && sched == nil:
+			// Already ran once.
+			if err := ct.store.Delete(record.ID); err != nil {
+				return err
+			}
+			continue
+		default:
+			next := following(record.LastRun, every, sched)
+			at = &next
+		}
+		job := newCronjob(record.ID, ct.clock, cfg, at, every, sched, plainJob(j)).lockWith(ct.locker)
+		ct.jobs[record.ID] = job.persistIn(ct.store, &record).goWork(ct.ctx)
+	}
+	return nil
+}
+
+// do performs the function with the identified cronjob.
+func (ct *Crontab) do(id string, f func(job *cronjob) error) error {
 	var err error
 	if actErr := ct.actor.DoSync(func() error {
-		if job, ok := ct.jobs[id]; ok {
-			delete(ct.jobs, id)
-			err = job.stop()
+		job, ok := ct.jobs[id]
+		if !ok {
+			err = fmt.Errorf("job ID '%s' does not exist", id)
 			return nil
 		}
-		err = fmt.Errorf("job ID '%s' does not exist", id)
+		err = f(job)
 		return nil
 	}); actErr != nil {
 		return actErr
@@ -253,4 +444,65 @@ func Revoke(id string) error {
 	return err
 }
 
+//--------------------
+// API
+//--------------------
+
+// Configure applies the options to the crontab used by the package
+// functions. They are valid for jobs submitted afterwards.
+func Configure(options ...Option) error {
+	return global().Configure(options...)
+}
+
+// SubmitAt adds a function running only once at a given time.
+func SubmitAt(id string, at time.Time, j func() error, options ...JobOption) error {
+	return global().SubmitAt(id, at, j, options...)
+}
+
+// SubmitEvery adds a function running every interval.
+func SubmitEvery(id string, every time.Duration, j func() error, options ...JobOption) error {
+	return global().SubmitEvery(id, every, j, options...)
+}
+
+// SubmitAtEvery adds a function running every interval starting at a given time.
+func SubmitAtEvery(id string, at time.Time, every time.Duration, j func() error, options ...JobOption) error {
+	return global().SubmitAtEvery(id, at, every, j, options...)
+}
+
+// SubmitAfterEvery adds a function running every interval after a given pause.
+func SubmitAfterEvery(id string, pause, every time.Duration, j func() error, options ...JobOption) error {
+	return global().SubmitAfterEvery(id, pause, every, j, options...)
+}
+
+// SubmitCron adds a function running at the times matching the cron
+// expression. See Crontab.SubmitCron() for the syntax.
+func SubmitCron(id, expr string, j func() error, options ...JobOption) error {
+	return global().SubmitCron(id, expr, j, options...)
+}
+
+// List returns all currently submitted IDs.
+func List() ([]string, error) {
+	return global().List()
+}
+
+// Status returns the status of a cronjob.
+func Status(id string) (notifier.Status, error) {
+	return global().Status(id)
+}
+
+// Next returns the time of the next run of a cronjob.
+func Next(id string) (time.Time, error) {
+	return global().Next(id)
+}
+
+// History returns the last finished runs of a cronjob.
+func History(id string) ([]Run, error) {
+	return global().History(id)
+}
+
+// Revoke stops a cronjob and removes it from the table.
+func Revoke(id string) error {
+	return global().Revoke(id)
+}
+
 // EOF
@@ -12,11 +12,16 @@ package crontab_test
 //--------------------
 
 import (
+	"context"
 	"errors"
+	"path/filepath"
+	"sync"
 	"testing"
 	"time"
 
 	"tideland.dev/go/audit/asserts"
+	"tideland.dev/go/audit/environments"
+	"tideland.dev/go/dsa/timex"
 	"tideland.dev/go/together/crontab"
 	"tideland.dev/go/together/notifier"
 )
@@ -269,4 +274,765 @@ func TestIllegal(t *testing.T) {
 	assert.ErrorMatch(err, `job ID 'yadda-2' does not exist`)
 }
 
+// TestClock tests the running of jobs based on a manual clock.
+func TestClock(t *testing.T) {
+	// Init.
+	assert := asserts.NewTesting(t, asserts.FailStop)
+	start := time.Date(2019, time.January, 1, 12, 0, 0, 0, time.UTC)
+	clock := timex.NewManualClock(start)
+	err := crontab.Configure(crontab.WithClock(clock))
+	assert.NoError(err)
+	defer crontab.Configure(crontab.WithClock(timex.RealClock()))
+	runC := make(chan time.Time, 1)
+	waitTimer := func() {
+		assert.Retry(func() bool { return clock.Timers() == 1 }, 100, 10*time.Millisecond)
+	}
+
+	// Test.
+	err = crontab.SubmitAfterEvery("clock-1", time.Minute, time.Hour, func() error {
+		runC <- clock.Now()
+		return nil
+	})
+	assert.NoError(err)
+
+	waitTimer()
+	clock.Advance(59 * time.Second)
+	clock.Advance(time.Second)
+	assert.Equal(<-runC, start.Add(time.Minute))
+	waitTimer()
+	clock.Advance(time.Hour)
+	assert.Equal(<-runC, start.Add(61*time.Minute))
+
+	err = crontab.Revoke("clock-1")
+	assert.NoError(err)
+	err = crontab.Configure(crontab.WithClock(nil))
+	assert.ErrorMatch(err, `invalid crontab option: clock is nil`)
+}
+
+// TestSubmitCron tests the running of jobs based on cron expressions.
+func TestSubmitCron(t *testing.T) {
+	// Init.
+	assert := asserts.NewTesting(t, asserts.FailStop)
+	start := time.Date(2019, time.January, 4, 17, 57, 0, 0, time.UTC)
+	clock := timex.NewManualClock(start)
+	err := crontab.Configure(crontab.WithClock(clock))
+	assert.NoError(err)
+	defer crontab.Configure(crontab.WithClock(timex.RealClock()))
+	runC := make(chan time.Time, 1)
+	monday := time.Date(2019, time.January, 7, 9, 0, 0, 0, time.UTC)
+
+	// Test.
+	err = crontab.SubmitCron("cron-1", "*/5 9-17 * * MON-FRI", func() error {
+		runC <- clock.Now()
+		return nil
+	})
+	assert.NoError(err)
+	next, err := crontab.Next("cron-1")
+	assert.NoError(err)
+	assert.Equal(next, monday)
+
+	assert.Retry(func() bool { return clock.Timers() == 1 }, 100, 10*time.Millisecond)
+	clock.Set(monday)
+	assert.Equal(<-runC, monday)
+	assert.Retry(func() bool {
+		next, err = crontab.Next("cron-1")
+		return err == nil && next.Equal(monday.Add(5*time.Minute))
+	}, 100, 10*time.Millisecond)
+
+	err = crontab.Revoke("cron-1")
+	assert.NoError(err)
+	_, err = crontab.Next("cron-1")
+	assert.ErrorMatch(err, `job ID 'cron-1' does not exist`)
+}
+
+// TestCronExpressions tests the next runs of different expressions,
+// time zones, and daylight saving time changes.
+func TestCronExpressions(t *testing.T) {
+	// Init.
+	assert := asserts.NewTesting(t, asserts.FailStop)
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	assert.NoError(err)
+	tests := []struct {
+		expr  string
+		start time.Time
+		next  time.Time
+	}{
+		{"* * * * *", time.Date(2019, 1, 1, 12, 0, 30, 0, time.UTC), time.Date(2019, 1, 1, 12, 1, 0, 0, time.UTC)},
+		{"30 */10 * * * *", time.Date(2019, 1, 1, 12, 0, 30, 0, time.UTC), time.Date(2019, 1, 1, 12, 10, 30, 0, time.UTC)},
+		{"0 0 1,15 * *", time.Date(2019, 1, 2, 0, 0, 0, 0, time.UTC), time.Date(2019, 1, 15, 0, 0, 0, 0, time.UTC)},
+		{"0 0 13 * FRI", time.Date(2019, 9, 1, 0, 0, 0, 0, time.UTC), time.Date(2019, 9, 6, 0, 0, 0, 0, time.UTC)},
+		{"0 0 29 FEB *", time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC), time.Date(2020, 2, 29, 0, 0, 0, 0, time.UTC)},
+		{"0 12 * * 7", time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC), time.Date(2019, 1, 6, 12, 0, 0, 0, time.UTC)},
+		{"@monthly", time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC), time.Date(2019, 2, 1, 0, 0, 0, 0, time.UTC)},
+		{"TZ=Europe/Berlin 0 9 * * *", time.Date(2019, 1, 1, 9, 0, 0, 0, time.UTC), time.Date(2019, 1, 2, 8, 0, 0, 0, time.UTC)},
+		// Skipped hour when switching to summer time.
+		{"TZ=Europe/Berlin 30 2 * * *", time.Date(2019, 3, 31, 0, 0, 0, 0, berlin), time.Date(2019, 4, 1, 2, 30, 0, 0, berlin)},
+		// Repeated hour when switching to winter time.
+		{"TZ=Europe/Berlin 30 2 * * *", time.Date(2019, 10, 27, 0, 30, 0, 0, time.UTC), time.Date(2019, 10, 28, 2, 30, 0, 0, berlin)},
+		{"TZ=Europe/Berlin 0 * * * *", time.Date(2019, 10, 27, 0, 0, 0, 0, time.UTC), time.Date(2019, 10, 27, 2, 0, 0, 0, time.UTC)},
+	}
+	defer crontab.Configure(crontab.WithClock(timex.RealClock()))
+	job := func() error { return nil }
+
+	// Test.
+	for i, test := range tests {
+		assert.Logf("test #%d: %s", i, test.expr)
+		err = crontab.Configure(crontab.WithClock(timex.NewManualClock(test.start)))
+		assert.NoError(err)
+		err = crontab.SubmitCron("cron-expr", test.expr, job)
+		assert.NoError(err)
+		next, err := crontab.Next("cron-expr")
+		assert.NoError(err)
+		assert.Equal(next.UTC(), test.next.UTC())
+		err = crontab.Revoke("cron-expr")
+		assert.NoError(err)
+	}
+}
+
+// TestCronIllegal tests illegal cron expressions.
+func TestCronIllegal(t *testing.T) {
+	// Init.
+	assert := asserts.NewTesting(t, asserts.FailStop)
+	job := func() error { return nil }
+	tests := []struct {
+		expr string
+		err  string
+	}{
+		{"* * * *", `.*need 5 or 6 fields`},
+		{"60 * * * *", `.*minutes: invalid range in '60'`},
+		{"* * * * FOO", `.*days of week: invalid value 'FOO'`},
+		{"*/0 * * * *", `.*minutes: invalid step in '\*/0'`},
+		{"5-1 * * * *", `.*minutes: invalid range in '5-1'`},
+		{"@often", `.*unknown macro`},
+		{"TZ=Nowhere/Somewhere * * * * *", `.*unknown time zone.*`},
+		{"0 0 30 2 *", `.*never matches`},
+	}
+
+	// Test.
+	for _, test := range tests {
+		err := crontab.SubmitCron("cron-illegal", test.expr, job)
+		assert.ErrorMatch(err, test.err)
+	}
+	_, err := crontab.Next("cron-illegal")
+	assert.ErrorMatch(err, `job ID 'cron-illegal' does not exist`)
+}
+
+// TestInstance tests a crontab instance stopped by its context.
+func TestInstance(t *testing.T) {
+	// Init.
+	assert := asserts.NewTesting(t, asserts.FailStop)
+	ctx, cancel := context.WithCancel(context.Background())
+	ct, err := crontab.New(ctx)
+	assert.NoError(err)
+	job := func() error { return nil }
+
+	// Test.
+	err = ct.SubmitEvery("instance-1", time.Hour, job)
+	assert.NoError(err)
+	err = crontab.SubmitEvery("instance-1", time.Hour, job)
+	assert.NoError(err)
+	ids, err := ct.List()
+	assert.NoError(err)
+	assert.Equal(ids, []string{"instance-1"})
+	err = crontab.Revoke("instance-1")
+	assert.NoError(err)
+	status, err := ct.Status("instance-1")
+	assert.NoError(err)
+	assert.Equal(status, notifier.Working)
+
+	cancel()
+	assert.Retry(func() bool {
+		_, err := ct.List()
+		return err != nil
+	}, 100, 10*time.Millisecond)
+
+	ct, err = crontab.New(context.Background(), crontab.WithClock(nil))
+	assert.ErrorMatch(err, `invalid crontab option: clock is nil`)
+	assert.Nil(ct)
+}
+
+// TestOverlap tests the policies for overlapping runs.
+func TestOverlap(t *testing.T) {
+	// Init.
+	assert := asserts.NewTesting(t, asserts.FailStop)
+	tests := []struct {
+		policy  crontab.OverlapPolicy
+		started int
+	}{
+		{crontab.OverlapSkip, 1},
+		{crontab.OverlapQueue, 1},
+		{crontab.OverlapConcurrent, 2},
+	}
+
+	// Test.
+	for i, test := range tests {
+		assert.Logf("test #%d: policy %d", i, test.policy)
+		clock := timex.NewManualClock(time.Date(2019, time.January, 1, 12, 0, 0, 0, time.UTC))
+		ct, err := crontab.New(context.Background(), crontab.WithClock(clock))
+		assert.NoError(err)
+		startedC := make(chan struct{}, 5)
+		releaseC := make(chan struct{})
+		err = ct.SubmitEvery("overlap", time.Minute, func() error {
+			startedC <- struct{}{}
+			<-releaseC
+			return nil
+		}, crontab.WithOverlap(test.policy))
+		assert.NoError(err)
+
+		// Second run is due while the first one runs.
+		for j := 0; j < 2; j++ {
+			assert.Retry(func() bool { return clock.Timers() == 1 }, 100, 10*time.Millisecond)
+			clock.Advance(time.Minute)
+		}
+		assert.Retry(func() bool { return clock.Timers() == 1 }, 100, 10*time.Millisecond)
+		assert.Retry(func() bool { return len(startedC) == test.started }, 100, 10*time.Millisecond)
+		close(releaseC)
+		runs := 2
+		if test.policy == crontab.OverlapSkip {
+			runs = 1
+		}
+		assert.Retry(func() bool {
+			history, err := ct.History("overlap")
+			return err == nil && len(history) == runs
+		}, 100, 10*time.Millisecond)
+		assert.Length(startedC, runs)
+		assert.NoError(ct.Stop())
+	}
+
+	ct, err := crontab.New(context.Background())
+	assert.NoError(err)
+	defer ct.Stop()
+	err = ct.SubmitEvery("overlap", time.Minute, func() error { return nil }, crontab.WithOverlap(0))
+	assert.ErrorMatch(err, `invalid job option: overlap policy 0`)
+}
+
+// TestHistory tests the bounded history of runs.
+func TestHistory(t *testing.T) {
+	// Init.
+	assert := asserts.NewTesting(t, asserts.FailStop)
+	start := time.Date(2019, time.January, 1, 12, 0, 0, 0, time.UTC)
+	clock := timex.NewManualClock(start)
+	ct, err := crontab.New(context.Background(), crontab.WithClock(clock))
+	assert.NoError(err)
+	defer ct.Stop()
+	count := 0
+
+	// Test.
+	err = ct.SubmitEvery("history", time.Minute, func() error {
+		count++
+		clock.Advance(time.Duration(count) * time.Second)
+		if count == 3 {
+			return errors.New("ouch")
+		}
+		return nil
+	}, crontab.WithHistory(2))
+	assert.NoError(err)
+
+	for i := 0; i < 3; i++ {
+		assert.Retry(func() bool { return clock.Timers() == 1 }, 100, 10*time.Millisecond)
+		clock.Set(start.Add(time.Duration(i+1) * time.Hour))
+	}
+	assert.Retry(func() bool {
+		status, err := ct.Status("history")
+		return err == nil && status == notifier.Stopped
+	}, 100, 10*time.Millisecond)
+	history, err := ct.History("history")
+	assert.NoError(err)
+	assert.Length(history, 2)
+	assert.Equal(history[0].Start, start.Add(2*time.Hour))
+	assert.Equal(history[0].Duration, 2*time.Second)
+	assert.NoError(history[0].Err)
+	assert.Equal(history[1].Start, start.Add(3*time.Hour))
+	assert.Equal(history[1].Duration, 3*time.Second)
+	assert.ErrorMatch(history[1].Err, "ouch")
+
+	_, err = ct.History("unknown")
+	assert.ErrorMatch(err, `job ID 'unknown' does not exist`)
+}
+
+// TestCatchUp tests the policies for missed runs.
+func TestCatchUp(t *testing.T) {
+	// Init.
+	assert := asserts.NewTesting(t, asserts.FailStop)
+	start := time.Date(2019, time.January, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		policy crontab.CatchUpPolicy
+		runs   int
+		next   time.Time
+	}{
+		{crontab.CatchUpOnce, 1, start.Add(time.Minute)},
+		{crontab.CatchUpSkip, 0, start.Add(time.Minute)},
+		{crontab.CatchUpAll, 6, start.Add(time.Minute)},
+	}
+
+	// Test.
+	for i, test := range tests {
+		assert.Logf("test #%d: policy %d", i, test.policy)
+		clock := timex.NewManualClock(start)
+		ct, err := crontab.New(context.Background(), crontab.WithClock(clock))
+		assert.NoError(err)
+		runC := make(chan time.Time, 10)
+		// Paused since five and a half minutes.
+		at := start.Add(-330 * time.Second)
+		err = ct.SubmitAtEvery("catch-up", at, time.Minute, func() error {
+			runC <- clock.Now()
+			return nil
+		}, crontab.WithCatchUp(test.policy), crontab.WithOverlap(crontab.OverlapQueue))
+		assert.NoError(err)
+
+		assert.Retry(func() bool {
+			if clock.Timers() == 1 {
+				clock.Advance(0)
+			}
+			next, err := ct.Next("catch-up")
+			return err == nil && next.Equal(test.next)
+		}, 100, 10*time.Millisecond)
+		assert.Retry(func() bool {
+			history, err := ct.History("catch-up")
+			return err == nil && len(history) == test.runs
+		}, 100, 10*time.Millisecond)
+		assert.Length(runC, test.runs)
+		assert.NoError(ct.Stop())
+	}
+}
+
+// TestPersistence tests the restoring of persistent jobs.
+func TestPersistence(t *testing.T) {
+	// Init.
+	assert := asserts.NewTesting(t, asserts.FailStop)
+	td := environments.NewTempDir(assert)
+	defer td.Restore()
+	store := crontab.NewFileStore(filepath.Join(td.String(), "jobs.json"))
+	start := time.Date(2019, time.January, 1, 3, 0, 0, 0, time.UTC)
+	runC := make(chan string, 10)
+	registry := crontab.Registry{
+		"count": func() error {
+			runC <- "count"
+			return nil
+		},
+	}
+	clock := timex.NewManualClock(start)
+	ct, err := crontab.New(context.Background(), crontab.WithClock(clock), crontab.WithStore(store, registry))
+	assert.NoError(err)
+
+	// Test.
+	err = ct.SubmitEvery("every", time.Minute, nil, crontab.WithPersistence("count"))
+	assert.NoError(err)
+	err = ct.SubmitAt("once", start.Add(90*time.Minute), nil, crontab.WithPersistence("count"))
+	assert.NoError(err)
+	err = ct.SubmitCron("cron", "TZ=Europe/Berlin 0 4 * * *", nil, crontab.WithPersistence("count"))
+	assert.NoError(err)
+	err = ct.SubmitEvery("transient", time.Minute, func() error { return nil })
+	assert.NoError(err)
+	err = ct.SubmitEvery("unknown", time.Minute, nil, crontab.WithPersistence("unknown"))
+	assert.ErrorMatch(err, `job function 'unknown' is not registered`)
+
+	assert.Retry(func() bool { return clock.Timers() == 4 }, 100, 10*time.Millisecond)
+	clock.Advance(time.Minute)
+	assert.Equal(<-runC, "count")
+	assert.Retry(func() bool {
+		history, err := ct.History("every")
+		return err == nil && len(history) == 1
+	}, 100, 10*time.Millisecond)
+	assert.NoError(ct.Stop())
+
+	records, err := store.Load()
+	assert.NoError(err)
+	assert.Length(records, 3)
+	assert.Equal(records[0].ID, "cron")
+	assert.Equal(records[0].Cron, "0 0 4 * * *")
+	assert.Equal(records[0].Location, "Europe/Berlin")
+	assert.Equal(records[1].ID, "every")
+	assert.Equal(records[1].LastRun, start.Add(time.Minute))
+	assert.Equal(records[2].ID, "once")
+	assert.True(records[2].LastRun.IsZero())
+
+	// Restart after a pause.
+	_, err = crontab.New(context.Background(), crontab.WithStore(store, crontab.Registry{}))
+	assert.ErrorMatch(err, `job ID 'cron' needs unregistered function 'count'`)
+
+	clock = timex.NewManualClock(start.Add(10 * time.Minute))
+	ct, err = crontab.New(context.Background(), crontab.WithClock(clock), crontab.WithStore(store, registry))
+	assert.NoError(err)
+	defer ct.Stop()
+	ids, err := ct.List()
+	assert.NoError(err)
+	assert.Equal(ids, []string{"cron", "every", "once"})
+	next, err := ct.Next("once")
+	assert.NoError(err)
+	assert.Equal(next, start.Add(90*time.Minute))
+	next, err = ct.Next("cron")
+	assert.NoError(err)
+	assert.Equal(next.UTC(), time.Date(2019, time.January, 2, 3, 0, 0, 0, time.UTC))
+
+	// Missed runs of the interval job are caught up once.
+	assert.Retry(func() bool {
+		if clock.Timers() == 3 {
+			clock.Advance(0)
+		}
+		next, err := ct.Next("every")
+		return err == nil && next.Equal(start.Add(11*time.Minute))
+	}, 100, 10*time.Millisecond)
+	assert.Equal(<-runC, "count")
+	assert.Length(runC, 0)
+
+	// Running once and revoking deletes the records.
+	clock.Set(start.Add(90 * time.Minute))
+	assert.Retry(func() bool {
+		records, err := store.Load()
+		return err == nil && len(records) == 2
+	}, 100, 10*time.Millisecond)
+	assert.NoError(ct.Revoke("cron"))
+	records, err = store.Load()
+	assert.NoError(err)
+	assert.Length(records, 1)
+	assert.Equal(records[0].ID, "every")
+}
+
+// TestRevokeStoreFailure tests that jobs stay when they cannot
+// be deleted from the store.
+func TestRevokeStoreFailure(t *testing.T) {
+	// Init.
+	assert := asserts.NewTesting(t, asserts.FailStop)
+	td := environments.NewTempDir(assert)
+	defer td.Restore()
+	store := &failingStore{
+		Store: crontab.NewFileStore(filepath.Join(td.String(), "jobs.json")),
+	}
+	runC := make(chan string, 10)
+	registry := crontab.Registry{
+		"count": func() error {
+			runC <- "count"
+			return nil
+		},
+	}
+	clock := timex.NewManualClock(time.Date(2019, time.January, 1, 3, 0, 0, 0, time.UTC))
+	ct, err := crontab.New(context.Background(), crontab.WithClock(clock), crontab.WithStore(store, registry))
+	assert.NoError(err)
+	defer ct.Stop()
+
+	// Test.
+	err = ct.SubmitEvery("every", time.Minute, nil, crontab.WithPersistence("count"))
+	assert.NoError(err)
+
+	store.fail(errors.New("ouch"))
+	err = ct.Revoke("every")
+	assert.ErrorMatch(err, "ouch")
+	ids, err := ct.List()
+	assert.NoError(err)
+	assert.Equal(ids, []string{"every"})
+	assert.Retry(func() bool { return clock.Timers() == 1 }, 100, 10*time.Millisecond)
+	clock.Advance(time.Minute)
+	assert.Equal(<-runC, "count")
+	assert.Retry(func() bool {
+		history, err := ct.History("every")
+		return err == nil && len(history) == 1
+	}, 100, 10*time.Millisecond)
+
+	store.fail(nil)
+	assert.NoError(ct.Revoke("every"))
+	ids, err = ct.List()
+	assert.NoError(err)
+	assert.Length(ids, 0)
+	records, err := store.Load()
+	assert.NoError(err)
+	assert.Length(records, 0)
+}
+
+// TestLocker tests the coordination of the runs of two crontabs
+// by a locker.
+func TestLocker(t *testing.T) {
+	// Init.
+	assert := asserts.NewTesting(t, asserts.FailStop)
+	start := time.Date(2019, time.January, 1, 12, 0, 0, 0, time.UTC)
+	locker := newTestLocker()
+	runC := make(chan string, 10)
+	clockA := timex.NewManualClock(start)
+	ctA, err := crontab.New(context.Background(), crontab.WithClock(clockA), crontab.WithLocker(locker))
+	assert.NoError(err)
+	defer ctA.Stop()
+	clockB := timex.NewManualClock(start)
+	ctB, err := crontab.New(context.Background(), crontab.WithClock(clockB), crontab.WithLocker(locker))
+	assert.NoError(err)
+	defer ctB.Stop()
+
+	_, err = crontab.New(context.Background(), crontab.WithLocker(nil))
+	assert.ErrorMatch(err, `invalid crontab option: locker is nil`)
+
+	// Test.
+	err = ctA.SubmitEvery("locked", time.Minute, func() error {
+		runC <- "a"
+		return nil
+	})
+	assert.NoError(err)
+	err = ctB.SubmitEvery("locked", time.Minute, func() error {
+		runC <- "b"
+		return nil
+	})
+	assert.NoError(err)
+
+	// First run is done by A, B skips it.
+	assert.Retry(func() bool { return clockA.Timers() == 1 && clockB.Timers() == 1 }, 100, 10*time.Millisecond)
+	clockA.Advance(time.Minute)
+	assert.Equal(<-runC, "a")
+	assert.Retry(func() bool { return clockA.Timers() == 1 }, 100, 10*time.Millisecond)
+	clockB.Advance(time.Minute)
+	assert.Retry(func() bool { return locker.attempts() == 2 }, 100, 10*time.Millisecond)
+
+	// Second run is done by B, A skips it.
+	assert.Retry(func() bool { return clockB.Timers() == 1 }, 100, 10*time.Millisecond)
+	clockB.Advance(time.Minute)
+	assert.Equal(<-runC, "b")
+	clockA.Advance(time.Minute)
+	assert.Retry(func() bool { return locker.attempts() == 4 }, 100, 10*time.Millisecond)
+	assert.Length(runC, 0)
+
+	historyA, err := ctA.History("locked")
+	assert.NoError(err)
+	assert.Length(historyA, 1)
+	assert.Equal(historyA[0].Token, int64(1))
+	historyB, err := ctB.History("locked")
+	assert.NoError(err)
+	assert.Length(historyB, 1)
+	assert.Equal(historyB[0].Token, int64(2))
+
+	// Failing locks don't run the job but keep it working.
+	locker.fail(errors.New("no connection"))
+	assert.Retry(func() bool { return clockA.Timers() == 1 }, 100, 10*time.Millisecond)
+	clockA.Advance(time.Minute)
+	assert.Retry(func() bool {
+		history, err := ctA.History("locked")
+		return err == nil && len(history) == 2
+	}, 100, 10*time.Millisecond)
+	historyA, err = ctA.History("locked")
+	assert.NoError(err)
+	assert.ErrorMatch(historyA[1].Err, `cannot lock run: no connection`)
+	assert.Length(runC, 0)
+	status, err := ctA.Status("locked")
+	assert.NoError(err)
+	assert.Equal(status, notifier.Working)
+}
+
+// TestLockerStaggered tests the coordination of the runs of interval
+// jobs by crontabs started at different times.
+func TestLockerStaggered(t *testing.T) {
+	// Init.
+	assert := asserts.NewTesting(t, asserts.FailStop)
+	start := time.Date(2019, time.January, 1, 12, 0, 0, 0, time.UTC)
+	locker := newTestLocker()
+	runC := make(chan string, 10)
+	clockA := timex.NewManualClock(start)
+	ctA, err := crontab.New(context.Background(), crontab.WithClock(clockA), crontab.WithLocker(locker))
+	assert.NoError(err)
+	defer ctA.Stop()
+	clockB := timex.NewManualClock(start.Add(30 * time.Second))
+	ctB, err := crontab.New(context.Background(), crontab.WithClock(clockB), crontab.WithLocker(locker))
+	assert.NoError(err)
+	defer ctB.Stop()
+
+	// Test.
+	err = ctA.SubmitEvery("locked", time.Minute, func() error {
+		runC <- "a"
+		return nil
+	})
+	assert.NoError(err)
+	err = ctB.SubmitEvery("locked", time.Minute, func() error {
+		runC <- "b"
+		return nil
+	})
+	assert.NoError(err)
+
+	// Run at 12:01:00 by A, B skips it at 12:01:30.
+	assert.Retry(func() bool { return clockA.Timers() == 1 && clockB.Timers() == 1 }, 100, 10*time.Millisecond)
+	clockA.Advance(time.Minute)
+	assert.Equal(<-runC, "a")
+	assert.Retry(func() bool { return clockA.Timers() == 1 }, 100, 10*time.Millisecond)
+	clockB.Advance(time.Minute)
+	assert.Retry(func() bool { return locker.attempts() == 2 }, 100, 10*time.Millisecond)
+
+	// Run at 12:02:30 by B, A skips it at 12:02:00 afterwards.
+	assert.Retry(func() bool { return clockB.Timers() == 1 }, 100, 10*time.Millisecond)
+	clockB.Advance(time.Minute)
+	assert.Equal(<-runC, "b")
+	clockA.Advance(time.Minute)
+	assert.Retry(func() bool { return locker.attempts() == 4 }, 100, 10*time.Millisecond)
+	assert.Length(runC, 0)
+}
+
+// TestLockerContextJob tests the passing of the fencing token to
+// context jobs and their cancellation when the lock is lost.
+func TestLockerContextJob(t *testing.T) {
+	// Init.
+	assert := asserts.NewTesting(t, asserts.FailStop)
+	start := time.Date(2019, time.January, 1, 12, 0, 0, 0, time.UTC)
+	locker := newTestLocker()
+	tokenC := make(chan int64, 10)
+	clock := timex.NewManualClock(start)
+	ct, err := crontab.New(context.Background(), crontab.WithClock(clock), crontab.WithLocker(locker))
+	assert.NoError(err)
+	defer ct.Stop()
+
+	// Test.
+	err = ct.SubmitEvery("locked", time.Minute, nil, crontab.WithContextJob(func(ctx context.Context) error {
+		token, ok := crontab.RunToken(ctx)
+		assert.True(ok)
+		tokenC <- token
+		<-ctx.Done()
+		return ctx.Err()
+	}), crontab.WithOverlap(crontab.OverlapQueue))
+	assert.NoError(err)
+
+	assert.Retry(func() bool { return clock.Timers() == 1 }, 100, 10*time.Millisecond)
+	clock.Advance(time.Minute)
+	assert.Equal(<-tokenC, int64(1))
+	locker.lose()
+	assert.Retry(func() bool {
+		history, err := ct.History("locked")
+		return err == nil && len(history) == 1
+	}, 100, 10*time.Millisecond)
+	history, err := ct.History("locked")
+	assert.NoError(err)
+	assert.Equal(history[0].Token, int64(1))
+	assert.ErrorMatch(history[0].Err, `job canceled by lost lock: context canceled`)
+	status, err := ct.Status("locked")
+	assert.NoError(err)
+	assert.Equal(status, notifier.Working)
+
+	// Invalid context jobs.
+	err = ct.SubmitEvery("invalid", time.Minute, func() error { return nil }, crontab.WithContextJob(func(ctx context.Context) error {
+		return nil
+	}))
+	assert.ErrorMatch(err, `job ID 'invalid' has a function and a context job`)
+	err = ct.SubmitEvery("invalid", time.Minute, nil, crontab.WithContextJob(nil))
+	assert.ErrorMatch(err, `invalid job option: context job is nil`)
+	err = ct.SubmitEvery("invalid", time.Minute, nil, crontab.WithContextJob(func(ctx context.Context) error {
+		return nil
+	}), crontab.WithPersistence("invalid"))
+	assert.ErrorMatch(err, `invalid job option: context job cannot be persisted`)
+}
+
+//--------------------
+// HELPERS
+//--------------------
+
+// testLocker coordinates crontabs in the same process.
+type testLocker struct {
+	mu    sync.Mutex
+	token int64
+	count int
+	err   error
+	held  map[string]bool
+	done  map[string]time.Time
+	last  *testLock
+}
+
+// newTestLocker creates a test locker.
+func newTestLocker() *testLocker {
+	return &testLocker{
+		held: make(map[string]bool),
+		done: make(map[string]time.Time),
+	}
+}
+
+// Lock implements crontab.Locker.
+func (tl *testLocker) Lock(id string, due time.Time) (crontab.Lock, error) {
+	tl.mu.Lock()
+	defer tl.mu.Unlock()
+	tl.count++
+	if tl.err != nil {
+		return nil, tl.err
+	}
+	if tl.held[id] || !tl.done[id].Before(due) {
+		return nil, nil
+	}
+	tl.token++
+	tl.held[id] = true
+	tl.last = &testLock{
+		locker: tl,
+		id:     id,
+		due:    due,
+		token:  tl.token,
+		lostc:  make(chan struct{}),
+	}
+	return tl.last, nil
+}
+
+// attempts returns the number of locking attempts.
+func (tl *testLocker) attempts() int {
+	tl.mu.Lock()
+	defer tl.mu.Unlock()
+	return tl.count
+}
+
+// fail lets the next locking attempts fail.
+func (tl *testLocker) fail(err error) {
+	tl.mu.Lock()
+	defer tl.mu.Unlock()
+	tl.err = err
+}
+
+// lose lets the last acquired lock be lost.
+func (tl *testLocker) lose() {
+	tl.mu.Lock()
+	defer tl.mu.Unlock()
+	delete(tl.held, tl.last.id)
+	close(tl.last.lostc)
+}
+
+// testLock is a lock of the test locker.
+type testLock struct {
+	locker *testLocker
+	id     string
+	due    time.Time
+	token  int64
+	lostc  chan struct{}
+}
+
+// Token implements crontab.Lock.
+func (tl *testLock) Token() int64 {
+	return tl.token
+}
+
+// Lost implements crontab.Lock.
+func (tl *testLock) Lost() <-chan struct{} {
+	return tl.lostc
+}
+
+// Unlock implements crontab.Lock.
+func (tl *testLock) Unlock() error {
+	tl.locker.mu.Lock()
+	defer tl.locker.mu.Unlock()
+	select {
+	case <-tl.lostc:
+		return errors.New("lock has been lost")
+	default:
+	}
+	delete(tl.locker.held, tl.id)
+	tl.locker.done[tl.id] = tl.due
+	return nil
+}
+
+// failingStore is a store whose deletions can fail.
+type failingStore struct {
+	crontab.Store
+
+	mu  sync.Mutex
+	err error
+}
+
+func (fs *failingStore) Delete(id string) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if fs.err != nil {
+		return fs.err
+	}
+	return fs.Store.Delete(id)
+}
+
+func (fs *failingStore) fail(err error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	fs.err = err
+}
+
 // EOF
@@ -6,9 +6,9 @@
 // by the new BSD license.
 
 // Package crontab provides the ability to run functions once at a given time,
-// every given interval, every given interval after a given time, or every
-// given interval after a given pause. The jobs are added by the different
-// submit commands.
+// every given interval, every given interval after a given time, every
+// given interval after a given pause, or following a cron expression. The
+// jobs are added by the different submit commands.
 //
 //     at := time.Date(2018, time.October, 31, 12, 0, 0, 0, time.UTC)
 //     every := 24 * time.Hour
@@ -33,7 +33,73 @@
 //         return nil
 //     })
 //
+// Jobs can also be scheduled with cron expressions. They have 5 fields
+// for minute, hour, day of month, month, and day of week, or 6 fields
+// with a leading second. Time zones are set with a "TZ=" prefix, runs
+// are computed based on the wall clock time of the location.
+//
+//     crontab.SubmitCron("id-5", "TZ=Europe/Berlin */5 9-17 * * MON-FRI", func() error {
+//         log.Printf("I'm executed every five minutes during office hours.")
+//         return nil
+//     })
+//
+// The time of the next run of a job is returned by crontab.Next(anyID).
 // Jobs can be deleted with crontab.Revoke(anyID) again.
+//
+// The package functions use a crontab running for the whole process. Own
+// instances are created with crontab.New() and run until their context is
+// done or they are stopped. They provide the same methods.
+//
+//     ct, err := crontab.New(ctx, crontab.WithClock(clock))
+//     ...
+//     err = ct.SubmitEvery("id-6", time.Minute, job,
+//         crontab.WithOverlap(crontab.OverlapQueue),
+//         crontab.WithCatchUp(crontab.CatchUpSkip),
+//         crontab.WithHistory(50),
+//     )
+//
+// Job options control if a run being due while the previous one still
+// runs is skipped, queued, or started concurrently, and if runs missed
+// e.g. during a pause of the process are run once, skipped, or all run.
+// The last runs with their start, duration, and error are returned by
+// History(anyID).
+//
+// Jobs can be made persistent to survive restarts. Here the crontab needs
+// a store and a registry of the job functions. The jobs are stored with
+// the names of their functions and the times of their last runs. When the
+// crontab is created again the jobs are restored and continue after their
+// last runs. Stores are provided for files, CouchDB, and Redis.
+//
+//     registry := crontab.Registry{"cleanup": cleanup}
+//     store := crontab.NewFileStore("/var/lib/myapp/crontab.json")
+//     ct, err := crontab.New(ctx, crontab.WithStore(store, registry))
+//     ...
+//     err = ct.SubmitAt("cleanup-0300", at, nil, crontab.WithPersistence("cleanup"))
+//
+// When several replicas of a service run the same jobs a locker lets
+// only one of them execute each due run. Runs of interval jobs are
+// locked per slot of the interval, so replicas started at different
+// times share them. The Redis locker sets a lock per job together with
+// a fencing token, renews it while the job runs, and records the done
+// runs. The fencing token of a run is part of its history.
+//
+//     locker, err := crontab.NewRedisLocker(db, "myapp:crontab", 30*time.Second, timex.RealClock())
+//     ...
+//     ct, err := crontab.New(ctx, crontab.WithLocker(locker))
+//
+// Jobs set with the option WithContextJob receive the context of their
+// run. It carries the fencing token and is canceled when the lock is
+// lost, e.g. because it cannot be renewed.
+//
+//     err = ct.SubmitEvery("export", time.Hour, nil, crontab.WithContextJob(func(ctx context.Context) error {
+//         token, _ := crontab.RunToken(ctx)
+//         return export(ctx, token)
+//     }))
+//
+// The crontab waits for the runs using the real clock. For tests it
+// can be replaced, e.g. by a timex.ManualClock.
+//
+//     crontab.Configure(crontab.WithClock(clock))
 package crontab // import "tideland.dev/go/together/crontab"
 
 // EOF
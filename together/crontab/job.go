@@ -0,0 +1,550 @@
+// Tideland Go Library - Together - CronTab
+//
+// Copyright (C) 2017-2019 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license.
+
+package crontab // import "tideland.dev/go/together/crontab"
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"tideland.dev/go/dsa/timex"
+	"tideland.dev/go/together/loop"
+	"tideland.dev/go/together/notifier"
+)
+
+//--------------------
+// POLICIES
+//--------------------
+
+// OverlapPolicy defines how a job is handled when it is due while
+// its previous run hasn't finished yet.
+type OverlapPolicy int
+
+// List of overlap policies.
+const (
+	// OverlapSkip skips the due run.
+	OverlapSkip OverlapPolicy = iota + 1
+
+	// OverlapQueue starts the due run after the previous one
+	// finished.
+	OverlapQueue
+
+	// OverlapConcurrent starts the due run concurrently to the
+	// previous one.
+	OverlapConcurrent
+)
+
+// CatchUpPolicy defines how a job is handled when runs have been
+// missed, e.g. because the process has been paused.
+type CatchUpPolicy int
+
+// List of catch-up policies.
+const (
+	// CatchUpOnce runs the job once for all missed runs.
+	CatchUpOnce CatchUpPolicy = iota + 1
+
+	// CatchUpSkip drops the missed runs.
+	CatchUpSkip
+
+	// CatchUpAll runs the job for each missed run.
+	CatchUpAll
+)
+
+// Defaults of the job options.
+const (
+	DefaultOverlapPolicy = OverlapSkip
+	DefaultCatchUpPolicy = CatchUpOnce
+	DefaultHistoryLen    = 10
+)
+
+//--------------------
+// JOB OPTIONS
+//--------------------
+
+// jobConfig contains the settings of a job.
+type jobConfig struct {
+	overlap    OverlapPolicy
+	catchUp    CatchUpPolicy
+	historyLen int
+	name       string
+	job        func(ctx context.Context) error
+}
+
+// JobOption defines the signature of a job option setting function.
+type JobOption func(cfg *jobConfig) error
+
+// WithOverlap sets the policy for runs of the job being due while
+// the previous one still runs.
+func WithOverlap(policy OverlapPolicy) JobOption {
+	return func(cfg *jobConfig) error {
+		if policy < OverlapSkip || policy > OverlapConcurrent {
+			return fmt.Errorf("invalid job option: overlap policy %d", policy)
+		}
+		cfg.overlap = policy
+		return nil
+	}
+}
+
+// WithCatchUp sets the policy for missed runs of the job.
+func WithCatchUp(policy CatchUpPolicy) JobOption {
+	return func(cfg *jobConfig) error {
+		if policy < CatchUpOnce || policy > CatchUpAll {
+			return fmt.Errorf("invalid job option: catch-up policy %d", policy)
+		}
+		cfg.catchUp = policy
+		return nil
+	}
+}
+
+// WithHistory sets the number of runs kept in the history of
+// the job.
+func WithHistory(length int) JobOption {
+	return func(cfg *jobConfig) error {
+		if length < 0 {
+			return fmt.Errorf("invalid job option: history length %d", length)
+		}
+		cfg.historyLen = length
+		return nil
+	}
+}
+
+// WithPersistence lets the crontab store the job so that it survives
+// restarts. The name is the one of the job function in the registry
+// of the crontab. Here it is looked up when the job is restored. The
+// crontab needs a store.
+func WithPersistence(name string) JobOption {
+	return func(cfg *jobConfig) error {
+		if name == "" {
+			return fmt.Errorf("invalid job option: persistence name is empty")
+		}
+		cfg.name = name
+		return nil
+	}
+}
+
+// WithContextJob sets a job receiving the context of each run. It is
+// executed instead of the function passed at submission, which has to
+// be nil. The context carries the fencing token of the run, see
+// RunToken(). It is canceled when the lock of the run is lost or the
+// job is stopped. Context jobs cannot be persisted.
+func WithContextJob(j func(ctx context.Context) error) JobOption {
+	return func(cfg *jobConfig) error {
+		if j == nil {
+			return fmt.Errorf("invalid job option: context job is nil")
+		}
+		cfg.job = j
+		return nil
+	}
+}
+
+// newJobConfig creates the configuration of a job.
+func newJobConfig(options []JobOption) (*jobConfig, error) {
+	cfg := &jobConfig{
+		overlap:    DefaultOverlapPolicy,
+		catchUp:    DefaultCatchUpPolicy,
+		historyLen: DefaultHistoryLen,
+	}
+	for _, option := range options {
+		if err := option(cfg); err != nil {
+			return nil, err
+		}
+	}
+	if cfg.job != nil && cfg.name != "" {
+		return nil, fmt.Errorf("invalid job option: context job cannot be persisted")
+	}
+	return cfg, nil
+}
+
+// plainJob lets a job without context be executed like a context
+// job. It returns nil for a nil job.
+func plainJob(j func() error) func(ctx context.Context) error {
+	if j == nil {
+		return nil
+	}
+	return func(ctx context.Context) error {
+		return j()
+	}
+}
+
+//--------------------
+// RUN
+//--------------------
+
+// runTokenKey is the context key of the fencing token of a run.
+type runTokenKey struct{}
+
+// RunToken returns the fencing token of the run of a context job if
+// the crontab has a locker. It can be passed to the resources written
+// by the job so that they reject writes of runs with lost locks.
+func RunToken(ctx context.Context) (int64, bool) {
+	token, ok := ctx.Value(runTokenKey{}).(int64)
+	return token, ok
+}
+
+// Run describes a finished run of a job. The token is the fencing
+// token of the lock if the crontab has a locker.
+type Run struct {
+	Start    time.Time
+	Duration time.Duration
+	Token    int64
+	Err      error
+}
+
+// runResult is sent by a finished run to the cronjob. The job is
+// not executed if the lock cannot be acquired, e.g. because another
+// crontab holds it or already did the run. Runs losing their lock
+// don't end the cronjob.
+type runResult struct {
+	run      Run
+	executed bool
+	lost     bool
+	lockErr  error
+	reason   interface{}
+}
+
+//--------------------
+// CRONJOB
+//--------------------
+
+// cronjob is responsible to run one job.
+type cronjob struct {
+	mu       sync.Mutex
+	id       string
+	clock    timex.Clock
+	cfg      *jobConfig
+	start    *time.Time
+	interval *time.Duration
+	schedule *schedule
+	next     time.Time
+	history  []Run
+	record   *JobRecord
+	store    Store
+	locker   Locker
+	job      func(ctx context.Context) error
+	loop     *loop.Loop
+	notifier *notifier.Notifier
+	rs       loop.Reasons
+}
+
+// newCronjob creates a new cronjob. A start time overrides the one
+// of the interval or schedule.
+func newCronjob(
+	id string,
+	clock timex.Clock,
+	cfg *jobConfig,
+	s *time.Time,
+	i *time.Duration,
+	sched *schedule,
+	j func(ctx context.Context) error,
+) *cronjob {
+	cj := &cronjob{
+		id:       id,
+		clock:    clock,
+		cfg:      cfg,
+		start:    s,
+		interval: i,
+		schedule: sched,
+		job:      j,
+		notifier: notifier.New(),
+		rs:       loop.MakeReasons(),
+	}
+	if s != nil {
+		cj.next = *s
+	} else {
+		cj.next = cj.following(clock.Now())
+	}
+	return cj
+}
+
+// persistIn lets the cronjob update the record in the store
+// after each run.
+func (cj *cronjob) persistIn(store Store, record *JobRecord) *cronjob {
+	cj.store = store
+	cj.record = record
+	return cj
+}
+
+// lockWith lets the cronjob acquire a lock of the locker
+// before each run. It may be nil.
+func (cj *cronjob) lockWith(locker Locker) *cronjob {
+	cj.locker = locker
+	return cj
+}
+
+// goWork starts the goroutine of the cronjob.
+func (cj *cronjob) goWork(ctx context.Context) *cronjob {
+	options := []loop.Option{
+		loop.WithRecoverer(cj.recoverer),
+		loop.WithNotifier(cj.notifier),
+	}
+	if ctx != nil {
+		options = append(options, loop.WithContext(ctx))
+	}
+	cj.loop = loop.New(cj.worker, options...).Go()
+	<-cj.notifier.Working()
+	return cj
+}
+
+// stop ends the cronjob goroutine.
+func (cj *cronjob) stop() error {
+	err := cj.loop.Stop(nil)
+	if loop.IsErrLoopNotWorking(err) {
+		return nil
+	}
+	return err
+}
+
+// status returns the status of the cronjob.
+func (cj *cronjob) status() notifier.Status {
+	return cj.loop.Status()
+}
+
+// nextRun returns the time of the next run. It is zero if
+// there is none.
+func (cj *cronjob) nextRun() time.Time {
+	cj.mu.Lock()
+	defer cj.mu.Unlock()
+	return cj.next
+}
+
+// setNextRun sets the time of the next run.
+func (cj *cronjob) setNextRun(next time.Time) {
+	cj.mu.Lock()
+	defer cj.mu.Unlock()
+	cj.next = next
+}
+
+// following returns the time of the run following the given
+// time. It is zero if there is none.
+func (cj *cronjob) following(t time.Time) time.Time {
+	return following(t, cj.interval, cj.schedule)
+}
+
+// persist updates the record of a persistent job after a run. Jobs
+// running only once are deleted.
+func (cj *cronjob) persist(run Run) error {
+	if cj.store == nil {
+		return nil
+	}
+	if cj.interval == nil && cj.schedule == nil {
+		return cj.store.Delete(cj.id)
+	}
+	cj.record.LastRun = run.Start
+	return cj.store.Save(*cj.record)
+}
+
+// runs returns a copy of the history.
+func (cj *cronjob) runs() []Run {
+	cj.mu.Lock()
+	defer cj.mu.Unlock()
+	return append([]Run{}, cj.history...)
+}
+
+// addRun adds a run to the bounded history.
+func (cj *cronjob) addRun(run Run) {
+	cj.mu.Lock()
+	defer cj.mu.Unlock()
+	if cj.cfg.historyLen == 0 {
+		return
+	}
+	cj.history = append(cj.history, run)
+	if len(cj.history) > cj.cfg.historyLen {
+		cj.history = cj.history[len(cj.history)-cj.cfg.historyLen:]
+	}
+}
+
+// worker runs the cronjob.
+func (cj *cronjob) worker(c *notifier.Closer) error {
+	resultc := make(chan runResult)
+	donec := make(chan struct{})
+	defer close(donec)
+	running := 0
+	var queued []time.Time
+	launch := func(due time.Time) {
+		running++
+		go cj.run(due, resultc, donec)
+	}
+	var timerc <-chan time.Time
+	for {
+		next := cj.nextRun()
+		switch {
+		case next.IsZero():
+			if running == 0 && len(queued) == 0 {
+				return nil
+			}
+		case timerc == nil:
+			timerc = cj.clock.After(next.Sub(cj.clock.Now()))
+		}
+		select {
+		case <-c.Done():
+			return nil
+		case <-timerc:
+			timerc = nil
+			// Check for missed runs.
+			now := cj.clock.Now()
+			following := cj.following(next)
+			missed := !following.IsZero() && !following.After(now)
+			switch {
+			case missed && cj.cfg.catchUp == CatchUpSkip:
+				cj.setNextRun(cj.following(now))
+				continue
+			case missed && cj.cfg.catchUp == CatchUpAll:
+				cj.setNextRun(following)
+			case now.After(next):
+				cj.setNextRun(cj.following(now))
+			default:
+				cj.setNextRun(following)
+			}
+			// Start the run depending on the overlapping.
+			switch {
+			case running == 0 || cj.cfg.overlap == OverlapConcurrent:
+				launch(next)
+			case cj.cfg.overlap == OverlapQueue:
+				queued = append(queued, next)
+			}
+		case result := <-resultc:
+			running--
+			run := result.run
+			if result.executed {
+				if err := cj.persist(run); err != nil && run.Err == nil {
+					run.Err = fmt.Errorf("cannot persist run: %v", err)
+				}
+			}
+			if result.lockErr != nil && run.Err == nil {
+				run.Err = result.lockErr
+			}
+			if result.executed || result.lockErr != nil {
+				cj.addRun(run)
+			}
+			if result.reason != nil {
+				// Let the recoverer handle the panic.
+				panic(result.reason)
+			}
+			if result.run.Err != nil && !result.lost {
+				return result.run.Err
+			}
+			if len(queued) > 0 {
+				due := queued[0]
+				queued = queued[1:]
+				launch(due)
+			}
+		}
+	}
+}
+
+// run executes the job once for the given due time and sends the
+// result to the worker. With a locker the job is only executed if
+// the lock for the due time can be acquired. The due times of interval
+// jobs depend on the start of each crontab, so here the slot of the
+// due time is locked instead. The context of the job is canceled when
+// the lock is lost or the worker ends.
+func (cj *cronjob) run(due time.Time, resultc chan runResult, donec chan struct{}) {
+	result := runResult{
+		run: Run{
+			Start: cj.clock.Now(),
+		},
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var lock Lock
+	defer func() {
+		if r := recover(); r != nil {
+			result.reason = r
+			result.run.Err = fmt.Errorf("job panicked: %v", r)
+		}
+		if lock != nil {
+			if err := lock.Unlock(); err != nil {
+				result.lockErr = fmt.Errorf("cannot unlock run: %v", err)
+			}
+		}
+		result.run.Duration = cj.clock.Since(result.run.Start)
+		select {
+		case resultc <- result:
+		case <-donec:
+		}
+	}()
+	if cj.locker != nil {
+		var err error
+		lock, err = cj.locker.Lock(cj.id, cj.slot(due))
+		switch {
+		case err != nil:
+			result.lockErr = fmt.Errorf("cannot lock run: %v", err)
+			return
+		case lock == nil:
+			return
+		}
+		result.run.Token = lock.Token()
+		ctx = context.WithValue(ctx, runTokenKey{}, result.run.Token)
+	}
+	var lostc <-chan struct{}
+	if lock != nil {
+		lostc = lock.Lost()
+	}
+	go func() {
+		select {
+		case <-lostc:
+			cancel()
+		case <-donec:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	result.executed = true
+	result.run.Err = cj.job(ctx)
+	select {
+	case <-lostc:
+		result.lost = true
+		if result.run.Err != nil {
+			result.run.Err = fmt.Errorf("job canceled by lost lock: %v", result.run.Err)
+		}
+	default:
+	}
+}
+
+// slot returns the due time of interval jobs rounded down to the
+// interval since the zero time. So the same runs of crontabs started
+// at different times share one slot. Other due times are returned
+// unchanged.
+func (cj *cronjob) slot(due time.Time) time.Time {
+	if cj.interval == nil || cj.schedule != nil {
+		return due
+	}
+	return due.Truncate(*cj.interval)
+}
+
+// following returns the time of the run following the given time
+// based on an interval or a schedule. It is zero if there is none.
+func following(t time.Time, every *time.Duration, sched *schedule) time.Time {
+	switch {
+	case sched != nil:
+		return sched.next(t)
+	case every != nil:
+		return t.Add(*every)
+	default:
+		return time.Time{}
+	}
+}
+
+// recoverer allows the cronjob to survive panics.
+func (cj *cronjob) recoverer(reason interface{}) error {
+	cj.rs = cj.rs.Append(reason)
+	if cj.rs.Frequency(5, 10*time.Millisecond) {
+		return fmt.Errorf("too high error frequency: %v", cj.rs)
+	}
+	if cj.rs.Len() >= 10 {
+		return fmt.Errorf("too many errors: %v", cj.rs)
+	}
+	return nil
+}
+
+// EOF
@@ -0,0 +1,50 @@
+// Tideland Go Library - Together - CronTab
+//
+// Copyright (C) 2017-2019 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license.
+
+package crontab // import "tideland.dev/go/together/crontab"
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"time"
+)
+
+//--------------------
+// LOCKER
+//--------------------
+
+// Locker coordinates the runs of jobs between multiple crontabs, e.g.
+// in the replicas of a service. Only the crontab acquiring the lock
+// for a due run executes it.
+type Locker interface {
+	// Lock tries to acquire the lock for the run of the job due at
+	// the given time. For interval jobs it is the due time rounded
+	// down to the interval, so it's the same for all crontabs. Lock
+	// returns nil if the lock is held by another crontab or if the
+	// run already has been done.
+	Lock(id string, due time.Time) (Lock, error)
+}
+
+// Lock is an acquired lock for one run of a job.
+type Lock interface {
+	// Token returns the fencing token of the lock. It increases
+	// with each acquired lock of a job.
+	Token() int64
+
+	// Lost returns a channel which is closed when the lock is lost
+	// before it is unlocked, e.g. because it cannot be renewed. The
+	// context of the running job is canceled then.
+	Lost() <-chan struct{}
+
+	// Unlock marks the run as done and releases the lock. It
+	// returns an error if the lock has been lost meanwhile.
+	Unlock() error
+}
+
+// EOF
@@ -0,0 +1,298 @@
+// Tideland Go Library - Together - CronTab
+//
+// Copyright (C) 2017-2019 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license.
+
+package crontab // import "tideland.dev/go/together/crontab"
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"tideland.dev/go/db/redis"
+	"tideland.dev/go/dsa/timex"
+)
+
+//--------------------
+// CONSTANTS
+//--------------------
+
+// Scripts of the Redis locker. The lock script only takes a fencing
+// token if the lock is free and the run is not done yet. The others
+// check the fencing token stored in the lock before changing it.
+const (
+	redisLockScript = `
+local done = redis.call("get", KEYS[3])
+if done and tonumber(done) >= tonumber(ARGV[1]) then
+	return 0
+end
+if redis.call("exists", KEYS[1]) == 1 then
+	return 0
+end
+local token = redis.call("incr", KEYS[2])
+redis.call("set", KEYS[1], token, "px", ARGV[2])
+return token`
+
+	redisRenewScript = `
+if redis.call("get", KEYS[1]) == ARGV[1] then
+	return redis.call("pexpire", KEYS[1], ARGV[2])
+end
+return 0`
+
+	redisUnlockScript = `
+if redis.call("get", KEYS[1]) ~= ARGV[1] then
+	return 0
+end
+redis.call("set", KEYS[2], ARGV[2])
+redis.call("del", KEYS[1])
+return 1`
+)
+
+//--------------------
+// REDIS STORE
+//--------------------
+
+// redisStore keeps the job records as JSON in a Redis hash.
+type redisStore struct {
+	db  *redis.Database
+	key string
+}
+
+// NewRedisStore returns a store keeping the job records in the
+// Redis hash with the given key.
+func NewRedisStore(db *redis.Database, key string) Store {
+	return &redisStore{
+		db:  db,
+		key: key,
+	}
+}
+
+// Load implements Store.
+func (rs *redisStore) Load() ([]JobRecord, error) {
+	conn, err := rs.db.Connection()
+	if err != nil {
+		return nil, fmt.Errorf("cannot connect job store: %v", err)
+	}
+	defer conn.Return()
+	hash, err := conn.DoHash("hgetall", rs.key)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read job store: %v", err)
+	}
+	var records []JobRecord
+	for id, value := range hash {
+		var record JobRecord
+		if err := json.Unmarshal(value.Bytes(), &record); err != nil {
+			return nil, fmt.Errorf("cannot unmarshal job record '%s': %v", id, err)
+		}
+		records = append(records, record)
+	}
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].ID < records[j].ID
+	})
+	return records, nil
+}
+
+// Save implements Store.
+func (rs *redisStore) Save(record JobRecord) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("cannot marshal job record '%s': %v", record.ID, err)
+	}
+	conn, err := rs.db.Connection()
+	if err != nil {
+		return fmt.Errorf("cannot connect job store: %v", err)
+	}
+	defer conn.Return()
+	if _, err := conn.Do("hset", rs.key, record.ID, data); err != nil {
+		return fmt.Errorf("cannot write job record '%s': %v", record.ID, err)
+	}
+	return nil
+}
+
+// Delete implements Store.
+func (rs *redisStore) Delete(id string) error {
+	conn, err := rs.db.Connection()
+	if err != nil {
+		return fmt.Errorf("cannot connect job store: %v", err)
+	}
+	defer conn.Return()
+	if _, err := conn.Do("hdel", rs.key, id); err != nil {
+		return fmt.Errorf("cannot delete job record '%s': %v", id, err)
+	}
+	return nil
+}
+
+//--------------------
+// REDIS LOCKER
+//--------------------
+
+// MinRedisLockTTL is the minimum TTL of the locks of the Redis locker.
+// The locks are renewed after a third of it.
+const MinRedisLockTTL = 3 * time.Millisecond
+
+// redisLocker coordinates the runs of jobs with Redis locks.
+type redisLocker struct {
+	db     *redis.Database
+	prefix string
+	ttl    time.Duration
+	clock  timex.Clock
+}
+
+// NewRedisLocker returns a locker using Redis. The lock of a job is
+// set with the key "<prefix>:<id>" and expires after the TTL. It is
+// renewed using the clock while the job runs, so it should be the one
+// of the crontab. The fencing tokens are counted with the key
+// "<prefix>:<id>:fence", the time of the last done run is stored in
+// milliseconds with the key "<prefix>:<id>:done".
+func NewRedisLocker(db *redis.Database, prefix string, ttl time.Duration, clock timex.Clock) (Locker, error) {
+	if ttl < MinRedisLockTTL {
+		return nil, fmt.Errorf("invalid job locker TTL %v, minimum is %v", ttl, MinRedisLockTTL)
+	}
+	if clock == nil {
+		return nil, fmt.Errorf("invalid job locker clock: clock is nil")
+	}
+	return &redisLocker{
+		db:     db,
+		prefix: prefix,
+		ttl:    ttl,
+		clock:  clock,
+	}, nil
+}
+
+// Lock implements Locker.
+func (rl *redisLocker) Lock(id string, due time.Time) (Lock, error) {
+	key := rl.prefix + ":" + id
+	conn, err := rl.db.Connection()
+	if err != nil {
+		return nil, fmt.Errorf("cannot connect job locker: %v", err)
+	}
+	defer conn.Return()
+	token, err := conn.DoInt("eval", redisLockScript, 3, key, key+":fence", key+":done", unixMillis(due), rl.ttlMillis())
+	if err != nil {
+		return nil, fmt.Errorf("cannot lock job '%s': %v", id, err)
+	}
+	if token == 0 {
+		// Lock is held or run already has been done.
+		return nil, nil
+	}
+	rlk := &redisLock{
+		locker: rl,
+		id:     id,
+		key:    key,
+		token:  int64(token),
+		due:    due,
+		lostc:  make(chan struct{}),
+		stopc:  make(chan struct{}),
+		donec:  make(chan struct{}),
+	}
+	go rlk.renew()
+	return rlk, nil
+}
+
+// ttlMillis returns the TTL of the locks in milliseconds.
+func (rl *redisLocker) ttlMillis() int64 {
+	return rl.ttl.Nanoseconds() / int64(time.Millisecond)
+}
+
+// redisLock is an acquired Redis lock.
+type redisLock struct {
+	mu     sync.Mutex
+	locker *redisLocker
+	id     string
+	key    string
+	token  int64
+	due    time.Time
+	lost   error
+	lostc  chan struct{}
+	stopc  chan struct{}
+	donec  chan struct{}
+}
+
+// Token implements Lock.
+func (rlk *redisLock) Token() int64 {
+	return rlk.token
+}
+
+// Lost implements Lock.
+func (rlk *redisLock) Lost() <-chan struct{} {
+	return rlk.lostc
+}
+
+// Unlock implements Lock.
+func (rlk *redisLock) Unlock() error {
+	close(rlk.stopc)
+	<-rlk.donec
+	rlk.mu.Lock()
+	lost := rlk.lost
+	rlk.mu.Unlock()
+	if lost != nil {
+		return lost
+	}
+	done := strconv.FormatInt(unixMillis(rlk.due), 10)
+	return rlk.eval(redisUnlockScript, []string{rlk.key, rlk.key + ":done"}, done)
+}
+
+// renew extends the TTL of the lock until it is unlocked. If this
+// fails the lock is signalled as lost.
+func (rlk *redisLock) renew() {
+	defer close(rlk.donec)
+	ticker := rlk.locker.clock.NewTicker(rlk.locker.ttl / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-rlk.stopc:
+			return
+		case <-ticker.C():
+			if err := rlk.eval(redisRenewScript, []string{rlk.key}, rlk.locker.ttlMillis()); err != nil {
+				rlk.mu.Lock()
+				rlk.lost = err
+				rlk.mu.Unlock()
+				close(rlk.lostc)
+				return
+			}
+		}
+	}
+}
+
+// eval executes one of the locker scripts with the keys, the token
+// as first argument, and the further arguments. A result of 0 means
+// the lock has been lost.
+func (rlk *redisLock) eval(script string, keys []string, args ...interface{}) error {
+	conn, err := rlk.locker.db.Connection()
+	if err != nil {
+		return fmt.Errorf("cannot connect job locker: %v", err)
+	}
+	defer conn.Return()
+	cmdArgs := []interface{}{script, len(keys)}
+	for _, key := range keys {
+		cmdArgs = append(cmdArgs, key)
+	}
+	cmdArgs = append(cmdArgs, rlk.token)
+	cmdArgs = append(cmdArgs, args...)
+	result, err := conn.DoInt("eval", cmdArgs...)
+	if err != nil {
+		return fmt.Errorf("cannot access lock of job '%s': %v", rlk.id, err)
+	}
+	if result == 0 {
+		return fmt.Errorf("lock of job '%s' with token %d has been lost", rlk.id, rlk.token)
+	}
+	return nil
+}
+
+// unixMillis returns the time in milliseconds since the Unix epoch.
+// They can be compared exactly by the Lua scripts.
+func unixMillis(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Millisecond)
+}
+
+// EOF
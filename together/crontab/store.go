@@ -0,0 +1,152 @@
+// Tideland Go Library - Together - CronTab
+//
+// Copyright (C) 2017-2019 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license.
+
+package crontab // import "tideland.dev/go/together/crontab"
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+//--------------------
+// STORE
+//--------------------
+
+// JobRecord contains the definition of a persistent job and the
+// start time of its last run.
+type JobRecord struct {
+	ID         string        `json:"id"`
+	Name       string        `json:"name"`
+	At         time.Time     `json:"at"`
+	Every      time.Duration `json:"every"`
+	Cron       string        `json:"cron"`
+	Location   string        `json:"location"`
+	Overlap    OverlapPolicy `json:"overlap"`
+	CatchUp    CatchUpPolicy `json:"catch_up"`
+	HistoryLen int           `json:"history_len"`
+	LastRun    time.Time     `json:"last_run"`
+}
+
+// Store persists the records of jobs.
+type Store interface {
+	// Load returns all stored job records.
+	Load() ([]JobRecord, error)
+
+	// Save creates or updates the record of a job.
+	Save(record JobRecord) error
+
+	// Delete removes the record of a job.
+	Delete(id string) error
+}
+
+// Registry maps names to job functions. It is used to bind the
+// stored jobs to their functions again after a restart.
+type Registry map[string]func() error
+
+//--------------------
+// FILE STORE
+//--------------------
+
+// fileStore keeps the job records as JSON in a file.
+type fileStore struct {
+	mu   sync.Mutex
+	path string
+}
+
+// NewFileStore returns a store keeping the job records in the
+// file with the given path.
+func NewFileStore(path string) Store {
+	return &fileStore{
+		path: path,
+	}
+}
+
+// Load implements Store.
+func (fs *fileStore) Load() ([]JobRecord, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	records, err := fs.read()
+	if err != nil {
+		return nil, err
+	}
+	var rs []JobRecord
+	for _, record := range records {
+		rs = append(rs, record)
+	}
+	sort.Slice(rs, func(i, j int) bool {
+		return rs[i].ID < rs[j].ID
+	})
+	return rs, nil
+}
+
+// Save implements Store.
+func (fs *fileStore) Save(record JobRecord) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	records, err := fs.read()
+	if err != nil {
+		return err
+	}
+	records[record.ID] = record
+	return fs.write(records)
+}
+
+// Delete implements Store.
+func (fs *fileStore) Delete(id string) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	records, err := fs.read()
+	if err != nil {
+		return err
+	}
+	delete(records, id)
+	return fs.write(records)
+}
+
+// read reads the records from the file. A missing file
+// contains no records.
+func (fs *fileStore) read() (map[string]JobRecord, error) {
+	records := map[string]JobRecord{}
+	data, err := ioutil.ReadFile(fs.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return records, nil
+		}
+		return nil, fmt.Errorf("cannot read job store: %v", err)
+	}
+	if err := json.Unmarshal(data, &records); err != nil {
+		return nil, fmt.Errorf("cannot unmarshal job store: %v", err)
+	}
+	return records, nil
+}
+
+// write replaces the file with the records.
+func (fs *fileStore) write(records map[string]JobRecord) error {
+	data, err := json.MarshalIndent(records, "", "\t")
+	if err != nil {
+		return fmt.Errorf("cannot marshal job store: %v", err)
+	}
+	tmp := fs.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0600); err != nil {
+		return fmt.Errorf("cannot write job store: %v", err)
+	}
+	if err := os.Rename(tmp, fs.path); err != nil {
+		return fmt.Errorf("cannot write job store: %v", err)
+	}
+	return nil
+}
+
+// EOF
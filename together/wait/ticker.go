@@ -15,6 +15,8 @@ import (
 	"context"
 	"math/rand"
 	"time"
+
+	"tideland.dev/go/dsa/timex"
 )
 
 //--------------------
@@ -29,6 +31,36 @@ import (
 // working.
 type Ticker func(ctx context.Context) <-chan struct{}
 
+// TickerOption defines the signature of an option setting function
+// for the tickers.
+type TickerOption func(ts *tickerSettings)
+
+// tickerSettings contains the settings of a ticker.
+type tickerSettings struct {
+	clock timex.Clock
+}
+
+// WithTickerClock sets the clock a ticker uses for its intervals and
+// deadlines. By default it is the real clock.
+func WithTickerClock(clock timex.Clock) TickerOption {
+	return func(ts *tickerSettings) {
+		if clock != nil {
+			ts.clock = clock
+		}
+	}
+}
+
+// newTickerSettings creates the settings of a ticker.
+func newTickerSettings(options []TickerOption) *tickerSettings {
+	ts := &tickerSettings{
+		clock: timex.RealClock(),
+	}
+	for _, option := range options {
+		option(ts)
+	}
+	return ts
+}
+
 // TickChanger allows to work with changing intervals. The
 // current one is the argument, the next has to be returned. In
 // case the bool return value is false the ticker will stop.
@@ -38,8 +70,10 @@ type TickChanger func(in time.Duration) (out time.Duration, ok bool)
 // intervals. The given changer is responsible for the intervals and
 // if the ticker shall signal a stopping. The changer is called initially
 // with a duration of zero to allow the changer stopping the ticker even
-// before a first tick.
-func MakeGenericIntervalTicker(changer TickChanger) Ticker {
+// before a first tick. Options like WithTickerClock() allow to configure
+// the ticker, they are accepted by all ticker factories.
+func MakeGenericIntervalTicker(changer TickChanger, options ...TickerOption) Ticker {
+	ts := newTickerSettings(options)
 	return func(ctx context.Context) <-chan struct{} {
 		tickc := make(chan struct{})
 		interval := 0 * time.Millisecond
@@ -50,13 +84,22 @@ func MakeGenericIntervalTicker(changer TickChanger) Ticker {
 			if interval, ok = changer(interval); !ok {
 				return
 			}
-			// Ticker for the interval.
-			timer := time.NewTimer(interval)
-			defer timer.Stop()
+			// Timer for the interval.
+			timerc := make(chan struct{}, 1)
+			fire := func() {
+				select {
+				case timerc <- struct{}{}:
+				default:
+				}
+			}
+			timer := ts.clock.AfterFunc(interval, fire)
+			defer func() {
+				timer.Stop()
+			}()
 			// Loop sending signals.
 			for {
 				select {
-				case <-timer.C:
+				case <-timerc:
 					// One interval tick. Ignore if needed.
 					select {
 					case tickc <- struct{}{}:
@@ -70,7 +113,7 @@ func MakeGenericIntervalTicker(changer TickChanger) Ticker {
 				if interval, ok = changer(interval); !ok {
 					return
 				}
-				timer.Reset(interval)
+				timer = ts.clock.AfterFunc(interval, fire)
 			}
 		}()
 		return tickc
@@ -78,16 +121,16 @@ func MakeGenericIntervalTicker(changer TickChanger) Ticker {
 }
 
 // MakeIntervalTicker returns a ticker signalling in intervals.
-func MakeIntervalTicker(interval time.Duration) Ticker {
+func MakeIntervalTicker(interval time.Duration, options ...TickerOption) Ticker {
 	changer := func(_ time.Duration) (out time.Duration, ok bool) {
 		return interval, true
 	}
-	return MakeGenericIntervalTicker(changer)
+	return MakeGenericIntervalTicker(changer, options...)
 }
 
 // MakeMaxIntervalsTicker returns a ticker signalling in intervals. It
 // stops after a maximum number of signals.
-func MakeMaxIntervalsTicker(interval time.Duration, max int) Ticker {
+func MakeMaxIntervalsTicker(interval time.Duration, max int, options ...TickerOption) Ticker {
 	count := 0
 	changer := func(_ time.Duration) (out time.Duration, ok bool) {
 		count++
@@ -96,42 +139,45 @@ func MakeMaxIntervalsTicker(interval time.Duration, max int) Ticker {
 		}
 		return interval, true
 	}
-	return MakeGenericIntervalTicker(changer)
+	return MakeGenericIntervalTicker(changer, options...)
 }
 
 // MakeDeadlinedIntervalTicker returns a ticker signalling in intervals
 // and stopping after a deadline.
-func MakeDeadlinedIntervalTicker(interval time.Duration, deadline time.Time) Ticker {
+func MakeDeadlinedIntervalTicker(interval time.Duration, deadline time.Time, options ...TickerOption) Ticker {
+	ts := newTickerSettings(options)
 	changer := func(_ time.Duration) (out time.Duration, ok bool) {
-		if time.Now().After(deadline) {
+		if ts.clock.Now().After(deadline) {
 			return 0, false
 		}
 		return interval, true
 	}
-	return MakeGenericIntervalTicker(changer)
+	return MakeGenericIntervalTicker(changer, options...)
 }
 
 // MakeExpiringIntervalTicker returns a ticker signalling in intervals
 // and stopping after a timeout.
-func MakeExpiringIntervalTicker(interval, timeout time.Duration) Ticker {
-	deadline := time.Now().Add(timeout)
+func MakeExpiringIntervalTicker(interval, timeout time.Duration, options ...TickerOption) Ticker {
+	ts := newTickerSettings(options)
+	deadline := ts.clock.Now().Add(timeout)
 	changer := func(_ time.Duration) (out time.Duration, ok bool) {
-		if time.Now().After(deadline) {
+		if ts.clock.Now().After(deadline) {
 			return 0, false
 		}
 		return interval, true
 	}
-	return MakeGenericIntervalTicker(changer)
+	return MakeGenericIntervalTicker(changer, options...)
 }
 
 // MakeJitteringTicker returns a ticker signalling in jittering intervals. This
 // avoids converging on periadoc behavior during condition check. The returned
 // intervals jitter between the given interval and interval + factor * interval.
 // The ticker stops after reaching timeout.
-func MakeJitteringTicker(interval time.Duration, factor float64, timeout time.Duration) Ticker {
-	deadline := time.Now().Add(timeout)
+func MakeJitteringTicker(interval time.Duration, factor float64, timeout time.Duration, options ...TickerOption) Ticker {
+	ts := newTickerSettings(options)
+	deadline := ts.clock.Now().Add(timeout)
 	changer := func(_ time.Duration) (time.Duration, bool) {
-		if time.Now().After(deadline) {
+		if ts.clock.Now().After(deadline) {
 			return 0, false
 		}
 		if factor <= 0.0 {
@@ -139,7 +185,7 @@ func MakeJitteringTicker(interval time.Duration, factor float64, timeout time.Du
 		}
 		return interval + time.Duration(rand.Float64()*factor*float64(interval)), true
 	}
-	return MakeGenericIntervalTicker(changer)
+	return MakeGenericIntervalTicker(changer, options...)
 }
 
 // EOF
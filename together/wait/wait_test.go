@@ -17,6 +17,7 @@ import (
 	"time"
 
 	"tideland.dev/go/audit/asserts"
+	"tideland.dev/go/dsa/timex"
 	"tideland.dev/go/together/wait"
 )
 
@@ -543,4 +544,38 @@ func TestPanic(t *testing.T) {
 	assert.Equal(count, 5)
 }
 
+
+// TestPollWithClock tests the polling with a ticker using
+// a manual clock.
+func TestPollWithClock(t *testing.T) {
+	// Init.
+	assert := asserts.NewTesting(t, asserts.FailStop)
+	clock := timex.NewManualClock(time.Date(2019, time.January, 1, 12, 0, 0, 0, time.UTC))
+	ticker := wait.MakeExpiringIntervalTicker(time.Minute, time.Hour, wait.WithTickerClock(clock))
+	errc := make(chan error, 1)
+
+	// Test.
+	go func() {
+		errc <- wait.Poll(context.Background(), ticker, func() (bool, error) {
+			return false, nil
+		})
+	}()
+	assert.Retry(func() bool { return clock.Timers() == 1 }, 100, 10*time.Millisecond)
+	select {
+	case <-errc:
+		assert.Fail("polling ended without advancing the clock")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// Passing the expiration with one tick.
+	clock.Advance(2 * time.Hour)
+	select {
+	case err := <-errc:
+		assert.ErrorMatch(err, ".*exceeded.*")
+	case <-time.After(5 * time.Second):
+		assert.Fail("polling did not end")
+	}
+	assert.Equal(clock.Timers(), 0)
+}
+
 // EOF
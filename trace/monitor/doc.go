@@ -9,7 +9,9 @@
 // to measure the execution time and retrieve how often it is called, minimum,
 // maximum, and average durations. Another one is the stay-set indicator allowing
 // to increase and decrease values and retrieve count, maximum, minimum, and
-// current value. This can help to control and manage limiters or pools.
+// current value. This can help to control and manage limiters or pools. The
+// option WithClock allows to pass a different clock than the real one, e.g.
+// for tests.
 package monitor // import "tideland.dev/go/trace/monitor"
 
 // EOF
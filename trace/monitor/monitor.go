@@ -7,22 +7,52 @@
 
 package monitor // import "tideland.dev/go/trace/monitor"
 
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"tideland.dev/go/dsa/timex"
+)
+
+//--------------------
+// OPTIONS
+//--------------------
+
+// Option defines the signature of an option setting function.
+type Option func(m *Monitor)
+
+// WithClock sets the clock used for the measurings and the intervals
+// of the accumulation. By default it is the real clock.
+func WithClock(clock timex.Clock) Option {
+	return func(m *Monitor) {
+		if clock != nil {
+			m.clock = clock
+		}
+	}
+}
+
 //--------------------
 // MONITOR
 //--------------------
 
 // Monitor combines StopWatch and StaySetIndicator.
 type Monitor struct {
-	sw  *StopWatch
-	ssi *StaySetIndicator
+	clock timex.Clock
+	sw    *StopWatch
+	ssi   *StaySetIndicator
 }
 
 // New creates a new monitor.
-func New() *Monitor {
+func New(options ...Option) *Monitor {
 	m := &Monitor{
-		sw:  newStopWatch(),
-		ssi: newStaySetIndicator(),
+		clock: timex.RealClock(),
+	}
+	for _, option := range options {
+		option(m)
 	}
+	m.sw = newStopWatch(m.clock)
+	m.ssi = newStaySetIndicator(m.clock)
 	return m
 }
 
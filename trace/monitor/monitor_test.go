@@ -17,6 +17,7 @@ import (
 
 	"tideland.dev/go/audit/asserts"
 	"tideland.dev/go/audit/generators"
+	"tideland.dev/go/dsa/timex"
 	"tideland.dev/go/trace/monitor"
 )
 
@@ -82,6 +83,29 @@ func TestStopWatch(t *testing.T) {
 	assert.Empty(wvs)
 }
 
+// TestStopWatchClock tests the stop watch with a manual clock.
+func TestStopWatchClock(t *testing.T) {
+	assert := asserts.NewTesting(t, asserts.FailStop)
+	clock := timex.NewManualClock(time.Date(2019, time.January, 1, 0, 0, 0, 0, time.UTC))
+	m := monitor.New(monitor.WithClock(clock))
+	defer m.Stop()
+
+	msr := m.StopWatch().Begin("clock")
+	clock.Advance(3 * time.Second)
+	assert.Equal(msr.End(), 3*time.Second)
+	d := m.StopWatch().Measure("clock", func() {
+		clock.Advance(time.Second)
+	})
+	assert.Equal(d, time.Second)
+
+	wv, err := m.StopWatch().Read("clock")
+	assert.NoError(err)
+	assert.Equal(wv.Count, 2)
+	assert.Equal(wv.Total, 4*time.Second)
+	assert.Equal(wv.Min, time.Second)
+	assert.Equal(wv.Max, 3*time.Second)
+}
+
 // Test of the stay-set indicators  of the monitor.
 func TestStaySetIndicators(t *testing.T) {
 	assert := asserts.NewTesting(t, asserts.FailStop)
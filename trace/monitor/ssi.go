@@ -15,6 +15,7 @@ import (
 	"fmt"
 	"time"
 
+	"tideland.dev/go/dsa/timex"
 	"tideland.dev/go/together/actor"
 	"tideland.dev/go/trace/failure"
 )
@@ -83,14 +84,16 @@ const (
 // StaySetIndicator allows to increase and decrease stay-set values.
 type StaySetIndicator struct {
 	act     *actor.Actor
+	clock   timex.Clock
 	changes map[string][]bool
 	values  map[string]*IndicatorValue
 }
 
 // newStaySetIndicator creates a new StaySetIndicator.
-func newStaySetIndicator() *StaySetIndicator {
+func newStaySetIndicator(clock timex.Clock) *StaySetIndicator {
 	i := &StaySetIndicator{
 		act:     actor.New(actor.WithQueueLen(100)).Go(),
+		clock:   clock,
 		changes: make(map[string][]bool),
 		values:  make(map[string]*IndicatorValue),
 	}
@@ -164,9 +167,9 @@ func (i *StaySetIndicator) stop() error {
 // ticker makes the monitor accumulate all measuring points
 // in intervals.
 func (i *StaySetIndicator) ticker() {
-	ticker := time.NewTicker(time.Minute)
+	ticker := i.clock.NewTicker(time.Minute)
 	defer ticker.Stop()
-	for range ticker.C {
+	for range ticker.C() {
 		err := i.act.DoAsync(func() error {
 			i.accumulateAll()
 			return nil
@@ -15,6 +15,7 @@ import (
 	"fmt"
 	"time"
 
+	"tideland.dev/go/dsa/timex"
 	"tideland.dev/go/together/actor"
 	"tideland.dev/go/trace/failure"
 )
@@ -34,7 +35,7 @@ type Measuring struct {
 
 // End ends the measuring and passes it to the measurer.
 func (m *Measuring) End() time.Duration {
-	duration := time.Since(m.begin)
+	duration := m.owner.clock.Since(m.begin)
 	m.owner.end(m.id, duration)
 	return duration
 }
@@ -99,14 +100,16 @@ func (wvs WatchValues) Less(i, j int) bool { return wvs[i].ID < wvs[j].ID }
 // code fragments.
 type StopWatch struct {
 	act        *actor.Actor
+	clock      timex.Clock
 	measurings map[string][]time.Duration
 	values     map[string]*WatchValue
 }
 
 // newStopWatch creates a new stop watch.
-func newStopWatch() *StopWatch {
+func newStopWatch(clock timex.Clock) *StopWatch {
 	s := &StopWatch{
 		act:        actor.New(actor.WithQueueLen(100)).Go(),
+		clock:      clock,
 		measurings: make(map[string][]time.Duration),
 		values:     make(map[string]*WatchValue),
 	}
@@ -119,7 +122,7 @@ func (s *StopWatch) Begin(id string) *Measuring {
 	return &Measuring{
 		owner: s,
 		id:    id,
-		begin: time.Now(),
+		begin: s.clock.Now(),
 	}
 }
 
@@ -136,7 +139,7 @@ func (s *StopWatch) Measure(id string, f func()) time.Duration {
 	measuring := &Measuring{
 		owner: s,
 		id:    id,
-		begin: time.Now(),
+		begin: s.clock.Now(),
 	}
 	f()
 	return measuring.End()
@@ -192,9 +195,9 @@ func (s *StopWatch) stop() error {
 // ticker makes the measurer accumulate all measuring points
 // in intervals.
 func (s *StopWatch) ticker() {
-	ticker := time.NewTicker(time.Minute)
+	ticker := s.clock.NewTicker(time.Minute)
 	defer ticker.Stop()
-	for range ticker.C {
+	for range ticker.C() {
 		err := s.act.DoAsync(func() error {
 			s.accumulateAll()
 			return nil